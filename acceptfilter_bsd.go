@@ -0,0 +1,17 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build freebsd dragonfly netbsd
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// setAcceptFilter installs the accept filter of the given name on the listener, whose argument is empty.
+// The value is struct accept_filter_arg in sys/socket.h: af_name[16] followed by af_arg[256-16].
+func setAcceptFilter(fd int, name string) error {
+	var arg [256]byte
+	copy(arg[:15], name)
+	return unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_ACCEPTFILTER, string(arg[:]))
+}
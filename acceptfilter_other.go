@@ -0,0 +1,12 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin openbsd
+
+package gnet
+
+// setAcceptFilter does nothing since the accept filters are only supported on FreeBSD, DragonFly and NetBSD.
+func setAcceptFilter(_ int, _ string) error {
+	return nil
+}
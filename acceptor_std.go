@@ -0,0 +1,103 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package gnet
+
+import (
+	"hash/crc32"
+	"net"
+	"time"
+)
+
+// hashCode hashes a string to a unique hashcode.
+func hashCode(s string) int {
+	v := int(crc32.ChecksumIEEE([]byte(s)))
+	if v >= 0 {
+		return v
+	}
+	return -v
+}
+
+func (svr *server) listenerRun() {
+	var err error
+	defer func() { svr.signalShutdown(err) }()
+	var packet [0x10000]byte
+	for {
+		if svr.ln.pconn != nil {
+			// Read data from UDP socket.
+			n, addr, e := svr.ln.pconn.ReadFrom(packet[:])
+			if e != nil {
+				err = e
+				return
+			}
+			buf := svr.bufPool.Get()
+			_, _ = buf.Write(packet[:n])
+
+			el := svr.subEventLoopSet.next(hashCode(addr.String()))
+			el.ch <- &udpIn{newUDPConn(el, svr.ln.lnaddr, addr, buf)}
+		} else {
+			// Accept TCP socket.
+			for wait := svr.throttleAccept(); wait > 0; wait = svr.throttleAccept() {
+				time.Sleep(wait)
+			}
+			conn, e := svr.ln.ln.Accept()
+			if e != nil {
+				err = e
+				return
+			}
+			_ = svr.openConn(conn, nil)
+		}
+	}
+}
+
+// openConn assigns the new connection to an event-loop and starts the goroutine reading it, the local address
+// is that of the listener if it is nil. The connection is closed if it is rejected by PerIPMaxConnections.
+func (svr *server) openConn(conn net.Conn, local net.Addr) error {
+	peer, ok := svr.peers().acquire(conn.RemoteAddr(), svr.options().PerIPMaxConnections)
+	if !ok {
+		_ = conn.Close()
+		return ErrConnRejected
+	}
+	svr.pickLock.Lock()
+	el := svr.pickEventLoop(conn.RemoteAddr())
+	if el == nil {
+		el = svr.subEventLoopSet.next(hashCode(conn.RemoteAddr().String()))
+	}
+	svr.pickLock.Unlock()
+	c := newTCPConn(conn, el)
+	c.peer, c.localAddr = peer, local
+	el.ch <- c
+	go func() {
+		var packet [0x10000]byte
+		for {
+			n, err := c.conn.Read(packet[:])
+			if err != nil {
+				_ = c.conn.SetReadDeadline(time.Time{})
+				el.ch <- &stderr{c, err}
+				return
+			}
+			buf := svr.bufPool.Get()
+			_, _ = buf.Write(packet[:n])
+			el.ch <- &tcpIn{c, buf}
+		}
+	}()
+	return nil
+}
+
+// adoptConn opens the given connection like the accepted ones, any net.Conn is served by its goroutine.
+func (svr *server) adoptConn(nc net.Conn) error {
+	if svr.ln.pconn != nil {
+		_ = nc.Close()
+		return ErrUnsupportedProtocol
+	}
+	return svr.openConn(nc, nc.LocalAddr())
+}
+
+// adoptFd is not supported, the connections are net.Conn on Windows.
+func (svr *server) adoptFd(fd int) error {
+	return ErrUnsupportedPlatform
+}
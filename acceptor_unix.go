@@ -6,7 +6,12 @@
 
 package gnet
 
-import "golang.org/x/sys/unix"
+import (
+	"net"
+
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
 
 func (svr *server) acceptNewConnection(fd int) error {
 	nfd, sa, err := unix.Accept(fd)
@@ -19,16 +24,74 @@ func (svr *server) acceptNewConnection(fd int) error {
 	if err := unix.SetNonblock(nfd, true); err != nil {
 		return err
 	}
-	el := svr.subEventLoopSet.next(nfd)
+	_ = svr.openConn(nfd, sa, nil)
+	return nil
+}
+
+// openConn assigns the new connection to an event-loop, which registers and opens it, the local address is
+// that of the listener if it is nil. The file descriptor is closed if the connection is rejected by the limits
+// of server or the event-loop can't be reached.
+func (svr *server) openConn(nfd int, sa unix.Sockaddr, local net.Addr) error {
+	if svr.rejectFD(nfd) {
+		_ = unix.Close(nfd)
+		return ErrConnRejected
+	}
+	peer, ok := svr.admitPeer(sa)
+	if !ok {
+		_ = unix.Close(nfd)
+		return ErrConnRejected
+	}
+	svr.pickLock.Lock()
+	el := svr.nextEventLoop(nfd, sa)
+	svr.pickLock.Unlock()
 	c := newTCPConn(nfd, el, sa)
-	_ = el.poller.Trigger(func() (err error) {
-		if err = el.poller.AddRead(nfd); err != nil {
-			return
+	c.peer, c.localAddr = peer, local
+	err := el.poller.Trigger(func() error {
+		return el.loopRegister(c)
+	})
+	if err != nil {
+		if peer != nil {
+			svr.peers().release(peer)
 		}
-		el.connections[nfd] = c
-		el.calibrateCallback(el, 1)
-		err = el.loopOpen(c)
+		_ = unix.Close(nfd)
+	}
+	return err
+}
+
+// admitPeer counts the newly accepted connection from the given address in PeerStats, it returns false if
+// the connection must be closed for PerIPMaxConnections.
+func (svr *server) admitPeer(sa unix.Sockaddr) (*peerEntry, bool) {
+	peers := svr.peers()
+	if peers == nil {
+		return nil, true
+	}
+	return peers.acquire(netpoll.SockaddrToTCPOrUnixAddr(sa), svr.options().PerIPMaxConnections)
+}
+
+// nextEventLoop returns the event-loop which the newly accepted connection is assigned to.
+func (svr *server) nextEventLoop(nfd int, sa unix.Sockaddr) *eventloop {
+	if svr.opts.EventLoopPicker != nil {
+		if el := svr.pickEventLoop(netpoll.SockaddrToTCPOrUnixAddr(sa)); el != nil {
+			return el
+		}
+	}
+	return svr.subEventLoopSet.next(nfd)
+}
+
+// loopRegister starts polling the newly accepted connection and opens it.
+func (el *eventloop) loopRegister(c *conn) (err error) {
+	if el.svr.lifecycle != nil {
+		c.pendingOpen = true // polled once OnOpened returns
+	} else if err = el.poller.AddRead(c.fd); err != nil {
 		return
-	})
-	return nil
+	}
+	el.connections[c.fd] = c
+	el.calibrateCallback(el, 1)
+	if err = el.relieveFDPressure(c); err != nil {
+		return
+	}
+	el.current = c
+	err = el.loopOpen(c)
+	el.current = nil
+	return
 }
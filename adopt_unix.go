@@ -0,0 +1,69 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// adoptConn duplicates the file descriptor of the given connection and closes it, the duplicate is adopted.
+func (svr *server) adoptConn(nc net.Conn) error {
+	defer nc.Close()
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return ErrUnsupportedProtocol
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var (
+		nfd  int
+		derr error
+	)
+	if err = rc.Control(func(fd uintptr) {
+		nfd, derr = unix.Dup(int(fd))
+	}); err != nil {
+		return err
+	}
+	if derr != nil {
+		return derr
+	}
+	unix.CloseOnExec(nfd)
+	return svr.adoptFd(nfd)
+}
+
+// adoptFd opens the connected stream socket in an event-loop like the accepted connections.
+func (svr *server) adoptFd(fd int) error {
+	if svr.ln.pconn != nil {
+		_ = unix.Close(fd)
+		return ErrUnsupportedProtocol
+	}
+	typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err == nil && typ != unix.SOCK_STREAM {
+		err = ErrUnsupportedProtocol
+	}
+	var sa, local unix.Sockaddr
+	if err == nil {
+		sa, err = unix.Getpeername(fd)
+	}
+	if err == nil {
+		local, err = unix.Getsockname(fd)
+	}
+	if err == nil {
+		err = unix.SetNonblock(fd, true)
+	}
+	if err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+	return svr.openConn(fd, sa, netpoll.SockaddrToTCPOrUnixAddr(local))
+}
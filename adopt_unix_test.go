@@ -0,0 +1,97 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAdoptConn(t *testing.T) {
+	events := &testAdoptServer{}
+	svr, err := Start(events, "tcp://127.0.0.1:0")
+	must(err)
+	p, q := net.Pipe()
+	defer q.Close()
+	if err = svr.AdoptConn(p); err != ErrUnsupportedProtocol {
+		t.Fatalf("expected ErrUnsupportedProtocol for the connection without socket, got %v", err)
+	}
+
+	// The connection accepted by another listener is handed over to the server.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	c, err := net.Dial("tcp", ln.Addr().String())
+	must(err)
+	nc, err := ln.Accept()
+	must(err)
+	must(ln.Close())
+	must(svr.AdoptConn(nc))
+	echo(c)
+
+	// So is the file descriptor of socket.
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	must(err)
+	must(svr.AdoptFd(fds[0]))
+	f := os.NewFile(uintptr(fds[1]), "client")
+	c, err = net.FileConn(f)
+	must(err)
+	must(f.Close())
+	echo(c)
+
+	svr.Wait()
+	if events.local != ln.Addr().String() {
+		t.Fatalf("expected the local address of the adopted connection %s, got %s", ln.Addr(), events.local)
+	}
+	if opened := atomic.LoadInt32(&events.opened); opened != 2 {
+		t.Fatalf("expected OnOpened for both adopted connections, got %d", opened)
+	}
+}
+
+type testAdoptServer struct {
+	*EventServer
+	local  string
+	opened int32
+	closed int32
+}
+
+// echo writes a message to the client side of an adopted connection and reads the echo.
+func echo(c net.Conn) {
+	defer c.Close()
+	must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+	_, err := c.Write([]byte("hello"))
+	must(err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(c, buf)
+	must(err)
+	if string(buf) != "hello" {
+		panic("unexpected echo: " + string(buf))
+	}
+}
+
+func (s *testAdoptServer) OnOpened(c Conn) (out []byte, action Action) {
+	if atomic.AddInt32(&s.opened, 1) == 1 {
+		s.local = c.LocalAddr().String()
+	}
+	return
+}
+
+func (s *testAdoptServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testAdoptServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&s.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
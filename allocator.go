@@ -0,0 +1,29 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "github.com/panjf2000/gnet/pool/bytebuffer"
+
+// ByteBufferPool is the allocator of byte buffers which back the frames combined from the internal buffers
+// of connections and passed to React, and the data queued by AsyncWrite, you can plug in your own implementation
+// like an arena allocator via WithByteBufferPool to make the server run with zero GC in steady state.
+type ByteBufferPool interface {
+	// Get returns an empty byte buffer.
+	Get() *bytebuffer.ByteBuffer
+
+	// Put recycles the byte buffer obtained via Get, the buffer mustn't be touched after it is put back.
+	Put(b *bytebuffer.ByteBuffer)
+}
+
+// defaultByteBufferPool is the built-in ByteBufferPool backed by sync.Pool.
+type defaultByteBufferPool struct{}
+
+func (defaultByteBufferPool) Get() *bytebuffer.ByteBuffer {
+	return bytebuffer.Get()
+}
+
+func (defaultByteBufferPool) Put(b *bytebuffer.ByteBuffer) {
+	bytebuffer.Put(b)
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// frameBatch collects the frames decoded from a connection for BatchHandler, it is reused by the event-loop.
+type frameBatch struct {
+	frames [][]byte // frames of the batch referring to arena
+	arena  []byte   // copies of the frames, which stay intact while decoding the following frames
+	out    []byte   // encoded outputs of the batch
+}
+
+func (b *frameBatch) reset() {
+	for i := range b.frames {
+		b.frames[i] = nil
+	}
+	b.frames, b.arena, b.out = b.frames[:0], b.arena[:0], b.out[:0]
+}
+
+// add appends a copy of the frame to the batch, since the memory of the frame may be reused by decoding the
+// next one.
+func (b *frameBatch) add(frame []byte) {
+	start := len(b.arena)
+	b.arena = append(b.arena, frame...)
+	b.frames = append(b.frames, b.arena[start:len(b.arena):len(b.arena)])
+}
+
+// encode encodes the outputs of the batch into a single chunk of data, nil is returned if there is nothing.
+func (b *frameBatch) encode(codec ICodec, c Conn, outs [][]byte) []byte {
+	for _, out := range outs {
+		if out == nil {
+			continue
+		}
+		if frame, err := codec.Encode(c, out); err == nil {
+			b.out = append(b.out, frame...)
+		}
+	}
+	if len(b.out) == 0 {
+		return nil
+	}
+	return b.out
+}
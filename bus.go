@@ -0,0 +1,99 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "sync"
+
+// bus is the pub/sub bus of the server, which fans out the data published to a topic to the connections
+// subscribing to it, the writes to the subscribers are done on their own event-loops.
+type bus struct {
+	mu     sync.RWMutex
+	topics map[string]map[Conn]struct{} // subscribers of topics
+	subs   map[Conn]map[string]struct{} // topics subscribed by connections, for unsubscribing on closing
+}
+
+func (b *bus) subscribe(c Conn, topic string) error {
+	if eventLoopOf(c) == nil {
+		return ErrUnsupportedProtocol
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.topics == nil {
+		b.topics = make(map[string]map[Conn]struct{})
+		b.subs = make(map[Conn]map[string]struct{})
+	}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[Conn]struct{})
+	}
+	b.topics[topic][c] = struct{}{}
+	if b.subs[c] == nil {
+		b.subs[c] = make(map[string]struct{})
+	}
+	b.subs[c][topic] = struct{}{}
+	return nil
+}
+
+func (b *bus) unsubscribe(c Conn, topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(c, topic)
+}
+
+// unsubscribeAll removes all the subscriptions of the given connection, it is called on closing the connection.
+func (b *bus) unsubscribeAll(c Conn) {
+	b.mu.RLock()
+	_, ok := b.subs[c]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for topic := range b.subs[c] {
+		b.remove(c, topic)
+	}
+}
+
+func (b *bus) remove(c Conn, topic string) {
+	if subs := b.topics[topic]; subs != nil {
+		if delete(subs, c); len(subs) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+	if topics := b.subs[c]; topics != nil {
+		if delete(topics, topic); len(topics) == 0 {
+			delete(b.subs, c)
+		}
+	}
+}
+
+// publish groups the subscribers of the topic by their event-loops, and writes the data to every group
+// on its event-loop.
+func (b *bus) publish(topic string, data []byte) (err error) {
+	groups := make(map[*eventloop][]Conn)
+	b.mu.RLock()
+	for c := range b.topics[topic] {
+		el := eventLoopOf(c)
+		groups[el] = append(groups[el], c)
+	}
+	b.mu.RUnlock()
+	if len(groups) == 0 {
+		return nil
+	}
+	// The data is shared by the event-loops, which only read it.
+	data = append([]byte(nil), data...)
+	for el, subs := range groups {
+		el, subs := el, subs
+		if err = el.submit(func() error {
+			for _, c := range subs {
+				el.loopPublish(c, data)
+			}
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
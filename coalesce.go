@@ -0,0 +1,50 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"sync"
+
+	"github.com/panjf2000/gnet/pool/bytebuffer"
+)
+
+// writeCoalescer batches the data of asynchronous writes to a connection issued within the window
+// of WriteCoalescing, so that they are written with one syscall.
+type writeCoalescer struct {
+	mu        sync.Mutex
+	pending   *bytebuffer.ByteBuffer
+	scheduled bool
+}
+
+// add appends the data to the pending batch, it reports whether the batch has reached maxBytes and
+// must be flushed right now, or whether it is a new batch that needs a flush at the end of the window.
+func (wc *writeCoalescer) add(pool ByteBufferPool, buf []byte, maxBytes int) (flushNow, schedule bool) {
+	wc.mu.Lock()
+	if wc.pending == nil {
+		wc.pending = pool.Get()
+	}
+	_, _ = wc.pending.Write(buf)
+	flushNow = maxBytes > 0 && wc.pending.Len() >= maxBytes
+	if !flushNow && !wc.scheduled {
+		wc.scheduled, schedule = true, true
+	}
+	wc.mu.Unlock()
+	return
+}
+
+// idle reports whether there is no pending batch.
+func (wc *writeCoalescer) idle() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.pending == nil
+}
+
+// take returns the pending batch and resets it, nil is returned if there is nothing to flush.
+func (wc *writeCoalescer) take() (bb *bytebuffer.ByteBuffer) {
+	wc.mu.Lock()
+	bb, wc.pending, wc.scheduled = wc.pending, nil, false
+	wc.mu.Unlock()
+	return
+}
@@ -75,12 +75,23 @@ func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	buf := c.Read()
 	idx := bytes.IndexByte(buf, CRLFByte)
 	if idx == -1 {
+		if max := maxFrameSize(c); max > 0 && len(buf) > max {
+			return nil, ErrFrameTooLarge
+		}
 		return nil, errCRLFNotFound
 	}
 	c.ShiftN(idx + 1)
 	return buf[:idx], nil
 }
 
+// maxFrameSize returns MaxFrameSize of the server of the given connection, 0 for the datagrams of UDP.
+func maxFrameSize(c Conn) int {
+	if el := eventLoopOf(c); el != nil {
+		return el.svr.opts.MaxFrameSize
+	}
+	return 0
+}
+
 // NewDelimiterBasedFrameCodec instantiates and returns a codec with a specific delimiter.
 func NewDelimiterBasedFrameCodec(delimiter byte) *DelimiterBasedFrameCodec {
 	return &DelimiterBasedFrameCodec{delimiter}
@@ -96,6 +107,9 @@ func (cc *DelimiterBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	buf := c.Read()
 	idx := bytes.IndexByte(buf, cc.delimiter)
 	if idx == -1 {
+		if max := maxFrameSize(c); max > 0 && len(buf) > max {
+			return nil, ErrFrameTooLarge
+		}
 		return nil, errDelimiterNotFound
 	}
 	c.ShiftN(idx + 1)
@@ -117,8 +131,11 @@ func (cc *FixedLengthFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 
 // Decode ...
 func (cc *FixedLengthFrameCodec) Decode(c Conn) ([]byte, error) {
+	if max := maxFrameSize(c); max > 0 && cc.frameLength > max {
+		return nil, ErrFrameTooLarge
+	}
 	size, buf := c.ReadN(cc.frameLength)
-	if size == 0 {
+	if size < cc.frameLength {
 		return nil, errUnexpectedEOF
 	}
 	c.ShiftN(size)
@@ -234,6 +251,14 @@ func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 		return nil, err
 	}
 
+	// refuse the frame declared larger than MaxFrameSize before it arrives in full
+	if max := maxFrameSize(c); max > 0 {
+		rest := len(header) + len(lenBuf) + cc.decoderConfig.LengthAdjustment - cc.decoderConfig.InitialBytesToStrip
+		if frameLength > 1<<62 || int64(frameLength)+int64(rest) > int64(max) {
+			return nil, ErrFrameTooLarge
+		}
+	}
+
 	// real message length
 	msgLength := int(frameLength) + cc.decoderConfig.LengthAdjustment
 	msg, err := in.readN(msgLength)
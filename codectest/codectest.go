@@ -0,0 +1,156 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package codectest is the conformance suite of the implementations of gnet.ICodec, it checks that the frames
+// survive the round-trip of encoding and decoding however the stream is segmented by the network, and that
+// decoding the corrupted streams doesn't panic.
+package codectest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/gnettest"
+)
+
+// Rounds is the number of the random segmentations and mutations tried by Run.
+var Rounds = 200
+
+// Run runs the conformance suite against the given codec with the sample frames, which must be valid payloads
+// of the codec, e.g. free of the delimiter or of the fixed length, and not empty since the empty frames are
+// taken as no frames by gnet. The default samples are made of ASCII letters
+// of various lengths if there are none. The codec is shared by all connections like gnet does, so it must not
+// keep the state of connections in itself.
+//
+// The suite is seeded randomly, the seed is logged on failure and can be fixed by Seed for reproducing it.
+func Run(t *testing.T, codec gnet.ICodec, samples ...[]byte) {
+	t.Helper()
+	if len(samples) == 0 {
+		samples = defaultSamples()
+	}
+	seed := Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+
+	var stream []byte
+	for i, sample := range samples {
+		// Encode may append to the given buffer, keep the samples intact.
+		frame, err := codec.Encode(nil, append([]byte(nil), sample...))
+		if err != nil {
+			t.Fatalf("failed to encode sample %d: %v", i, err)
+		}
+		stream = append(stream, frame...)
+	}
+
+	for round := 0; round < Rounds; round++ {
+		segments := segment(rnd, stream)
+		frames, err := decode(codec, segments)
+		if err != nil {
+			t.Fatalf("seed %d: decoding panics with segments %q: %v", seed, segments, err)
+		}
+		if !equal(frames, samples) {
+			t.Fatalf("seed %d: expected frames %q decoded from segments %q, got %q", seed, samples, segments, frames)
+		}
+	}
+
+	for round := 0; round < Rounds; round++ {
+		corrupted := mutate(rnd, stream)
+		if _, err := decode(codec, segment(rnd, corrupted)); err != nil {
+			t.Fatalf("seed %d: decoding panics with corrupted stream %q: %v", seed, corrupted, err)
+		}
+	}
+}
+
+// Seed fixes the seed of Run if it is not 0.
+var Seed int64
+
+type collector struct {
+	*gnet.EventServer
+	frames [][]byte
+}
+
+func (h *collector) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	h.frames = append(h.frames, c.RetainFrame(frame))
+	return
+}
+
+// decode feeds the segments to a connection decoding frames with the codec, it turns the panic into an error.
+func decode(codec gnet.ICodec, segments [][]byte) (frames [][]byte, err error) {
+	h := new(collector)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	c := gnettest.NewServer(h, codec).Dial()
+	c.Send(segments...)
+	return h.frames, nil
+}
+
+// segment splits the stream at random points, the segments may be empty.
+func segment(rnd *rand.Rand, stream []byte) (segments [][]byte) {
+	for len(stream) > 0 {
+		n := rnd.Intn(len(stream)) + 1
+		if rnd.Intn(4) == 0 {
+			// Favour the tiny segments which cut the headers and delimiters apart.
+			if n = rnd.Intn(3); n > len(stream) {
+				n = len(stream)
+			}
+		}
+		segments = append(segments, stream[:n])
+		stream = stream[n:]
+	}
+	return
+}
+
+// mutate returns a copy of the stream with a random corruption.
+func mutate(rnd *rand.Rand, stream []byte) []byte {
+	out := append([]byte(nil), stream...)
+	if len(out) == 0 {
+		return append(out, byte(rnd.Intn(256)))
+	}
+	switch i := rnd.Intn(len(out)); rnd.Intn(4) {
+	case 0: // flip a byte
+		out[i] ^= byte(rnd.Intn(255) + 1)
+	case 1: // truncate
+		out = out[:i]
+	case 2: // insert random bytes
+		junk := make([]byte, rnd.Intn(8)+1)
+		rnd.Read(junk)
+		out = append(out[:i], append(junk, out[i:]...)...)
+	default: // set a byte to an extreme value
+		out[i] = []byte{0, 0x7f, 0x80, 0xff}[rnd.Intn(4)]
+	}
+	return out
+}
+
+func equal(frames, samples [][]byte) bool {
+	if len(frames) != len(samples) {
+		return false
+	}
+	for i := range frames {
+		if !bytes.Equal(frames[i], samples[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func defaultSamples() [][]byte {
+	letters := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	return [][]byte{
+		[]byte("a"),
+		[]byte("hello"),
+		[]byte(letters),
+		[]byte(strings.Repeat(letters, 20)),
+		[]byte("gnet"),
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package codectest
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/panjf2000/gnet"
+)
+
+func TestBuiltInCodecs(t *testing.T) {
+	t.Run("line-based", func(t *testing.T) {
+		Run(t, new(gnet.LineBasedFrameCodec))
+	})
+	t.Run("delimiter-based", func(t *testing.T) {
+		Run(t, gnet.NewDelimiterBasedFrameCodec('|'))
+	})
+	t.Run("fixed-length", func(t *testing.T) {
+		Run(t, gnet.NewFixedLengthFrameCodec(4), []byte("gnet"), []byte("1234"), []byte{0, 0, 0, 0})
+	})
+	t.Run("length-field-based", func(t *testing.T) {
+		encoderConfig := gnet.EncoderConfig{
+			ByteOrder:         binary.BigEndian,
+			LengthFieldLength: 2,
+		}
+		decoderConfig := gnet.DecoderConfig{
+			ByteOrder:           binary.BigEndian,
+			LengthFieldLength:   2,
+			InitialBytesToStrip: 2,
+		}
+		Run(t, gnet.NewLengthFieldBasedFrameCodec(encoderConfig, decoderConfig), []byte("a"), []byte{0}, []byte{0, 1, 2})
+	})
+}
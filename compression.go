@@ -0,0 +1,188 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Compression is the algorithm of compressing the streams of connections.
+type Compression int
+
+const (
+	// NoCompression leaves the streams as they are.
+	NoCompression Compression = iota
+
+	// Deflate compresses the streams in the raw DEFLATE format (RFC 1951).
+	Deflate
+
+	// Gzip compresses the streams in the gzip format (RFC 1952).
+	Gzip
+)
+
+// deflater compresses the outbound stream of a connection, every piece of data is flushed on its own,
+// so that the peer is able to decompress it right away.
+type deflater struct {
+	w interface {
+		io.Writer
+		Flush() error
+	}
+	out bytes.Buffer
+}
+
+func newDeflater(alg Compression, level int) (d *deflater, err error) {
+	d = new(deflater)
+	switch alg {
+	case Deflate:
+		d.w, err = flate.NewWriter(&d.out, level)
+	case Gzip:
+		d.w, err = gzip.NewWriterLevel(&d.out, level)
+	default:
+		err = ErrUnsupportedProtocol
+	}
+	return
+}
+
+// compress returns the compressed data, which is only valid until the next call.
+func (d *deflater) compress(buf []byte) []byte {
+	d.out.Reset()
+	_, _ = d.w.Write(buf)
+	_ = d.w.Flush()
+	return d.out.Bytes()
+}
+
+// inflateQueueCap is the number of compressed bytes queued for the decompressor at which the connection is not
+// read any more until the decompressor has caught up with half of them.
+const inflateQueueCap = 1 << 18
+
+// inflater decompresses the inbound stream of a connection in its own goroutine, since the decompressors of
+// the standard library are blocking readers which can't be resumed once they run out of input, the event-loop
+// pushes the compressed data without blocking.
+type inflater struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]byte
+	queued  int    // bytes in queue
+	pending int    // decompressed bytes passed to deliver but not taken by the event-loop yet
+	full    bool   // queued has reached inflateQueueCap, cleared once it drops to half of that
+	resume  func() // called by the decompressor when the queue is no longer full
+	closed  bool
+}
+
+func newInflater(resume func()) *inflater {
+	z := &inflater{resume: resume}
+	z.cond = sync.NewCond(&z.mu)
+	return z
+}
+
+// push queues the compressed data for the decompressor, the data must not be referenced by the caller afterwards.
+// It returns true once the queue is full, the caller should stop pushing until resume is called.
+func (z *inflater) push(data []byte) (full bool) {
+	z.mu.Lock()
+	z.queue = append(z.queue, data)
+	z.queued += len(data)
+	if z.queued >= inflateQueueCap {
+		z.full = true
+	}
+	full = z.full
+	z.mu.Unlock()
+	z.cond.Signal()
+	return
+}
+
+// delivered tells the decompressor that the event-loop has taken n decompressed bytes passed to deliver.
+func (z *inflater) delivered(n int) {
+	z.mu.Lock()
+	z.pending -= n
+	z.mu.Unlock()
+	z.cond.Signal()
+}
+
+// size returns the number of bytes held by the decompressor, both the compressed ones in queue and
+// the decompressed ones passed to deliver.
+func (z *inflater) size() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.queued + z.pending
+}
+
+// close stops the decompressor, whose Read returns io.EOF from then on.
+func (z *inflater) close() {
+	z.mu.Lock()
+	z.closed = true
+	z.mu.Unlock()
+	z.cond.Signal()
+}
+
+func (z *inflater) isClosed() bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.closed
+}
+
+// Read feeds the decompressor with the queued data, it blocks until there is some.
+func (z *inflater) Read(p []byte) (n int, err error) {
+	z.mu.Lock()
+	for len(z.queue) == 0 && !z.closed {
+		z.cond.Wait()
+	}
+	if z.closed {
+		z.mu.Unlock()
+		return 0, io.EOF
+	}
+	n = copy(p, z.queue[0])
+	if z.queue[0] = z.queue[0][n:]; len(z.queue[0]) == 0 {
+		z.queue[0] = nil
+		z.queue = z.queue[1:]
+	}
+	z.queued -= n
+	resume := z.full && z.queued <= inflateQueueCap/2
+	if resume {
+		z.full = false
+	}
+	z.mu.Unlock()
+	if resume {
+		z.resume()
+	}
+	return
+}
+
+// run decompresses the queued data and passes the copies of results to deliver until the stream ends or fails,
+// then the error is passed to fail unless the inflater has been closed. It waits while inflateQueueCap bytes
+// passed to deliver are not taken by the event-loop, which holds the queue and then the reads of the connection.
+func (z *inflater) run(alg Compression, deliver func([]byte), fail func(error)) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch alg {
+	case Deflate:
+		r = flate.NewReader(z)
+	case Gzip:
+		r, err = gzip.NewReader(z)
+	default:
+		err = ErrUnsupportedProtocol
+	}
+	buf := make([]byte, 0x10000)
+	for err == nil {
+		var n int
+		if n, err = r.Read(buf); n > 0 {
+			z.mu.Lock()
+			for z.pending >= inflateQueueCap && !z.closed {
+				z.cond.Wait()
+			}
+			z.pending += n
+			z.mu.Unlock()
+			deliver(append([]byte(nil), buf[:n]...))
+		}
+	}
+	if !z.isClosed() {
+		fail(err)
+	}
+}
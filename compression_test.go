@@ -0,0 +1,74 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestInflaterQueue(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+	must(err)
+	_, _ = w.Write(data)
+	must(w.Close())
+
+	resumed := make(chan struct{}, 1)
+	z := newInflater(func() { resumed <- struct{}{} })
+	in := compressed.Bytes()
+	for !z.push(append([]byte(nil), in[:0x4000]...)) {
+		in = in[0x4000:]
+	}
+	in = in[0x4000:]
+	if n := z.size(); n < inflateQueueCap || n >= inflateQueueCap+0x4000 {
+		t.Fatalf("the queue is reported full with %d bytes", n)
+	}
+
+	out := make(chan []byte, 1024)
+	go z.run(Deflate, func(b []byte) { out <- b }, func(err error) {})
+	defer z.close()
+	select {
+	case <-resumed:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the queue is not resumed")
+	}
+	for len(in) > 0 {
+		n := len(in)
+		if n > 0x4000 {
+			n = 0x4000
+		}
+		z.push(append([]byte(nil), in[:n]...))
+		in = in[n:]
+	}
+
+	// The decompressor waits for the event-loop to take the decompressed bytes.
+	time.Sleep(time.Millisecond * 100)
+	got := make([]byte, 0, len(data))
+	for len(out) > 0 {
+		got = append(got, <-out...)
+	}
+	if len(got) > inflateQueueCap+0x10000 {
+		t.Fatalf("%d decompressed bytes are passed on before the event-loop takes them", len(got))
+	}
+	z.delivered(len(got))
+	for len(got) < len(data) {
+		select {
+		case b := <-out:
+			got = append(got, b...)
+			z.delivered(len(b))
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%d of %d bytes are decompressed", len(got), len(data))
+		}
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("mismatched decompressed data")
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"compress/flate"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the plain-data counterpart of Options which can be populated from the config files (JSON/YAML) or
+// the environment variables and passed to Serve by WithConfig, along with the functional options for the rest,
+// e.g. the codec, the logger and the callbacks. The fields are documented in Options, the durations are written
+// as strings like "1m30s" and the enumerations as the names below, the empty names and the zero values mean
+// the defaults of Options:
+//
+//	load_balancing:           round-robin, least-connections, source-addr-hash
+//	loop_task_queue_overload: block, reject, drop-oldest
+//	compression:              none, deflate, gzip
+//	fd_limit_policy:          close-least-active, reject-new
+//	slow_consumer_policy:     close, drop-oldest, pause, notify-only
+//	memory_policy:            pause-accept, close-most-buffered, notify-only
+type Config struct {
+	Multicore             bool     `json:"multicore" yaml:"multicore"`
+	NumEventLoop          int      `json:"num_event_loop" yaml:"num_event_loop"`
+	LoadBalancing         string   `json:"load_balancing" yaml:"load_balancing"`
+	ReusePort             bool     `json:"reuse_port" yaml:"reuse_port"`
+	ReusePortCPUAffinity  bool     `json:"reuse_port_cpu_affinity" yaml:"reuse_port_cpu_affinity"`
+	ReusePortEBPF         int      `json:"reuse_port_ebpf" yaml:"reuse_port_ebpf"`
+	UDPConnect            bool     `json:"udp_connect" yaml:"udp_connect"`
+	UDPMaxSessions        int      `json:"udp_max_sessions" yaml:"udp_max_sessions"`
+	UDPControl            bool     `json:"udp_control" yaml:"udp_control"`
+	NumAcceptors          int      `json:"num_acceptors" yaml:"num_acceptors"`
+	Ticker                bool     `json:"ticker" yaml:"ticker"`
+	TickerAlign           Duration `json:"ticker_align" yaml:"ticker_align"`
+	TickerJitter          Duration `json:"ticker_jitter" yaml:"ticker_jitter"`
+	IPv6Only              bool     `json:"ipv6_only" yaml:"ipv6_only"`
+	SocketMark            int      `json:"socket_mark" yaml:"socket_mark"`
+	BindToDevice          string   `json:"bind_to_device" yaml:"bind_to_device"`
+	FreeBind              bool     `json:"free_bind" yaml:"free_bind"`
+	ShutdownTimeout       Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	TCPKeepAlive          Duration `json:"tcp_keep_alive" yaml:"tcp_keep_alive"`
+	TCPUserTimeout        Duration `json:"tcp_user_timeout" yaml:"tcp_user_timeout"`
+	FirstByteTimeout      Duration `json:"first_byte_timeout" yaml:"first_byte_timeout"`
+	FirstDataBytes        int      `json:"first_data_bytes" yaml:"first_data_bytes"`
+	IdleTimeout           Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	PollTimeout           Duration `json:"poll_timeout" yaml:"poll_timeout"`
+	BusyPoll              bool     `json:"busy_poll" yaml:"busy_poll"`
+	BatchPollerUpdates    bool     `json:"batch_poller_updates" yaml:"batch_poller_updates"`
+	WriteCoalesceWindow   Duration `json:"write_coalesce_window" yaml:"write_coalesce_window"`
+	WriteCoalesceMaxBytes int      `json:"write_coalesce_max_bytes" yaml:"write_coalesce_max_bytes"`
+	ListenBacklog         int      `json:"listen_backlog" yaml:"listen_backlog"`
+	DeferAccept           bool     `json:"defer_accept" yaml:"defer_accept"`
+	AcceptFilter          string   `json:"accept_filter" yaml:"accept_filter"`
+	AcceptRate            int      `json:"accept_rate" yaml:"accept_rate"`
+	AcceptBurst           int      `json:"accept_burst" yaml:"accept_burst"`
+	MaxFramesPerPoll      int      `json:"max_frames_per_poll" yaml:"max_frames_per_poll"`
+	MaxFrameSize          int      `json:"max_frame_size" yaml:"max_frame_size"`
+	LazyInboundBuffer     bool     `json:"lazy_inbound_buffer" yaml:"lazy_inbound_buffer"`
+	WriteBuffering        bool     `json:"write_buffering" yaml:"write_buffering"`
+	LifecycleWorkers      int      `json:"lifecycle_workers" yaml:"lifecycle_workers"`
+	LoopTaskQueueCap      int      `json:"loop_task_queue_cap" yaml:"loop_task_queue_cap"`
+	LoopTaskQueueOverload string   `json:"loop_task_queue_overload" yaml:"loop_task_queue_overload"`
+	Compression           string   `json:"compression" yaml:"compression"`
+	CompressionLevel      int      `json:"compression_level" yaml:"compression_level"`
+	DisablePanicRecovery  bool     `json:"disable_panic_recovery" yaml:"disable_panic_recovery"`
+	DebugChecks           bool     `json:"debug_checks" yaml:"debug_checks"`
+	LoopProfiling         bool     `json:"loop_profiling" yaml:"loop_profiling"`
+	FDWatermark           float64  `json:"fd_watermark" yaml:"fd_watermark"`
+	FDLimitPolicy         string   `json:"fd_limit_policy" yaml:"fd_limit_policy"`
+	SlowConsumerThreshold int      `json:"slow_consumer_threshold" yaml:"slow_consumer_threshold"`
+	SlowConsumerTimeout   Duration `json:"slow_consumer_timeout" yaml:"slow_consumer_timeout"`
+	SlowConsumerPolicy    string   `json:"slow_consumer_policy" yaml:"slow_consumer_policy"`
+	UDPSendQueue          int      `json:"udp_send_queue" yaml:"udp_send_queue"`
+	UDPSendPolicy         string   `json:"udp_send_policy" yaml:"udp_send_policy"`
+	UDPSendTimeout        Duration `json:"udp_send_timeout" yaml:"udp_send_timeout"`
+	MemoryLimit           int64    `json:"memory_limit" yaml:"memory_limit"`
+	MemoryPolicy          string   `json:"memory_policy" yaml:"memory_policy"`
+	PeerStats             bool     `json:"peer_stats" yaml:"peer_stats"`
+	PeerIPv4Prefix        int      `json:"peer_ipv4_prefix" yaml:"peer_ipv4_prefix"`
+	PeerIPv6Prefix        int      `json:"peer_ipv6_prefix" yaml:"peer_ipv6_prefix"`
+	PerIPMaxConnections   int      `json:"per_ip_max_connections" yaml:"per_ip_max_connections"`
+}
+
+var (
+	loadBalancingNames = map[string]LoadBalancing{
+		"round-robin": RoundRobin, "least-connections": LeastConnections, "source-addr-hash": SourceAddrHash,
+	}
+	overloadPolicyNames = map[string]OverloadPolicy{
+		"block": OverloadBlock, "reject": OverloadReject, "drop-oldest": OverloadDropOldest,
+	}
+	compressionNames = map[string]Compression{
+		"none": NoCompression, "deflate": Deflate, "gzip": Gzip,
+	}
+	fdLimitPolicyNames = map[string]FDLimitPolicy{
+		"close-least-active": CloseLeastActive, "reject-new": RejectNew,
+	}
+	slowConsumerPolicyNames = map[string]SlowConsumerPolicy{
+		"close": SlowConsumerClose, "drop-oldest": SlowConsumerDropOldest, "pause": SlowConsumerPause,
+		"notify-only": SlowConsumerNotifyOnly,
+	}
+	udpSendPolicyNames = map[string]UDPSendPolicy{
+		"drop-newest": UDPSendDropNewest, "drop-oldest": UDPSendDropOldest, "block": UDPSendBlock,
+	}
+	memoryPolicyNames = map[string]MemoryPolicy{
+		"pause-accept": PauseAccept, "close-most-buffered": CloseMostBuffered, "notify-only": NotifyOnly,
+	}
+)
+
+// DefaultConfig returns the config with the defaults of gnet spelled out, except that Multicore is on, unmarshal
+// the config files onto it so that the omitted fields keep the defaults.
+func DefaultConfig() Config {
+	return Config{
+		Multicore:             true,
+		LoadBalancing:         "round-robin",
+		LoopTaskQueueOverload: "block",
+		Compression:           "none",
+		CompressionLevel:      flate.DefaultCompression,
+		FDLimitPolicy:         "close-least-active",
+		SlowConsumerPolicy:    "close",
+		UDPSendPolicy:         "drop-newest",
+		MemoryPolicy:          "pause-accept",
+	}
+}
+
+// Validate checks the config for the values out of range and the unknown names, the error wraps
+// ErrInvalidConfig.
+func (cfg *Config) Validate() error {
+	for name, v := range map[string]int64{
+		"num_event_loop":           int64(cfg.NumEventLoop),
+		"reuse_port_ebpf":          int64(cfg.ReusePortEBPF),
+		"udp_max_sessions":         int64(cfg.UDPMaxSessions),
+		"num_acceptors":            int64(cfg.NumAcceptors),
+		"write_coalesce_max_bytes": int64(cfg.WriteCoalesceMaxBytes),
+		"first_data_bytes":         int64(cfg.FirstDataBytes),
+		"listen_backlog":           int64(cfg.ListenBacklog),
+		"socket_mark":              int64(cfg.SocketMark),
+		"accept_rate":              int64(cfg.AcceptRate),
+		"accept_burst":             int64(cfg.AcceptBurst),
+		"max_frames_per_poll":      int64(cfg.MaxFramesPerPoll),
+		"max_frame_size":           int64(cfg.MaxFrameSize),
+		"loop_task_queue_cap":      int64(cfg.LoopTaskQueueCap),
+		"lifecycle_workers":        int64(cfg.LifecycleWorkers),
+		"slow_consumer_threshold":  int64(cfg.SlowConsumerThreshold),
+		"udp_send_queue":           int64(cfg.UDPSendQueue),
+		"memory_limit":             cfg.MemoryLimit,
+		"ticker_align":             int64(cfg.TickerAlign),
+		"ticker_jitter":            int64(cfg.TickerJitter),
+		"shutdown_timeout":         int64(cfg.ShutdownTimeout),
+		"tcp_keep_alive":           int64(cfg.TCPKeepAlive),
+		"tcp_user_timeout":         int64(cfg.TCPUserTimeout),
+		"first_byte_timeout":       int64(cfg.FirstByteTimeout),
+		"idle_timeout":             int64(cfg.IdleTimeout),
+		"poll_timeout":             int64(cfg.PollTimeout),
+		"write_coalesce_window":    int64(cfg.WriteCoalesceWindow),
+		"slow_consumer_timeout":    int64(cfg.SlowConsumerTimeout),
+		"udp_send_timeout":         int64(cfg.UDPSendTimeout),
+		"per_ip_max_connections":   int64(cfg.PerIPMaxConnections),
+	} {
+		if v < 0 {
+			return fmt.Errorf("%w: %s must not be negative", ErrInvalidConfig, name)
+		}
+	}
+	if cfg.FDWatermark < 0 || cfg.FDWatermark > 1 {
+		return fmt.Errorf("%w: fd_watermark must be in [0, 1]", ErrInvalidConfig)
+	}
+	if cfg.CompressionLevel < flate.HuffmanOnly || cfg.CompressionLevel > flate.BestCompression {
+		return fmt.Errorf("%w: compression_level must be in [%d, %d]", ErrInvalidConfig, flate.HuffmanOnly,
+			flate.BestCompression)
+	}
+	if cfg.PeerIPv4Prefix < 0 || cfg.PeerIPv4Prefix > 32 || cfg.PeerIPv6Prefix < 0 || cfg.PeerIPv6Prefix > 128 {
+		return fmt.Errorf("%w: peer_ipv4_prefix must be in [0, 32] and peer_ipv6_prefix in [0, 128]", ErrInvalidConfig)
+	}
+	if cfg.UDPSendQueue > 0 && cfg.UDPSendPolicy == "block" {
+		return fmt.Errorf("%w: udp_send_policy block can't be used with udp_send_queue", ErrInvalidConfig)
+	}
+	if cfg.SlowConsumerThreshold > 0 && cfg.SlowConsumerTimeout == 0 {
+		return fmt.Errorf("%w: slow_consumer_timeout is required by slow_consumer_threshold", ErrInvalidConfig)
+	}
+	return cfg.apply(new(Options))
+}
+
+// apply sets up the options in the config, it fails with the unknown names.
+func (cfg *Config) apply(opts *Options) error {
+	*opts = Options{
+		Multicore:             cfg.Multicore,
+		NumEventLoop:          cfg.NumEventLoop,
+		ReusePort:             cfg.ReusePort,
+		ReusePortCPUAffinity:  cfg.ReusePortCPUAffinity,
+		ReusePortEBPF:         cfg.ReusePortEBPF,
+		UDPConnect:            cfg.UDPConnect,
+		UDPMaxSessions:        cfg.UDPMaxSessions,
+		UDPControl:            cfg.UDPControl,
+		NumAcceptors:          cfg.NumAcceptors,
+		Ticker:                cfg.Ticker,
+		TickerAlign:           time.Duration(cfg.TickerAlign),
+		TickerJitter:          time.Duration(cfg.TickerJitter),
+		IPv6Only:              cfg.IPv6Only,
+		SocketMark:            cfg.SocketMark,
+		BindToDevice:          cfg.BindToDevice,
+		FreeBind:              cfg.FreeBind,
+		ShutdownTimeout:       time.Duration(cfg.ShutdownTimeout),
+		TCPKeepAlive:          time.Duration(cfg.TCPKeepAlive),
+		TCPUserTimeout:        time.Duration(cfg.TCPUserTimeout),
+		FirstByteTimeout:      time.Duration(cfg.FirstByteTimeout),
+		FirstDataBytes:        cfg.FirstDataBytes,
+		IdleTimeout:           time.Duration(cfg.IdleTimeout),
+		PollTimeout:           time.Duration(cfg.PollTimeout),
+		BusyPoll:              cfg.BusyPoll,
+		BatchPollerUpdates:    cfg.BatchPollerUpdates,
+		WriteCoalesceWindow:   time.Duration(cfg.WriteCoalesceWindow),
+		WriteCoalesceMaxBytes: cfg.WriteCoalesceMaxBytes,
+		ListenBacklog:         cfg.ListenBacklog,
+		DeferAccept:           cfg.DeferAccept,
+		AcceptFilter:          cfg.AcceptFilter,
+		AcceptRate:            cfg.AcceptRate,
+		AcceptBurst:           cfg.AcceptBurst,
+		MaxFramesPerPoll:      cfg.MaxFramesPerPoll,
+		MaxFrameSize:          cfg.MaxFrameSize,
+		LazyInboundBuffer:     cfg.LazyInboundBuffer,
+		WriteBuffering:        cfg.WriteBuffering,
+		LifecycleWorkers:      cfg.LifecycleWorkers,
+		LoopTaskQueueCap:      cfg.LoopTaskQueueCap,
+		CompressionLevel:      cfg.CompressionLevel,
+		DisablePanicRecovery:  cfg.DisablePanicRecovery,
+		DebugChecks:           cfg.DebugChecks,
+		LoopProfiling:         cfg.LoopProfiling,
+		FDWatermark:           cfg.FDWatermark,
+		SlowConsumerThreshold: cfg.SlowConsumerThreshold,
+		SlowConsumerTimeout:   time.Duration(cfg.SlowConsumerTimeout),
+		UDPSendQueue:          cfg.UDPSendQueue,
+		UDPSendTimeout:        time.Duration(cfg.UDPSendTimeout),
+		MemoryLimit:           cfg.MemoryLimit,
+		PeerStats:             cfg.PeerStats,
+		PeerIPv4Prefix:        cfg.PeerIPv4Prefix,
+		PeerIPv6Prefix:        cfg.PeerIPv6Prefix,
+		PerIPMaxConnections:   cfg.PerIPMaxConnections,
+
+		// The rest can't be written in the config files.
+		EventLoopPicker:    opts.EventLoopPicker,
+		Codec:              opts.Codec,
+		ByteBufferPool:     opts.ByteBufferPool,
+		TrafficTap:         opts.TrafficTap,
+		ConnRecorder:       opts.ConnRecorder,
+		Tracer:             opts.Tracer,
+		Logger:             opts.Logger,
+		PanicHandler:       opts.PanicHandler,
+		MemoryLimitHandler: opts.MemoryLimitHandler,
+	}
+	var ok bool
+	if opts.LB, ok = loadBalancingNames[cfg.LoadBalancing]; !ok && cfg.LoadBalancing != "" {
+		return unknownName("load_balancing", cfg.LoadBalancing)
+	}
+	overload := cfg.LoopTaskQueueOverload
+	if opts.LoopTaskQueueOverload, ok = overloadPolicyNames[overload]; !ok && overload != "" {
+		return unknownName("loop_task_queue_overload", overload)
+	}
+	if opts.Compression, ok = compressionNames[cfg.Compression]; !ok && cfg.Compression != "" {
+		return unknownName("compression", cfg.Compression)
+	}
+	if opts.FDLimitPolicy, ok = fdLimitPolicyNames[cfg.FDLimitPolicy]; !ok && cfg.FDLimitPolicy != "" {
+		return unknownName("fd_limit_policy", cfg.FDLimitPolicy)
+	}
+	if opts.SlowConsumerPolicy, ok = slowConsumerPolicyNames[cfg.SlowConsumerPolicy]; !ok && cfg.SlowConsumerPolicy != "" {
+		return unknownName("slow_consumer_policy", cfg.SlowConsumerPolicy)
+	}
+	if opts.UDPSendPolicy, ok = udpSendPolicyNames[cfg.UDPSendPolicy]; !ok && cfg.UDPSendPolicy != "" {
+		return unknownName("udp_send_policy", cfg.UDPSendPolicy)
+	}
+	if opts.MemoryPolicy, ok = memoryPolicyNames[cfg.MemoryPolicy]; !ok && cfg.MemoryPolicy != "" {
+		return unknownName("memory_policy", cfg.MemoryPolicy)
+	}
+	return nil
+}
+
+func unknownName(field, name string) error {
+	return fmt.Errorf("%w: unknown %s %q", ErrInvalidConfig, field, name)
+}
+
+// LoadEnv overrides the config with the environment variables named after the prefix and the upper-cased
+// JSON names of fields, e.g. GNET_NUM_EVENT_LOOP for the prefix "GNET_", the unset variables are ignored.
+func (cfg *Config) LoadEnv(prefix string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := prefix + strings.ToUpper(v.Type().Field(i).Tag.Get("json"))
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch f := v.Field(i); f.Addr().Interface().(type) {
+		case *Duration:
+			err = f.Addr().Interface().(*Duration).UnmarshalText([]byte(s))
+		case *bool:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			f.SetBool(b)
+		case *int, *int64:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 64)
+			f.SetInt(n)
+		case *float64:
+			var x float64
+			x, err = strconv.ParseFloat(s, 64)
+			f.SetFloat(x)
+		case *string:
+			f.SetString(s)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s=%q: %v", ErrInvalidConfig, name, s, err)
+		}
+	}
+	return nil
+}
+
+// Duration is a time.Duration which is written as a string like "1m30s" in the config files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, which is used by encoding/json as well.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalYAML implements the Unmarshaler of the YAML packages (e.g. gopkg.in/yaml.v2 and v3).
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// WithConfig sets up the options in the config, Serve fails with the error of Config.Validate if the config
+// is invalid.
+func WithConfig(cfg Config) Option {
+	return func(opts *Options) {
+		if err := cfg.Validate(); err != nil {
+			opts.err = err
+			return
+		}
+		_ = cfg.apply(opts)
+	}
+}
@@ -0,0 +1,527 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/panjf2000/gnet/pool/bytebuffer"
+	prb "github.com/panjf2000/gnet/pool/ringbuffer"
+	"github.com/panjf2000/gnet/ringbuffer"
+)
+
+type stderr struct {
+	c   *stdConn
+	err error
+}
+
+type wakeReq struct {
+	c *stdConn
+}
+
+type tcpIn struct {
+	c  *stdConn
+	in *bytebuffer.ByteBuffer
+}
+
+type udpIn struct {
+	c *stdConn
+}
+
+type stdConn struct {
+	closed         int32                  // 1 if the connection has been closed, accessed atomically by ConnHandle
+	ctx            interface{}            // user-defined context
+	conn           net.Conn               // original connection
+	loop           *eventloop             // owner event-loop
+	buffer         *bytebuffer.ByteBuffer // reuse memory of inbound data as a temporary buffer
+	codec          ICodec                 // codec for TCP
+	splicer        *splicer               // relays inbound bytes to another connection, set by Splice
+	reason         error                  // reason of closing connection, passed to OnClosed
+	firstByteTimer *time.Timer            // closes the connection if the first bytes don't arrive in time
+	coalescer      writeCoalescer         // batches the data of AsyncWrite within WriteCoalesceWindow
+	sequencer      sequencer              // orders the responses of AsyncWriteSeq by the frames
+	state          StateHandler           // handles the frames instead of React if it's set
+	openedAt       time.Time              // time of opening the connection
+	bytesIn        uint64                 // total bytes read from the connection, accessed atomically
+	bytesOut       uint64                 // total bytes written to the connection, accessed atomically
+	peer           *peerEntry             // statistics of the remote IP, only tracked with PeerStats
+	localAddr      net.Addr               // local server addr
+	remoteAddr     net.Addr               // remote peer addr
+	byteBuffer     *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
+	inboundBuffer  *ringbuffer.RingBuffer // buffer for data from client
+}
+
+func newTCPConn(conn net.Conn, el *eventloop) *stdConn {
+	return &stdConn{
+		conn:          conn,
+		loop:          el,
+		codec:         el.codec,
+		inboundBuffer: prb.Get(),
+	}
+}
+
+// eventLoopOf returns the event-loop of the given connection, nil is returned for the datagrams of UDP
+// which are not connections.
+func eventLoopOf(c Conn) *eventloop {
+	if c, ok := c.(*stdConn); ok && c.loop.svr.ln.pconn == nil {
+		return c.loop
+	}
+	return nil
+}
+
+func (c *stdConn) releaseTCP() {
+	c.state = nil
+	c.stopFirstByteTimer()
+	c.ctx = nil
+	c.localAddr = nil
+	c.remoteAddr = nil
+	prb.Put(c.inboundBuffer)
+	c.inboundBuffer = nil
+	if c.buffer != nil {
+		c.loop.svr.bufPool.Put(c.buffer)
+		c.buffer = nil
+	}
+	c.releaseByteBuffer()
+}
+
+// stopFirstByteTimer stops the timer for the first bytes if it has not expired yet.
+func (c *stdConn) stopFirstByteTimer() {
+	if c.firstByteTimer != nil {
+		c.firstByteTimer.Stop()
+		c.firstByteTimer = nil
+	}
+}
+
+func newUDPConn(el *eventloop, localAddr, remoteAddr net.Addr, buf *bytebuffer.ByteBuffer) *stdConn {
+	return &stdConn{
+		loop:       el,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		buffer:     buf,
+	}
+}
+
+func (c *stdConn) releaseUDP() {
+	c.ctx = nil
+	c.loop.svr.bufPool.Put(c.buffer)
+	c.buffer = nil
+}
+
+func (c *stdConn) releaseByteBuffer() {
+	if c.byteBuffer != nil {
+		c.loop.svr.bufPool.Put(c.byteBuffer)
+		c.byteBuffer = nil
+	}
+}
+
+// write writes the data to the connection, passing it to TrafficTap beforehand and the written bytes to Tracer.
+func (c *stdConn) write(buf []byte) (int, error) {
+	c.loop.tap(c, Outbound, buf)
+	if c.loop.svr.opts.LoopProfiling {
+		defer c.loop.profile.since(&c.loop.profile.flush, time.Now())
+	}
+	n, err := c.conn.Write(buf)
+	c.countOut(n)
+	c.loop.traceFlush(c, n)
+	return n, err
+}
+
+// countIn counts the bytes read from the connection.
+func (c *stdConn) countIn(n int) {
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	atomic.AddUint64(&c.loop.bytesIn, uint64(n))
+	if c.peer != nil {
+		c.peer.addIn(n)
+	}
+}
+
+// countOut counts the bytes written to the connection.
+func (c *stdConn) countOut(n int) {
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	atomic.AddUint64(&c.loop.bytesOut, uint64(n))
+	if c.peer != nil {
+		c.peer.addOut(n)
+	}
+}
+
+// read decodes a frame from the inbound data, the frame larger than MaxFrameSize fails with ErrFrameTooLarge.
+func (c *stdConn) read() ([]byte, error) {
+	frame, err := c.codec.Decode(c)
+	if max := c.loop.svr.opts.MaxFrameSize; max > 0 && len(frame) > max {
+		return nil, ErrFrameTooLarge
+	}
+	return frame, err
+}
+
+// ================================= Public APIs of gnet.Conn =================================
+
+func (c *stdConn) Read() []byte {
+	if c.inboundBuffer.IsEmpty() {
+		return c.buffer.Bytes()
+	}
+	c.releaseByteBuffer()
+	c.byteBuffer = c.loop.svr.bufPool.Get()
+	head, tail := c.inboundBuffer.LazyReadAll()
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail)
+	_, _ = c.byteBuffer.Write(c.buffer.Bytes())
+	return c.byteBuffer.Bytes()
+}
+
+func (c *stdConn) ResetBuffer() {
+	c.buffer.Reset()
+	c.inboundBuffer.Reset()
+	c.releaseByteBuffer()
+}
+
+func (c *stdConn) ReadN(n int) (size int, buf []byte) {
+	inBufferLen := c.inboundBuffer.Length()
+	tempBufferLen := c.buffer.Len()
+	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
+		n = totalLen
+	}
+	size = n
+	if c.inboundBuffer.IsEmpty() {
+		buf = c.buffer.B[:n]
+		return
+	}
+	head, tail := c.inboundBuffer.LazyRead(n)
+	c.releaseByteBuffer()
+	c.byteBuffer = c.loop.svr.bufPool.Get()
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail)
+	if inBufferLen >= n {
+		buf = c.byteBuffer.Bytes()
+		return
+	}
+
+	restSize := n - inBufferLen
+	_, _ = c.byteBuffer.Write(c.buffer.B[:restSize])
+	buf = c.byteBuffer.Bytes()
+	return
+}
+
+func (c *stdConn) ShiftN(n int) (size int) {
+	inBufferLen := c.inboundBuffer.Length()
+	tempBufferLen := c.buffer.Len()
+	if inBufferLen+tempBufferLen < n || n <= 0 {
+		c.ResetBuffer()
+		size = inBufferLen + tempBufferLen
+		return
+	}
+	size = n
+	if c.inboundBuffer.IsEmpty() {
+		c.buffer.B = c.buffer.B[n:]
+		return
+	}
+
+	c.releaseByteBuffer()
+
+	if inBufferLen >= n {
+		c.inboundBuffer.Shift(n)
+		return
+	}
+	c.inboundBuffer.Reset()
+
+	restSize := n - inBufferLen
+	c.buffer.B = c.buffer.B[restSize:]
+	return
+}
+
+func (c *stdConn) Peek(n int) (buf []byte, err error) {
+	var size int
+	if size, buf = c.ReadN(n); size < n {
+		err = io.ErrShortBuffer
+	}
+	return
+}
+
+func (c *stdConn) Next(n int) (buf []byte, err error) {
+	inBufferLen := c.inboundBuffer.Length()
+	if totalLen := inBufferLen + c.buffer.Len(); totalLen < n {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = totalLen
+	}
+	if c.inboundBuffer.IsEmpty() {
+		buf = c.buffer.B[:n]
+		c.buffer.B = c.buffer.B[n:]
+		return
+	}
+	// ReadN copies the data into the byte buffer, thus it stays intact after shifting.
+	_, buf = c.ReadN(n)
+	if inBufferLen >= n {
+		c.inboundBuffer.Shift(n)
+		return
+	}
+	c.inboundBuffer.Reset()
+	c.buffer.B = c.buffer.B[n-inBufferLen:]
+	return
+}
+
+func (c *stdConn) BufferLength() int {
+	return c.inboundBuffer.Length() + c.buffer.Len()
+}
+
+func (c *stdConn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *stdConn) BytesRead() uint64    { return atomic.LoadUint64(&c.bytesIn) }
+func (c *stdConn) BytesWritten() uint64 { return atomic.LoadUint64(&c.bytesOut) }
+func (c *stdConn) OpenedAt() time.Time  { return c.openedAt }
+
+func (c *stdConn) AsyncWrite(buf []byte) (err error) {
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		pool := c.loop.svr.bufPool
+		if opts := c.loop.svr.opts; opts.WriteCoalesceWindow > 0 {
+			flush := func() error {
+				if bb := c.coalescer.take(); bb != nil {
+					_, _ = c.write(bb.B)
+					pool.Put(bb)
+				}
+				return nil
+			}
+			switch now, schedule := c.coalescer.add(pool, encodedBuf, opts.WriteCoalesceMaxBytes); {
+			case now:
+				c.loop.ch <- flush
+			case schedule:
+				time.AfterFunc(opts.WriteCoalesceWindow, func() { c.loop.ch <- flush })
+			}
+			return
+		}
+		bb := pool.Get()
+		_, _ = bb.Write(encodedBuf)
+		c.loop.ch <- func() error {
+			_, _ = c.write(bb.B)
+			pool.Put(bb)
+			return nil
+		}
+	}
+	return
+}
+
+func (c *stdConn) AsyncWriteWithTimeout(buf []byte, d time.Duration) (err error) {
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		pool := c.loop.svr.bufPool
+		bb := pool.Get()
+		_, _ = bb.Write(encodedBuf)
+		c.loop.ch <- func() error {
+			defer pool.Put(bb)
+			// The writes are blocking on Windows, so the data reaches the socket once Write returns.
+			_ = c.conn.SetWriteDeadline(time.Now().Add(d))
+			_, err := c.write(bb.B)
+			_ = c.conn.SetWriteDeadline(time.Time{})
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return c.loop.loopCloseConn(c, ErrWriteTimeout)
+			}
+			return nil
+		}
+	}
+	return
+}
+
+func (c *stdConn) AsyncWriteAfter(d time.Duration, buf []byte) (err error) {
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		el := c.loop
+		pool := el.svr.bufPool
+		bb := pool.Get()
+		_, _ = bb.Write(encodedBuf)
+		time.AfterFunc(d, func() {
+			el.ch <- func() error {
+				if _, ok := el.connections[c]; ok {
+					_, _ = c.write(bb.B)
+				}
+				pool.Put(bb)
+				return nil
+			}
+		})
+	}
+	return
+}
+
+func (c *stdConn) FrameSeq() uint64 {
+	return c.sequencer.frame
+}
+
+func (c *stdConn) AsyncWriteSeq(seq uint64, buf []byte) (err error) {
+	var encodedBuf []byte
+	if buf != nil {
+		if encodedBuf, err = c.codec.Encode(c, buf); err != nil {
+			return
+		}
+		// The response may be held for a while, so it can't refer to the memory of the caller.
+		encodedBuf = append([]byte(nil), encodedBuf...)
+	}
+	c.loop.ch <- func() error {
+		c.sequencer.push(seq, encodedBuf, func(b []byte) { _, _ = c.write(b) })
+		return nil
+	}
+	return
+}
+
+func (c *stdConn) SendFD(fd int) error {
+	return ErrUnsupportedProtocol
+}
+
+func (c *stdConn) SendTo(buf []byte) (err error) {
+	_, err = c.loop.svr.ln.pconn.WriteTo(buf, c.remoteAddr)
+	return
+}
+
+func (c *stdConn) Wake() error {
+	c.loop.ch <- wakeReq{c}
+	return nil
+}
+
+func (c *stdConn) Execute(fn func(c Conn)) error {
+	return c.loop.execute(func() error {
+		if _, ok := c.loop.connections[c]; ok {
+			fn(c)
+		}
+		return nil
+	})
+}
+
+func (c *stdConn) SetUserTimeout(d time.Duration) error {
+	if !strings.HasPrefix(c.loop.svr.ln.network, "tcp") {
+		return ErrUnsupportedProtocol
+	}
+	return setUserTimeout(c.Fd(), d)
+}
+
+func (c *stdConn) SetTOS(_ int) error {
+	return ErrUnsupportedPlatform
+}
+
+func (c *stdConn) TCPInfo() (*TCPInfo, error) {
+	if !strings.HasPrefix(c.loop.svr.ln.network, "tcp") {
+		return nil, ErrUnsupportedProtocol
+	}
+	return tcpInfo(c.Fd())
+}
+
+func (c *stdConn) SetPriority(_ Priority) {}
+
+func (c *stdConn) SetWritePacing(_ int, _ time.Duration) {}
+
+func (c *stdConn) SetLowLatency(_ bool) {}
+
+func (c *stdConn) MigrateTo(_ int) error {
+	return ErrUnsupportedPlatform
+}
+
+func (c *stdConn) AfterFunc(d time.Duration, fn func(c Conn)) (stop func() bool) {
+	el := c.loop
+	t := time.AfterFunc(d, func() {
+		el.ch <- func() error {
+			if _, ok := el.connections[c]; ok {
+				fn(c)
+			}
+			return nil
+		}
+	})
+	return t.Stop
+}
+
+func (c *stdConn) RetainFrame(frame []byte) []byte {
+	if frame == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(frame)), frame...)
+}
+
+func (c *stdConn) SendToWithControl(_ []byte, _ *ControlMessage) error {
+	return ErrUnsupportedPlatform
+}
+
+func (c *stdConn) ControlMessage() *ControlMessage {
+	return nil
+}
+
+func (c *stdConn) LoopTime() time.Time {
+	return c.loop.loopTime()
+}
+
+func (c *stdConn) Flush() error {
+	return nil
+}
+
+func (c *stdConn) Close() error {
+	c.loop.ch <- func() error {
+		return c.loop.loopCloseConn(c, ErrClosedByHandler)
+	}
+	return nil
+}
+
+func (c *stdConn) Handle() ConnHandle {
+	return ConnHandle{c}
+}
+
+// Alive reports whether the connection has been closed for ConnHandle, it is safe to be called by any goroutine.
+func (c *stdConn) Alive() bool {
+	return atomic.LoadInt32(&c.closed) == 0
+}
+
+func (c *stdConn) closeOpened() error {
+	c.loop.ch <- func() error {
+		if _, ok := c.loop.connections[c]; !ok {
+			return nil
+		}
+		return c.loop.loopCloseConn(c, ErrClosedByHandler)
+	}
+	return nil
+}
+
+func (c *stdConn) Fd() (fd int) {
+	var sc interface{} = c.conn
+	if c.conn == nil {
+		sc = c.loop.svr.ln.pconn
+	}
+	fd = -1
+	if sc, ok := sc.(syscall.Conn); ok {
+		if rc, err := sc.SyscallConn(); err == nil {
+			_ = rc.Control(func(s uintptr) {
+				fd = int(s)
+			})
+		}
+	}
+	return
+}
+
+func (c *stdConn) SetCodec(codec ICodec) {
+	if codec == nil {
+		codec = c.loop.svr.codec
+	}
+	c.codec = codec
+}
+
+func (c *stdConn) Context() interface{}                { return c.ctx }
+func (c *stdConn) SetState(h StateHandler)             { c.state = h }
+func (c *stdConn) SetContext(ctx interface{})          { c.ctx = ctx }
+func (c *stdConn) LocalAddr() net.Addr                 { return c.localAddr }
+func (c *stdConn) RemoteAddr() net.Addr                { return c.remoteAddr }
+func (c *stdConn) EventLoopContext() interface{}       { return c.loop.ctx }
+func (c *stdConn) SetEventLoopContext(ctx interface{}) { c.loop.ctx = ctx }
+func (c *stdConn) Server() Server                      { return *c.loop.svr.server }
+func (c *stdConn) LoopIndex() int                      { return c.loop.svr.loopIndex(c.loop) }
+
+func (c *stdConn) RecvAddr() net.Addr {
+	if c.loop.svr.ln.pconn != nil {
+		return c.remoteAddr
+	}
+	return nil
+}
@@ -8,8 +8,14 @@
 package gnet
 
 import (
+	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/internal/netpoll"
 	"github.com/panjf2000/gnet/pool/bytebuffer"
 	prb "github.com/panjf2000/gnet/pool/ringbuffer"
@@ -19,12 +25,50 @@ import (
 
 type conn struct {
 	fd             int                    // file descriptor
+	closed         int32                  // 1 if the connection has been closed, accessed atomically by ConnHandle
 	sa             unix.Sockaddr          // remote socket address
 	ctx            interface{}            // user-defined context
 	loop           *eventloop             // connected event-loop
 	buffer         []byte                 // reuse memory of inbound data as a temporary buffer
 	codec          ICodec                 // codec for TCP
 	opened         bool                   // connection opened event fired
+	memHeld        int64                  // bytes held in buffers, reported to the memory accountant
+	outHeld        int64                  // bytes held in the outbound buffer, which is a part of memHeld
+	outFlushed     uint64                 // total bytes flushed from the outbound buffer, or dropped by SlowConsumerDropOldest
+	openedAt       time.Time              // time of opening the connection
+	bytesIn        uint64                 // total bytes read from the socket, accessed atomically
+	bytesOut       uint64                 // total bytes written to the socket, accessed atomically
+	peer           *peerEntry             // statistics of the remote IP, only tracked with PeerStats
+	splicer        *splicer               // relays inbound bytes to another connection, set by Splice
+	throttled      []*splicer             // relays whose sources hold their reads until the outbound buffer is drained
+	readHolds      uint8                  // reasons for holding the readable events, see holdRead
+	firstByteTimer *internal.Timer        // closes the connection if the first bytes don't arrive in time
+	idleTimer      *internal.Timer        // closes the connection if it receives nothing for IdleTimeout
+	lastActive     time.Time              // time of receiving data last time, only tracked with IdleTimeout, FDWatermark or while draining
+	priority       Priority               // priority set by SetPriority
+	slowTimer      *internal.Timer        // fires when the outbound buffer stays above SlowConsumerThreshold
+	pacer          *writePacer            // paces the writes, set by SetWritePacing
+	paused         int32                  // 1 if the sources are pushed back by SlowConsumerPause, accessed atomically
+	direct         int32                  // state of the direct writes of SetLowLatency, accessed atomically
+	directRest     []byte                 // rest of the direct write left for the event-loop, guarded by direct
+	queuedWrites   int32                  // AsyncWrite tasks waiting for the event-loop, which hold the direct writes off
+	pendingTasks   int32                  // other tasks of the connection waiting for the event-loop, which hold draining off
+	outMarks       []uint64               // ends of the writes in the outbound buffer, only kept for SlowConsumerDropOldest
+	udpPeer        string                 // remote peer of the connected UDP socket, empty for other connections
+	cm             *ControlMessage        // control messages of the datagram received with UDPControl
+	coalescer      writeCoalescer         // batches the data of AsyncWrite within WriteCoalesceWindow
+	sequencer      sequencer              // orders the responses of AsyncWriteSeq by the frames
+	state          StateHandler           // handles the frames instead of React if it's set
+	previewed      bool                   // OnFirstData of FirstDataHandler has fired
+	resuming       bool                   // decoding the rest of frames is deferred by MaxFramesPerPoll
+	migrating      bool                   // handed over to another event-loop by MigrateTo, but not adopted by it yet
+	pendingOpen    bool                   // not polled until OnOpened returns on the workers of LifecycleWorkers
+	unpolled       bool                   // failed to be registered with the poller again by MigrateTo
+	lifecycle      int32                  // lifecycleOpening or lifecycleClosing while OnOpened runs on the workers
+	closedJob      func()                 // runs OnClosed on the workers, deferred if the connection is still opening
+	staged         bool                   // the writes are staged in the outbound buffer by WriteBuffering
+	deflater       *deflater              // compresses the outbound stream if Compression is in effect
+	inflater       *inflater              // decompresses the inbound stream if Compression is in effect
 	localAddr      net.Addr               // local addr
 	remoteAddr     net.Addr               // remote addr
 	byteBuffer     *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
@@ -33,105 +77,367 @@ type conn struct {
 }
 
 func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
-	return &conn{
+	c := &conn{
 		fd:             fd,
 		sa:             sa,
 		loop:           el,
 		codec:          el.codec,
-		inboundBuffer:  prb.Get(),
 		outboundBuffer: prb.Get(),
 	}
+	if el.svr.opts.LazyInboundBuffer {
+		c.inboundBuffer = ringbuffer.New(0)
+	} else {
+		c.inboundBuffer = prb.Get()
+	}
+	return c
 }
 
 func (c *conn) releaseTCP() {
+	c.stopTCP()
+	c.releaseBuffers()
+}
+
+// stopTCP stops everything of the closed connection which is driven by the event-loop.
+func (c *conn) stopTCP() {
+	c.state = nil
 	c.opened = false
+	c.stopFirstByteTimer()
+	c.stopSlowConsumer()
+	c.stopPacing()
+	c.outMarks = c.outMarks[:0]
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	if c.inflater != nil {
+		c.inflater.close()
+		c.inflater = nil
+	}
+	c.deflater = nil
+	c.udpPeer = ""
 	c.sa = nil
+}
+
+// releaseBuffers releases the context, the addresses and the buffers of the closed connection, after OnClosed.
+func (c *conn) releaseBuffers() {
 	c.ctx = nil
 	c.buffer = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
-	prb.Put(c.inboundBuffer)
+	if c.inboundBuffer.Cap() > 0 || !c.loop.svr.opts.LazyInboundBuffer {
+		prb.Put(c.inboundBuffer)
+	}
 	prb.Put(c.outboundBuffer)
 	c.inboundBuffer = nil
 	c.outboundBuffer = nil
-	bytebuffer.Put(c.byteBuffer)
-	c.byteBuffer = nil
+	c.releaseByteBuffer()
 }
 
 func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
 	return &conn{
 		fd:         fd,
 		sa:         sa,
+		loop:       el,
 		localAddr:  el.svr.ln.lnaddr,
-		remoteAddr: netpoll.SockaddrToUDPAddr(sa),
+		remoteAddr: sockaddrToPacketSrcAddr(el.svr.ln.network, sa),
+	}
+}
+
+// sockaddrToPacketSrcAddr converts the source Sockaddr of the packet to the net.Addr of the network.
+func sockaddrToPacketSrcAddr(network string, sa unix.Sockaddr) net.Addr {
+	switch {
+	case network == "packet":
+		return sockaddrToPacketAddr(sa)
+	case isRawNetwork(network):
+		return netpoll.SockaddrToIPAddr(sa)
+	}
+	return netpoll.SockaddrToUDPOrUnixgramAddr(sa)
+}
+
+func newConnectedUDPConn(fd int, el *eventloop, sa unix.Sockaddr, peer string) *conn {
+	c := newTCPConn(fd, el, sa)
+	c.codec = new(BuiltInFrameCodec)
+	c.udpPeer = peer
+	return c
+}
+
+// eventLoopOf returns the event-loop of the given connection, nil is returned for the datagrams of UDP
+// which are not connections.
+func eventLoopOf(c Conn) *eventloop {
+	if c, ok := c.(*conn); ok {
+		if el := c.owner(); el.svr.ln.pconn == nil || c.udpPeer != "" {
+			return el
+		}
+	}
+	return nil
+}
+
+// stopFirstByteTimer stops the timer for the first bytes if it has not expired yet.
+func (c *conn) stopFirstByteTimer() {
+	if c.firstByteTimer != nil {
+		c.firstByteTimer.Stop()
+		c.firstByteTimer = nil
 	}
 }
 
 func (c *conn) releaseUDP() {
 	c.ctx = nil
-	c.localAddr = nil
-	c.remoteAddr = nil
+	c.cm = nil
 }
 
 func (c *conn) open(buf []byte) {
-	n, err := unix.Write(c.fd, buf)
+	c.loop.tap(c, Outbound, buf)
+	if c.deflater != nil {
+		buf = c.deflater.compress(buf)
+	}
+	if c.pacer != nil {
+		c.bufferOutbound(buf)
+		return
+	}
+	if c.takeSocket() {
+		defer c.releaseSocket()
+	}
+	n, err := c.writeSocket(buf)
 	if err != nil {
-		_, _ = c.outboundBuffer.Write(buf)
+		c.bufferOutbound(buf)
 		return
 	}
+	c.countOut(n)
+	c.loop.traceFlush(c, n)
 
 	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
+		c.bufferOutbound(buf[n:])
 	}
 }
 
+// keepInbound keeps the inbound data not consumed by the handler in the inbound buffer, which is only held
+// while there is a partial frame in LazyInboundBuffer mode.
+func (c *conn) keepInbound() {
+	lazy := c.loop.svr.opts.LazyInboundBuffer
+	if len(c.buffer) > 0 {
+		if lazy && c.inboundBuffer.Cap() == 0 {
+			c.inboundBuffer = prb.Get()
+		}
+		_, _ = c.inboundBuffer.Write(c.buffer)
+	} else if lazy && c.inboundBuffer.IsEmpty() && c.inboundBuffer.Cap() > 0 {
+		prb.Put(c.inboundBuffer)
+		c.inboundBuffer = ringbuffer.New(0)
+	}
+	c.buffer = nil
+}
+
+func (c *conn) releaseByteBuffer() {
+	if c.byteBuffer != nil {
+		c.loop.svr.bufPool.Put(c.byteBuffer)
+		c.byteBuffer = nil
+	}
+}
+
+// read decodes a frame from the inbound data, the frame larger than MaxFrameSize fails with ErrFrameTooLarge.
 func (c *conn) read() ([]byte, error) {
-	return c.codec.Decode(c)
+	frame, err := c.codec.Decode(c)
+	if max := c.loop.svr.opts.MaxFrameSize; max > 0 && len(frame) > max {
+		return nil, ErrFrameTooLarge
+	}
+	return frame, err
 }
 
 func (c *conn) write(buf []byte) {
+	c.loop.tap(c, Outbound, buf)
+	if c.udpPeer != "" {
+		// Datagrams must not be merged in the outbound buffer, drop it if the socket buffer is full.
+		if n, err := c.writeSocket(buf); err == nil {
+			c.countOut(n)
+			c.loop.traceFlush(c, n)
+		}
+		return
+	}
+	if c.deflater != nil {
+		buf = c.deflater.compress(buf)
+	}
+	if c.loop.svr.opts.WriteBuffering {
+		c.loop.stage(c, buf)
+		return
+	}
+	if c.pacer != nil {
+		// The paced writes are flushed on the writable events by the budget of interval.
+		c.bufferOutbound(buf)
+		if !c.pacer.held {
+			_ = c.loop.poller.ModReadWrite(c.fd)
+		}
+		return
+	}
+	if c.takeSocket() {
+		defer c.releaseSocket()
+	}
 	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Write(buf)
+		c.bufferOutbound(buf)
 		return
 	}
-	n, err := unix.Write(c.fd, buf)
+	n, err := c.writeSocket(buf)
 	if err != nil {
 		if err == unix.EAGAIN {
-			_, _ = c.outboundBuffer.Write(buf)
+			c.bufferOutbound(buf)
 			_ = c.loop.poller.ModReadWrite(c.fd)
 			return
 		}
-		_ = c.loop.loopCloseConn(c, err)
+		_ = c.loop.loopIOError(c, "write", err)
 		return
 	}
+	c.countOut(n)
+	c.loop.traceFlush(c, n)
 	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
+		c.bufferOutbound(buf[n:])
 		_ = c.loop.poller.ModReadWrite(c.fd)
 	}
 }
 
+// countIn counts the bytes read from the socket of connection.
+func (c *conn) countIn(n int) {
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	atomic.AddUint64(&c.loop.bytesIn, uint64(n))
+	if c.peer != nil {
+		c.peer.addIn(n)
+	}
+}
+
+// countOut counts the bytes written to the socket of connection.
+func (c *conn) countOut(n int) {
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	atomic.AddUint64(&c.loop.bytesOut, uint64(n))
+	if c.peer != nil {
+		c.peer.addOut(n)
+	}
+}
+
+// writeSocket writes to the socket of connection, the time of writing is profiled if LoopProfiling is set.
+func (c *conn) writeSocket(buf []byte) (int, error) {
+	if c.loop.svr.opts.LoopProfiling {
+		defer c.loop.profile.since(&c.loop.profile.flush, time.Now())
+	}
+	return unix.Write(c.fd, buf)
+}
+
 func (c *conn) sendTo(buf []byte) error {
-	return unix.Sendto(c.fd, buf, 0, c.sa)
+	if c.loop.svr.opts.LoopProfiling {
+		defer c.loop.profile.since(&c.loop.profile.flush, time.Now())
+	}
+	if c.udpPeer != "" {
+		n, err := unix.Write(c.fd, buf)
+		if err == nil {
+			c.countOut(n)
+		}
+		return err
+	}
+	return c.loop.sendUDP(c.fd, buf, c.sa)
+}
+
+func (c *conn) sendToWithControl(buf []byte, cm *ControlMessage) error {
+	if !strings.HasPrefix(c.loop.svr.ln.network, "udp") || c.loop.svr.ln.pconn == nil {
+		return ErrUnsupportedProtocol
+	}
+	oob, err := marshalControlMessage(cm, c.sa)
+	if err != nil {
+		return err
+	}
+	if c.loop.svr.opts.LoopProfiling {
+		defer c.loop.profile.since(&c.loop.profile.flush, time.Now())
+	}
+	if c.udpPeer != "" {
+		n, err := unix.SendmsgN(c.fd, buf, oob, nil, 0)
+		if err == nil {
+			c.countOut(n)
+		}
+		return err
+	}
+	return unix.Sendmsg(c.fd, buf, oob, c.sa, 0)
+}
+
+// asyncWrite is the task of AsyncWrite and Wake, which is pooled along with its buffer, so that the hot paths of
+// them allocate nothing.
+type asyncWrite struct {
+	c  *conn
+	el *eventloop // event-loop the task is queued to
+	bb *bytebuffer.ByteBuffer
+}
+
+var asyncWritePool = sync.Pool{New: func() interface{} { return new(asyncWrite) }}
+
+// runAsyncWrite writes the data of AsyncWrite on the event-loop.
+func runAsyncWrite(arg interface{}) error {
+	w := arg.(*asyncWrite)
+	if w.c.moved(w.el) {
+		w.el = w.c.loop
+		return w.el.poller.TriggerTask(runAsyncWrite, w)
+	}
+	c, bb := w.c, w.bb
+	w.c, w.el, w.bb = nil, nil, nil
+	asyncWritePool.Put(w)
+	if c.opened {
+		c.write(bb.B)
+		c.loop.accountMemory(c)
+	}
+	atomic.AddInt32(&c.queuedWrites, -1)
+	c.loop.svr.bufPool.Put(bb)
+	return nil
+}
+
+// dropAsyncWrite releases the asynchronous write which never runs.
+func dropAsyncWrite(arg interface{}) {
+	w := arg.(*asyncWrite)
+	c, bb := w.c, w.bb
+	pool := w.el.svr.bufPool
+	w.c, w.el, w.bb = nil, nil, nil
+	asyncWritePool.Put(w)
+	atomic.AddInt32(&c.queuedWrites, -1)
+	pool.Put(bb)
+}
+
+// runWake triggers the React event of Wake on the event-loop.
+func runWake(arg interface{}) error {
+	w := arg.(*asyncWrite)
+	if w.c.moved(w.el) {
+		w.el = w.c.loop
+		return w.el.poller.TriggerTask(runWake, w)
+	}
+	c := w.c
+	w.c, w.el = nil, nil
+	asyncWritePool.Put(w)
+	atomic.AddInt32(&c.pendingTasks, -1)
+	if c.opened {
+		c.loop.current = c
+		err := c.loop.loopWake(c)
+		c.loop.current = nil
+		return err
+	}
+	return nil
 }
 
 // ================================= Public APIs of gnet.Conn =================================
 
 func (c *conn) Read() []byte {
+	c.checkBuffer("Read")
 	if c.inboundBuffer.IsEmpty() {
 		return c.buffer
 	}
-	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer)
+	c.releaseByteBuffer()
+	c.byteBuffer = c.loop.svr.bufPool.Get()
+	head, tail := c.inboundBuffer.LazyReadAll()
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail)
+	_, _ = c.byteBuffer.Write(c.buffer)
 	return c.byteBuffer.Bytes()
 }
 
 func (c *conn) ResetBuffer() {
+	c.checkBuffer("ResetBuffer")
 	c.buffer = c.buffer[:0]
 	c.inboundBuffer.Reset()
-	bytebuffer.Put(c.byteBuffer)
-	c.byteBuffer = nil
+	c.releaseByteBuffer()
 }
 
 func (c *conn) ReadN(n int) (size int, buf []byte) {
+	c.checkBuffer("ReadN")
 	inBufferLen := c.inboundBuffer.Length()
 	tempBufferLen := len(c.buffer)
 	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
@@ -143,7 +449,8 @@ func (c *conn) ReadN(n int) (size int, buf []byte) {
 		return
 	}
 	head, tail := c.inboundBuffer.LazyRead(n)
-	c.byteBuffer = bytebuffer.Get()
+	c.releaseByteBuffer()
+	c.byteBuffer = c.loop.svr.bufPool.Get()
 	_, _ = c.byteBuffer.Write(head)
 	_, _ = c.byteBuffer.Write(tail)
 	if inBufferLen >= n {
@@ -158,6 +465,7 @@ func (c *conn) ReadN(n int) (size int, buf []byte) {
 }
 
 func (c *conn) ShiftN(n int) (size int) {
+	c.checkBuffer("ShiftN")
 	inBufferLen := c.inboundBuffer.Length()
 	tempBufferLen := len(c.buffer)
 	if inBufferLen+tempBufferLen < n || n <= 0 {
@@ -171,8 +479,7 @@ func (c *conn) ShiftN(n int) (size int) {
 		return
 	}
 
-	bytebuffer.Put(c.byteBuffer)
-	c.byteBuffer = nil
+	c.releaseByteBuffer()
 
 	if inBufferLen >= n {
 		c.inboundBuffer.Shift(n)
@@ -185,40 +492,352 @@ func (c *conn) ShiftN(n int) (size int) {
 	return
 }
 
+func (c *conn) Peek(n int) (buf []byte, err error) {
+	c.checkBuffer("Peek")
+	var size int
+	if size, buf = c.ReadN(n); size < n {
+		err = io.ErrShortBuffer
+	}
+	return
+}
+
+func (c *conn) Next(n int) (buf []byte, err error) {
+	c.checkBuffer("Next")
+	inBufferLen := c.inboundBuffer.Length()
+	if totalLen := inBufferLen + len(c.buffer); totalLen < n {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = totalLen
+	}
+	if c.inboundBuffer.IsEmpty() {
+		buf = c.buffer[:n]
+		c.buffer = c.buffer[n:]
+		return
+	}
+	// ReadN copies the data into the byte buffer, thus it stays intact after shifting.
+	_, buf = c.ReadN(n)
+	if inBufferLen >= n {
+		c.inboundBuffer.Shift(n)
+		return
+	}
+	c.inboundBuffer.Reset()
+	c.buffer = c.buffer[n-inBufferLen:]
+	return
+}
+
 func (c *conn) BufferLength() int {
+	c.checkBuffer("BufferLength")
 	return c.inboundBuffer.Length() + len(c.buffer)
 }
 
+func (c *conn) OutboundBuffered() int {
+	c.checkBuffer("OutboundBuffered")
+	if c.outboundBuffer == nil {
+		return 0
+	}
+	return c.outboundBuffer.Length()
+}
+
+func (c *conn) BytesRead() uint64    { return atomic.LoadUint64(&c.bytesIn) }
+func (c *conn) BytesWritten() uint64 { return atomic.LoadUint64(&c.bytesOut) }
+func (c *conn) OpenedAt() time.Time  { return c.openedAt }
+
 func (c *conn) AsyncWrite(buf []byte) (err error) {
+	if c.congested() {
+		return ErrSlowConsumer
+	}
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		if c.writeDirect(encodedBuf) {
+			return nil
+		}
+		el := c.owner()
+		pool := el.svr.bufPool
+		if opts := el.svr.opts; opts.WriteCoalesceWindow > 0 {
+			flush := func() error {
+				if bb := c.coalescer.take(); bb != nil {
+					if c.opened {
+						c.write(bb.B)
+						c.loop.accountMemory(c)
+					}
+					pool.Put(bb)
+				}
+				return nil
+			}
+			switch now, schedule := c.coalescer.add(pool, encodedBuf, opts.WriteCoalesceMaxBytes); {
+			case now:
+				return c.submit(flush)
+			case schedule:
+				// The window is timed by the timing wheel of the event-loop, the shorter one than its tick ends
+				// as soon as the event-loop gets to the batch.
+				if err = c.submit(func() error {
+					if window := opts.WriteCoalesceWindow; window >= timerTick && c.opened {
+						el := c.loop
+						el.timingWheel().AfterFunc(window, c.follow(el, flush))
+						return nil
+					}
+					return flush()
+				}); err != nil {
+					if bb := c.coalescer.take(); bb != nil {
+						pool.Put(bb)
+					}
+				}
+				return
+			}
+			return nil
+		}
+		w := asyncWritePool.Get().(*asyncWrite)
+		w.c, w.el, w.bb = c, el, pool.Get()
+		_, _ = w.bb.Write(encodedBuf)
+		// The direct writes must not overtake the queued ones.
+		atomic.AddInt32(&c.queuedWrites, 1)
+		if err = el.submitTask(runAsyncWrite, dropAsyncWrite, w); err != nil {
+			dropAsyncWrite(w)
+		}
+	}
+	return
+}
+
+func (c *conn) AsyncWriteWithTimeout(buf []byte, d time.Duration) (err error) {
+	if c.congested() {
+		return ErrSlowConsumer
+	}
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		pool := c.owner().svr.bufPool
+		bb := pool.Get()
+		_, _ = bb.Write(encodedBuf)
+		if err = c.submit(func() error {
+			if c.opened {
+				c.write(bb.B)
+				c.loop.accountMemory(c)
+				c.loop.watchWrite(c, d)
+			}
+			pool.Put(bb)
+			return nil
+		}); err != nil {
+			pool.Put(bb)
+		}
+	}
+	return
+}
+
+func (c *conn) AsyncWriteAfter(d time.Duration, buf []byte) (err error) {
+	if c.congested() {
+		return ErrSlowConsumer
+	}
 	var encodedBuf []byte
 	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
-		return c.loop.poller.Trigger(func() error {
+		pool := c.owner().svr.bufPool
+		bb := pool.Get()
+		_, _ = bb.Write(encodedBuf)
+		write := func() error {
 			if c.opened {
-				c.write(encodedBuf)
+				c.write(bb.B)
+				c.loop.accountMemory(c)
 			}
+			pool.Put(bb)
 			return nil
-		})
+		}
+		if err = c.submit(func() error {
+			if !c.opened {
+				pool.Put(bb)
+				return nil
+			}
+			el := c.loop
+			el.timingWheel().AfterFunc(d, c.follow(el, write))
+			return nil
+		}); err != nil {
+			pool.Put(bb)
+		}
 	}
 	return
 }
 
+func (c *conn) FrameSeq() uint64 {
+	return c.sequencer.frame
+}
+
+func (c *conn) AsyncWriteSeq(seq uint64, buf []byte) (err error) {
+	if c.congested() {
+		return ErrSlowConsumer
+	}
+	var encodedBuf []byte
+	if buf != nil {
+		if encodedBuf, err = c.codec.Encode(c, buf); err != nil {
+			return
+		}
+		// The response may be held for a while, so it can't refer to the memory of the caller.
+		encodedBuf = append([]byte(nil), encodedBuf...)
+	}
+	return c.submit(func() error {
+		if c.opened {
+			c.sequencer.push(seq, encodedBuf, c.write)
+			c.loop.accountMemory(c)
+		}
+		return nil
+	})
+}
+
+// dropWake releases the Wake which never runs.
+func dropWake(arg interface{}) {
+	w := arg.(*asyncWrite)
+	c := w.c
+	w.c, w.el = nil, nil
+	asyncWritePool.Put(w)
+	atomic.AddInt32(&c.pendingTasks, -1)
+}
+
 func (c *conn) SendTo(buf []byte) error {
 	return c.sendTo(buf)
 }
 
-func (c *conn) Wake() error {
-	return c.loop.poller.Trigger(func() error {
-		return c.loop.loopWake(c)
+func (c *conn) SendToWithControl(buf []byte, cm *ControlMessage) error {
+	return c.sendToWithControl(buf, cm)
+}
+
+func (c *conn) ControlMessage() *ControlMessage {
+	return c.cm
+}
+
+func (c *conn) Wake() (err error) {
+	el := c.owner()
+	w := asyncWritePool.Get().(*asyncWrite)
+	w.c, w.el = c, el
+	atomic.AddInt32(&c.pendingTasks, 1)
+	if err = el.submitTask(runWake, dropWake, w); err != nil {
+		dropWake(w)
+	}
+	return
+}
+
+func (c *conn) Execute(fn func(c Conn)) error {
+	return c.submit(func() error {
+		if c.opened {
+			c.loop.current = c
+			fn(c)
+			c.loop.current = nil
+		}
+		return nil
 	})
 }
 
+func (c *conn) TCPInfo() (*TCPInfo, error) {
+	if !strings.HasPrefix(c.loop.svr.ln.network, "tcp") {
+		return nil, ErrUnsupportedProtocol
+	}
+	return tcpInfo(c.Fd())
+}
+
+func (c *conn) SetUserTimeout(d time.Duration) error {
+	if !strings.HasPrefix(c.loop.svr.ln.network, "tcp") {
+		return ErrUnsupportedProtocol
+	}
+	return setUserTimeout(c.Fd(), d)
+}
+
+func (c *conn) SetTOS(tos int) error {
+	if network := c.loop.svr.ln.network; !strings.HasPrefix(network, "tcp") &&
+		!(strings.HasPrefix(network, "udp") && c.udpPeer != "") {
+		return ErrUnsupportedProtocol
+	}
+	return setTOS(c.Fd(), tos)
+}
+
+func (c *conn) SetPriority(p Priority) {
+	if c.loop != nil && c.opened {
+		c.priority = p
+		c.loop.poller.SetPriority(c.fd, p > PriorityNormal)
+	}
+}
+
+func (c *conn) RetainFrame(frame []byte) []byte {
+	if frame == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(frame)), frame...)
+}
+
+func (c *conn) AfterFunc(d time.Duration, fn func(c Conn)) (stop func() bool) {
+	if c.loop == nil || !c.opened {
+		return func() bool { return false }
+	}
+	el := c.loop
+	var fired, stopped bool
+	t := el.timingWheel().AfterFunc(d, c.follow(el, func() error {
+		if fired = true; c.opened && !stopped {
+			c.loop.current = c
+			fn(c)
+			c.loop.current = nil
+		}
+		return nil
+	}))
+	return func() bool {
+		if fired || stopped {
+			return false
+		}
+		stopped = true
+		// The timer is left in the timing wheel of the event-loop the connection has been migrated from.
+		if !c.moved(el) {
+			t.Stop()
+		}
+		return true
+	}
+}
+
+func (c *conn) LoopTime() time.Time {
+	return c.loop.loopTime()
+}
+
+func (c *conn) Flush() error {
+	return c.loop.loopFlush(c)
+}
+
 func (c *conn) Close() error {
-	return c.loop.poller.Trigger(func() error {
-		return c.loop.loopCloseConn(c, nil)
+	return c.trigger(func() error {
+		return c.loop.loopCloseConn(c, ErrClosedByHandler)
+	})
+}
+
+func (c *conn) Handle() ConnHandle {
+	return ConnHandle{c}
+}
+
+// Alive reports whether the connection has been closed for ConnHandle, it is safe to be called by any goroutine.
+func (c *conn) Alive() bool {
+	return atomic.LoadInt32(&c.closed) == 0
+}
+
+func (c *conn) closeOpened() error {
+	return c.trigger(func() error {
+		if !c.opened {
+			return nil
+		}
+		return c.loop.loopCloseConn(c, ErrClosedByHandler)
 	})
 }
 
-func (c *conn) Context() interface{}       { return c.ctx }
-func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
-func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
-func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *conn) SetCodec(codec ICodec) {
+	if codec == nil {
+		codec = c.loop.svr.codec
+	}
+	c.codec = codec
+}
+
+func (c *conn) Fd() int                             { return c.fd }
+func (c *conn) Context() interface{}                { return c.ctx }
+func (c *conn) SetState(h StateHandler)             { c.state = h }
+func (c *conn) SetContext(ctx interface{})          { c.ctx = ctx }
+func (c *conn) LocalAddr() net.Addr                 { return c.localAddr }
+func (c *conn) RemoteAddr() net.Addr                { return c.remoteAddr }
+func (c *conn) EventLoopContext() interface{}       { return c.loop.ctx }
+func (c *conn) SetEventLoopContext(ctx interface{}) { c.loop.ctx = ctx }
+func (c *conn) Server() Server                      { return *c.loop.svr.server }
+func (c *conn) LoopIndex() int                      { return c.loop.svr.loopIndex(c.loop) }
+
+func (c *conn) RecvAddr() net.Addr {
+	if c.loop.svr.ln.pconn != nil {
+		return c.remoteAddr
+	}
+	return nil
+}
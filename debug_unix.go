@@ -0,0 +1,85 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// poisonByte overwrites the data read into the buffer of event-loop once it's handled with DebugChecks, so that
+// the frames kept beyond React read as garbage right away.
+const poisonByte = 0xDE
+
+// canaryLen is the number of the bytes following a frame which are checked with DebugChecks.
+const canaryLen = 16
+
+// goroutineID returns the id of the calling goroutine parsed from its stack, it's slow and only used by DebugChecks.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// checkBuffer panics if the buffer method of the connection is called outside the event-loop of it or after it
+// has been closed with DebugChecks.
+func (c *conn) checkBuffer(method string) {
+	el := c.owner()
+	if el == nil || !el.svr.opts.DebugChecks {
+		return
+	}
+	// The state of the connection is only read on its event-loop, so that the check itself doesn't race.
+	if id := goroutineID(); id != el.goroutine {
+		panic("gnet: Conn." + method + " is called on goroutine " + strconv.FormatUint(id, 10) + " rather than " +
+			"the event-loop of the connection, the buffers of Conn are only safe to access within the event " +
+			"callbacks or the function passed to Conn.Execute, use AsyncWrite to write from other goroutines")
+	}
+	// The datagrams of UDP which are not connections are never opened.
+	if !c.opened && (el.svr.ln.pconn == nil || c.udpPeer != "") {
+		panic("gnet: Conn." + method + " is called after the connection is closed, the Conn must not be used " +
+			"after OnClosed, copy the data or use Conn.RetainFrame to keep it")
+	}
+}
+
+// frameCanary is a copy of the inbound data following the frame passed to React, which is compared with the data
+// after React returns with DebugChecks.
+type frameCanary struct {
+	data []byte
+	copy [canaryLen]byte
+}
+
+// watchFrame takes the canary of the inbound data that is not consumed yet.
+func (c *conn) watchFrame() (fc frameCanary) {
+	n := len(c.buffer)
+	if n > canaryLen {
+		n = canaryLen
+	}
+	fc.data = c.buffer[:n]
+	copy(fc.copy[:], fc.data)
+	return
+}
+
+// check panics if the inbound data following the frame has been overwritten by React.
+func (fc *frameCanary) check() {
+	if !bytes.Equal(fc.data, fc.copy[:len(fc.data)]) {
+		panic("gnet: the inbound data following the frame is overwritten while handling the frame, which " +
+			"corrupts the frames after it, e.g. by appending to the frame, copy the frame or use " +
+			"Conn.RetainFrame before modifying it")
+	}
+}
+
+// poison overwrites the handled data in the buffer of event-loop with DebugChecks.
+func poison(data []byte) {
+	for i := range data {
+		data[i] = poisonByte
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugChecks(t *testing.T) {
+	events := &testDebugChecksServer{network: "tcp", addr: ":9940", offLoop: make(chan string, 1)}
+	must(Serve(events, "tcp://:9940", WithCodec(new(LineBasedFrameCodec)), WithDebugChecks(true), WithTicker(true),
+		WithPanicHandler(func(c Conn, p interface{}, stack []byte) {
+			events.canary = fmt.Sprint(p)
+		})))
+	if msg := <-events.offLoop; !strings.Contains(msg, "rather than the event-loop") {
+		t.Fatalf("expected the panic of accessing the buffer outside the event-loop, got %q", msg)
+	}
+	if !events.poisoned {
+		t.Fatal("expected the frame kept beyond React to be overwritten")
+	}
+	if !strings.Contains(events.canary, "following the frame is overwritten") {
+		t.Fatalf("expected the panic of overwriting the data following the frame, got %q", events.canary)
+	}
+	if !strings.Contains(events.afterClose, "after the connection is closed") {
+		t.Fatalf("expected the panic of accessing the closed connection, got %q", events.afterClose)
+	}
+}
+
+func catchPanic(fn func()) (msg string) {
+	defer func() {
+		msg = fmt.Sprint(recover())
+	}()
+	fn()
+	return
+}
+
+type testDebugChecksServer struct {
+	*EventServer
+	network, addr string
+	conn          Conn
+	kept          []byte
+	offLoop       chan string
+	poisoned      bool
+	canary        string
+	closed        bool
+	afterClose    string
+}
+
+func (s *testDebugChecksServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		r := bufio.NewReader(c)
+		for _, req := range []string{"ping\n", "hello\n"} {
+			_, err = c.Write([]byte(req))
+			must(err)
+			if line, err := r.ReadString('\n'); err != nil || line != req {
+				panic(fmt.Sprintf("unexpected response: %q, %v", line, err))
+			}
+		}
+		// The frames are written in one go, so that the second one follows the first in the buffer.
+		_, err = c.Write([]byte("a\nbb\n"))
+		must(err)
+		_, _ = ioutil.ReadAll(r)
+	}()
+	return
+}
+
+func (s *testDebugChecksServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "ping":
+		s.conn, s.kept = c, frame
+		go func() {
+			s.offLoop <- catchPanic(func() { c.BufferLength() })
+		}()
+		// The frame is checked once the event-loop is done with it, before the next read reuses the buffer.
+		_ = c.Execute(func(c Conn) {
+			s.poisoned = strings.Trim(string(s.kept), "\xde") == ""
+		})
+	case "a":
+		_ = append(frame, "XY"...)
+	}
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testDebugChecksServer) OnClosed(c Conn, err error) (action Action) {
+	s.closed = true
+	return
+}
+
+// Tick accesses the closed connection on its event-loop, which is the only place the closing is checked.
+func (s *testDebugChecksServer) Tick() (delay time.Duration, action Action) {
+	if !s.closed {
+		return time.Millisecond * 10, None
+	}
+	s.afterClose = catchPanic(func() { s.conn.Read() })
+	return 0, Shutdown
+}
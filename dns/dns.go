@@ -0,0 +1,230 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package dns is the toolkit of building DNS servers on gnet, it handles the message boundaries of DNS
+// over UDP (one message per datagram) and TCP (messages prefixed with 2-byte length), and truncates the
+// replies which don't fit in the UDP payload size of queries, so that a server is written once for both
+// transports.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+const (
+	// HeaderLen is the length of the header of DNS messages.
+	HeaderLen = 12
+
+	// MinUDPSize is the UDP payload size every DNS server must support, it is used when
+	// the query doesn't advertise a larger one in the EDNS(0) OPT record.
+	MinUDPSize = 512
+
+	typeOPT = 41
+
+	flagTC = 0x02 // TC bit in the third byte of the header
+
+	stopCheckInterval = 100 * time.Millisecond
+)
+
+// ErrMalformedMessage occurs when the message doesn't follow the wire format of DNS.
+var ErrMalformedMessage = errors.New("dns: malformed message")
+
+// NewTCPCodec returns the codec handling the 2-byte length prefix of DNS messages over TCP.
+func NewTCPCodec() gnet.ICodec {
+	return gnet.NewLengthFieldBasedFrameCodec(
+		gnet.EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		gnet.DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+	)
+}
+
+// IsTruncated reports whether the TC bit of the message is set.
+func IsTruncated(msg []byte) bool {
+	return len(msg) >= HeaderLen && msg[2]&flagTC != 0
+}
+
+// SetTruncated sets the TC bit of the message.
+func SetTruncated(msg []byte) {
+	if len(msg) >= HeaderLen {
+		msg[2] |= flagTC
+	}
+}
+
+// UDPSize returns the UDP payload size advertised by the EDNS(0) OPT record of the query,
+// MinUDPSize is returned if there is none or it is smaller.
+func UDPSize(query []byte) int {
+	if len(query) < HeaderLen {
+		return MinUDPSize
+	}
+	off, err := skipQuestions(query)
+	if err != nil {
+		return MinUDPSize
+	}
+	an, ns, ar := count(query, 6), count(query, 8), count(query, 10)
+	for i := 0; i < an+ns+ar; i++ {
+		var rrType, rrClass int
+		if off, rrType, rrClass, err = skipRR(query, off); err != nil {
+			break
+		}
+		if i >= an+ns && rrType == typeOPT {
+			if rrClass > MinUDPSize {
+				return rrClass
+			}
+			break
+		}
+	}
+	return MinUDPSize
+}
+
+// Truncate truncates the reply in place to fit in the given size if it is larger, only the header and the question
+// section are kept and the TC bit is set, telling the client to retry over TCP.
+func Truncate(reply []byte, size int) []byte {
+	if len(reply) <= size || len(reply) < HeaderLen {
+		return reply
+	}
+	off, err := skipQuestions(reply)
+	if err != nil || off > size {
+		off = HeaderLen
+		binary.BigEndian.PutUint16(reply[4:], 0)
+	}
+	for i := 6; i < HeaderLen; i += 2 {
+		binary.BigEndian.PutUint16(reply[i:], 0)
+	}
+	SetTruncated(reply)
+	return reply[:off]
+}
+
+func count(msg []byte, off int) int {
+	return int(binary.BigEndian.Uint16(msg[off:]))
+}
+
+// skipQuestions returns the offset of the end of the question section.
+func skipQuestions(msg []byte) (off int, err error) {
+	off = HeaderLen
+	for i := count(msg, 4); i > 0; i-- {
+		if off, err = skipName(msg, off); err != nil {
+			return
+		}
+		if off += 4; off > len(msg) {
+			return 0, ErrMalformedMessage
+		}
+	}
+	return
+}
+
+// skipRR returns the offset of the end of the resource record beginning at off, along with its type and class.
+func skipRR(msg []byte, off int) (end, rrType, rrClass int, err error) {
+	if off, err = skipName(msg, off); err != nil {
+		return
+	}
+	if off+10 > len(msg) {
+		err = ErrMalformedMessage
+		return
+	}
+	rrType, rrClass = count(msg, off), count(msg, off+2)
+	if end = off + 10 + count(msg, off+8); end > len(msg) {
+		err = ErrMalformedMessage
+	}
+	return
+}
+
+// skipName returns the offset of the end of the domain name beginning at off.
+func skipName(msg []byte, off int) (int, error) {
+	for off < len(msg) {
+		l := int(msg[off])
+		switch l & 0xC0 {
+		case 0x00:
+			off++
+			if l == 0 {
+				return off, nil
+			}
+			off += l
+		case 0xC0:
+			if off+2 > len(msg) {
+				return 0, ErrMalformedMessage
+			}
+			return off + 2, nil
+		default:
+			return 0, ErrMalformedMessage
+		}
+	}
+	return 0, ErrMalformedMessage
+}
+
+// Handler responds to DNS queries, it runs on the event-loops of gnet, thus it must not block.
+type Handler interface {
+	// ServeDNS returns the reply to the query, no reply is sent if it is nil. The query is only valid
+	// until ServeDNS returns, see gnet.Conn.RetainFrame if it needs to be kept.
+	ServeDNS(query []byte, c gnet.Conn) (reply []byte)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as DNS handlers.
+type HandlerFunc func(query []byte, c gnet.Conn) (reply []byte)
+
+// ServeDNS calls f(query, c).
+func (f HandlerFunc) ServeDNS(query []byte, c gnet.Conn) []byte {
+	return f(query, c)
+}
+
+// Server serves DNS queries over both UDP and TCP on the same address.
+type Server struct {
+	// Handler responds to the queries.
+	Handler Handler
+
+	stopped int32
+}
+
+// Serve starts serving the queries on the given address, e.g. ":53", it blocks until Stop is called or
+// either of the UDP and TCP servers fails, the given options are applied to both of them.
+func (s *Server) Serve(addr string, opts ...gnet.Option) error {
+	errCh := make(chan error, 2)
+	serve := func(protoAddr string, opts ...gnet.Option) {
+		err := gnet.Serve(&eventHandler{s: s}, protoAddr, opts...)
+		s.Stop()
+		errCh <- err
+	}
+	udpOpts := append(append([]gnet.Option{}, opts...), gnet.WithTicker(true))
+	tcpOpts := append(append([]gnet.Option{}, udpOpts...), gnet.WithCodec(NewTCPCodec()))
+	go serve("udp://"+addr, udpOpts...)
+	go serve("tcp://"+addr, tcpOpts...)
+	err := <-errCh
+	if err1 := <-errCh; err == nil {
+		err = err1
+	}
+	return err
+}
+
+// Stop shuts down both the UDP and TCP servers, the Server can't be served again afterwards.
+func (s *Server) Stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+}
+
+type eventHandler struct {
+	*gnet.EventServer
+	s *Server
+}
+
+func (h *eventHandler) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	if len(frame) < HeaderLen {
+		return
+	}
+	out = h.s.Handler.ServeDNS(frame, c)
+	if _, ok := c.LocalAddr().(*net.UDPAddr); ok && out != nil {
+		out = Truncate(out, UDPSize(frame))
+	}
+	return
+}
+
+func (h *eventHandler) Tick() (delay time.Duration, action gnet.Action) {
+	if atomic.LoadInt32(&h.s.stopped) == 1 {
+		action = gnet.Shutdown
+	}
+	delay = stopCheckInterval
+	return
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// query builds a query of "example.com. IN A", with an OPT record advertising udpSize if it is not 0.
+func query(id uint16, udpSize int) []byte {
+	msg := make([]byte, HeaderLen)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = append(msg, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1)
+	if udpSize > 0 {
+		binary.BigEndian.PutUint16(msg[10:], 1)
+		msg = append(msg, 0, 0, typeOPT, byte(udpSize>>8), byte(udpSize), 0, 0, 0, 0, 0, 0)
+	}
+	return msg
+}
+
+// reply answers the query with n TXT records pointing to the name in question.
+func reply(q []byte, n int) []byte {
+	msg := append([]byte{}, q[:HeaderLen+17]...)
+	msg[2] |= 0x80
+	binary.BigEndian.PutUint16(msg[6:], uint16(n))
+	binary.BigEndian.PutUint16(msg[10:], 0)
+	for i := 0; i < n; i++ {
+		msg = append(msg, 0xC0, HeaderLen, 0, 16, 0, 1, 0, 0, 0, 60, 0, 101, 100)
+		msg = append(msg, bytes.Repeat([]byte{'x'}, 100)...)
+	}
+	return msg
+}
+
+func TestUDPSize(t *testing.T) {
+	if size := UDPSize(query(1, 0)); size != MinUDPSize {
+		t.Fatalf("expected %d, got %d", MinUDPSize, size)
+	}
+	if size := UDPSize(query(1, 4096)); size != 4096 {
+		t.Fatalf("expected 4096, got %d", size)
+	}
+	if size := UDPSize([]byte{1, 2, 3}); size != MinUDPSize {
+		t.Fatalf("expected %d for malformed query, got %d", MinUDPSize, size)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	q := query(1, 0)
+	r := reply(q, 10)
+	tr := Truncate(append([]byte{}, r...), MinUDPSize)
+	if !IsTruncated(tr) || len(tr) != len(q) || count(tr, 4) != 1 || count(tr, 6) != 0 {
+		t.Fatalf("unexpected truncated reply: %v", tr)
+	}
+	if small := reply(q, 1); !bytes.Equal(Truncate(small, MinUDPSize), small) || IsTruncated(small) {
+		t.Fatalf("reply which fits is truncated")
+	}
+}
+
+func TestServer(t *testing.T) {
+	const addr = "127.0.0.1:9983"
+	s := &Server{Handler: HandlerFunc(func(q []byte, c gnet.Conn) []byte {
+		return reply(q, 10)
+	})}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(addr) }()
+	defer func() {
+		s.Stop()
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 200)
+
+	uc, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	_ = uc.SetDeadline(time.Now().Add(time.Second * 3))
+	if _, err = uc.Write(query(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 0x10000)
+	n, err := uc.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsTruncated(buf[:n]) || n > MinUDPSize {
+		t.Fatalf("expected a truncated reply over UDP, got %d bytes", n)
+	}
+
+	tc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	_ = tc.SetDeadline(time.Now().Add(time.Second * 3))
+	q := query(2, 0)
+	if _, err = tc.Write(append([]byte{0, byte(len(q))}, q...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(tc, buf[:2]); err != nil {
+		t.Fatal(err)
+	}
+	r := buf[:binary.BigEndian.Uint16(buf)]
+	if _, err = io.ReadFull(tc, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, reply(q, 10)) {
+		t.Fatalf("unexpected reply over TCP")
+	}
+}
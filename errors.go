@@ -8,8 +8,64 @@ var (
 	// ErrUnsupportedPlatform occurs when running gnet on an unsupported platform.
 	ErrUnsupportedPlatform = errors.New("unsupported platform in gnet")
 
-	// errServerShutdown occurs when server is closing.
-	errServerShutdown = errors.New("server is going to be shutdown")
+	// ErrOptionNotReloadable occurs when trying to change the option which can't be changed at runtime.
+	ErrOptionNotReloadable = errors.New("option can't be changed at runtime")
+	// ErrServerShutdown occurs when server is closing, it is also passed to OnClosed for the connections
+	// closed by the shutdown of server.
+	ErrServerShutdown = errors.New("server is going to be shutdown")
+	// ErrShutdownBySignal is the reason of ShutdownReport when the server is shut down by SIGINT or SIGTERM.
+	ErrShutdownBySignal = errors.New("server is shut down by signal")
+	// ErrInvalidConfig occurs when the Config given to WithConfig is invalid.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrConnClosed occurs when using the ConnHandle of a closed connection.
+	ErrConnClosed = errors.New("connection is closed")
+	// ErrTaskQueueFull occurs when the task queue of event-loop is full with LoopTaskQueueCap and OverloadReject.
+	ErrTaskQueueFull = errors.New("task queue of event-loop is full")
+	// ErrWouldBlock occurs when Conn.SendFD can't send the file descriptor right away for the outbound data pending
+	// or the socket buffer being full.
+	ErrWouldBlock = errors.New("operation on connection would block")
+	// ErrUDPSendDropped occurs when the datagram sent from the UDP socket of server is dropped by UDPSendPolicy
+	// for the socket buffer being full.
+	ErrUDPSendDropped = errors.New("datagram is dropped for the full socket buffer")
+	// ErrUDPSendBlockQueued occurs when UDPSendBlock is given along with UDPSendQueue, since the queue is flushed by
+	// the event-loop which the blocked sender may be running on.
+	ErrUDPSendBlockQueued = errors.New("UDPSendBlock can't be used with UDPSendQueue")
+	// ErrInvalidLoopIndex occurs when Conn.MigrateTo is given an index out of the range of event-loops.
+	ErrInvalidLoopIndex = errors.New("invalid index of event-loop")
+	// ErrConnRejected occurs when the connection given to Server.AdoptConn or Server.AdoptFd is over
+	// PerIPMaxConnections, or FDWatermark with RejectNew.
+	ErrConnRejected = errors.New("connection is rejected by the limits of server")
+
+	// The errors below are passed to OnClosed as the reasons of closing connections, the errors other than
+	// them are the raw errors of the failed I/O on connections.
+
+	// ErrEOF occurs when the connection is closed by peer gracefully.
+	ErrEOF = errors.New("connection is closed by peer")
+	// ErrReset occurs when the connection is reset by peer.
+	ErrReset = errors.New("connection is reset by peer")
+	// ErrClosedByHandler occurs when the connection is closed by the Close action or Conn.Close.
+	ErrClosedByHandler = errors.New("connection is closed by handler")
+	// ErrBufferLimit occurs when the connection is closed for exceeding the memory budget of server.
+	ErrBufferLimit = errors.New("memory limit of server is exceeded")
+	// ErrWriteTimeout occurs when the connection is closed for failing to write data out in time.
+	ErrWriteTimeout = errors.New("connection write timeout")
+	// ErrIdleTimeout occurs when the connection is closed for being idle too long.
+	ErrIdleTimeout = errors.New("connection idle timeout")
+	// ErrFDLimit occurs when the connection is closed by CloseLeastActive for the file descriptors of process
+	// reaching FDWatermark.
+	ErrFDLimit = errors.New("file descriptors of process reach the watermark")
+	// ErrSlowConsumer occurs when the connection is closed for its outbound buffer staying above
+	// SlowConsumerThreshold, or when writing to a connection paused by SlowConsumerPause.
+	ErrSlowConsumer = errors.New("connection is a slow consumer")
+	// ErrPanic occurs when the connection is closed for a panic in the event callbacks recovered by the event-loop.
+	ErrPanic = errors.New("panic in event callback")
+	// ErrFrameTooLarge occurs when the connection is closed for sending a frame larger than MaxFrameSize.
+	ErrFrameTooLarge = errors.New("frame is too large")
+	// ErrFirstByteTimeout occurs when the newly accepted connection is closed for sending nothing in time.
+	ErrFirstByteTimeout = errors.New("connection first byte timeout")
+
+	// errPanicRecovered occurs when the event-loop recovers a panic, it goes on polling then.
+	errPanicRecovered = errors.New("panic is recovered")
 	// errInvalidFixedLength occurs when the output data have invalid fixed length.
 	errInvalidFixedLength = errors.New("invalid fixed length of bytes")
 	// errUnexpectedEOF occurs when no enough data to read by codec.
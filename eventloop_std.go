@@ -0,0 +1,529 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+type eventloop struct {
+	ch                chan interface{}        // command channel
+	idx               int                     // loop index
+	svr               *server                 // server in loop
+	codec             ICodec                  // codec for TCP
+	connCount         int32                   // number of active connections in event-loop
+	connections       map[*stdConn]struct{}   // track all the sockets bound to this loop
+	ctx               interface{}             // user-defined context of the event-loop
+	now               time.Time               // time cached for the current command, zero if not captured
+	profile           loopProfile             // durations of LoopStats, collected if LoopProfiling is set
+	bytesIn, bytesOut uint64                  // bytes read from and written to the connections, accessed atomically
+	lifetime          lifetime                // counts of the connections for ShutdownReport
+	batch             frameBatch              // frames passed to BatchHandler
+	eventHandler      EventHandler            // user eventHandler
+	calibrateCallback func(*eventloop, int32) // callback func for re-adjusting connCount
+}
+
+// execute runs the given job on the event-loop asynchronously.
+func (el *eventloop) execute(job func() error) error {
+	el.ch <- job
+	return nil
+}
+
+// submit queues the job of the asynchronous APIs to the event-loop, the command channel is of fixed capacity
+// on Windows, so the callers are blocked when it is full.
+func (el *eventloop) submit(job func() error) error {
+	return el.execute(job)
+}
+
+// queuedTasks returns the number of the commands waiting in the command channel.
+func (el *eventloop) queuedTasks() int {
+	return len(el.ch)
+}
+
+func (el *eventloop) loopRun() {
+	var err error
+	defer func() {
+		if el.idx == 0 && el.svr.opts.Ticker {
+			close(el.svr.ticktock)
+		}
+		el.svr.signalShutdown(err)
+		el.svr.loopWG.Done()
+		el.loopEgress()
+		el.svr.loopWG.Done()
+	}()
+	if el.idx == 0 && el.svr.opts.Ticker {
+		go el.loopTicker()
+	}
+	el.startProfiling()
+	idleSince := time.Now()
+	for v := range el.ch {
+		if el.svr.opts.LoopProfiling {
+			el.profile.wait(idleSince, time.Now())
+		}
+		err = el.dispatch(v)
+		if el.svr.opts.LoopProfiling {
+			idleSince = time.Now()
+		}
+		el.now = time.Time{}
+		if err != nil {
+			el.svr.logger().Printf("event-loop:%d exits with error:%v\n", el.idx, err)
+			break
+		}
+	}
+}
+
+// startProfiling sets up LoopProfiling for the event-loop, the time of waiting for the commands is taken as
+// the time of polling.
+func (el *eventloop) startProfiling() {
+	if el.svr.opts.LoopProfiling {
+		el.svr.labelLoop(el)
+	}
+}
+
+// pollerUpdates returns zeros, there is no poller on Windows.
+func (el *eventloop) pollerUpdates() (requests, syscalls uint64) {
+	return 0, 0
+}
+
+// udpSendStats returns zeros, UDPSendQueue is not supported on Windows.
+func (el *eventloop) udpSendStats() (queued, dropped uint64) {
+	return 0, 0
+}
+
+// dispatch handles the given command, the panics in the callbacks are recovered unless DisablePanicRecovery
+// is set, the connection causing the panic is closed with ErrPanic and the other connections are left alone.
+func (el *eventloop) dispatch(v interface{}) (err error) {
+	if !el.svr.opts.DisablePanicRecovery {
+		defer func() {
+			if p := recover(); p != nil {
+				err = el.recoverPanic(v, p, debug.Stack())
+			}
+		}()
+	}
+	switch v := v.(type) {
+	case error:
+		err = v
+	case *stdConn:
+		err = el.loopAccept(v)
+	case *tcpIn:
+		err = el.loopRead(v)
+	case *udpIn:
+		err = el.loopReadUDP(v.c)
+	case *stderr:
+		err = el.loopError(v.c, v.err)
+	case wakeReq:
+		err = el.loopWake(v.c)
+	case func() error:
+		err = v()
+	}
+	return
+}
+
+// recoverPanic reports the panic recovered from handling the given command and closes the connection causing it.
+func (el *eventloop) recoverPanic(v interface{}, p interface{}, stack []byte) error {
+	var c *stdConn
+	switch v := v.(type) {
+	case *stdConn:
+		c = v
+	case *tcpIn:
+		c = v.c
+	case *udpIn:
+		c = v.c
+	case *stderr:
+		c = v.c
+	case wakeReq:
+		c = v.c
+	}
+	if c == nil {
+		el.svr.reportPanic(el.idx, nil, p, stack)
+		return nil
+	}
+	el.svr.reportPanic(el.idx, c, p, stack)
+	if _, ok := el.connections[c]; ok {
+		_ = el.loopCloseConn(c, ErrPanic)
+	}
+	return nil
+}
+
+func (el *eventloop) loopAccept(c *stdConn) error {
+	el.connections[c] = struct{}{}
+	if c.localAddr == nil {
+		c.localAddr = el.svr.ln.lnaddr
+	}
+	c.remoteAddr = c.conn.RemoteAddr()
+	el.calibrateCallback(el, 1)
+	el.recordOpen(c)
+
+	out, action := el.eventHandler.OnOpened(c)
+	if timeout := el.svr.options().FirstByteTimeout; timeout > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			el.ch <- func() error {
+				if _, ok := el.connections[c]; ok && c.firstByteTimer == timer {
+					return el.loopCloseConn(c, ErrFirstByteTimeout)
+				}
+				return nil
+			}
+		})
+		c.firstByteTimer = timer
+	}
+	if out != nil {
+		el.eventHandler.PreWrite()
+		_, _ = c.write(out)
+	}
+	if keepAlive := el.svr.options().TCPKeepAlive; keepAlive > 0 {
+		if c, ok := c.conn.(*net.TCPConn); ok {
+			_ = c.SetKeepAlive(true)
+			_ = c.SetKeepAlivePeriod(keepAlive)
+		}
+	}
+	return el.handleAction(c, action)
+}
+
+func (el *eventloop) loopRead(ti *tcpIn) (err error) {
+	c := ti.c
+	c.stopFirstByteTimer()
+	if tracer := el.svr.opts.Tracer; tracer != nil && c.bytesIn == 0 {
+		tracer.TraceFirstByte(c)
+	}
+	c.countIn(ti.in.Len())
+	el.tap(c, Inbound, ti.in.Bytes())
+	if c.splicer != nil {
+		if ti.in = el.loopSplice(c, ti.in); ti.in == nil {
+			return
+		}
+	}
+	c.buffer = ti.in
+
+	var action Action
+	if th := el.svr.trafficHandler; th != nil {
+		action = th.OnTraffic(c)
+	} else {
+		action, err = el.loopReact(c)
+	}
+	switch action {
+	case Close:
+		return el.loopCloseConn(c, ErrClosedByHandler)
+	case Shutdown:
+		return ErrServerShutdown
+	}
+	if err != nil {
+		return el.loopError(c, err)
+	}
+	_, _ = c.inboundBuffer.Write(c.buffer.Bytes())
+	el.svr.bufPool.Put(c.buffer)
+	c.buffer = nil
+	return
+}
+
+// loopReact decodes frames from the inbound data of the given connection and passes them to React,
+// it serves as the adapter of EventHandler.React for the traffic event.
+func (el *eventloop) loopReact(c *stdConn) (action Action, err error) {
+	if bh := el.svr.batchHandler; bh != nil && c.state == nil {
+		return el.loopReactBatch(c, bh)
+	}
+	inFrame, rerr := c.read()
+	for ; inFrame != nil; inFrame, rerr = c.read() {
+		var out []byte
+		c.sequencer.next()
+		done := el.traceFrame(c, inFrame)
+		out, action = el.react(inFrame, c)
+		if done != nil {
+			done(out, action)
+		}
+		if out != nil {
+			outFrame, _ := c.codec.Encode(c, out)
+			el.eventHandler.PreWrite()
+			_, err = c.write(outFrame)
+		}
+		if action == Close || action == Shutdown || err != nil {
+			return
+		}
+	}
+	if rerr == ErrFrameTooLarge {
+		err = el.loopCloseConn(c, rerr)
+	}
+	return
+}
+
+// loopReactBatch decodes frames from the inbound data of the given connection and passes them to ReactBatch
+// at once.
+func (el *eventloop) loopReactBatch(c *stdConn, bh BatchHandler) (action Action, err error) {
+	b := &el.batch
+	defer b.reset()
+	inFrame, rerr := c.read()
+	for ; inFrame != nil; inFrame, rerr = c.read() {
+		c.sequencer.next()
+		b.add(inFrame)
+	}
+	if len(b.frames) > 0 {
+		var outs [][]byte
+		outs, action = el.reactBatch(bh, b.frames, c)
+		if out := b.encode(c.codec, c, outs); out != nil {
+			el.eventHandler.PreWrite()
+			if _, err = c.write(out); err != nil {
+				return
+			}
+		}
+	}
+	// The frames before the one too large are still passed to ReactBatch.
+	if rerr == ErrFrameTooLarge && action == None {
+		err = el.loopCloseConn(c, rerr)
+	}
+	return
+}
+
+// loopCloseConn closes the given connection with the reason which is passed to OnClosed, by interrupting
+// the goroutine reading the connection.
+func (el *eventloop) loopCloseConn(c *stdConn, reason error) error {
+	if c.reason == nil {
+		c.reason = reason
+	}
+	return c.conn.SetReadDeadline(time.Now())
+}
+
+// closeReason translates the error of the failed I/O on connection into the reason passed to OnClosed.
+func closeReason(err error) error {
+	switch {
+	case err == io.EOF:
+		return ErrEOF
+	case isConnReset(err):
+		return ErrReset
+	}
+	return err
+}
+
+func (el *eventloop) loopEgress() {
+	var closed bool
+	for v := range el.ch {
+		switch v := v.(type) {
+		case error:
+			if v == errCloseAllConns {
+				closed = true
+				for c := range el.connections {
+					_ = el.loopCloseConn(c, ErrServerShutdown)
+				}
+			}
+		case *stderr:
+			_ = el.loopError(v.c, v.err)
+		}
+		if closed && len(el.connections) == 0 {
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopTicker() {
+	var (
+		delay time.Duration
+		open  bool
+	)
+	for {
+		el.ch <- func() (err error) {
+			// The delay is handed over in a deferred call, so that ticking goes on with the last delay if Tick
+			// panics and the panic is recovered by the event-loop.
+			next, action := delay, None
+			defer func() { el.svr.ticktock <- next }()
+			next, action = el.eventHandler.Tick()
+			switch action {
+			case Shutdown:
+				err = ErrServerShutdown
+			}
+			return
+		}
+		if delay, open = <-el.svr.ticktock; open {
+			time.Sleep(tickDelay(el.svr.opts, delay, time.Now()))
+		} else {
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopError(c *stdConn, err error) (e error) {
+	var action Action
+	if c.reason != nil {
+		err = c.reason
+	} else {
+		if eh := el.svr.errorHandler; eh != nil && err != io.EOF {
+			// The goroutine reading the connection has quit, so it is closed whatever the action is.
+			action = eh.OnError(c, "read", err)
+		}
+		err = closeReason(err)
+	}
+	if e = c.conn.Close(); e == nil {
+		delete(el.connections, c)
+		if c.peer != nil {
+			el.svr.peers().release(c.peer)
+			c.peer = nil
+		}
+		el.calibrateCallback(el, -1)
+		if c.splicer != nil {
+			el.stopSplice(c, err)
+		}
+		el.svr.bus.unsubscribeAll(c)
+		atomic.StoreInt32(&c.closed, 1)
+		el.recordClose(c, err)
+		switch el.eventHandler.OnClosed(c, err) {
+		case Shutdown:
+			return ErrServerShutdown
+		}
+		c.releaseTCP()
+	} else {
+		el.svr.logger().Printf("failed to close connection:%s, error:%v\n", c.remoteAddr.String(), e)
+	}
+	if action == Shutdown {
+		return ErrServerShutdown
+	}
+	return
+}
+
+// loopTime returns the time cached for the current command, it is captured on the first call.
+func (el *eventloop) loopTime() time.Time {
+	if el.now.IsZero() {
+		el.now = time.Now()
+	}
+	return el.now
+}
+
+// react passes the frame to the handler of the current state of the given connection, or React if it's not set.
+func (el *eventloop) react(frame []byte, c *stdConn) ([]byte, Action) {
+	if el.svr.opts.LoopProfiling {
+		defer el.profile.since(&el.profile.handler, time.Now())
+	}
+	if c.state != nil {
+		return c.state(frame, c)
+	}
+	return el.eventHandler.React(frame, c)
+}
+
+// reactBatch passes the frames to ReactBatch, the time of it is profiled if LoopProfiling is set.
+func (el *eventloop) reactBatch(bh BatchHandler, frames [][]byte, c *stdConn) ([][]byte, Action) {
+	if el.svr.opts.LoopProfiling {
+		defer el.profile.since(&el.profile.handler, time.Now())
+	}
+	return bh.ReactBatch(frames, c)
+}
+
+// recordOpen notes the time of opening the given connection and emits the record of it to ConnRecorder and Tracer
+// if they are set.
+func (el *eventloop) recordOpen(c *stdConn) {
+	c.openedAt = time.Now()
+	el.lifetime.opened++
+	if recorder := el.svr.opts.ConnRecorder; recorder != nil {
+		recorder(ConnRecord{LocalAddr: c.localAddr, RemoteAddr: c.remoteAddr, OpenedAt: c.openedAt})
+	}
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		tracer.TraceOpen(c)
+	}
+}
+
+// recordClose counts the reason of closing the given connection for ShutdownReport and emits the record of it
+// to ConnRecorder and Tracer if they are set.
+func (el *eventloop) recordClose(c *stdConn, reason error) {
+	el.lifetime.close(reason)
+	if recorder := el.svr.opts.ConnRecorder; recorder != nil {
+		recorder(ConnRecord{
+			Closed:     true,
+			LocalAddr:  c.localAddr,
+			RemoteAddr: c.remoteAddr,
+			OpenedAt:   c.openedAt,
+			Duration:   time.Since(c.openedAt),
+			BytesIn:    c.bytesIn,
+			BytesOut:   c.bytesOut,
+			Reason:     reason,
+		})
+	}
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		tracer.TraceClose(c, reason)
+	}
+}
+
+// loopPublish writes the data published to a topic to the given subscriber.
+func (el *eventloop) loopPublish(sub Conn, data []byte) {
+	c := sub.(*stdConn)
+	if _, ok := el.connections[c]; ok {
+		out, _ := c.codec.Encode(c, data)
+		_, _ = c.write(out)
+	}
+}
+
+func (el *eventloop) loopWake(c *stdConn) error {
+	if _, ok := el.connections[c]; !ok {
+		return nil // ignore stale wakes.
+	}
+	out, action := el.react(nil, c)
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		_, _ = c.write(frame)
+	}
+	return el.handleAction(c, action)
+}
+
+// traceFrame passes the decoded frame of connection to Tracer if it is set, it returns the function
+// to be called when React returns, nil if there is none.
+func (el *eventloop) traceFrame(c *stdConn, frame []byte) func(out []byte, action Action) {
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		return tracer.TraceFrame(c, frame)
+	}
+	return nil
+}
+
+// traceFlush reports the bytes of connection written to the socket to Tracer if it is set.
+func (el *eventloop) traceFlush(c *stdConn, n int) {
+	if tracer := el.svr.opts.Tracer; tracer != nil && n > 0 {
+		tracer.TraceFlush(c, n)
+	}
+}
+
+// tap passes the data of connection to TrafficTap if it is set.
+func (el *eventloop) tap(c *stdConn, dir Direction, data []byte) {
+	if tap := el.svr.opts.TrafficTap; tap != nil {
+		tap(c, dir, data)
+	}
+}
+
+func (el *eventloop) handleAction(c *stdConn, action Action) error {
+	switch action {
+	case None:
+		return nil
+	case Close:
+		return el.loopCloseConn(c, ErrClosedByHandler)
+	case Shutdown:
+		return ErrServerShutdown
+	default:
+		return nil
+	}
+}
+
+// writeTo writes the datagram to the given address, the time of writing is profiled if LoopProfiling is set.
+func (el *eventloop) writeTo(buf []byte, addr net.Addr) {
+	if el.svr.opts.LoopProfiling {
+		defer el.profile.since(&el.profile.flush, time.Now())
+	}
+	_, _ = el.svr.ln.pconn.WriteTo(buf, addr)
+}
+
+func (el *eventloop) loopReadUDP(c *stdConn) error {
+	el.tap(c, Inbound, c.buffer.Bytes())
+	out, action := el.react(c.buffer.Bytes(), c)
+	if out != nil {
+		el.eventHandler.PreWrite()
+		el.tap(c, Outbound, out)
+		el.writeTo(out, c.remoteAddr)
+	}
+	switch action {
+	case Shutdown:
+		return ErrServerShutdown
+	}
+	c.releaseUDP()
+	return nil
+}
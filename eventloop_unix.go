@@ -8,53 +8,196 @@
 package gnet
 
 import (
+	"io"
 	"net"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/internal/netpoll"
 	"golang.org/x/sys/unix"
 )
 
 type eventloop struct {
+	ln                *listener               // listener polled by this event-loop
 	idx               int                     // loop index in the server loops list
 	svr               *server                 // server in loop
 	codec             ICodec                  // codec for TCP
 	packet            []byte                  // read packet buffer
+	oob               []byte                  // buffer of the control messages of UDPControl, created on the first use
+	rightsOOB         []byte                  // buffer of the descriptors passed to FDHandler, created on the first use
 	poller            *netpoll.Poller         // epoll or kqueue
 	connCount         int32                   // number of active connections in event-loop
 	connections       map[int]*conn           // loop connections fd -> conn
+	acceptPaused      bool                    // listener is not being polled
+	acceptThrottled   bool                    // listener is not polled until the next token of AcceptRate
+	timers            *internal.TimingWheel   // timers of connections, created on the first use
+	staged            []*conn                 // connections with the writes staged by WriteBuffering
+	batch             frameBatch              // frames passed to BatchHandler
+	now               time.Time               // time cached for the current batch of events, zero if not captured
+	profile           loopProfile             // durations of LoopStats, collected if LoopProfiling is set
+	bytesIn, bytesOut uint64                  // bytes read from and written to the connections, accessed atomically
+	lifetime          lifetime                // counts of the connections for ShutdownReport
+	goroutine         uint64                  // id of the goroutine running the event-loop, only tracked with DebugChecks
+	current           *conn                   // connection whose events are being handled, blamed for the panics
+	tasks             taskQueue               // tasks submitted by the asynchronous APIs
+	udpOut            udpSendQueue            // datagrams waiting for the UDP socket of server to be writable
+	lastTick          time.Duration           // delay returned by the last Tick, which re-arms the timer of ticker on BSD
+	ctx               interface{}             // user-defined context of the event-loop
 	eventHandler      EventHandler            // user eventHandler
 	calibrateCallback func(*eventloop, int32) // callback func for re-adjusting connCount
 }
 
+// shutdown begins shutting down the server, it terminates the event-loop right away unless the server drains
+// connections before stopping, in which case the event-loop keeps running until the draining is done.
+func (el *eventloop) shutdown() error {
+	if el.svr.options().ShutdownTimeout > 0 {
+		el.svr.signalShutdown(ErrServerShutdown)
+		return nil
+	}
+	return ErrServerShutdown
+}
+
+// closeIdleConns closes the connections which have neither buffered data nor writes and tasks on the way,
+// and have received nothing since the given time.
+func (el *eventloop) closeIdleConns(since time.Time) error {
+	for _, c := range el.connections {
+		if c.inboundBuffer.IsEmpty() && c.outboundBuffer.IsEmpty() && c.coalescer.idle() &&
+			atomic.LoadInt32(&c.queuedWrites) == 0 && atomic.LoadInt32(&c.pendingTasks) == 0 &&
+			!c.lastActive.After(since) {
+			if err := el.loopCloseConn(c, ErrServerShutdown); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// execute runs the given job on the event-loop asynchronously.
+func (el *eventloop) execute(job func() error) error {
+	return el.poller.Trigger(job)
+}
+
 func (el *eventloop) closeAllConns() {
 	// Close loops and all outstanding connections
 	for _, c := range el.connections {
-		_ = el.loopCloseConn(c, nil)
+		_ = el.loopCloseConn(c, ErrServerShutdown)
 	}
 }
 
 func (el *eventloop) loopRun() {
+	var err error
 	defer func() {
 		el.closeAllConns()
 		if el.idx == 0 && el.svr.opts.Ticker {
-			close(el.svr.ticktock)
+			el.stopTicker()
 		}
-		el.svr.signalShutdown()
+		el.svr.signalShutdown(err)
 	}()
 
+	if el.svr.opts.ReusePort && el.svr.opts.ReusePortCPUAffinity {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if err := bindCPU(el.idx % runtime.NumCPU()); err != nil {
+			el.svr.logger().Printf("event-loop:%d failed to bind CPU, error:%v\n", el.idx, err)
+		}
+	}
+
 	if el.idx == 0 && el.svr.opts.Ticker {
-		go el.loopTicker()
+		el.startTicker()
 	}
+	el.poller.SetBatchHook(el.endBatch)
+	el.startProfiling()
 
-	el.svr.logger.Printf("event-loop:%d exits with error: %v\n", el.idx, el.poller.Polling(el.handleEvent))
+	err = el.polling(func() error {
+		return el.poller.Polling(el.handleEvent)
+	})
+	el.svr.logger().Printf("event-loop:%d exits with error: %v\n", el.idx, err)
+}
+
+// startProfiling sets up LoopProfiling for the event-loop, it must be called by the goroutine polling it.
+func (el *eventloop) startProfiling() {
+	if el.svr.opts.LoopProfiling {
+		el.svr.labelLoop(el)
+		el.poller.SetWaitHook(el.profile.wait)
+	}
+}
+
+// pollerUpdates returns the number of the changes of interest requested to the poller of the event-loop and
+// the number of system calls made for them.
+func (el *eventloop) pollerUpdates() (requests, syscalls uint64) {
+	return el.poller.Updates()
+}
+
+// polling runs the poller by the given function, it starts over the poller whenever a panic in the callbacks
+// is recovered, the panics are not recovered if DisablePanicRecovery is set.
+func (el *eventloop) polling(poll func() error) error {
+	if el.svr.opts.DebugChecks {
+		el.goroutine = goroutineID()
+	}
+	for {
+		if err := el.pollRecovered(poll); err != errPanicRecovered {
+			return err
+		}
+	}
+}
+
+func (el *eventloop) pollRecovered(poll func() error) (err error) {
+	if !el.svr.opts.DisablePanicRecovery {
+		defer func() {
+			if p := recover(); p != nil {
+				err = el.recoverPanic(p, debug.Stack())
+			}
+		}()
+	}
+	return poll()
+}
+
+// recoverPanic reports the recovered panic and closes the connection causing it with ErrPanic, the other
+// connections of the event-loop are left alone.
+func (el *eventloop) recoverPanic(p interface{}, stack []byte) (err error) {
+	c := el.current
+	el.current = nil
+	el.now = time.Time{}
+	if c == nil {
+		el.svr.reportPanic(el.idx, nil, p, stack)
+	} else {
+		el.svr.reportPanic(el.idx, c, p, stack)
+		if el.connections[c.fd] == c {
+			err = el.closePanicked(c)
+		}
+	}
+	// Run the tasks put back by the panicking one, if any.
+	_ = el.poller.Wake()
+	if err == nil {
+		err = errPanicRecovered
+	}
+	return
+}
+
+// closePanicked closes the connection with ErrPanic, a panic in OnClosed is reported and swallowed since
+// the connection has been released by then.
+func (el *eventloop) closePanicked(c *conn) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			el.svr.reportPanic(el.idx, c, p, debug.Stack())
+			err = nil
+		}
+	}()
+	return el.loopCloseConn(c, ErrPanic)
 }
 
 func (el *eventloop) loopAccept(fd int) error {
-	if fd == el.svr.ln.fd {
-		if el.svr.ln.pconn != nil {
+	if fd == el.ln.fd {
+		if el.ln.pconn != nil {
 			return el.loopReadUDP(fd)
 		}
+		if el.throttleAccept() {
+			return nil
+		}
 		nfd, sa, err := unix.Accept(fd)
 		if err != nil {
 			if err == unix.EAGAIN {
@@ -65,163 +208,620 @@ func (el *eventloop) loopAccept(fd int) error {
 		if err = unix.SetNonblock(nfd, true); err != nil {
 			return err
 		}
-		c := newTCPConn(nfd, el, sa)
-		if err = el.poller.AddRead(c.fd); err == nil {
-			el.connections[c.fd] = c
-			el.calibrateCallback(el, 1)
-			return el.loopOpen(c)
+		if el.svr.rejectFD(nfd) {
+			_ = unix.Close(nfd)
+			return nil
 		}
-		return err
+		peer, ok := el.svr.admitPeer(sa)
+		if !ok {
+			_ = unix.Close(nfd)
+			return nil
+		}
+		if el.svr.opts.EventLoopPicker != nil {
+			if target := el.svr.pickEventLoop(netpoll.SockaddrToTCPOrUnixAddr(sa)); target != nil && target != el {
+				c := newTCPConn(nfd, target, sa)
+				c.peer = peer
+				return target.poller.Trigger(func() error {
+					return target.loopRegister(c)
+				})
+			}
+		}
+		c := newTCPConn(nfd, el, sa)
+		c.peer = peer
+		return el.loopRegister(c)
 	}
 	return nil
 }
 
 func (el *eventloop) loopOpen(c *conn) error {
 	c.opened = true
-	c.localAddr = el.svr.ln.lnaddr
+	if c.localAddr == nil {
+		c.localAddr = el.svr.ln.lnaddr
+	}
 	c.remoteAddr = netpoll.SockaddrToTCPOrUnixAddr(c.sa)
+	el.recordOpen(c)
+	if err := el.startCompression(c); err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	if timeout := el.svr.opts.TCPUserTimeout; timeout > 0 {
+		if _, ok := el.svr.ln.ln.(*net.TCPListener); ok {
+			_ = setUserTimeout(c.fd, timeout)
+		}
+	}
+	if el.svr.lifecycle != nil {
+		el.openAsync(c)
+		return nil
+	}
 	out, action := el.eventHandler.OnOpened(c)
-	if el.svr.opts.TCPKeepAlive > 0 {
+	return el.loopOpened(c, out, action)
+}
+
+// loopOpened sets up the connection after OnOpened returns, the connection opened by the workers of
+// LifecycleWorkers starts being polled here.
+func (el *eventloop) loopOpened(c *conn, out []byte, action Action) error {
+	if c.pendingOpen {
+		if !c.opened {
+			return nil
+		}
+		var err error
+		if c.outboundBuffer.IsEmpty() {
+			err = el.poller.AddRead(c.fd)
+		} else {
+			err = el.poller.AddReadWrite(c.fd)
+		}
+		if err != nil {
+			return el.loopCloseConn(c, err)
+		}
+		c.pendingOpen = false
+	}
+	if keepAlive := el.svr.options().TCPKeepAlive; keepAlive > 0 {
 		if _, ok := el.svr.ln.ln.(*net.TCPListener); ok {
-			_ = netpoll.SetKeepAlive(c.fd, int(el.svr.opts.TCPKeepAlive/time.Second))
+			_ = netpoll.SetKeepAlive(c.fd, int(keepAlive/time.Second))
 		}
 	}
+	if timeout := el.svr.options().FirstByteTimeout; timeout > 0 {
+		c.firstByteTimer = el.timingWheel().AfterFunc(timeout, func() error {
+			c.firstByteTimer = nil
+			return el.loopCloseConn(c, ErrFirstByteTimeout)
+		})
+	}
+	if timeout := el.svr.options().IdleTimeout; timeout > 0 {
+		c.lastActive = time.Now()
+		el.armIdleTimer(c, timeout)
+	} else if el.svr.fdWatermark > 0 {
+		c.lastActive = el.loopTime()
+	}
 	if out != nil {
 		c.open(out)
 	}
 
 	if !c.outboundBuffer.IsEmpty() {
-		_ = el.poller.AddWrite(c.fd)
+		_ = el.poller.ModReadWrite(c.fd)
 	}
+	el.accountMemory(c)
 
 	return el.handleAction(c, action)
 }
 
 func (el *eventloop) loopRead(c *conn) error {
-	n, err := unix.Read(c.fd, el.packet)
+	c.stopFirstByteTimer()
+	if c.idleTimer != nil {
+		c.lastActive = time.Now()
+	} else if el.svr.fdWatermark > 0 || atomic.LoadInt32(&el.svr.draining) == 1 {
+		c.lastActive = el.loopTime()
+	}
+	if c.udpPeer != "" {
+		return el.loopReadConnectedUDP(c)
+	}
+
+	if sp := c.splicer; sp != nil {
+		if atomic.LoadInt32(&sp.dead) == 0 {
+			return el.loopSplice(c)
+		}
+		el.stopSplice(c, ErrConnClosed)
+	}
+
+	var (
+		n   int
+		fds []int
+		err error
+	)
+	if el.svr.fdHandler != nil {
+		n, fds, err = el.readRights(c)
+	} else {
+		n, err = unix.Read(c.fd, el.packet)
+	}
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
-		return el.loopCloseConn(c, err)
+		return el.loopIOError(c, "read", err)
+	}
+	if tracer := el.svr.opts.Tracer; tracer != nil && c.bytesIn == 0 {
+		tracer.TraceFirstByte(c)
+	}
+	c.countIn(n)
+	if len(fds) > 0 {
+		if err = el.loopReceivedFDs(c, fds); err != nil || !c.opened {
+			return err
+		}
+	}
+	if z := c.inflater; z != nil {
+		if z.push(append([]byte(nil), el.packet[:n]...)) {
+			el.holdRead(c, holdByInflater)
+		}
+		el.accountMemory(c)
+		return nil
+	}
+	err = el.loopReactInbound(c, el.packet[:n])
+	if el.svr.opts.DebugChecks {
+		poison(el.packet[:n])
+	}
+	return err
+}
+
+// loopReactInbound passes the inbound data of the given connection to the handler, the data that is not consumed
+// is kept in the inbound buffer.
+func (el *eventloop) loopReactInbound(c *conn, data []byte) (err error) {
+	c.buffer = data
+	el.tap(c, Inbound, c.buffer)
+
+	if fh := el.svr.firstHandler; fh != nil && !c.previewed {
+		if done, err := el.loopFirstData(c, fh); !done {
+			return err
+		}
+	}
+
+	if th := el.svr.trafficHandler; th != nil {
+		err = el.handleAction(c, th.OnTraffic(c))
+	} else {
+		err = el.loopReact(c)
+	}
+	if err != nil || !c.opened {
+		return err
 	}
-	c.buffer = el.packet[:n]
+	c.keepInbound()
+	el.accountMemory(c)
 
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
-		out, action := el.eventHandler.React(inFrame, c)
+	return nil
+}
+
+// loopFirstData passes the leading bytes of the connection to OnFirstData once they all arrive, done is false if
+// the inbound data is kept for waiting for more or the connection is closed by the action.
+func (el *eventloop) loopFirstData(c *conn, fh FirstDataHandler) (done bool, err error) {
+	n := el.svr.opts.FirstDataBytes
+	if n <= 0 {
+		n = c.BufferLength()
+	}
+	preview, err := c.Peek(n)
+	if err != nil {
+		c.keepInbound()
+		el.accountMemory(c)
+		return false, nil
+	}
+	c.previewed = true
+	if err = el.handleAction(c, fh.OnFirstData(c, preview)); err != nil || !c.opened {
+		return false, err
+	}
+	return true, nil
+}
+
+// loopReact decodes frames from the inbound data of the given connection and passes them to React,
+// it serves as the adapter of EventHandler.React for the traffic event.
+func (el *eventloop) loopReact(c *conn) error {
+	if bh := el.svr.batchHandler; bh != nil && c.state == nil {
+		return el.loopReactBatch(c, bh)
+	}
+	var frames int
+	inFrame, err := c.read()
+	for ; inFrame != nil; inFrame, err = c.read() {
+		c.sequencer.next()
+		done := el.traceFrame(c, inFrame)
+		var canary frameCanary
+		if el.svr.opts.DebugChecks {
+			canary = c.watchFrame()
+		}
+		out, action := el.react(inFrame, c)
+		if canary.data != nil {
+			canary.check()
+		}
+		if done != nil {
+			done(out, action)
+		}
 		if out != nil {
-			outFrame, _ := el.codec.Encode(c, out)
+			outFrame, _ := c.codec.Encode(c, out)
 			el.eventHandler.PreWrite()
 			c.write(outFrame)
 		}
 		switch action {
 		case None:
 		case Close:
-			return el.loopCloseConn(c, nil)
+			return el.loopCloseConn(c, ErrClosedByHandler)
 		case Shutdown:
-			return errServerShutdown
+			return el.shutdown()
 		}
 		if !c.opened {
 			return nil
 		}
+		if frames++; frames == el.svr.opts.MaxFramesPerPoll {
+			el.resumeReact(c)
+			return nil
+		}
+	}
+	if err == ErrFrameTooLarge {
+		return el.loopCloseConn(c, err)
 	}
-	_, _ = c.inboundBuffer.Write(c.buffer)
+	return nil
+}
 
+// loopReactBatch decodes frames from the inbound data of the given connection and passes them to ReactBatch
+// at once.
+func (el *eventloop) loopReactBatch(c *conn, bh BatchHandler) error {
+	b := &el.batch
+	defer b.reset()
+	inFrame, err := c.read()
+	for ; inFrame != nil; inFrame, err = c.read() {
+		c.sequencer.next()
+		b.add(inFrame)
+		if len(b.frames) == el.svr.opts.MaxFramesPerPoll {
+			el.resumeReact(c)
+			break
+		}
+	}
+	if len(b.frames) > 0 {
+		outs, action := el.reactBatch(bh, b.frames, c)
+		if out := b.encode(c.codec, c, outs); out != nil {
+			el.eventHandler.PreWrite()
+			c.write(out)
+		}
+		if e := el.handleAction(c, action); e != nil || !c.opened {
+			return e
+		}
+	}
+	// The frames before the one too large are still passed to ReactBatch.
+	if err == ErrFrameTooLarge {
+		return el.loopCloseConn(c, err)
+	}
 	return nil
 }
 
+// resumeReact resumes decoding the frames left in the inbound buffer of the given connection in the next
+// iteration of polling, after the events of the other connections are handled, it serves MaxFramesPerPoll.
+func (el *eventloop) resumeReact(c *conn) {
+	if c.resuming {
+		return
+	}
+	c.resuming = true
+	_ = el.execute(func() error {
+		if c.moved(el) {
+			return nil // resumed by the event-loop adopting the connection
+		}
+		c.resuming = false
+		if !c.opened {
+			return nil
+		}
+		if err := el.loopReact(c); err != nil || !c.opened {
+			return err
+		}
+		c.keepInbound()
+		el.accountMemory(c)
+		return nil
+	})
+}
+
 func (el *eventloop) loopWrite(c *conn) error {
 	el.eventHandler.PreWrite()
 
 	head, tail := c.outboundBuffer.LazyReadAll()
-	n, err := unix.Write(c.fd, head)
+	if c.pacer != nil {
+		if head, tail = c.pacer.limit(head, tail); len(head) == 0 {
+			el.holdWrites(c)
+			return nil
+		}
+	}
+	n, err := c.writeSocket(head)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
-		return el.loopCloseConn(c, err)
+		return el.loopIOError(c, "write", err)
 	}
 	c.outboundBuffer.Shift(n)
+	c.outFlushed += uint64(n)
+	c.countOut(n)
+	el.traceFlush(c, n)
+	el.spendPacing(c, n)
 
 	if len(head) == n && tail != nil {
-		n, err = unix.Write(c.fd, tail)
+		n, err = c.writeSocket(tail)
 		if err != nil {
 			if err == unix.EAGAIN {
 				return nil
 			}
-			return el.loopCloseConn(c, err)
+			return el.loopIOError(c, "write", err)
 		}
 		c.outboundBuffer.Shift(n)
+		c.outFlushed += uint64(n)
+		c.countOut(n)
+		el.traceFlush(c, n)
+		el.spendPacing(c, n)
 	}
 
+	if len(c.outMarks) > 0 {
+		c.trimMarks()
+	}
 	if c.outboundBuffer.IsEmpty() {
 		_ = el.poller.ModRead(c.fd)
+		c.drainedSocket()
+		if len(c.throttled) > 0 {
+			c.resumeSplicers()
+		}
+	} else if c.pacer != nil && c.pacer.budget <= 0 {
+		el.holdWrites(c)
 	}
+	el.accountMemory(c)
 	return nil
 }
 
+// closeReason translates the error of the failed I/O on connection into the reason passed to OnClosed.
+func closeReason(err error) error {
+	switch err {
+	case nil:
+		return ErrEOF
+	case unix.ECONNRESET, unix.EPIPE:
+		return ErrReset
+	}
+	return err
+}
+
+// react passes the frame to the handler of the current state of the given connection, or React if it's not set.
+func (el *eventloop) react(frame []byte, c *conn) ([]byte, Action) {
+	if el.svr.opts.LoopProfiling {
+		defer el.profile.since(&el.profile.handler, time.Now())
+	}
+	if c.state != nil {
+		return c.state(frame, c)
+	}
+	return el.eventHandler.React(frame, c)
+}
+
+// reactBatch passes the frames to ReactBatch, the time of it is profiled if LoopProfiling is set.
+func (el *eventloop) reactBatch(bh BatchHandler, frames [][]byte, c *conn) ([][]byte, Action) {
+	if el.svr.opts.LoopProfiling {
+		defer el.profile.since(&el.profile.handler, time.Now())
+	}
+	return bh.ReactBatch(frames, c)
+}
+
+// loopIOError handles the failed I/O on the given connection, it is closed unless ErrorHandler decides otherwise,
+// a nil err means that the connection is closed by peer.
+func (el *eventloop) loopIOError(c *conn, op string, err error) error {
+	if eh := el.svr.errorHandler; eh != nil && err != nil {
+		switch eh.OnError(c, op, err) {
+		case None:
+			return nil
+		case Shutdown:
+			return el.shutdown()
+		}
+	}
+	return el.loopCloseConn(c, closeReason(err))
+}
+
+// loopCloseConn closes the given connection with the reason which is passed to OnClosed,
+// the pending outbound data is flushed beforehand unless the connection is broken.
 func (el *eventloop) loopCloseConn(c *conn, err error) error {
-	if !c.outboundBuffer.IsEmpty() && err == nil {
+	c.stopDirect()
+	if !c.outboundBuffer.IsEmpty() && (err == ErrEOF || err == ErrClosedByHandler || err == ErrServerShutdown) {
 		_ = el.loopWrite(c)
 	}
-	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
+	var err0 error
+	if !c.pendingOpen && !c.unpolled {
+		err0 = el.poller.Delete(c.fd)
+	}
+	err1 := unix.Close(c.fd)
 	if err0 == nil && err1 == nil {
 		delete(el.connections, c.fd)
 		el.calibrateCallback(el, -1)
-		switch el.eventHandler.OnClosed(c, err) {
-		case Shutdown:
-			return errServerShutdown
+		el.adjustMemory(-c.memHeld)
+		el.svr.mem.addOutbound(-c.outHeld)
+		c.memHeld, c.outHeld = 0, 0
+		// The poller has let go of the file descriptor.
+		c.readHolds = 0
+		if c.splicer != nil {
+			el.stopSplice(c, err)
+		}
+		if len(c.throttled) > 0 {
+			c.resumeSplicers()
+		}
+		if c.udpPeer != "" {
+			el.svr.udpPeers.Delete(c.udpPeer)
+			atomic.AddInt32(&el.svr.udpSessions, -1)
+		}
+		el.svr.bus.unsubscribeAll(c)
+		if c.peer != nil {
+			el.svr.peers().release(c.peer)
+			c.peer = nil
+		}
+		atomic.StoreInt32(&c.closed, 1)
+		el.recordClose(c, err)
+		if el.svr.lifecycle != nil {
+			el.closeAsync(c, err)
+			return nil
 		}
+		action := el.eventHandler.OnClosed(c, err)
 		c.releaseTCP()
+		if action == Shutdown {
+			return el.shutdown()
+		}
 	} else {
 		if err0 != nil {
-			el.svr.logger.Printf("failed to delete fd:%d from poller, error:%v\n", c.fd, err0)
+			el.svr.logger().Printf("failed to delete fd:%d from poller, error:%v\n", c.fd, err0)
 		}
 		if err1 != nil {
-			el.svr.logger.Printf("failed to close fd:%d, error:%v\n", c.fd, err1)
+			el.svr.logger().Printf("failed to close fd:%d, error:%v\n", c.fd, err1)
 		}
 	}
 	return nil
 }
 
+// loopPublish writes the data published to a topic to the given subscriber.
+func (el *eventloop) loopPublish(c Conn, data []byte) {
+	if c := c.(*conn); c.moved(el) {
+		_ = c.trigger(func() error {
+			c.loop.loopPublish(c, data)
+			return nil
+		})
+	} else if c.opened && !c.congested() {
+		out, _ := c.codec.Encode(c, data)
+		c.write(out)
+		el.accountMemory(c)
+	}
+}
+
 func (el *eventloop) loopWake(c *conn) error {
 	//if co, ok := el.connections[c.fd]; !ok || co != c {
 	//	return nil // ignore stale wakes.
 	//}
-	out, action := el.eventHandler.React(nil, c)
+	out, action := el.react(nil, c)
 	if out != nil {
-		frame, _ := el.codec.Encode(c, out)
+		frame, _ := c.codec.Encode(c, out)
 		c.write(frame)
 	}
+	el.accountMemory(c)
 	return el.handleAction(c, action)
 }
 
-func (el *eventloop) loopTicker() {
-	var (
-		delay time.Duration
-		open  bool
-		err   error
-	)
-	for {
-		err = el.poller.Trigger(func() (err error) {
-			delay, action := el.eventHandler.Tick()
-			el.svr.ticktock <- delay
-			switch action {
-			case None:
-			case Shutdown:
-				err = errServerShutdown
+// armIdleTimer sets up the timer which closes the connection if it has received nothing for IdleTimeout
+// when the timer fires, otherwise the timer is set up again for the rest of IdleTimeout.
+func (el *eventloop) armIdleTimer(c *conn, d time.Duration) {
+	c.idleTimer = el.timingWheel().AfterFunc(d, func() error {
+		c.idleTimer = nil
+		timeout := el.svr.options().IdleTimeout
+		if timeout <= 0 {
+			return nil
+		}
+		if idle := time.Since(c.lastActive); idle < timeout {
+			el.armIdleTimer(c, timeout-idle)
+			return nil
+		}
+		return el.loopCloseConn(c, ErrIdleTimeout)
+	})
+}
+
+// startCompression sets up the compression of the streams of the given connection by the negotiation
+// of the handler or Options.Compression.
+func (el *eventloop) startCompression(c *conn) (err error) {
+	alg, level := el.svr.opts.Compression, el.svr.opts.CompressionLevel
+	if el.svr.negotiator != nil {
+		alg, level = el.svr.negotiator.NegotiateCompression(c)
+	}
+	if alg == NoCompression {
+		return
+	}
+	if c.deflater, err = newDeflater(alg, level); err != nil {
+		return
+	}
+	var z *inflater
+	z = newInflater(func() {
+		_ = c.trigger(func() error {
+			if c.opened && c.inflater == z {
+				c.loop.releaseRead(c, holdByInflater)
 			}
-			return
+			return nil
 		})
-		if err != nil {
-			el.svr.logger.Printf("failed to awake poller with error:%v, stopping ticker\n", err)
-			break
+	})
+	c.inflater = z
+	go z.run(alg, func(data []byte) {
+		_ = c.trigger(func() error {
+			z.delivered(len(data))
+			if !c.opened || c.inflater != z {
+				return nil
+			}
+			return c.loop.loopReactInbound(c, data)
+		})
+	}, func(err error) {
+		if err == io.EOF {
+			err = ErrEOF
 		}
-		if delay, open = <-el.svr.ticktock; open {
-			time.Sleep(delay)
-		} else {
-			break
+		_ = c.trigger(func() error {
+			if !c.opened || c.inflater != z {
+				return nil
+			}
+			return c.loop.loopCloseConn(c, err)
+		})
+	})
+	return
+}
+
+// watchWrite closes the connection with ErrWriteTimeout if the data in its outbound buffer by now is not flushed
+// to the socket within the given duration.
+func (el *eventloop) watchWrite(c *conn, d time.Duration) {
+	if !c.opened || c.outboundBuffer.IsEmpty() {
+		return
+	}
+	target := c.outFlushed + uint64(c.outboundBuffer.Length())
+	el.timingWheel().AfterFunc(d, c.follow(el, func() error {
+		if c.opened && c.outFlushed < target {
+			return c.loop.loopCloseConn(c, ErrWriteTimeout)
 		}
+		return nil
+	}))
+}
+
+// recordOpen notes the time of opening the given connection and emits the record of it to ConnRecorder and Tracer
+// if they are set.
+func (el *eventloop) recordOpen(c *conn) {
+	c.openedAt = time.Now()
+	el.lifetime.opened++
+	if recorder := el.svr.opts.ConnRecorder; recorder != nil {
+		recorder(ConnRecord{LocalAddr: c.localAddr, RemoteAddr: c.remoteAddr, OpenedAt: c.openedAt})
+	}
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		tracer.TraceOpen(c)
+	}
+}
+
+// recordClose counts the reason of closing the given connection for ShutdownReport and emits the record of it
+// to ConnRecorder and Tracer if they are set.
+func (el *eventloop) recordClose(c *conn, reason error) {
+	el.lifetime.close(reason)
+	if recorder := el.svr.opts.ConnRecorder; recorder != nil {
+		recorder(ConnRecord{
+			Closed:     true,
+			LocalAddr:  c.localAddr,
+			RemoteAddr: c.remoteAddr,
+			OpenedAt:   c.openedAt,
+			Duration:   time.Since(c.openedAt),
+			BytesIn:    c.bytesIn,
+			BytesOut:   c.bytesOut,
+			Reason:     reason,
+		})
+	}
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		tracer.TraceClose(c, reason)
+	}
+}
+
+// traceFrame passes the decoded frame of connection to Tracer if it is set, it returns the function
+// to be called when React returns, nil if there is none.
+func (el *eventloop) traceFrame(c *conn, frame []byte) func(out []byte, action Action) {
+	if tracer := el.svr.opts.Tracer; tracer != nil {
+		return tracer.TraceFrame(c, frame)
+	}
+	return nil
+}
+
+// traceFlush reports the bytes of connection written to the socket to Tracer if it is set.
+func (el *eventloop) traceFlush(c *conn, n int) {
+	if tracer := el.svr.opts.Tracer; tracer != nil && n > 0 {
+		tracer.TraceFlush(c, n)
+	}
+}
+
+// tap passes the data of connection to TrafficTap if it is set.
+func (el *eventloop) tap(c *conn, dir Direction, data []byte) {
+	if tap := el.svr.opts.TrafficTap; tap != nil {
+		tap(c, dir, data)
 	}
 }
 
@@ -230,32 +830,350 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 	case None:
 		return nil
 	case Close:
-		return el.loopCloseConn(c, nil)
+		return el.loopCloseConn(c, ErrClosedByHandler)
 	case Shutdown:
-		return errServerShutdown
+		return el.shutdown()
 	default:
 		return nil
 	}
 }
 
 func (el *eventloop) loopReadUDP(fd int) error {
-	n, sa, err := unix.Recvfrom(fd, el.packet, 0)
+	var (
+		n   int
+		sa  unix.Sockaddr
+		cm  *ControlMessage
+		err error
+	)
+	if el.svr.opts.UDPControl && strings.HasPrefix(el.svr.ln.network, "udp") {
+		n, sa, cm, err = el.recvmsgUDP(fd)
+	} else {
+		n, sa, err = unix.Recvfrom(fd, el.packet, 0)
+	}
 	if err != nil || n == 0 {
 		if err != nil && err != unix.EAGAIN {
-			el.svr.logger.Printf("failed to read UDP packet from fd:%d, error:%v\n", fd, err)
+			el.svr.logger().Printf("failed to read UDP packet from fd:%d, error:%v\n", fd, err)
 		}
 		return nil
 	}
+	if el.svr.opts.UDPConnect && strings.HasPrefix(el.svr.ln.network, "udp") {
+		c, err := el.loopConnectUDP(sa)
+		if err != nil || c != nil && !c.opened {
+			return err
+		}
+		if c != nil {
+			if c.idleTimer != nil {
+				c.lastActive = time.Now()
+			}
+			c.countIn(n)
+			el.tap(c, Inbound, el.packet[:n])
+			return el.loopReactUDP(c, el.packet[:n])
+		}
+	}
 	c := newUDPConn(fd, el, sa)
-	out, action := el.eventHandler.React(el.packet[:n], c)
+	c.cm = cm
+	el.tap(c, Inbound, el.packet[:n])
+	out, action := el.react(el.packet[:n], c)
 	if out != nil {
 		el.eventHandler.PreWrite()
+		el.tap(c, Outbound, out)
 		_ = c.sendTo(out)
 	}
+	if el.svr.opts.DebugChecks {
+		poison(el.packet[:n])
+	}
 	switch action {
 	case Shutdown:
-		return errServerShutdown
+		return el.shutdown()
 	}
 	c.releaseUDP()
 	return nil
 }
+
+// recvmsgUDP reads a datagram along with its control messages of UDPControl.
+func (el *eventloop) recvmsgUDP(fd int) (n int, sa unix.Sockaddr, cm *ControlMessage, err error) {
+	if el.oob == nil {
+		el.oob = make([]byte, controlMessageSpace)
+	}
+	var oobn int
+	if n, oobn, _, sa, err = unix.Recvmsg(fd, el.packet, el.oob, 0); err == nil {
+		cm = parseControlMessage(el.oob[:oobn])
+	}
+	return
+}
+
+// loopConnectUDP returns the connected UDP socket of the remote peer owned by this event-loop, it creates one
+// if the peer hasn't been seen yet, nil is returned if the peer is owned by another event-loop, it is over the limits
+// of sessions or the creation fails.
+func (el *eventloop) loopConnectUDP(sa unix.Sockaddr) (*conn, error) {
+	addr := netpoll.SockaddrToUDPOrUnixgramAddr(sa)
+	peer := addr.String()
+	if v, loaded := el.svr.udpPeers.LoadOrStore(peer, (*conn)(nil)); loaded {
+		if c, _ := v.(*conn); c != nil && c.loop == el {
+			return c, nil
+		}
+		return nil, nil
+	}
+	// The peers which are not admitted are left to the shared socket, the placeholder is deleted so that
+	// they are admitted again by their next datagrams once the sessions are closed.
+	opts := el.svr.options()
+	if n := atomic.AddInt32(&el.svr.udpSessions, 1); opts.UDPMaxSessions > 0 && int(n) > opts.UDPMaxSessions {
+		atomic.AddInt32(&el.svr.udpSessions, -1)
+		el.svr.udpPeers.Delete(peer)
+		return nil, nil
+	}
+	entry, ok := el.svr.admitPeer(sa)
+	if !ok {
+		atomic.AddInt32(&el.svr.udpSessions, -1)
+		el.svr.udpPeers.Delete(peer)
+		return nil, nil
+	}
+	var setup func(fd int) error
+	if opts.SocketMark != 0 || opts.BindToDevice != "" || opts.FreeBind {
+		setup = func(fd int) error {
+			return setRoutingSockopts(fd, opts)
+		}
+	}
+	fd, err := netpoll.ConnectUDP(el.ln.fd, sa, setup)
+	if err == nil && el.svr.fdWatermark > 0 && fd >= el.svr.fdWatermark {
+		_ = unix.Close(fd)
+		err = ErrFDLimit
+	}
+	if err == nil {
+		if err = el.poller.AddRead(fd); err != nil {
+			_ = unix.Close(fd)
+		}
+	}
+	if err != nil {
+		atomic.AddInt32(&el.svr.udpSessions, -1)
+		el.svr.udpPeers.Delete(peer)
+		if entry != nil {
+			el.svr.peers().release(entry)
+		}
+		if err != ErrFDLimit {
+			el.svr.logger().Printf("failed to connect UDP socket to %s, error:%v\n", peer, err)
+		}
+		return nil, nil
+	}
+	c := newConnectedUDPConn(fd, el, sa, peer)
+	el.svr.udpPeers.Store(peer, c)
+	el.connections[c.fd] = c
+	el.calibrateCallback(el, 1)
+	c.opened = true
+	c.peer = entry
+	c.localAddr = el.svr.ln.lnaddr
+	c.remoteAddr = addr
+	if timeout := opts.IdleTimeout; timeout > 0 {
+		c.lastActive = time.Now()
+		el.armIdleTimer(c, timeout)
+	}
+	el.recordOpen(c)
+	out, action := el.eventHandler.OnOpened(c)
+	if out != nil {
+		el.eventHandler.PreWrite()
+		c.write(out)
+	}
+	return c, el.handleAction(c, action)
+}
+
+// loopReadConnectedUDP reads a datagram from the connected UDP socket.
+func (el *eventloop) loopReadConnectedUDP(c *conn) error {
+	n, err := unix.Read(c.fd, el.packet)
+	if err != nil {
+		if err == unix.EAGAIN {
+			return nil
+		}
+		return el.loopIOError(c, "read", err)
+	}
+	c.countIn(n)
+	el.tap(c, Inbound, el.packet[:n])
+	return el.loopReactUDP(c, el.packet[:n])
+}
+
+func (el *eventloop) loopReactUDP(c *conn, packet []byte) error {
+	done := el.traceFrame(c, packet)
+	out, action := el.react(packet, c)
+	if done != nil {
+		done(out, action)
+	}
+	if out != nil {
+		el.eventHandler.PreWrite()
+		c.write(out)
+	}
+	return el.handleAction(c, action)
+}
+
+// accountMemory reports the change of bytes held in buffers of the given connection to the memory accountant.
+func (el *eventloop) accountMemory(c *conn) {
+	if !c.opened {
+		return
+	}
+	out := int64(c.outboundBuffer.Length())
+	if delta := out - c.outHeld; delta != 0 {
+		c.outHeld = out
+		el.svr.mem.addOutbound(delta)
+	}
+	if el.svr.opts.SlowConsumerThreshold > 0 {
+		el.watchSlowConsumer(c, int(out))
+	}
+	held := int64(c.inboundBuffer.Length()) + out
+	if c.inflater != nil {
+		held += int64(c.inflater.size())
+	}
+	if delta := held - c.memHeld; delta != 0 {
+		c.memHeld = held
+		el.adjustMemory(delta)
+	}
+}
+
+// Reasons for holding the readable events of a connection.
+const (
+	holdBySplice   uint8 = 1 << iota // the destination of the splice is backed up
+	holdByInflater                   // the queue of the decompressor is full
+)
+
+// holdRead stops polling the readable events of the given connection for the reason until releaseRead is called
+// with it.
+func (el *eventloop) holdRead(c *conn, reason uint8) {
+	if c.readHolds == 0 {
+		_ = el.poller.HoldRead(c.fd, !c.outboundBuffer.IsEmpty())
+	}
+	c.readHolds |= reason
+}
+
+// releaseRead drops the reason for holding the readable events of the given connection, which are polled again
+// once no reason is left.
+func (el *eventloop) releaseRead(c *conn, reason uint8) {
+	if c.readHolds&reason == 0 {
+		return
+	}
+	if c.readHolds &^= reason; c.readHolds == 0 {
+		_ = el.poller.ReleaseRead(c.fd, !c.outboundBuffer.IsEmpty())
+	}
+}
+
+// adjustMemory adds delta to the memory usage of server and applies the memory policy
+// when the memory budget is exceeded.
+func (el *eventloop) adjustMemory(delta int64) {
+	if delta == 0 {
+		return
+	}
+	el.svr.applyMemoryPolicy(el.svr.mem.add(delta))
+}
+
+// evictMostBuffered closes the connections holding the most buffered bytes in this event-loop
+// until the memory usage drops below the limit.
+func (el *eventloop) evictMostBuffered() {
+	_ = el.poller.Trigger(func() error {
+		for el.svr.mem.overLimit() {
+			var victim *conn
+			for _, c := range el.connections {
+				if c.memHeld > 0 && (victim == nil || c.memHeld > victim.memHeld) {
+					victim = c
+				}
+			}
+			if victim == nil {
+				break
+			}
+			if err := el.loopCloseConn(victim, ErrBufferLimit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// endBatch runs after each batch of events and jobs, it expires the time cached for LoopTime and flushes
+// the writes staged by WriteBuffering.
+func (el *eventloop) endBatch() error {
+	el.now = time.Time{}
+	if el.svr.opts.WriteBuffering {
+		return el.flushStaged()
+	}
+	return nil
+}
+
+// loopTime returns the time cached for the current batch of events, it is captured on the first call.
+func (el *eventloop) loopTime() time.Time {
+	if el.now.IsZero() {
+		el.now = time.Now()
+	}
+	return el.now
+}
+
+// stage holds the data written to the given connection in its outbound buffer until the end of the current
+// batch of events or Flush.
+func (el *eventloop) stage(c *conn, buf []byte) {
+	c.bufferOutbound(buf)
+	if !c.staged {
+		c.staged = true
+		el.staged = append(el.staged, c)
+	}
+}
+
+// flushStaged flushes the writes staged by WriteBuffering to the sockets.
+func (el *eventloop) flushStaged() error {
+	for i, c := range el.staged {
+		el.staged[i] = nil
+		if err := el.loopFlush(c); err != nil {
+			el.staged = el.staged[:0]
+			return err
+		}
+	}
+	el.staged = el.staged[:0]
+	return nil
+}
+
+// loopFlush writes the outbound buffer of the given connection to the socket as much as possible, and waits
+// for the socket to be writable for the rest.
+func (el *eventloop) loopFlush(c *conn) error {
+	c.staged = false
+	if !c.opened || c.outboundBuffer.IsEmpty() {
+		return nil
+	}
+	if err := el.loopWrite(c); err != nil || !c.opened {
+		return err
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		_ = el.poller.ModReadWrite(c.fd)
+	}
+	return nil
+}
+
+// throttleAccept stops polling the listener until the next token of AcceptRate is available if there is none,
+// it reports whether accepting is throttled.
+func (el *eventloop) throttleAccept() bool {
+	wait := el.svr.throttleAccept()
+	if wait <= 0 {
+		return false
+	}
+	el.acceptThrottled = true
+	el.toggleAccepting()
+	el.timingWheel().AfterFunc(wait, func() error {
+		el.acceptThrottled = false
+		el.toggleAccepting()
+		return nil
+	})
+	return true
+}
+
+// toggleAccepting starts or stops polling the listener according to the server state.
+func (el *eventloop) toggleAccepting() {
+	paused := !el.svr.acceptable() || el.acceptThrottled
+	if paused == el.acceptPaused {
+		return
+	}
+	var err error
+	if paused {
+		err = el.poller.DeleteRead(el.ln.fd)
+	} else if len(el.svr.mainLoops) > 1 {
+		err = el.poller.AddReadExclusive(el.ln.fd)
+	} else {
+		err = el.poller.AddRead(el.ln.fd)
+	}
+	if err != nil {
+		el.svr.logger().Printf("failed to toggle polling on listener fd:%d, error:%v\n", el.ln.fd, err)
+		return
+	}
+	el.acceptPaused = paused
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// fdWatermark returns the file descriptor from which the server is under the pressure of the limit of file
+// descriptors, 0 means FDWatermark is not set. The file descriptors are allocated from the lowest free one,
+// so the value of the newly accepted one tells how many of them are open.
+func fdWatermark(watermark float64) int {
+	if watermark <= 0 || watermark > 1 {
+		return 0
+	}
+	var rlimit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0
+	}
+	if fd := int(float64(rlimit.Cur) * watermark); fd > 0 {
+		return fd
+	}
+	return 1
+}
+
+// rejectFD reports whether the newly accepted file descriptor must be closed right away for being over
+// the watermark with RejectNew.
+func (svr *server) rejectFD(fd int) bool {
+	return svr.fdWatermark > 0 && fd >= svr.fdWatermark && svr.opts.FDLimitPolicy == RejectNew
+}
+
+// relieveFDPressure closes the least active connection of the event-loop if the newly accepted connection
+// is over the watermark with CloseLeastActive, the connections of PriorityHigh are spared unless there are
+// no others.
+func (el *eventloop) relieveFDPressure(accepted *conn) error {
+	svr := el.svr
+	if svr.fdWatermark == 0 || accepted.fd < svr.fdWatermark || svr.opts.FDLimitPolicy != CloseLeastActive {
+		return nil
+	}
+	var victim *conn
+	for _, c := range el.connections {
+		if c == accepted || !c.opened || c.udpPeer != "" {
+			continue
+		}
+		if victim == nil || c.priority < victim.priority ||
+			c.priority == victim.priority && c.lastActive.Before(victim.lastActive) {
+			victim = c
+		}
+	}
+	if victim == nil {
+		return nil
+	}
+	return el.loopCloseConn(victim, ErrFDLimit)
+}
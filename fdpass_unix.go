@@ -0,0 +1,84 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// maxReceivedFDs is the number of file descriptors which can be received along with a read, the ones beyond it
+// are discarded by the kernel.
+const maxReceivedFDs = 64
+
+func (c *conn) SendFD(fd int) error {
+	if network := c.loop.svr.ln.network; network != "unix" && network != "unixpacket" {
+		return ErrUnsupportedProtocol
+	}
+	if !c.opened {
+		return ErrConnClosed
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		if err := c.loop.loopWrite(c); err != nil || !c.opened {
+			return ErrConnClosed
+		}
+		if !c.outboundBuffer.IsEmpty() {
+			return ErrWouldBlock
+		}
+	}
+	if c.takeSocket() {
+		defer c.releaseSocket()
+	}
+	if !c.outboundBuffer.IsEmpty() {
+		// The rest of a direct write of SetLowLatency is buffered by takeSocket.
+		return ErrWouldBlock
+	}
+	n, err := unix.SendmsgN(c.fd, []byte{0}, unix.UnixRights(fd), nil, 0)
+	if err != nil {
+		if err == unix.EAGAIN {
+			return ErrWouldBlock
+		}
+		return err
+	}
+	c.countOut(n)
+	return nil
+}
+
+// readRights reads the connection of unix domain socket like read(2) along with the file descriptors sent
+// by the peer, which are set close-on-exec.
+func (el *eventloop) readRights(c *conn) (n int, fds []int, err error) {
+	if el.rightsOOB == nil {
+		el.rightsOOB = make([]byte, unix.CmsgSpace(maxReceivedFDs*4))
+	}
+	var oobn int
+	if n, oobn, _, _, err = unix.Recvmsg(c.fd, el.packet, el.rightsOOB, 0); err != nil || oobn == 0 {
+		return
+	}
+	msgs, _ := unix.ParseSocketControlMessage(el.rightsOOB[:oobn])
+	for i := range msgs {
+		if msgs[i].Header.Level != unix.SOL_SOCKET || msgs[i].Header.Type != unix.SCM_RIGHTS {
+			continue
+		}
+		rights, _ := unix.ParseUnixRights(&msgs[i])
+		for _, fd := range rights {
+			unix.CloseOnExec(fd)
+		}
+		fds = append(fds, rights...)
+	}
+	return
+}
+
+// loopReceivedFDs passes the file descriptors received by the given connection to OnReceivedFD, the ones left
+// are closed if the connection is closed by the handler.
+func (el *eventloop) loopReceivedFDs(c *conn, fds []int) (err error) {
+	for i, fd := range fds {
+		if err = el.handleAction(c, el.svr.fdHandler.OnReceivedFD(c, fd)); err != nil || !c.opened {
+			for _, fd := range fds[i+1:] {
+				_ = unix.Close(fd)
+			}
+			return
+		}
+	}
+	return
+}
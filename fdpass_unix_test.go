@@ -0,0 +1,100 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFDPassing(t *testing.T) {
+	events := &testFDServer{network: "unix", addr: "gnet-fd.sock"}
+	must(Serve(events, "unix://gnet-fd.sock"))
+	if events.received != 1 || events.sent != 1 {
+		t.Fatalf("expected 1 descriptor received and 1 sent, got %d and %d", events.received, events.sent)
+	}
+}
+
+type testFDServer struct {
+	*EventServer
+	network, addr  string
+	received, sent int
+}
+
+func (s *testFDServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		uc := c.(*net.UnixConn)
+		must(uc.SetReadDeadline(time.Now().Add(time.Second * 3)))
+
+		// Pass the writing end of a pipe to the server, which writes to it.
+		r, w, err := os.Pipe()
+		must(err)
+		_, _, err = uc.WriteMsgUnix([]byte{0}, syscall.UnixRights(int(w.Fd())), nil)
+		must(err)
+		must(w.Close())
+		expectPipe(r, "hello")
+
+		// The server passes back the reading end of its own pipe in reply.
+		buf, oob := make([]byte, 1), make([]byte, syscall.CmsgSpace(4))
+		n, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+		must(err)
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		must(err)
+		if n != 1 || buf[0] != 0 || len(msgs) != 1 {
+			panic(fmt.Sprintf("unexpected message carrying descriptor: %q, %d control messages", buf[:n], len(msgs)))
+		}
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		must(err)
+		expectPipe(os.NewFile(uintptr(fds[0]), "pipe"), "world")
+	}()
+	return
+}
+
+func expectPipe(r *os.File, expected string) {
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	must(err)
+	if string(data) != expected {
+		panic(fmt.Sprintf("expected %q from pipe, got %q", expected, data))
+	}
+}
+
+func (s *testFDServer) OnReceivedFD(c Conn, fd int) (action Action) {
+	s.received++
+	f := os.NewFile(uintptr(fd), "pipe")
+	_, err := f.WriteString("hello")
+	must(err)
+	must(f.Close())
+	return
+}
+
+func (s *testFDServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "\x00" {
+		panic(fmt.Sprintf("unexpected frame carrying descriptor: %q", frame))
+	}
+	r, w, err := os.Pipe()
+	must(err)
+	_, err = w.WriteString("world")
+	must(err)
+	must(w.Close())
+	must(c.SendFD(int(r.Fd())))
+	must(r.Close())
+	s.sent++
+	return
+}
+
+func (s *testFDServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
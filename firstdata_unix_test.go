@@ -0,0 +1,87 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFirstData(t *testing.T) {
+	events := &testFirstDataServer{network: "tcp", addr: "127.0.0.1:9943"}
+	must(Serve(events, "tcp://127.0.0.1:9943", WithFirstDataBytes(4), WithNumEventLoop(1)))
+	if previews := atomic.LoadInt32(&events.previews); previews != 2 {
+		t.Fatalf("expected OnFirstData once per connection, got %d calls", previews)
+	}
+}
+
+type testFirstDataServer struct {
+	*EventServer
+	network, addr string
+	previews      int32
+	closed        int32
+}
+
+func (s *testFirstDataServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// The garbage is rejected before the codec engages.
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("\x16\x03\x01garbage"))
+		must(err)
+		if data, err := ioutil.ReadAll(c); err != nil || len(data) != 0 {
+			panic("the connection of garbage is not rejected")
+		}
+		must(c.Close())
+
+		// The preview waits for the first 4 bytes and picks the codec, the bytes are decoded by it afterwards.
+		c, err = net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("LI"))
+		must(err)
+		time.Sleep(time.Millisecond * 50)
+		_, err = c.Write([]byte("NE\nhello\n"))
+		must(err)
+		buf := make([]byte, len("LINE\nhello\n"))
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if string(buf) != "LINE\nhello\n" {
+			panic("unexpected response: " + string(buf))
+		}
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testFirstDataServer) OnFirstData(c Conn, preview []byte) (action Action) {
+	atomic.AddInt32(&s.previews, 1)
+	if len(preview) != 4 {
+		panic("unexpected size of preview")
+	}
+	if string(preview) != "LINE" {
+		return Close
+	}
+	c.SetCodec(new(LineBasedFrameCodec))
+	return
+}
+
+func (s *testFirstDataServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testFirstDataServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&s.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
@@ -6,12 +6,14 @@
 package gnet
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
 	"runtime"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/panjf2000/gnet/internal/netpoll"
@@ -31,6 +33,99 @@ const (
 	Shutdown
 )
 
+// Priority is the priority of a connection in having its events handled by the event-loop.
+type Priority int
+
+const (
+	// PriorityNormal is the default priority of connections.
+	PriorityNormal Priority = iota
+
+	// PriorityHigh makes the events of connection handled ahead of the ones of normal connections
+	// in each batch of events polled by the event-loop.
+	PriorityHigh
+)
+
+// Direction is the direction of the data passing through a connection.
+type Direction int
+
+const (
+	// Inbound is the direction of the data received from the remote peer.
+	Inbound Direction = iota
+
+	// Outbound is the direction of the data sent to the remote peer.
+	Outbound
+)
+
+// OverloadPolicy is the behavior of the asynchronous APIs when the task queue of event-loop is full.
+type OverloadPolicy int
+
+const (
+	// OverloadBlock blocks the caller until there is room in the queue, so the asynchronous APIs must not be
+	// called on the same event-loop (e.g. within the event callbacks) with it, which would block forever.
+	OverloadBlock OverloadPolicy = iota
+
+	// OverloadReject returns ErrTaskQueueFull to the caller.
+	OverloadReject
+
+	// OverloadDropOldest drops the oldest task in the queue to make room for the new one, e.g. the stale updates
+	// of a live feed, the data of the dropped writes is never written.
+	OverloadDropOldest
+)
+
+// FDLimitPolicy is the action that is taken when the file descriptors of process reach FDWatermark.
+type FDLimitPolicy int
+
+const (
+	// CloseLeastActive closes the connection which has received nothing for the longest time in the event-loop
+	// taking the newly accepted connection, one for each accepted connection over the watermark, the connections
+	// of PriorityHigh are closed only when there are no others. OnClosed fires with ErrFDLimit.
+	CloseLeastActive FDLimitPolicy = iota
+
+	// RejectNew closes the newly accepted connections over the watermark right away, before OnOpened.
+	RejectNew
+)
+
+// SlowConsumerPolicy is the action that is taken when the outbound buffer of a connection stays above
+// SlowConsumerThreshold for SlowConsumerTimeout.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerClose closes the slow connection without flushing its outbound buffer, OnClosed fires
+	// with ErrSlowConsumer.
+	SlowConsumerClose SlowConsumerPolicy = iota
+
+	// SlowConsumerDropOldest discards the oldest pending writes in the outbound buffer of the slow connection
+	// until it drops to the threshold, the write being flushed is always kept since the peer may have received
+	// a part of it. The connections with Compression are closed instead since their stream can't be cut.
+	SlowConsumerDropOldest
+
+	// SlowConsumerPause pushes back on the sources of the slow connection until its outbound buffer drops to
+	// the threshold: AsyncWrite, AsyncWriteWithTimeout, AsyncWriteAfter and AsyncWriteSeq fail with ErrSlowConsumer
+	// and the data published to its topics is skipped.
+	SlowConsumerPause
+
+	// SlowConsumerNotifyOnly does nothing but invoking OnSlowConsumer.
+	SlowConsumerNotifyOnly
+)
+
+// UDPSendPolicy is the behavior of sending a datagram from the UDP socket of server when the socket buffer is full.
+type UDPSendPolicy int
+
+const (
+	// UDPSendDropNewest drops the datagram being sent if the queue of UDPSendQueue is full too, SendTo returns
+	// ErrUDPSendDropped for it.
+	UDPSendDropNewest UDPSendPolicy = iota
+
+	// UDPSendDropOldest drops the oldest datagram in the queue of UDPSendQueue to make room for the one being sent,
+	// it is the same as UDPSendDropNewest without the queue.
+	UDPSendDropOldest
+
+	// UDPSendBlock blocks the sender until the socket buffer has room for the datagram, for UDPSendTimeout at most,
+	// after which the datagram is dropped with ErrUDPSendDropped. The datagrams are not queued with it, Serve fails
+	// with ErrUDPSendBlockQueued if UDPSendQueue is set, and the event-loop is blocked by the replies of React.
+	UDPSendBlock
+)
+
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
 
 // Logger is used for logging formatted messages.
@@ -62,6 +157,10 @@ type Server struct {
 
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
+
+	// ListenBacklog is the effective backlog of the listener, which is ListenBacklog capped by the limit
+	// of system or the limit itself if ListenBacklog is not set, 0 for UDP or if it is unknown.
+	ListenBacklog int
 }
 
 // CountConnections counts the number of currently active connections and returns it.
@@ -73,20 +172,197 @@ func (s Server) CountConnections() (count int) {
 	return
 }
 
+// BytesRead returns the total number of bytes read from the connections of the server since it started, the
+// datagrams of UDP which are not in the sessions of UDPConnect are not counted.
+func (s Server) BytesRead() (n uint64) {
+	s.svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		n += atomic.LoadUint64(&el.bytesIn)
+		return true
+	})
+	return
+}
+
+// BytesWritten returns the total number of bytes written to the connections of the server since it started, the
+// datagrams of UDP which are not in the sessions of UDPConnect are not counted.
+func (s Server) BytesWritten() (n uint64) {
+	s.svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		n += atomic.LoadUint64(&el.bytesOut)
+		return true
+	})
+	return
+}
+
+// Trigger runs the given function on every event-loop asynchronously, which makes it safe to access
+// the state of connections owned by each event-loop in fn.
+func (s Server) Trigger(fn func()) (err error) {
+	s.svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		err = el.submit(func() error {
+			fn()
+			return nil
+		})
+		return err == nil
+	})
+	return
+}
+
+// MemoryUsage returns the number of bytes currently held in inbound/outbound buffers of all connections.
+func (s Server) MemoryUsage() int64 {
+	return s.svr.mem.load()
+}
+
+// QueuedTasks returns the number of the tasks submitted by the asynchronous APIs (AsyncWrite, Wake, Execute, etc.)
+// and waiting to run on all event-loops, it is meant for monitoring the backpressure of producer goroutines.
+func (s Server) QueuedTasks() (n int) {
+	s.svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		n += el.queuedTasks()
+		return true
+	})
+	return
+}
+
+// OutboundBuffered returns the number of bytes currently held in outbound buffers of all connections, which
+// is the data waiting to be flushed to the slow peers. It is always 0 on Windows where the writes are blocking.
+func (s Server) OutboundBuffered() int64 {
+	return s.svr.mem.loadOutbound()
+}
+
+// Wait blocks until the server started by Start is shut down, then it closes the listener and returns the report
+// of the lifetime of server. It must not be called within the event callbacks, and it returns right away with
+// an empty report of ErrServerShutdown if the server has stopped in OnInitComplete. The calls after the first
+// one return the same report.
+func (s Server) Wait() ShutdownReport {
+	s.svr.waitOnce.Do(func() {
+		s.svr.final = ShutdownReport{Reason: ErrServerShutdown}
+		if s.svr.wait() {
+			s.svr.final = s.svr.report()
+			s.svr.eventHandler.OnShutdown(s)
+		}
+		closeListener(s.svr.ln)
+	})
+	return s.svr.final
+}
+
+// Subscribe subscribes the given connection to the topic of the pub/sub bus of server, so that the data published
+// to the topic is written to the connection, the subscriptions are removed on closing the connection. It returns
+// ErrUnsupportedProtocol for the datagrams of UDP which are not connections.
+func (s Server) Subscribe(c Conn, topic string) error {
+	return s.svr.bus.subscribe(c, topic)
+}
+
+// Unsubscribe unsubscribes the given connection from the topic.
+func (s Server) Unsubscribe(c Conn, topic string) {
+	s.svr.bus.unsubscribe(c, topic)
+}
+
+// Publish writes the data to all the subscribers of the topic asynchronously like AsyncWrite, the writes are done
+// on the event-loops of the subscribers, one job per event-loop, so it is safe to call from any goroutine.
+func (s Server) Publish(topic string, data []byte) error {
+	return s.svr.bus.publish(topic, data)
+}
+
+// PauseAccept stops accepting new connections while the existing connections are still served, which takes
+// the server out of rotation for maintenance without shutting it down, the new connections stay in the backlog
+// of listener until ResumeAccept is called. It returns ErrUnsupportedProtocol for UDP and it is not supported
+// on Windows yet.
+func (s Server) PauseAccept() error {
+	return s.svr.pauseAccept(true)
+}
+
+// ResumeAccept starts accepting new connections again after PauseAccept.
+func (s Server) ResumeAccept() error {
+	return s.svr.pauseAccept(false)
+}
+
+// AcceptPaused reports whether accepting new connections is paused by PauseAccept,
+// it is meant for reporting the state to health checks of load balancers.
+func (s Server) AcceptPaused() bool {
+	return s.svr.acceptPaused()
+}
+
+// AdoptConn inserts the stream connection established elsewhere, e.g. accepted by another listener or handed
+// over by the old process in a hot upgrade, into an event-loop of the server picked like the accepted ones, where
+// it is opened by OnOpened and served as usual. The server takes nc over, nc is closed whether it succeeds or not.
+// The connection must be backed by a socket (syscall.Conn, e.g. *net.TCPConn or *net.UnixConn) except on Windows,
+// so the wrappers like *tls.Conn whose state lives in Go can only be adopted on Windows. It returns
+// ErrUnsupportedProtocol for UDP servers and such wrappers, and ErrConnRejected if the connection is over
+// PerIPMaxConnections or FDWatermark with RejectNew. It must be called once the event-loops are running, e.g. with
+// the Server returned by Start or in the event callbacks, rather than in OnInitComplete.
+func (s Server) AdoptConn(nc net.Conn) error {
+	return s.svr.adoptConn(nc)
+}
+
+// AdoptFd is AdoptConn for the file descriptor of a connected stream socket, e.g. one received by
+// FDHandler.OnReceivedFD, the server takes the descriptor over and closes it if it fails. It is not supported
+// on Windows.
+func (s Server) AdoptFd(fd int) error {
+	return s.svr.adoptFd(fd)
+}
+
+// ApplyOptions changes the options of the running server, only WithTCPKeepAlive, WithFirstByteTimeout, WithIdleTimeout,
+// WithShutdownTimeout, WithMemoryLimit, WithMemoryLimitHandler, WithAcceptRateLimit, WithPerIPMaxConnections and
+// WithLogger are allowed to be given, otherwise it returns ErrOptionNotReloadable without applying anything.
+// The changes take effect on all event-loops from the next events on, e.g. the new TCPKeepAlive applies to
+// the connections accepted afterwards.
+func (s Server) ApplyOptions(opts ...Option) error {
+	return s.svr.applyOptions(opts)
+}
+
+// StateHandler handles the frames of a connection in a certain phase of protocol, it has the same semantics
+// as EventHandler.React.
+type StateHandler func(frame []byte, c Conn) (out []byte, action Action)
+
 // Conn is a interface of gnet connection.
 type Conn interface {
-	// Context returns a user-defined context.
+	// Context returns a user-defined context, see TypedContext for a typed one with Go 1.18 and later.
 	Context() (ctx interface{})
 
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
+	// SetState routes the frames of this connection to the given handler instead of React from now on, which is
+	// the way of breaking down the protocols with multiple phases (e.g. handshake, authentication and streaming)
+	// into a handler per phase, every handler moves the connection to the next phase by calling SetState, nil
+	// routes the frames back to React. It must be called within the event callbacks or the function passed to
+	// Execute, and the frames decoded from the same inbound data go to the new handler right away.
+	SetState(h StateHandler)
+
+	// SetCodec sets up the codec of this connection instead of Options.Codec, nil restores Options.Codec. It must be
+	// called within the event callbacks or the function passed to Execute, e.g. in OnFirstData of FirstDataHandler
+	// which picks the codec by the leading bytes of the connection, and before the connection is written from other
+	// goroutines by AsyncWrite.
+	SetCodec(codec ICodec)
+
+	// EventLoopContext returns the user-defined context of the event-loop this connection belongs to, which is
+	// shared by all connections of the event-loop, nil is returned if it hasn't been set.
+	EventLoopContext() (ctx interface{})
+
+	// SetEventLoopContext sets the user-defined context of the event-loop this connection belongs to, it is meant
+	// for keeping per-loop caches, scratch buffers, batch accumulators, etc. that are accessed without locks in
+	// Multicore mode. The context is only safe to access within the event callbacks or the function passed to
+	// Execute, which run on the event-loop, and Tick which runs on the first event-loop.
+	SetEventLoopContext(ctx interface{})
+
+	// Server returns the server this connection belongs to, which is the one passed to OnInitComplete, so that the
+	// callbacks reach the server-level operations, e.g. Publish, CountConnections and Trigger, without keeping
+	// the Server in global variables.
+	Server() Server
+
+	// LoopIndex returns the index of the event-loop this connection belongs to, which is the index of it in
+	// Server.LoopStats and the one taken by MigrateTo.
+	LoopIndex() int
+
 	// LocalAddr is the connection's local socket address.
 	LocalAddr() (addr net.Addr)
 
-	// RemoteAddr is the connection's remote peer address.
+	// RemoteAddr is the connection's remote peer address, for the datagrams of UDP which are not in the sessions
+	// of UDPConnect, it is the source address of the datagram like RecvAddr.
 	RemoteAddr() (addr net.Addr)
 
+	// RecvAddr is the source address of the datagram being handled, which is where SendTo replies to. It stays
+	// the same after React returns, so that the Conn of a datagram can be kept for replying later. It is the peer
+	// of the session for the connected UDP sockets of UDPConnect, and nil for the stream connections.
+	RecvAddr() (addr net.Addr)
+
 	// Read reads all data from inbound ring-buffer and event-loop-buffer without moving "read" pointer, which means
 	// it does not evict the data from buffers actually and those data will present in buffers until the
 	// ResetBuffer method is called.
@@ -105,22 +381,187 @@ type Conn interface {
 	// ShiftN shifts "read" pointer in the internal buffers with the given length.
 	ShiftN(n int) (size int)
 
-	// BufferLength returns the length of available data in the internal buffers.
+	// BufferLength returns the length of available data in the internal buffers, i.e. the number of bytes received
+	// but not consumed yet, which is the inbound counterpart of OutboundBuffered.
 	BufferLength() (size int)
 
+	// Peek returns the next n bytes from the internal buffers without consuming them, if n <= 0, all available
+	// data is returned. It returns io.ErrShortBuffer along with all available data when there are less than n bytes.
+	// The returned slice is only valid until the next call of Read/ReadN/Peek/Next or the end of current event.
+	Peek(n int) (buf []byte, err error)
+
+	// Next returns the next n bytes from the internal buffers and consumes them, if n <= 0, all available data
+	// is returned. It returns io.ErrShortBuffer and consumes nothing when there are less than n bytes.
+	// The returned slice is only valid until the next call of Read/ReadN/Peek/Next or the end of current event.
+	Next(n int) (buf []byte, err error)
+
 	// InboundBuffer returns the inbound ring-buffer.
 	//InboundBuffer() *ringbuffer.RingBuffer
 
 	// SendTo writes data for UDP sockets, it allows you to send data back to UDP socket in individual goroutines.
+	// The datagram which would block is queued by Options.UDPSendQueue, or it fails with ErrUDPSendDropped.
 	SendTo(buf []byte) error
 
+	// SendToWithControl writes data for UDP sockets like SendTo along with the given control messages, which set
+	// the source address, the outgoing interface, the TTL or the TOS/ECN bits of the datagram, e.g. echoing the ECN
+	// bits of the received datagram or replying from the address it was sent to. It returns ErrUnsupportedProtocol
+	// for the other protocols and ErrUnsupportedPlatform on platforms other than Linux.
+	SendToWithControl(buf []byte, cm *ControlMessage) error
+
+	// ControlMessage returns the control messages of the datagram being passed to React when UDPControl is enabled,
+	// nil is returned otherwise. The datagrams of the connected sockets of UDPConnect have no control messages.
+	ControlMessage() *ControlMessage
+
+	// SendFD sends the file descriptor to the peer of unix domain socket with SCM_RIGHTS, it is carried by a byte
+	// of 0 in the stream. The peer gets a duplicate of the descriptor, the given one is still owned by the caller.
+	// It must be called within the event callbacks or the function passed to Execute, the outbound data is flushed
+	// first, and ErrWouldBlock is returned if the data or the descriptor can't be written right away, which can be
+	// retried later. It returns ErrUnsupportedProtocol for the other protocols and on Windows.
+	SendFD(fd int) error
+
 	// AsyncWrite writes data to client/connection asynchronously, usually you would call it in individual goroutines
 	// instead of the event-loop goroutines.
 	AsyncWrite(buf []byte) error
 
+	// AsyncWriteWithTimeout writes data to client/connection asynchronously like AsyncWrite, and closes the
+	// connection with ErrWriteTimeout if the data doesn't reach the socket within the given duration, which bounds
+	// how long a message can linger for a stalled client. The data buffered before it is flushed first, so it has
+	// to reach the socket in time as well. The lingering data can't be dropped alone without corrupting the stream,
+	// thus the connection is closed to cancel the write.
+	AsyncWriteWithTimeout(buf []byte, d time.Duration) error
+
+	// AsyncWriteAfter writes data to client/connection asynchronously like AsyncWrite once the given duration
+	// elapses, which is meant for the delays required by protocols, e.g. throttling notices, paced keepalives and
+	// retry backoff, without external timers and Wake. The data is encoded right away and held in the timing wheel
+	// of the event-loop which is accurate to 10ms, it's dropped if the connection is closed by then. The delayed
+	// writes are not ordered with the other writes, nor with each other within the same 10ms.
+	AsyncWriteAfter(d time.Duration, buf []byte) error
+
+	// OutboundBuffered returns the number of bytes written to this connection but not flushed to the socket yet
+	// because the peer doesn't read fast enough, it is meant for backpressure, e.g. stop producing data for the
+	// connection when it grows too large. It must be called within the event callbacks or the function passed to
+	// Execute, and it is always 0 for UDP and on Windows where the writes are blocking.
+	OutboundBuffered() int
+
+	// BytesRead returns the total number of bytes read from the socket of this connection, which is the data on the
+	// wire, i.e. before the decompression of Compression. It is safe to call from any goroutine.
+	BytesRead() uint64
+
+	// BytesWritten returns the total number of bytes written to the socket of this connection, which is the data on
+	// the wire, i.e. after the compression of Compression, not including the data still in the outbound buffer.
+	// It is safe to call from any goroutine.
+	BytesWritten() uint64
+
+	// OpenedAt returns the time when this connection was opened, i.e. right before OnOpened.
+	OpenedAt() time.Time
+
+	// FrameSeq returns the sequence of the frame being passed to React, the frames decoded from the connection
+	// are numbered from 1 in the order of arrival. It is meant to be captured in React along with the frame that
+	// is handed over to other goroutines, whose responses are then written by AsyncWriteSeq.
+	FrameSeq() uint64
+
+	// AsyncWriteSeq writes data to client/connection asynchronously like AsyncWrite, but it holds the response
+	// of the given frame sequence until the responses of all the earlier frames are written, which preserves the
+	// semantics of pipelining (e.g. Redis, HTTP/1.1) when the frames are handled concurrently. Every frame must be
+	// answered by AsyncWriteSeq exactly once, pass nil for the frames that have no responses, and don't mix it with
+	// the other writes to this connection. The response failed with ErrSlowConsumer must be written again later,
+	// since the responses of the later frames wait for it.
+	AsyncWriteSeq(seq uint64, buf []byte) error
+
 	// Wake triggers a React event for this connection.
 	Wake() error
 
+	// Execute runs the given function on the event-loop of this connection asynchronously, it is the way of
+	// accessing the state of connection from other goroutines without data races. The function is not run
+	// if the connection has been closed by then.
+	Execute(fn func(c Conn)) error
+
+	// Fd returns the underlying file descriptor of this connection, for UDP it is the file descriptor of the
+	// listening socket that is shared by all UDP "connections" of the event-loop unless it's a connected socket
+	// in UDPConnect mode, -1 is returned if not available.
+	// It is meant for applying uncommon socket options (TCP_USER_TIMEOUT, TCP_CONGESTION, SO_MARK, etc.) or querying
+	// TCP_INFO, the file descriptor is owned by gnet, so never close it or change its blocking mode, and only
+	// touch it within the event callbacks or the function passed to Execute to avoid racing with the event-loop.
+	Fd() int
+
+	// TCPInfo queries the kernel for the latest statistics about the path quality of this TCP connection,
+	// it returns ErrUnsupportedProtocol for non-TCP connections and ErrUnsupportedPlatform on platforms other
+	// than Linux.
+	TCPInfo() (info *TCPInfo, err error)
+
+	// SetUserTimeout sets up TCP_USER_TIMEOUT of this TCP connection, the kernel closes the connection whose data
+	// stays unacknowledged for longer than the given duration, which bounds the time of detecting the peers
+	// vanished in the middle of transfer, while the keepalive only probes the idle connections. 0 restores the
+	// default of system. It overrides TCPUserTimeout, and it returns ErrUnsupportedProtocol for non-TCP connections
+	// and ErrUnsupportedPlatform on platforms other than Linux.
+	SetUserTimeout(d time.Duration) error
+
+	// SetTOS sets up the type of service of the packets sent by this connection, i.e. IP_TOS for IPv4 and
+	// IPV6_TCLASS for IPv6, whose upper six bits are the DSCP marking the traffic for QoS, e.g. 0xb8 (EF) for voice.
+	// It returns ErrUnsupportedProtocol for the connections other than TCP and the connected UDP sockets of
+	// UDPConnect, whose socket is shared by all peers otherwise, and ErrUnsupportedPlatform on Windows.
+	SetTOS(tos int) error
+
+	// SetPriority sets the priority of this connection in having its events handled by the event-loop, it is
+	// meant for giving the latency-sensitive connections (e.g. control-plane) precedence over the others sharing
+	// the same event-loop, the connections of PriorityHigh are also spared by CloseLeastActive. It must be called
+	// within the event callbacks or the function passed to Execute, and it does nothing for UDP or on Windows.
+	SetPriority(p Priority)
+
+	// SetWritePacing trickles the writes of this connection out at the rate of the given bytes per interval instead
+	// of flushing them to the socket buffer at once, e.g. to pace the segments of video, the writes beyond the rate
+	// are held in the outbound buffer and flushed by the timing wheel of the event-loop, which is accurate to 10ms.
+	// Non-positive arguments turn the pacing off. It must be called within the event callbacks or the function
+	// passed to Execute, and it does nothing for UDP or on Windows.
+	SetWritePacing(bytesPerInterval int, interval time.Duration)
+
+	// SetLowLatency makes AsyncWrite write the data to the socket from the calling goroutine right away while
+	// the outbound buffer of this connection is empty and no other goroutine is writing it, which saves the
+	// round-trip through the event-loop, the data which can't be written at once and the writes when the socket
+	// is taken are passed to the event-loop as usual, keeping the order of them. It must be called within the
+	// event callbacks or the function passed to Execute, and it does nothing for UDP, the connections with
+	// compression or write pacing, with WriteBuffering, WriteCoalesceWindow, TrafficTap or Tracer, which process
+	// the writes on the event-loop, or on Windows, SetWritePacing turns it off. It must not be used with the
+	// connections spliced into by Splice.
+	SetLowLatency(on bool)
+
+	// MigrateTo moves this connection to the event-loop of the given index in Server.LoopStats, e.g. to rebalance
+	// the event-loops after skew or to co-locate the related connections, along with its buffers, context and
+	// timers. It must be called within the event callbacks or the function passed to Execute, the connection is
+	// handed over once the current event is handled, and its events are handled by the new event-loop from then on,
+	// where the asynchronous tasks of the connection pending in the old one follow it. It returns ErrInvalidLoopIndex
+	// for the index out of range, and ErrUnsupportedProtocol for UDP and ErrUnsupportedPlatform on Windows.
+	MigrateTo(loopIndex int) error
+
+	// RetainFrame returns a copy of the given frame which is owned by the caller, it is the way of keeping the
+	// frame passed to React or the data returned by Read/ReadN/Peek/Next beyond the current event, whose memory
+	// is reused by gnet afterwards.
+	RetainFrame(frame []byte) []byte
+
+	// AfterFunc runs fn with this connection on its event-loop after the given duration unless the connection
+	// has been closed by then, it is meant for heartbeats and other per-connection deadlines, the returned stop
+	// prevents fn from running and reports whether it is stopped in time. The timers are kept in the timing wheel
+	// of the event-loop which is accurate to 10ms, so AfterFunc and stop must be called within the event callbacks
+	// or the function passed to Execute. It does nothing for UDP.
+	AfterFunc(d time.Duration, fn func(c Conn)) (stop func() bool)
+
+	// LoopTime returns the time cached by the event-loop for the current iteration of polling, which is captured
+	// once per iteration on the first call, so that the handlers at high QPS are able to stamp the logs or check
+	// the expiry without calling time.Now for every frame. It is behind the wall clock by the time of handling the
+	// events of the iteration so far, and it must be called within the event callbacks or the function passed
+	// to Execute.
+	LoopTime() time.Time
+
+	// Flush writes the data staged by WriteBuffering to the socket right away instead of waiting for the end of
+	// the current batch of events, the data which the socket can't take is left to be written once the socket is
+	// writable. It must be called within the event callbacks or the function passed to Execute, and it does
+	// nothing without WriteBuffering or on Windows.
+	Flush() error
+
+	// Handle returns the handle of this connection, which is safe to be kept and used by any goroutine after
+	// the connection is closed, unlike Conn itself.
+	Handle() ConnHandle
+
 	// Close closes the current connection.
 	Close() error
 }
@@ -141,10 +582,14 @@ type (
 		// OnOpened fires when a new connection has been opened.
 		// The parameter:c has information about the connection such as it's local and remote address.
 		// Parameter:out is the return value which is going to be sent back to the client.
+		// It runs on the workers of Options.LifecycleWorkers instead of the event-loop if they are set.
 		OnOpened(c Conn) (out []byte, action Action)
 
 		// OnClosed fires when a connection has been closed.
-		// The parameter:err is the last known connection error.
+		// The parameter:err is the reason of closing, which is one of ErrEOF, ErrReset, ErrClosedByHandler,
+		// ErrServerShutdown, ErrBufferLimit, ErrWriteTimeout, ErrIdleTimeout, ErrFirstByteTimeout and ErrPanic,
+		// or the raw error of the failed I/O on connection otherwise. It runs on the workers of
+		// Options.LifecycleWorkers like OnOpened.
 		OnClosed(c Conn, err error) (action Action)
 
 		// PreWrite fires just before any data is written to any client socket, this event function is usually used to
@@ -153,7 +598,14 @@ type (
 
 		// React fires when a connection sends the server data.
 		// Call c.Read() or c.ReadN(n) within the parameter:c to read incoming data from client.
-		// Parameter:out is the return value which is going to be sent back to the client.
+		// Parameter:out is the return value which is going to be sent back to the client, it is written to
+		// the socket directly and only the part which can't be written at once (partial write or EAGAIN) is
+		// buffered and flushed when the socket becomes writable.
+		//
+		// Parameter:frame is backed by the internal buffers which are reused once React returns, it must not be
+		// referenced after that, e.g. in other goroutines or the context of connection, call c.RetainFrame(frame)
+		// to obtain a copy owned by the handler if the data is needed beyond React. The same applies to the data
+		// returned by Read/ReadN/Peek/Next.
 		React(frame []byte, c Conn) (out []byte, action Action)
 
 		// Tick fires immediately after the server starts and will fire again
@@ -161,6 +613,78 @@ type (
 		Tick() (delay time.Duration, action Action)
 	}
 
+	// TrafficHandler is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// OnTraffic will replace the implicit decoding of frames with the codec and React for inbound data.
+	TrafficHandler interface {
+		// OnTraffic fires when a connection has inbound data, the handler is supposed to pull exactly what
+		// it needs via c.Peek(n)/c.Next(n), the data which is not consumed will stay in the internal buffers
+		// for the next OnTraffic.
+		OnTraffic(c Conn) (action Action)
+	}
+
+	// BatchHandler is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// ReactBatch will replace React for the frames decoded from inbound data, which lets the handler amortize
+	// the locking or the round-trips to backends over the pipelined frames and answer them at once.
+	BatchHandler interface {
+		// ReactBatch fires with all complete frames decoded from the inbound data of a connection in one
+		// iteration of polling, at most MaxFramesPerPoll of them if it is set. The outputs are encoded one by one
+		// with the codec and written in a single write, return a single output to coalesce the responses on
+		// your own. FrameSeq is the sequence of the last frame of the batch. The frames are only valid until
+		// ReactBatch returns. React still serves Wake and the connections routed by SetState.
+		ReactBatch(frames [][]byte, c Conn) (outs [][]byte, action Action)
+	}
+
+	// ErrorHandler is an optional interface that can be implemented by the EventHandler passed to Serve, OnError
+	// lets the handler decide what to do with the failed I/O on connections instead of closing them silently.
+	ErrorHandler interface {
+		// OnError fires on the event-loop when reading or writing a connection fails with an error other than
+		// the peer closing it gracefully, op is either "read" or "write" and err is the raw error. Close closes
+		// the connection with err passed to OnClosed, which is what happens without OnError. None keeps the
+		// connection, which only makes sense for the transient errors (e.g. ENOBUFS), the failed read is retried
+		// on the next event and the data of failed write is dropped. Shutdown shuts down the server. The connections
+		// are always closed after read errors on Windows.
+		OnError(c Conn, op string, err error) (action Action)
+	}
+
+	// SlowConsumerHandler is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// OnSlowConsumer lets the handler know of the connections which don't keep up with the outbound data.
+	SlowConsumerHandler interface {
+		// OnSlowConsumer fires when the outbound buffer of a connection has stayed above SlowConsumerThreshold
+		// for SlowConsumerTimeout, and again every SlowConsumerTimeout while it stays there. Close closes the
+		// connection with ErrSlowConsumer, Shutdown shuts down the server, None goes on with SlowConsumerPolicy.
+		OnSlowConsumer(c Conn) (action Action)
+	}
+
+	// FDHandler is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// OnReceivedFD receives the file descriptors sent by the peers of unix domain sockets with SCM_RIGHTS,
+	// which are discarded by the kernel if it isn't implemented. It is not supported on Windows.
+	FDHandler interface {
+		// OnReceivedFD fires for every file descriptor received, before the data carrying it is passed to React.
+		// The handler owns the descriptor, which is set close-on-exec and must be closed once it is not needed.
+		// Close closes the connection with ErrClosedByHandler along with the rest of the descriptors received,
+		// Shutdown shuts down the server.
+		OnReceivedFD(c Conn, fd int) (action Action)
+	}
+
+	// CompressionNegotiator is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// which decides the compression of every connection instead of Options.Compression.
+	CompressionNegotiator interface {
+		// NegotiateCompression fires before OnOpened, it returns the compression of the connection and the level,
+		// NoCompression leaves the stream of the connection as it is.
+		NegotiateCompression(c Conn) (alg Compression, level int)
+	}
+
+	// FirstDataHandler is an optional interface that can be implemented by the EventHandler passed to Serve,
+	// which previews the leading bytes of every TCP connection before the codec engages, it's not supported on
+	// Windows yet.
+	FirstDataHandler interface {
+		// OnFirstData fires once when the first Options.FirstDataBytes bytes of the connection arrive, the preview
+		// is not consumed and goes to the codec or OnTraffic afterwards, so that the connections of garbage or
+		// scanners can be rejected early with Close and the codec of the connection can be picked by SetCodec.
+		// The preview is only valid within the call.
+		OnFirstData(c Conn, preview []byte) (action Action)
+	}
+
 	// EventServer is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
@@ -215,39 +739,81 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 // Address should use a scheme prefix and be formatted
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
 // Valid network schemes:
-//  tcp   - bind to both IPv4 and IPv6
-//  tcp4  - IPv4
-//  tcp6  - IPv6
-//  udp   - bind to both IPv4 and IPv6
-//  udp4  - IPv4
-//  udp6  - IPv6
-//  unix  - Unix Domain Socket
 //
-// The "tcp" network scheme is assumed when one is not specified.
-func Serve(eventHandler EventHandler, addr string, opts ...Option) (err error) {
-	var ln listener
+//	tcp   - bind to both IPv4 and IPv6
+//	tcp4  - IPv4
+//	tcp6  - IPv6
+//	udp   - bind to both IPv4 and IPv6
+//	udp4  - IPv4
+//	udp6  - IPv6
+//	unix  - Unix Domain Socket
+//	unixgram   - Unix Domain Socket with datagram semantics
+//	unixpacket - Unix Domain Socket with sequenced-packet semantics
+//	ip, ip4, ip6 - raw IP sockets of the protocol, e.g. "ip4:icmp://0.0.0.0"
+//	packet - raw link-layer frames of the network interface, e.g. "packet://eth0", Linux only
+//
+// The raw sockets of "ip" and "packet" require the privilege of CAP_NET_RAW, the packets are delivered
+// to React with their headers of the layer as the kernel hands them over, which is the IPv4 header for
+// "ip4" and the Ethernet header for "packet", and the data returned from React is sent as it is.
+//
+// The "tcp" network scheme is assumed when one is not specified. The IPv6 address with zone is written
+// like `tcp://[fe80::1%eth0]:9851`.
+func Serve(eventHandler EventHandler, addr string, opts ...Option) error {
+	s, err := Start(eventHandler, addr, opts...)
+	if err != nil {
+		return err
+	}
+	s.Wait()
+	return nil
+}
+
+// Start is like Serve but returns once the listener is bound and the event-loops are running, instead of blocking
+// until the server is shut down, which is the way of learning the bound address of Server.Addr before the clients
+// connect, e.g. the port assigned by OS for "tcp://127.0.0.1:0". The server must be waited by Server.Wait then,
+// which returns the ShutdownReport of server.
+func Start(eventHandler EventHandler, addr string, opts ...Option) (s *Server, err error) {
+	ln := new(listener)
 	defer func() {
-		ln.close()
-		if ln.network == "unix" {
-			sniffErrorAndLog(os.RemoveAll(ln.addr))
+		if err != nil {
+			closeListener(ln)
 		}
 	}()
 
 	options := loadOptions(opts...)
+	if options.err != nil {
+		return nil, options.err
+	}
+	if options.UDPSendQueue > 0 && options.UDPSendPolicy == UDPSendBlock {
+		return nil, ErrUDPSendBlockQueued
+	}
 
 	if options.Logger != nil {
 		defaultLogger = options.Logger
 	}
 
 	ln.network, ln.addr = parseAddr(addr)
+	if options.IPv6Only {
+		// The sockets of "tcp6" and "udp6" are created with IPV6_V6ONLY, while the others are dual-stack.
+		switch ln.network {
+		case "tcp":
+			ln.network = "tcp6"
+		case "udp":
+			ln.network = "udp6"
+		}
+	}
 	switch ln.network {
-	case "udp", "udp4", "udp6":
-		if options.ReusePort {
-			ln.pconn, err = netpoll.ReusePortListenPacket(ln.network, ln.addr)
-		} else {
-			ln.pconn, err = net.ListenPacket(ln.network, ln.addr)
+	case "unixgram":
+		sniffErrorAndLog(os.RemoveAll(ln.addr))
+		if runtime.GOOS == "windows" {
+			err = ErrUnsupportedProtocol
+			break
 		}
-	case "unix":
+		fallthrough
+	case "udp", "udp4", "udp6":
+		// The connected UDP sockets share the local address with the listener via SO_REUSEPORT.
+		reusePort := options.ReusePort || options.UDPConnect && ln.network != "unixgram"
+		ln.pconn, err = listenConfig(options, reusePort).ListenPacket(context.Background(), ln.network, ln.addr)
+	case "unix", "unixpacket":
 		sniffErrorAndLog(os.RemoveAll(ln.addr))
 		if runtime.GOOS == "windows" {
 			err = ErrUnsupportedProtocol
@@ -255,12 +821,14 @@ func Serve(eventHandler EventHandler, addr string, opts ...Option) (err error) {
 		}
 		fallthrough
 	case "tcp", "tcp4", "tcp6":
-		if options.ReusePort {
-			ln.ln, err = netpoll.ReusePortListen(ln.network, ln.addr)
-		} else {
-			ln.ln, err = net.Listen(ln.network, ln.addr)
-		}
+		ln.ln, err = listenConfig(options, options.ReusePort).Listen(context.Background(), ln.network, ln.addr)
+	case "packet":
+		ln.pconn, err = listenPacketSocket(ln.addr)
 	default:
+		if isRawNetwork(ln.network) {
+			ln.pconn, err = listenConfig(options, false).ListenPacket(context.Background(), ln.network, ln.addr)
+			break
+		}
 		err = ErrUnsupportedProtocol
 	}
 	if err != nil {
@@ -277,20 +845,56 @@ func Serve(eventHandler EventHandler, addr string, opts ...Option) (err error) {
 		return
 	}
 
-	return serve(eventHandler, &ln, options)
+	return start(eventHandler, ln, options)
+}
+
+// listenConfig returns the config of the listener, which sets up SO_REUSEPORT if reusePort is true, and the
+// options of policy routing, SocketMark, BindToDevice and FreeBind, before the socket is bound.
+func listenConfig(options *Options, reusePort bool) *net.ListenConfig {
+	routing := options.SocketMark != 0 || options.BindToDevice != "" || options.FreeBind
+	return &net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		if reusePort {
+			if err := netpoll.ReusePortControl(network, address, c); err != nil {
+				return err
+			}
+		}
+		if !routing || isUnixNetwork(network) {
+			return nil
+		}
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = setRoutingSockopts(int(fd), options)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+}
+
+// closeListener closes the listener and removes the file of unix domain socket.
+func closeListener(ln *listener) {
+	ln.close()
+	if isUnixNetwork(ln.network) {
+		sniffErrorAndLog(os.RemoveAll(ln.addr))
+	}
 }
 
+// parseAddr splits the network scheme from the address, only the scheme is case-insensitive, since the zone of
+// IPv6 address (e.g. "tcp://[fe80::1%eth0]:8080"), the path of unix domain socket and the name of network interface
+// are not.
 func parseAddr(addr string) (network, address string) {
-	network = "tcp"
-	address = strings.ToLower(addr)
-	if strings.Contains(address, "://") {
-		pair := strings.Split(address, "://")
-		network = pair[0]
-		address = pair[1]
+	network, address = "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = strings.ToLower(addr[:i]), addr[i+3:]
 	}
 	return
 }
 
+// isUnixNetwork reports whether the given network is one of the unix domain socket networks.
+func isUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixgram" || network == "unixpacket"
+}
+
 func sniffErrorAndLog(err error) {
 	if err != nil {
 		defaultLogger.Printf(err.Error())
@@ -7,14 +7,26 @@ package gnet
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -667,6 +679,9 @@ func (t *testShutdownServer) OnOpened(c Conn) (out []byte, action Action) {
 	return
 }
 func (t *testShutdownServer) OnClosed(c Conn, err error) (action Action) {
+	if err != ErrServerShutdown {
+		panic("unexpected close reason: " + fmt.Sprint(err))
+	}
 	atomic.AddInt64(&t.clients, -1)
 	return
 }
@@ -702,6 +717,43 @@ func testShutdown(network, addr string) {
 	}
 }
 
+func TestNumAcceptors(t *testing.T) {
+	events := &testShutdownServer{network: "tcp", addr: ":9993", N: 20}
+	must(Serve(events, "tcp://:9993", WithTicker(true), WithMulticore(true), WithNumAcceptors(4)))
+	if events.clients != 0 {
+		t.Fatalf("did not call close on all clients")
+	}
+}
+
+func TestReusePortCPUAffinity(t *testing.T) {
+	events := &testShutdownServer{network: "tcp", addr: ":9990", N: 20}
+	must(Serve(events, "tcp://:9990", WithTicker(true), WithNumEventLoop(4), WithReusePort(true),
+		WithReusePortCPUAffinity(true)))
+	if events.clients != 0 {
+		t.Fatalf("did not call close on all clients")
+	}
+}
+
+func TestBusyPoll(t *testing.T) {
+	events := &testShutdownServer{network: "tcp", addr: ":9994", N: 10}
+	must(Serve(events, "tcp://:9994", WithTicker(true), WithBusyPoll(true), WithPollTimeout(time.Millisecond*10)))
+	if events.clients != 0 {
+		t.Fatalf("did not call close on all clients")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	events := &testShutdownServer{network: "tcp", addr: ":9996", N: 10}
+	start := time.Now()
+	must(Serve(events, "tcp://:9996", WithTicker(true), WithMulticore(true), WithShutdownTimeout(time.Second*5)))
+	if events.clients != 0 {
+		t.Fatalf("did not call close on all clients")
+	}
+	if time.Since(start) > time.Second*5 {
+		t.Fatalf("idle connections were not closed before the grace period expired")
+	}
+}
+
 type testBadAddrServer struct {
 	*EventServer
 }
@@ -734,6 +786,9 @@ type testCloseActionErrorServer struct {
 }
 
 func (t *testCloseActionErrorServer) OnClosed(c Conn, err error) (action Action) {
+	if err != ErrClosedByHandler {
+		panic("unexpected close reason: " + fmt.Sprint(err))
+	}
 	action = Shutdown
 	return
 }
@@ -939,6 +994,9 @@ type testCloseConnectionServer struct {
 }
 
 func (t *testCloseConnectionServer) OnClosed(c Conn, err error) (action Action) {
+	if err != ErrClosedByHandler {
+		panic("unexpected close reason: " + fmt.Sprint(err))
+	}
 	action = Shutdown
 	return
 }
@@ -981,3 +1039,3341 @@ func testCloseConnection(network, addr string) {
 	events := &testCloseConnectionServer{network: network, addr: addr}
 	must(Serve(events, network+"://"+addr, WithTicker(true)))
 }
+
+func TestTraffic(t *testing.T) {
+	testTraffic("tcp", ":9992", t)
+}
+
+type testTrafficServer struct {
+	*EventServer
+	t             *testing.T
+	network, addr string
+	started       bool
+}
+
+func (s *testTrafficServer) OnTraffic(c Conn) (action Action) {
+	for {
+		header, err := c.Peek(2)
+		if err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(header))
+		msg, err := c.Next(2 + size)
+		if err != nil {
+			return
+		}
+		if string(msg[2:]) == "bye" {
+			return Shutdown
+		}
+		_ = c.AsyncWrite(append([]byte{}, msg[2:]...))
+	}
+}
+
+func (s *testTrafficServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.t.Fatalf("React should not be fired when OnTraffic is implemented")
+	return
+}
+
+func (s *testTrafficServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if s.started {
+		return
+	}
+	s.started = true
+	go func() {
+		conn, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer conn.Close()
+		encode := func(msg string) []byte {
+			buf := make([]byte, 2, 2+len(msg))
+			binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+			return append(buf, msg...)
+		}
+		// Send the messages in split and merged segments.
+		data := append(encode("hello"), encode("gnet")...)
+		_, _ = conn.Write(data[:3])
+		time.Sleep(time.Millisecond * 50)
+		_, _ = conn.Write(data[3:])
+		reply := make([]byte, len("hellognet"))
+		_, err = io.ReadFull(conn, reply)
+		must(err)
+		if string(reply) != "hellognet" {
+			panic("unexpected reply: " + string(reply))
+		}
+		_, _ = conn.Write(encode("bye"))
+	}()
+	return
+}
+
+func testTraffic(network, addr string, t *testing.T) {
+	events := &testTrafficServer{t: t, network: network, addr: addr}
+	must(Serve(events, network+"://"+addr, WithTicker(true)))
+}
+
+func TestExecute(t *testing.T) {
+	testExecute("tcp", ":9995", t)
+}
+
+type testExecuteServer struct {
+	*EventServer
+	t             *testing.T
+	svr           Server
+	network, addr string
+	started       bool
+	triggered     int32
+}
+
+func (s *testExecuteServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	return
+}
+
+func (s *testExecuteServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	data := c.RetainFrame(frame)
+	go func() {
+		must(c.Execute(func(c Conn) {
+			if c.Fd() < 0 {
+				panic("invalid file descriptor")
+			}
+			if info, err := c.TCPInfo(); err != nil && err != ErrUnsupportedPlatform {
+				panic(err)
+			} else if err == nil && info.SndMSS == 0 {
+				panic("invalid tcp info")
+			}
+			c.SetPriority(PriorityHigh)
+			c.SetContext(data)
+			_ = c.AsyncWrite(c.Context().([]byte))
+		}))
+	}()
+	return
+}
+
+func (s *testExecuteServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !s.started {
+		s.started = true
+		must(s.svr.Trigger(func() {
+			atomic.AddInt32(&s.triggered, 1)
+		}))
+		go func() {
+			conn, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer conn.Close()
+			data := []byte("Hello World!")
+			_, _ = conn.Write(data)
+			_, err = io.ReadFull(conn, data)
+			must(err)
+			if string(data) != "Hello World!" {
+				panic("unexpected reply: " + string(data))
+			}
+			_ = s.svr.Trigger(func() {
+				atomic.AddInt32(&s.triggered, 1)
+			})
+		}()
+		return
+	}
+	if int(atomic.LoadInt32(&s.triggered)) == 2*s.svr.NumEventLoop {
+		action = Shutdown
+	}
+	return
+}
+
+func testExecute(network, addr string, t *testing.T) {
+	events := &testExecuteServer{t: t, network: network, addr: addr}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithNumEventLoop(2)))
+}
+
+func TestUnixDatagram(t *testing.T) {
+	t.Run("unixgram", func(t *testing.T) {
+		testUnixDatagram("unixgram", "gnet-dgram.sock", t)
+	})
+	t.Run("unixpacket", func(t *testing.T) {
+		testUnixDatagram("unixpacket", "gnet-seqpacket.sock", t)
+	})
+}
+
+type testUnixDatagramServer struct {
+	*EventServer
+	t             *testing.T
+	network, addr string
+	started       int32
+	replied       int32
+}
+
+func (s *testUnixDatagramServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if ua, ok := c.RemoteAddr().(*net.UnixAddr); s.network == "unixgram" && (!ok || ua.Net != "unixgram") {
+		panic("unexpected remote addr")
+	}
+	out = frame
+	return
+}
+
+func (s *testUnixDatagramServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			var (
+				conn net.Conn
+				err  error
+			)
+			if s.network == "unixgram" {
+				laddr := &net.UnixAddr{Name: s.addr + ".client", Net: "unixgram"}
+				_ = os.RemoveAll(laddr.Name)
+				defer os.RemoveAll(laddr.Name)
+				conn, err = net.DialUnix("unixgram", laddr, &net.UnixAddr{Name: s.addr, Net: "unixgram"})
+			} else {
+				conn, err = net.Dial(s.network, s.addr)
+			}
+			must(err)
+			defer conn.Close()
+			for _, msg := range []string{"Hello", "World!"} {
+				_, err = conn.Write([]byte(msg))
+				must(err)
+				buf := make([]byte, 64)
+				n, err := conn.Read(buf)
+				must(err)
+				if string(buf[:n]) != msg {
+					panic("unexpected reply: " + string(buf[:n]))
+				}
+			}
+			atomic.StoreInt32(&s.replied, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&s.replied) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testUnixDatagram(network, addr string, t *testing.T) {
+	events := &testUnixDatagramServer{t: t, network: network, addr: addr}
+	must(Serve(events, network+"://"+addr, WithTicker(true)))
+	if _, err := os.Stat(addr); !os.IsNotExist(err) {
+		t.Fatalf("socket file %s was not removed", addr)
+	}
+}
+
+func TestSplice(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testSplice("tcp", ":9997", false, t)
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testSplice("tcp", ":9997", true, t)
+	})
+}
+
+type testSpliceServer struct {
+	*EventServer
+	t             *testing.T
+	network, addr string
+	mu            sync.Mutex
+	first         Conn
+	peers         map[Conn]Conn
+	started       int32
+	done          int32
+}
+
+func (s *testSpliceServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first == nil {
+		s.first = c
+		return
+	}
+	s.peers[s.first], s.peers[c] = c, s.first
+	must(Splice(s.first, c, -1))
+	must(Splice(c, s.first, 4))
+	out = []byte("ok")
+	return
+}
+
+// React forwards the bytes which are read before the splice takes effect.
+func (s *testSpliceServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.mu.Lock()
+	peer := s.peers[c]
+	s.mu.Unlock()
+	if peer != nil {
+		_ = peer.AsyncWrite(append([]byte{}, frame...))
+	}
+	return
+}
+
+func (s *testSpliceServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			a, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer a.Close()
+			b, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer b.Close()
+			ok := make([]byte, 2)
+			_, err = io.ReadFull(b, ok)
+			must(err)
+
+			data := make([]byte, 1<<20)
+			rand.Read(data)
+			go func() {
+				_, err := a.Write(data)
+				must(err)
+			}()
+			reply := make([]byte, len(data))
+			_, err = io.ReadFull(b, reply)
+			must(err)
+			if !bytes.Equal(data, reply) {
+				panic("mismatched data relayed from a to b")
+			}
+
+			_, err = b.Write([]byte("pong"))
+			must(err)
+			pong := make([]byte, 4)
+			_, err = io.ReadFull(a, pong)
+			must(err)
+			if string(pong) != "pong" {
+				panic("unexpected reply: " + string(pong))
+			}
+			atomic.StoreInt32(&s.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testSplice(network, addr string, multicore bool, t *testing.T) {
+	events := &testSpliceServer{t: t, network: network, addr: addr, peers: make(map[Conn]Conn)}
+	must(Serve(events, network+"://"+addr, WithMulticore(multicore), WithTicker(true)))
+}
+
+func TestApplyOptions(t *testing.T) {
+	events := &testApplyOptionsServer{}
+	must(Serve(events, "tcp://:9998", WithTicker(true)))
+}
+
+type testApplyOptionsServer struct {
+	*EventServer
+	svr Server
+}
+
+func (s *testApplyOptionsServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	return
+}
+
+func (s *testApplyOptionsServer) Tick() (delay time.Duration, action Action) {
+	if err := s.svr.ApplyOptions(WithTCPKeepAlive(time.Minute), WithMemoryLimit(1<<20, NotifyOnly)); err != nil {
+		panic(err)
+	}
+	if opts := s.svr.svr.options(); opts.TCPKeepAlive != time.Minute || opts.MemoryLimit != 1<<20 {
+		panic(fmt.Sprintf("options are not applied: %+v", opts))
+	}
+	if err := s.svr.ApplyOptions(WithMulticore(true), WithTCPKeepAlive(time.Second)); err != ErrOptionNotReloadable {
+		panic(fmt.Sprintf("expected ErrOptionNotReloadable, got %v", err))
+	}
+	if s.svr.svr.options().TCPKeepAlive != time.Minute {
+		panic("options are applied partially")
+	}
+	action = Shutdown
+	return
+}
+
+func TestApplyAcceptRateLimit(t *testing.T) {
+	events := &testAcceptRateLimitServer{network: "tcp", addr: ":9921", N: 4}
+	events.apply = []Option{WithAcceptRateLimit(10, 1)}
+	must(Serve(events, "tcp://:9921"))
+	// The first connection is accepted at once, the other 3 ones are accepted at the rate of 10 per second.
+	if elapsed := events.last.Sub(events.first); elapsed < time.Millisecond*200 {
+		t.Fatalf("%d connections are accepted within %v", events.N, elapsed)
+	}
+}
+
+func TestApplyPerIPMaxConnections(t *testing.T) {
+	events := &testApplyPerIPServer{network: "tcp", addr: "127.0.0.1:9920"}
+	must(Serve(events, "tcp://127.0.0.1:9920"))
+	if events.opened != 1 {
+		t.Fatalf("expected 1 connection opened, got %d", events.opened)
+	}
+}
+
+type testApplyPerIPServer struct {
+	*EventServer
+	network, addr string
+	opened        int
+}
+
+func (s *testApplyPerIPServer) OnInitComplete(svr Server) (action Action) {
+	must(svr.ApplyOptions(WithPerIPMaxConnections(1)))
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(c, make([]byte, 5))
+		must(err)
+
+		// The second connection from the same IP is over the limit.
+		over, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer over.Close()
+		must(over.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		if _, err = over.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection over the limit to be closed, got %v", err))
+		}
+	}()
+	return
+}
+
+func (s *testApplyPerIPServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.opened++
+	return
+}
+
+func (s *testApplyPerIPServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return frame, None
+}
+
+func (s *testApplyPerIPServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestApplyLogger(t *testing.T) {
+	events := &testApplyLoggerServer{network: "tcp", addr: ":9919"}
+	must(Serve(events, "tcp://:9919"))
+	if !strings.Contains(events.logged, "recovered from panic") {
+		t.Fatalf("the panic is not logged by the logger applied, got %q", events.logged)
+	}
+}
+
+type testApplyLoggerServer struct {
+	*EventServer
+	network, addr string
+	logged        string
+}
+
+func (s *testApplyLoggerServer) Printf(format string, args ...interface{}) {
+	s.logged += fmt.Sprintf(format, args...)
+}
+
+func (s *testApplyLoggerServer) OnInitComplete(svr Server) (action Action) {
+	must(svr.ApplyOptions(WithLogger(s)))
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("panic"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testApplyLoggerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	panic("logged")
+}
+
+func (s *testApplyLoggerServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	events := &testFirstByteTimeoutServer{network: "tcp", addr: ":9989"}
+	must(Serve(events, "tcp://:9989", WithFirstByteTimeout(time.Millisecond*100)))
+	if events.reason != ErrFirstByteTimeout {
+		t.Fatalf("expected %v, got %v", ErrFirstByteTimeout, events.reason)
+	}
+}
+
+type testFirstByteTimeoutServer struct {
+	*EventServer
+	network, addr string
+	reason        error
+}
+
+func (s *testFirstByteTimeoutServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		// Send nothing, the server is expected to hang up on its own.
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testFirstByteTimeoutServer) OnClosed(c Conn, err error) (action Action) {
+	s.reason = err
+	action = Shutdown
+	return
+}
+
+func TestUDPConnect(t *testing.T) {
+	events := &testUDPConnectServer{network: "udp", addr: ":9989"}
+	must(Serve(events, "udp://:9989", WithUDPConnect(true), WithMulticore(true)))
+	if events.opened != 1 || events.closed != 1 {
+		t.Fatalf("expected the connected socket to be opened and closed once, got %d/%d", events.opened, events.closed)
+	}
+}
+
+type testUDPConnectServer struct {
+	*EventServer
+	network, addr  string
+	opened, closed int32
+}
+
+func (s *testUDPConnectServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		buf := make([]byte, 64)
+		for i := 0; i < 10; i++ {
+			msg := []byte(fmt.Sprintf("datagram-%d", i))
+			_, err = c.Write(msg)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			n, err := c.Read(buf)
+			must(err)
+			if !bytes.Equal(buf[:n], msg) {
+				panic("mismatched echo: " + string(buf[:n]))
+			}
+		}
+		_, err = c.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testUDPConnectServer) OnOpened(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&s.opened, 1)
+	if c.RemoteAddr() == nil || c.Fd() < 0 {
+		panic("connected UDP socket is not set up")
+	}
+	c.SetContext(true)
+	return
+}
+
+func (s *testUDPConnectServer) OnClosed(c Conn, err error) (action Action) {
+	atomic.AddInt32(&s.closed, 1)
+	if err != ErrServerShutdown {
+		panic("unexpected close reason: " + fmt.Sprint(err))
+	}
+	return
+}
+
+func (s *testUDPConnectServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.Context() == nil {
+		panic("datagram is not received by the connected UDP socket")
+	}
+	if string(frame) == "shutdown" {
+		action = Shutdown
+		return
+	}
+	out = frame
+	return
+}
+
+func TestRecvAddr(t *testing.T) {
+	for _, connect := range []bool{false, true} {
+		events := &testRecvAddrServer{network: "udp", addr: ":9954", done: make(chan struct{})}
+		must(Serve(events, "udp://:9954", WithUDPConnect(connect)))
+		<-events.done
+		if !events.replied {
+			t.Fatalf("UDPConnect %t: the kept Conn doesn't reply to the source of datagram", connect)
+		}
+	}
+	events := &testRecvAddrServer{network: "tcp", addr: ":9954"}
+	must(Serve(events, "tcp://:9954"))
+	if !events.replied {
+		t.Fatal("the stream connection has the source address of datagram")
+	}
+}
+
+type testRecvAddrServer struct {
+	*EventServer
+	network, addr string
+	kept          Conn
+	done          chan struct{}
+	replied       bool
+}
+
+func (s *testRecvAddrServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		if s.network == "tcp" {
+			return
+		}
+		defer close(s.done)
+		_, err = c.Write([]byte("keep"))
+		must(err)
+		_, err = c.Write([]byte("reply"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 64)
+		n, err := c.Read(buf)
+		must(err)
+		s.replied = string(buf[:n]) == "later"
+	}()
+	return
+}
+
+func (s *testRecvAddrServer) OnOpened(c Conn) (out []byte, action Action) {
+	if s.network == "tcp" {
+		s.replied = c.RecvAddr() == nil && c.RemoteAddr() != nil
+		action = Shutdown
+	}
+	return
+}
+
+func (s *testRecvAddrServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "keep" {
+		s.kept = c
+		return
+	}
+	// The addresses of the kept Conn stay the same after React returns.
+	if addr := s.kept.RecvAddr(); addr == nil || addr.String() != c.RecvAddr().String() ||
+		s.kept.RemoteAddr().String() != addr.String() {
+		panic(fmt.Sprintf("the source address of the kept datagram changes: %v, %v", addr, c.RecvAddr()))
+	}
+	must(s.kept.SendTo([]byte("later")))
+	action = Shutdown
+	return
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	// The longer window is timed by the timing wheel of event-loop on Unix.
+	for _, window := range []time.Duration{time.Millisecond, 30 * time.Millisecond} {
+		events := &testWriteCoalescingServer{network: "tcp", addr: ":9987", N: 100}
+		must(Serve(events, "tcp://:9987", WithWriteCoalescing(window, 512)))
+		if !events.matched {
+			t.Fatalf("window %v: the coalesced writes are corrupted", window)
+		}
+	}
+}
+
+type testWriteCoalescingServer struct {
+	*EventServer
+	network, addr string
+	N             int
+	matched       bool
+}
+
+func (s *testWriteCoalescingServer) message(i int) []byte {
+	return []byte(fmt.Sprintf("message-%03d;", i))
+}
+
+func (s *testWriteCoalescingServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		var expected []byte
+		for i := 0; i < s.N; i++ {
+			expected = append(expected, s.message(i)...)
+		}
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 5)))
+		data := make([]byte, len(expected))
+		_, err = io.ReadFull(c, data)
+		must(err)
+		if bytes.Equal(data, expected) {
+			_, _ = c.Write([]byte("matched"))
+		} else {
+			_, _ = c.Write([]byte("mismatched"))
+		}
+	}()
+	return
+}
+
+func (s *testWriteCoalescingServer) OnOpened(c Conn) (out []byte, action Action) {
+	go func() {
+		for i := 0; i < s.N; i++ {
+			must(c.AsyncWrite(s.message(i)))
+		}
+	}()
+	return
+}
+
+func (s *testWriteCoalescingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.matched = string(frame) == "matched"
+	action = Shutdown
+	return
+}
+
+func TestPauseAccept(t *testing.T) {
+	events := &testPauseAcceptServer{network: "tcp", addr: ":9986"}
+	must(Serve(events, "tcp://:9986", WithTicker(true)))
+	if events.opened != 1 {
+		t.Fatalf("expected 1 opened connection, got %d", events.opened)
+	}
+}
+
+type testPauseAcceptServer struct {
+	*EventServer
+	svr           Server
+	network, addr string
+	tick          int
+	opened        int
+}
+
+func (s *testPauseAcceptServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	must(svr.PauseAccept())
+	if !svr.AcceptPaused() {
+		panic("accepting is not paused")
+	}
+	return
+}
+
+func (s *testPauseAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	if s.svr.AcceptPaused() {
+		panic("connection is accepted while accepting is paused")
+	}
+	s.opened++
+	return
+}
+
+func (s *testPauseAcceptServer) Tick() (delay time.Duration, action Action) {
+	switch s.tick {
+	case 0:
+		go func() {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer c.Close()
+			_, _ = c.Read(make([]byte, 1))
+		}()
+	case 3:
+		must(s.svr.ResumeAccept())
+	case 6:
+		action = Shutdown
+	}
+	s.tick++
+	delay = time.Millisecond * 100
+	return
+}
+
+func TestDeferAccept(t *testing.T) {
+	events := &testDeferAcceptServer{network: "tcp", addr: ":9985"}
+	must(Serve(events, "tcp://:9985", WithDeferAccept(true), WithFirstByteTimeout(time.Second*5)))
+	if runtime.GOOS == "linux" && events.early {
+		t.Fatalf("connection is delivered before it has data to read")
+	}
+}
+
+type testDeferAcceptServer struct {
+	*EventServer
+	network, addr string
+	sent          int32
+	early         bool
+}
+
+func (s *testDeferAcceptServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		time.Sleep(time.Millisecond * 300)
+		atomic.StoreInt32(&s.sent, 1)
+		_, err = c.Write([]byte("hello"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testDeferAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.early = atomic.LoadInt32(&s.sent) == 0
+	return
+}
+
+func (s *testDeferAcceptServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	action = Shutdown
+	return
+}
+
+func TestIdleTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("idle timeout is not supported on Windows yet")
+	}
+	events := &testIdleTimeoutServer{network: "tcp", addr: ":9982"}
+	must(Serve(events, "tcp://:9982", WithIdleTimeout(time.Millisecond*300)))
+	if events.reason != ErrIdleTimeout {
+		t.Fatalf("expected %v, got %v", ErrIdleTimeout, events.reason)
+	}
+	if events.idle < time.Millisecond*300 {
+		t.Fatalf("connection is closed after being idle for only %v", events.idle)
+	}
+	if events.beats != 1 {
+		t.Fatalf("expected 1 heartbeat, got %d", events.beats)
+	}
+}
+
+type testIdleTimeoutServer struct {
+	*EventServer
+	network, addr string
+	lastActive    time.Time
+	idle          time.Duration
+	beats         int
+	reason        error
+}
+
+func (s *testIdleTimeoutServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		for i := 0; i < 3; i++ {
+			_, err = c.Write([]byte("ping"))
+			must(err)
+			time.Sleep(time.Millisecond * 100)
+		}
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testIdleTimeoutServer) OnOpened(c Conn) (out []byte, action Action) {
+	if stop := c.AfterFunc(time.Hour, func(c Conn) { panic("stopped timer fired") }); !stop() {
+		panic("failed to stop timer")
+	}
+	c.AfterFunc(time.Millisecond*50, func(c Conn) { s.beats++ })
+	return
+}
+
+func (s *testIdleTimeoutServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.lastActive = time.Now()
+	return
+}
+
+func (s *testIdleTimeoutServer) OnClosed(c Conn, err error) (action Action) {
+	s.idle = time.Since(s.lastActive)
+	s.reason = err
+	action = Shutdown
+	return
+}
+
+func TestTrafficTap(t *testing.T) {
+	events := &testTrafficTapServer{network: "tcp", addr: ":9981"}
+	tapped := make(map[Direction][]byte)
+	must(Serve(events, "tcp://:9981", WithTrafficTap(func(c Conn, dir Direction, data []byte) {
+		tapped[dir] = append(tapped[dir], data...)
+	})))
+	if string(tapped[Inbound]) != "ping" || string(tapped[Outbound]) != "hi;ping" {
+		t.Fatalf("unexpected tapped traffic: %q", tapped)
+	}
+}
+
+type testTrafficTapServer struct {
+	*EventServer
+	network, addr string
+}
+
+func (s *testTrafficTapServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testTrafficTapServer) OnOpened(c Conn) (out []byte, action Action) {
+	out = []byte("hi;")
+	return
+}
+
+func (s *testTrafficTapServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	action = Shutdown
+	return
+}
+
+func TestListenBacklog(t *testing.T) {
+	events := &testListenBacklogServer{network: "tcp", addr: ":9980"}
+	must(Serve(events, "tcp://:9980", WithListenBacklog(16), WithReusePort(true)))
+	if runtime.GOOS != "windows" && events.backlog != 16 {
+		t.Fatalf("expected the effective backlog 16, got %d", events.backlog)
+	}
+}
+
+type testListenBacklogServer struct {
+	*EventServer
+	network, addr string
+	backlog       int
+}
+
+func (s *testListenBacklogServer) OnInitComplete(svr Server) (action Action) {
+	s.backlog = svr.ListenBacklog
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testListenBacklogServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	action = Shutdown
+	return
+}
+
+func TestRawIP(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("raw IPv4 packets are delivered with the header only on Linux")
+	}
+	probe, err := net.ListenPacket("ip4:253", "127.0.0.1")
+	if err != nil {
+		t.Skipf("raw IP socket is not permitted: %v", err)
+	}
+	_ = probe.Close()
+	events := &testRawIPServer{}
+	must(Serve(events, "ip4:253://127.0.0.1"))
+	if !events.addressed {
+		t.Fatalf("the remote address of raw IP packets is not *net.IPAddr")
+	}
+}
+
+type testRawIPServer struct {
+	*EventServer
+	addressed bool
+}
+
+func (s *testRawIPServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial("ip4:253", "127.0.0.1")
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		// The raw socket of the client sees its own packets on the loopback as well, with the IPv4 header.
+		buf := make([]byte, 64)
+		for {
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			n, err := c.Read(buf)
+			must(err)
+			if bytes.HasSuffix(buf[:n], []byte("pong")) {
+				break
+			}
+		}
+		_, err = c.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testRawIPServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if len(frame) < 20 || frame[0]>>4 != 4 {
+		panic("raw IPv4 packet is delivered without the header")
+	}
+	_, s.addressed = c.RemoteAddr().(*net.IPAddr)
+	switch payload := string(frame[int(frame[0]&0x0f)*4:]); payload {
+	case "ping":
+		out = []byte("pong")
+	case "shutdown":
+		action = Shutdown
+	}
+	return
+}
+
+func TestAsyncWriteSeq(t *testing.T) {
+	events := &testAsyncWriteSeqServer{network: "tcp", addr: ":9979", N: 50}
+	must(Serve(events, "tcp://:9979", WithCodec(new(LineBasedFrameCodec))))
+	if !events.ordered {
+		t.Fatalf("the responses of the pipelined requests are out of order")
+	}
+}
+
+type testAsyncWriteSeqServer struct {
+	*EventServer
+	network, addr string
+	N             int
+	ordered       bool
+}
+
+func (s *testAsyncWriteSeqServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		var req, expected bytes.Buffer
+		for i := 0; i < s.N; i++ {
+			fmt.Fprintf(&req, "%d\n", i)
+			fmt.Fprintf(&expected, "%d\n", i)
+		}
+		_, err = c.Write(req.Bytes())
+		must(err)
+		resp := make([]byte, expected.Len())
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 5)))
+		_, err = io.ReadFull(c, resp)
+		must(err)
+		verdict := "ordered\n"
+		if !bytes.Equal(resp, expected.Bytes()) {
+			verdict = "disordered\n"
+		}
+		_, err = c.Write([]byte(verdict))
+		must(err)
+	}()
+	return
+}
+
+func (s *testAsyncWriteSeqServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "ordered":
+		s.ordered = true
+		return nil, Shutdown
+	case "disordered":
+		return nil, Shutdown
+	}
+	seq, data := c.FrameSeq(), c.RetainFrame(frame)
+	// The later frames are handled sooner, so the responses come out of order without the sequencer.
+	go func(delay time.Duration) {
+		time.Sleep(delay)
+		_ = c.AsyncWriteSeq(seq, data)
+	}(time.Duration(s.N-int(seq)) * time.Millisecond)
+	return
+}
+
+func TestOutboundBuffered(t *testing.T) {
+	events := &testOutboundBufferedServer{network: "tcp", addr: ":9978"}
+	must(Serve(events, "tcp://:9978", WithTicker(true)))
+	if events.connBuffered <= 0 || int64(events.connBuffered) != events.svrBuffered {
+		t.Fatalf("unexpected outbound buffered bytes, conn: %d, server: %d", events.connBuffered, events.svrBuffered)
+	}
+}
+
+type testOutboundBufferedServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	conn          Conn
+	connBuffered  int
+	svrBuffered   int64
+}
+
+func (s *testOutboundBufferedServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		// Never read the response, so that it piles up in the outbound buffer.
+		_, err = c.Write([]byte("fill"))
+		must(err)
+		time.Sleep(time.Second * 3)
+	}()
+	return
+}
+
+func (s *testOutboundBufferedServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.conn = c
+	out = make([]byte, 32*1024*1024)
+	return
+}
+
+func (s *testOutboundBufferedServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 10
+	// Tick runs on the only event-loop, which owns the connection.
+	if s.conn != nil && s.svr.OutboundBuffered() > 0 {
+		s.connBuffered, s.svrBuffered = s.conn.OutboundBuffered(), s.svr.OutboundBuffered()
+		action = Shutdown
+	}
+	return
+}
+
+func TestEventLoopContext(t *testing.T) {
+	events := &testEventLoopContextServer{network: "tcp", addr: ":9977"}
+	must(Serve(events, "tcp://:9977", WithMulticore(true), WithNumEventLoop(2), WithLoadBalancing(RoundRobin)))
+	if !events.shared {
+		t.Fatalf("the context of event-loop is not shared by the connections of the event-loop")
+	}
+}
+
+type testEventLoopContextServer struct {
+	*EventServer
+	network, addr string
+	shared        bool
+}
+
+func (s *testEventLoopContextServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// The connections are spread over the 2 event-loops in turn, each of which counts its own requests.
+		var counts []string
+		for i := 0; i < 4; i++ {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			_, err = c.Write([]byte("count"))
+			must(err)
+			buf := make([]byte, 16)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			n, err := c.Read(buf)
+			must(err)
+			counts = append(counts, string(buf[:n]))
+			_ = c.Close()
+		}
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte(strings.Join(counts, ",")))
+		must(err)
+	}()
+	return
+}
+
+func (s *testEventLoopContextServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "count" {
+		s.shared = string(frame) == "1,1,2,2"
+		action = Shutdown
+		return
+	}
+	n, _ := c.EventLoopContext().(*int)
+	if n == nil {
+		n = new(int)
+		c.SetEventLoopContext(n)
+	}
+	*n++
+	out = []byte(strconv.Itoa(*n))
+	return
+}
+
+func TestAsyncWriteWithTimeout(t *testing.T) {
+	events := &testAsyncWriteWithTimeoutServer{network: "tcp", addr: ":9976", closed: make(chan struct{})}
+	must(Serve(events, "tcp://:9976"))
+	if events.reason != ErrWriteTimeout {
+		t.Fatalf("expected %v, got %v", ErrWriteTimeout, events.reason)
+	}
+	if events.elapsed < time.Millisecond*100 {
+		t.Fatalf("connection is closed %v after the write with the timeout of 100ms", events.elapsed)
+	}
+}
+
+type testAsyncWriteWithTimeoutServer struct {
+	*EventServer
+	network, addr string
+	start         time.Time
+	elapsed       time.Duration
+	reason        error
+	closed        chan struct{}
+}
+
+func (s *testAsyncWriteWithTimeoutServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("small"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		// Stall the server by never reading the large message.
+		_, err = c.Write([]byte("large"))
+		must(err)
+		<-s.closed
+	}()
+	return
+}
+
+func (s *testAsyncWriteWithTimeoutServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "small":
+		must(c.AsyncWriteWithTimeout([]byte("small"), time.Millisecond*100))
+	case "large":
+		s.start = time.Now()
+		must(c.AsyncWriteWithTimeout(make([]byte, 32*1024*1024), time.Millisecond*100))
+	}
+	return
+}
+
+func (s *testAsyncWriteWithTimeoutServer) OnClosed(c Conn, err error) (action Action) {
+	s.elapsed, s.reason = time.Since(s.start), err
+	close(s.closed)
+	action = Shutdown
+	return
+}
+
+func TestCompression(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("compression is not supported on Windows yet")
+	}
+	t.Run("gzip", func(t *testing.T) {
+		events := &testCompressionServer{network: "tcp", addr: ":9975", alg: Gzip}
+		must(Serve(events, "tcp://:9975", WithCompression(Gzip, flate.BestSpeed)))
+		if !events.matched {
+			t.Fatalf("the decompressed echo doesn't match")
+		}
+	})
+	t.Run("negotiated-deflate", func(t *testing.T) {
+		events := &testNegotiatedCompressionServer{
+			testCompressionServer{network: "tcp", addr: ":9975", alg: Deflate},
+		}
+		must(Serve(events, "tcp://:9975", WithCompression(Gzip, flate.BestSpeed)))
+		if !events.matched {
+			t.Fatalf("the decompressed echo doesn't match")
+		}
+	})
+}
+
+type testCompressionServer struct {
+	*EventServer
+	network, addr string
+	alg           Compression
+	matched       bool
+}
+
+type testNegotiatedCompressionServer struct {
+	testCompressionServer
+}
+
+func (s *testNegotiatedCompressionServer) NegotiateCompression(c Conn) (alg Compression, level int) {
+	return Deflate, flate.DefaultCompression
+}
+
+func (s *testCompressionServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		var (
+			w interface {
+				io.Writer
+				Flush() error
+			}
+			r io.Reader
+		)
+		if s.alg == Gzip {
+			w = gzip.NewWriter(c)
+		} else {
+			w, err = flate.NewWriter(c, flate.BestSpeed)
+			must(err)
+		}
+		var sent bytes.Buffer
+		for i := 0; i < 10; i++ {
+			msg := bytes.Repeat([]byte(fmt.Sprintf("message-%d;", i)), 1000)
+			sent.Write(msg)
+			_, err = w.Write(msg)
+			must(err)
+			must(w.Flush())
+		}
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 5)))
+		if s.alg == Gzip {
+			r, err = gzip.NewReader(c)
+			must(err)
+		} else {
+			r = flate.NewReader(c)
+		}
+		echo := make([]byte, sent.Len())
+		_, err = io.ReadFull(r, echo)
+		must(err)
+		verdict := "matched"
+		if !bytes.Equal(echo, sent.Bytes()) {
+			verdict = "mismatched"
+		}
+		_, err = w.Write([]byte(verdict))
+		must(err)
+		must(w.Flush())
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testCompressionServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "matched":
+		s.matched = true
+		action = Shutdown
+	case "mismatched":
+		action = Shutdown
+	default:
+		out = frame
+	}
+	return
+}
+
+func TestConnRecorder(t *testing.T) {
+	var records []ConnRecord
+	events := &testConnRecorderServer{network: "tcp", addr: ":9974"}
+	must(Serve(events, "tcp://:9974", WithConnRecorder(func(rec ConnRecord) {
+		records = append(records, rec)
+	})))
+	if len(records) != 2 || records[0].Closed || !records[1].Closed {
+		t.Fatalf("expected the records of opening and closing, got %+v", records)
+	}
+	closing := records[1]
+	if closing.BytesIn != 5 || closing.BytesOut != 5 || closing.Reason != ErrEOF {
+		t.Fatalf("unexpected record of closing: %+v", closing)
+	}
+	if closing.RemoteAddr == nil || !closing.OpenedAt.Equal(records[0].OpenedAt) || closing.Duration <= 0 {
+		t.Fatalf("unexpected record of closing: %+v", closing)
+	}
+}
+
+type testConnRecorderServer struct {
+	*EventServer
+	network, addr string
+}
+
+func (s *testConnRecorderServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		_, err = c.Write([]byte("hello"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = io.ReadFull(c, make([]byte, 5))
+		must(err)
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testConnRecorderServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (s *testConnRecorderServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestTracer(t *testing.T) {
+	tracer := new(testTracer)
+	events := &testConnRecorderServer{network: "tcp", addr: ":9961"}
+	must(Serve(events, "tcp://:9961", WithTracer(tracer)))
+	expected := []string{"open", "first-byte", "frame hello", "done hello", "flush 5", "close " + ErrEOF.Error()}
+	if !reflect.DeepEqual(tracer.points, expected) {
+		t.Fatalf("expected the points of tracing %q, got %q", expected, tracer.points)
+	}
+}
+
+type testTracer struct {
+	points []string
+}
+
+func (t *testTracer) TraceOpen(c Conn) {
+	t.points = append(t.points, "open")
+}
+
+func (t *testTracer) TraceFirstByte(c Conn) {
+	t.points = append(t.points, "first-byte")
+}
+
+func (t *testTracer) TraceFrame(c Conn, frame []byte) func(out []byte, action Action) {
+	t.points = append(t.points, "frame "+string(frame))
+	return func(out []byte, action Action) {
+		t.points = append(t.points, "done "+string(out))
+	}
+}
+
+func (t *testTracer) TraceFlush(c Conn, n int) {
+	t.points = append(t.points, "flush "+strconv.Itoa(n))
+}
+
+func (t *testTracer) TraceClose(c Conn, reason error) {
+	t.points = append(t.points, "close "+reason.Error())
+}
+
+func TestErrorHandler(t *testing.T) {
+	events := &testErrorHandlerServer{network: "tcp", addr: ":9973"}
+	must(Serve(events, "tcp://:9973"))
+	if events.op != "read" || events.err == nil {
+		t.Fatalf("OnError is not fired for the reset connection, op: %q, err: %v", events.op, events.err)
+	}
+	if events.reason != ErrReset {
+		t.Fatalf("expected %v, got %v", ErrReset, events.reason)
+	}
+}
+
+type testErrorHandlerServer struct {
+	*EventServer
+	network, addr string
+	op            string
+	err, reason   error
+}
+
+func (s *testErrorHandlerServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		_, err = c.Write([]byte("hello"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = io.ReadFull(c, make([]byte, 5))
+		must(err)
+		// Reset the connection instead of closing it gracefully.
+		must(c.(*net.TCPConn).SetLinger(0))
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testErrorHandlerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (s *testErrorHandlerServer) OnError(c Conn, op string, err error) (action Action) {
+	s.op, s.err = op, err
+	action = Close
+	return
+}
+
+func (s *testErrorHandlerServer) OnClosed(c Conn, err error) (action Action) {
+	s.reason = err
+	action = Shutdown
+	return
+}
+
+func TestParseAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr, network, address string
+	}{
+		{":9000", "tcp", ":9000"},
+		{"TCP://127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"tcp6://[fe80::1%Ethernet0]:9000", "tcp6", "[fe80::1%Ethernet0]:9000"},
+		{"unix:///tmp/Gnet.sock", "unix", "/tmp/Gnet.sock"},
+	} {
+		if network, address := parseAddr(tc.addr); network != tc.network || address != tc.address {
+			t.Fatalf("parseAddr(%q) = %q, %q, expected %q, %q", tc.addr, network, address, tc.network, tc.address)
+		}
+	}
+}
+
+func TestIPv6Only(t *testing.T) {
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	} else {
+		_ = ln.Close()
+	}
+	events := &testIPv6OnlyServer{addr: "9972"}
+	must(Serve(events, "tcp://:9972", WithIPv6Only(true), WithTicker(true)))
+	if events.v4Err == nil || events.v6Err != nil {
+		t.Fatalf("expected IPv6 only, got the error of IPv4: %v, IPv6: %v", events.v4Err, events.v6Err)
+	}
+}
+
+type testIPv6OnlyServer struct {
+	*EventServer
+	addr         string
+	v4Err, v6Err error
+	done         int32
+}
+
+func (s *testIPv6OnlyServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&s.done, 1)
+		if c, err := net.DialTimeout("tcp4", "127.0.0.1:"+s.addr, time.Second); err == nil {
+			_ = c.Close()
+		} else {
+			s.v4Err = err
+		}
+		if c, err := net.DialTimeout("tcp6", "[::1]:"+s.addr, time.Second); err == nil {
+			_ = c.Close()
+		} else {
+			s.v6Err = err
+		}
+	}()
+	return
+}
+
+func (s *testIPv6OnlyServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 10
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func TestConnState(t *testing.T) {
+	events := &testConnStateServer{network: "tcp", addr: ":9971"}
+	must(Serve(events, "tcp://:9971", WithCodec(new(LineBasedFrameCodec))))
+	if events.result != "hello,ok,echo:ping,react:bye" {
+		t.Fatalf("the frames are not routed by the states of connection: %s", events.result)
+	}
+}
+
+type testConnStateServer struct {
+	*EventServer
+	network, addr string
+	result        string
+}
+
+func (s *testConnStateServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		// All frames are sent at once, so that the states are switched in the middle of the inbound data.
+		_, err = c.Write([]byte("HELO\nAUTH secret\nping\nQUIT\nbye\n"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		r := bufio.NewReader(c)
+		var replies []string
+		for i := 0; i < 4; i++ {
+			line, err := r.ReadString('\n')
+			must(err)
+			replies = append(replies, strings.TrimSuffix(line, "\n"))
+		}
+		_, err = c.Write([]byte(strings.Join(replies, ",") + "\n"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testConnStateServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetState(s.handshake)
+	return
+}
+
+func (s *testConnStateServer) handshake(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "HELO" {
+		return nil, Close
+	}
+	c.SetState(s.auth)
+	return []byte("hello"), None
+}
+
+func (s *testConnStateServer) auth(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "AUTH secret" {
+		return nil, Close
+	}
+	c.SetState(s.streaming)
+	return []byte("ok"), None
+}
+
+func (s *testConnStateServer) streaming(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "QUIT" {
+		c.SetState(nil)
+		return
+	}
+	return append([]byte("echo:"), frame...), None
+}
+
+func (s *testConnStateServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if strings.HasPrefix(string(frame), "hello,") {
+		s.result = string(frame)
+		return nil, Shutdown
+	}
+	return append([]byte("react:"), frame...), None
+}
+
+func TestAcceptRateLimit(t *testing.T) {
+	events := &testAcceptRateLimitServer{network: "tcp", addr: ":9970", N: 6}
+	must(Serve(events, "tcp://:9970", WithAcceptRateLimit(10, 2)))
+	// 2 connections are accepted at once, the other 4 ones are accepted at the rate of 10 per second.
+	if elapsed := events.last.Sub(events.first); elapsed < time.Millisecond*300 {
+		t.Fatalf("%d connections are accepted within %v", events.N, elapsed)
+	}
+}
+
+type testAcceptRateLimitServer struct {
+	*EventServer
+	network, addr string
+	N, opened     int
+	first, last   time.Time
+	apply         []Option
+}
+
+func (s *testAcceptRateLimitServer) OnInitComplete(svr Server) (action Action) {
+	if len(s.apply) > 0 {
+		must(svr.ApplyOptions(s.apply...))
+	}
+	for i := 0; i < s.N; i++ {
+		go func() {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer c.Close()
+			_, _ = c.Read(make([]byte, 1))
+		}()
+	}
+	return
+}
+
+func (s *testAcceptRateLimitServer) OnOpened(c Conn) (out []byte, action Action) {
+	if s.opened++; s.opened == 1 {
+		s.first = time.Now()
+	}
+	if s.opened == s.N {
+		s.last = time.Now()
+		action = Shutdown
+	}
+	return
+}
+
+func TestWriteBuffering(t *testing.T) {
+	events := &testWriteBufferingServer{network: "tcp", addr: ":9969"}
+	must(Serve(events, "tcp://:9969", WithCodec(new(LineBasedFrameCodec)), WithWriteBuffering(true)))
+	if events.staged != len("a\nb\n") || events.flushed != 0 {
+		t.Fatalf("the writes are not staged until Flush: %d bytes staged, %d bytes left after Flush",
+			events.staged, events.flushed)
+	}
+	if events.result != "a,b,c,d" {
+		t.Fatalf("the staged writes are not flushed in order: %s", events.result)
+	}
+}
+
+type testWriteBufferingServer struct {
+	*EventServer
+	network, addr   string
+	staged, flushed int
+	result          string
+}
+
+func (s *testWriteBufferingServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("a\nb\nc\nd\n"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		r := bufio.NewReader(c)
+		var replies []string
+		for i := 0; i < 4; i++ {
+			line, err := r.ReadString('\n')
+			must(err)
+			replies = append(replies, strings.TrimSuffix(line, "\n"))
+		}
+		_, err = c.Write([]byte("result:" + strings.Join(replies, ",") + "\n"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testWriteBufferingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "c":
+		// The responses to "a" and "b" are staged since the frames arrive in one batch of events.
+		s.staged = c.OutboundBuffered()
+		must(c.Flush())
+		s.flushed = c.OutboundBuffered()
+	default:
+		if strings.HasPrefix(string(frame), "result:") {
+			s.result = strings.TrimPrefix(string(frame), "result:")
+			return nil, Shutdown
+		}
+	}
+	return frame, None
+}
+
+func TestUDPControl(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("UDPControl is only supported on Linux")
+	}
+	events := &testUDPControlServer{network: "udp", addr: "127.0.0.1:9968"}
+	must(Serve(events, "udp://127.0.0.1:9968", WithUDPControl(true)))
+	cm := events.cm
+	if cm == nil || !cm.Dst.Equal(net.IPv4(127, 0, 0, 1)) || cm.IfIndex == 0 || cm.TTL == 0 || cm.Timestamp.IsZero() {
+		t.Fatalf("the control messages of datagram are not received: %+v", cm)
+	}
+	if !events.echoed {
+		t.Fatal("the datagram with the control messages is not sent")
+	}
+}
+
+type testUDPControlServer struct {
+	*EventServer
+	network, addr string
+	cm            *ControlMessage
+	echoed        bool
+}
+
+func (s *testUDPControlServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 16)
+		n, err := c.Read(buf)
+		must(err)
+		_, err = c.Write(buf[:n])
+		must(err)
+	}()
+	return
+}
+
+func (s *testUDPControlServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "pong" {
+		s.echoed = true
+		return nil, Shutdown
+	}
+	s.cm = c.ControlMessage()
+	// Echo the ECN bits of ECT(0) and reply from the address which the datagram was sent to.
+	must(c.SendToWithControl([]byte("pong"), &ControlMessage{Src: s.cm.Dst, TOS: 0x02}))
+	return
+}
+
+func TestMaxFramesPerPoll(t *testing.T) {
+	events := &testMaxFramesPerPollServer{network: "tcp", addr: ":9967"}
+	must(Serve(events, "tcp://:9967", WithCodec(new(LineBasedFrameCodec)), WithMaxFramesPerPoll(1)))
+	// The job queued by "a" runs before "b" since the rest of frames are deferred to the next iteration.
+	if events.result != "a,job,b,c" {
+		t.Fatalf("the frames are not deferred by MaxFramesPerPoll: %s", events.result)
+	}
+}
+
+type testMaxFramesPerPollServer struct {
+	*EventServer
+	network, addr string
+	handled       []string
+	result        string
+}
+
+func (s *testMaxFramesPerPollServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("a\nb\nc\n"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testMaxFramesPerPollServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.handled = append(s.handled, string(frame))
+	switch string(frame) {
+	case "a":
+		must(c.Execute(func(c Conn) {
+			s.handled = append(s.handled, "job")
+		}))
+	case "c":
+		s.result = strings.Join(s.handled, ",")
+		action = Shutdown
+	}
+	return
+}
+
+func TestReactBatch(t *testing.T) {
+	events := &testReactBatchServer{network: "tcp", addr: ":9960", reply: make(chan string, 1)}
+	must(Serve(events, "tcp://:9960", WithCodec(new(LineBasedFrameCodec))))
+	if len(events.batches) != 1 || events.batches[0] != "a,b,c" || events.seq != 3 {
+		t.Fatalf("the pipelined frames are not passed to ReactBatch at once: %q, seq: %d", events.batches, events.seq)
+	}
+	if reply := <-events.reply; reply != "A\nB\nC\n" {
+		t.Fatalf("unexpected reply of the batch: %q", reply)
+	}
+}
+
+type testReactBatchServer struct {
+	*EventServer
+	network, addr string
+	batches       []string
+	seq           uint64
+	reply         chan string
+}
+
+func (s *testReactBatchServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		_, err = c.Write([]byte("a\nb\nc\n"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		reply := make([]byte, 6)
+		_, err = io.ReadFull(c, reply)
+		must(err)
+		s.reply <- string(reply)
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testReactBatchServer) ReactBatch(frames [][]byte, c Conn) (outs [][]byte, action Action) {
+	var batch []string
+	for _, frame := range frames {
+		batch = append(batch, string(frame))
+		outs = append(outs, bytes.ToUpper(frame))
+	}
+	s.batches = append(s.batches, strings.Join(batch, ","))
+	s.seq = c.FrameSeq()
+	return
+}
+
+func (s *testReactBatchServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestFDLimitPolicy(t *testing.T) {
+	events := &testFDLimitServer{network: "tcp", addr: ":9959"}
+	// Every accepted connection is over the watermark of the least file descriptor.
+	must(Serve(events, "tcp://:9959", WithFDLimitPolicy(1e-9, CloseLeastActive)))
+	if len(events.reasons) != 2 || events.reasons[0] != ErrFDLimit || events.reasons[1] != ErrEOF {
+		t.Fatalf("the least active connection is not closed for the limit of file descriptors: %v", events.reasons)
+	}
+}
+
+type testFDLimitServer struct {
+	*EventServer
+	network, addr string
+	reasons       []error
+}
+
+func (s *testFDLimitServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		dial := func(data string) net.Conn {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			_, err = c.Write([]byte(data))
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = io.ReadFull(c, make([]byte, len(data)))
+			must(err)
+			return c
+		}
+		idle := dial("a")
+		active := dial("b")
+		if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the idle connection closed by server, got %v", err))
+		}
+		must(idle.Close())
+		must(active.Close())
+	}()
+	return
+}
+
+func (s *testFDLimitServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (s *testFDLimitServer) OnClosed(c Conn, err error) (action Action) {
+	s.reasons = append(s.reasons, err)
+	if err != ErrFDLimit {
+		action = Shutdown
+	}
+	return
+}
+
+func TestEventLoopPicker(t *testing.T) {
+	events := &testEventLoopPickerServer{network: "tcp", addr: ":9958"}
+	must(Serve(events, "tcp://:9958", WithNumEventLoop(4), WithEventLoopPicker(func(addr net.Addr, loops int) int {
+		if addr == nil {
+			panic("the remote address is not passed to EventLoopPicker")
+		}
+		return loops - 1
+	})))
+	if events.reacted != 2 || events.misplaced != 0 {
+		t.Fatalf("the connections are not pinned to the picked event-loop: %d reacted, %d misplaced",
+			events.reacted, events.misplaced)
+	}
+}
+
+type testEventLoopPickerServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	reacted       int32
+	misplaced     int32
+	closed        int32
+}
+
+func (s *testEventLoopPickerServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			_, err = c.Write([]byte("ping"))
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = io.ReadFull(c, make([]byte, 4))
+			must(err)
+			must(c.Close())
+		}
+	}()
+	return
+}
+
+func (s *testEventLoopPickerServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&s.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func (s *testEventLoopPickerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if loops := s.svr.svr.loops; eventLoopOf(c) != loops[len(loops)-1] {
+		atomic.AddInt32(&s.misplaced, 1)
+	}
+	atomic.AddInt32(&s.reacted, 1)
+	out = frame
+	return
+}
+
+func TestConnHandle(t *testing.T) {
+	events := &testConnHandleServer{network: "tcp", addr: ":9957"}
+	must(Serve(events, "tcp://:9957"))
+	h := events.handle
+	if !events.equal || h.Alive() {
+		t.Fatalf("unexpected handle of the closed connection, equal: %t, alive: %t", events.equal, h.Alive())
+	}
+	if err := h.AsyncWrite([]byte("late")); err != ErrConnClosed {
+		t.Fatalf("expected %v, got %v", ErrConnClosed, err)
+	}
+	if err := h.Close(); err != ErrConnClosed {
+		t.Fatalf("expected %v, got %v", ErrConnClosed, err)
+	}
+	if (ConnHandle{}).Alive() {
+		t.Fatal("the zero handle is alive")
+	}
+}
+
+type testConnHandleServer struct {
+	*EventServer
+	network, addr string
+	handle        ConnHandle
+	equal         bool
+}
+
+func (s *testConnHandleServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = io.ReadFull(c, make([]byte, 5))
+		must(err)
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testConnHandleServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.handle = c.Handle()
+	go func(h ConnHandle) {
+		if !h.Alive() {
+			panic("the handle of the open connection is not alive")
+		}
+		must(h.AsyncWrite([]byte("hello")))
+	}(s.handle)
+	return
+}
+
+func (s *testConnHandleServer) OnClosed(c Conn, err error) (action Action) {
+	s.equal = c.Handle() == s.handle
+	action = Shutdown
+	return
+}
+
+func TestSlowConsumer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("slow consumer detection is not supported on Windows")
+	}
+	for _, policy := range []SlowConsumerPolicy{SlowConsumerClose, SlowConsumerDropOldest, SlowConsumerPause} {
+		events := &testSlowConsumerServer{network: "tcp", addr: ":9956", policy: policy, slow: make(chan struct{})}
+		expected := ErrEOF
+		if policy == SlowConsumerClose {
+			expected = ErrSlowConsumer
+		}
+		must(Serve(events, "tcp://:9956", WithCodec(new(LineBasedFrameCodec)),
+			WithSlowConsumer(1<<20, 100*time.Millisecond, policy)))
+		if atomic.LoadInt32(&events.notified) == 0 {
+			t.Fatalf("policy %d: OnSlowConsumer didn't fire", policy)
+		}
+		if events.err != expected {
+			t.Fatalf("policy %d: expected the connection closed with %v, got %v", policy, expected, events.err)
+		}
+	}
+}
+
+type testSlowConsumerServer struct {
+	*EventServer
+	network, addr string
+	policy        SlowConsumerPolicy
+	conn          Conn
+	slow          chan struct{}
+	notified      int32
+	err           error
+}
+
+// The messages written to the slow consumer, which are far more than the socket buffers can hold.
+const (
+	slowConsumerMessages    = 128
+	slowConsumerMessageSize = 256 << 10
+)
+
+func (s *testSlowConsumerServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetDeadline(time.Now().Add(time.Second * 10)))
+		_, err = c.Write([]byte("go\n"))
+		must(err)
+		select {
+		case <-s.slow:
+		case <-time.After(time.Second * 3):
+			panic("the connection is not taken as a slow consumer")
+		}
+
+		if s.policy == SlowConsumerClose {
+			_, err = io.Copy(ioutil.Discard, c)
+			must(err)
+			return
+		}
+		if s.policy == SlowConsumerPause {
+			if err = s.conn.AsyncWrite([]byte("paused")); err != ErrSlowConsumer {
+				panic(fmt.Sprintf("expected %v writing to the paused connection, got %v", ErrSlowConsumer, err))
+			}
+			if err = s.conn.AsyncWriteSeq(0, []byte("paused")); err != ErrSlowConsumer {
+				panic(fmt.Sprintf("expected %v writing the sequenced response, got %v", ErrSlowConsumer, err))
+			}
+		}
+		_, err = c.Write([]byte("end\n"))
+		must(err)
+		// The messages are never cut, only the whole ones are dropped.
+		r := bufio.NewReader(c)
+		var lines int
+		for ; ; lines++ {
+			line, err := r.ReadString('\n')
+			must(err)
+			if line == "end\n" {
+				break
+			}
+			if len(line) != slowConsumerMessageSize {
+				panic(fmt.Sprintf("the message is corrupted, got %d bytes", len(line)))
+			}
+		}
+		switch {
+		case s.policy == SlowConsumerDropOldest && lines >= slowConsumerMessages:
+			panic("the oldest messages are not dropped")
+		case s.policy == SlowConsumerPause && lines != slowConsumerMessages:
+			panic(fmt.Sprintf("expected %d messages, got %d", slowConsumerMessages, lines))
+		}
+		// The pause is lifted once the outbound buffer drains.
+		must(s.conn.AsyncWrite([]byte("resumed")))
+		line, err := r.ReadString('\n')
+		must(err)
+		if line != "resumed\n" {
+			panic(fmt.Sprintf("expected the write after the pause, got %q", line))
+		}
+	}()
+	return
+}
+
+func (s *testSlowConsumerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "go":
+		s.conn = c
+		for i := 0; i < slowConsumerMessages; i++ {
+			must(c.AsyncWrite(bytes.Repeat([]byte{'x'}, slowConsumerMessageSize-1)))
+		}
+	case "end":
+		out = frame
+	}
+	return
+}
+
+func (s *testSlowConsumerServer) OnSlowConsumer(c Conn) (action Action) {
+	// The pause is applied after the first OnSlowConsumer, it is in effect on the second one.
+	want := int32(1)
+	if s.policy == SlowConsumerPause {
+		want = 2
+	}
+	if atomic.AddInt32(&s.notified, 1) == want {
+		close(s.slow)
+	}
+	return
+}
+
+func (s *testSlowConsumerServer) OnClosed(c Conn, err error) (action Action) {
+	s.err = err
+	action = Shutdown
+	return
+}
+
+func TestMiddleware(t *testing.T) {
+	events := &testMiddlewareServer{network: "tcp", addr: ":9953"}
+	logger := new(testBufferLogger)
+	var metrics HandlerMetrics
+	handler := Chain(events, Recover(logger), Logging(logger), Metrics(&metrics), RateLimit(1, 2))
+	must(Serve(handler, "tcp://:9953", WithCodec(new(LineBasedFrameCodec))))
+	if events.svr.svr.errorHandler == nil {
+		t.Fatal("the optional interfaces of the chained handler are lost")
+	}
+	if metrics.Opened != 2 || metrics.Closed != 2 || metrics.Frames < 4 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+	for _, log := range []string{"panic in React", "frame from", "closed"} {
+		if !strings.Contains(logger.String(), log) {
+			t.Fatalf("%q is not logged in:\n%s", log, logger.String())
+		}
+	}
+}
+
+type testBufferLogger struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *testBufferLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	fmt.Fprintf(&l.buf, format, args...)
+	l.mu.Unlock()
+}
+
+func (l *testBufferLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+type testMiddlewareServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	closed        int
+}
+
+func (s *testMiddlewareServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		for _, tc := range []struct{ in, out string }{
+			// The panic closes the connection instead of the event-loop.
+			{"ping\npanic\n", "ping\n"},
+			// The third frame exceeds the burst.
+			{"a\nb\nc\n", "a\nb\n"},
+		} {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = c.Write([]byte(tc.in))
+			must(err)
+			out, err := ioutil.ReadAll(c)
+			must(err)
+			if string(out) != tc.out {
+				panic(fmt.Sprintf("expected %q before closing, got %q", tc.out, out))
+			}
+			must(c.Close())
+		}
+	}()
+	return
+}
+
+func (s *testMiddlewareServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "panic" {
+		panic("bad frame")
+	}
+	return frame, None
+}
+
+func (s *testMiddlewareServer) OnError(c Conn, op string, err error) (action Action) {
+	return Close
+}
+
+func (s *testMiddlewareServer) OnClosed(c Conn, err error) (action Action) {
+	if s.closed++; s.closed == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func TestConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	data := `{"num_event_loop": 4, "load_balancing": "least-connections", "idle_timeout": "1m30s",
+		"slow_consumer_threshold": 1024, "slow_consumer_timeout": "2s", "slow_consumer_policy": "pause"}`
+	must(json.Unmarshal([]byte(data), &cfg))
+	must(os.Setenv("GNET_TEST_FD_WATERMARK", "0.8"))
+	must(os.Setenv("GNET_TEST_TCP_KEEP_ALIVE", "15s"))
+	must(os.Setenv("GNET_TEST_REUSE_PORT", "true"))
+	defer func() {
+		for _, name := range []string{"GNET_TEST_FD_WATERMARK", "GNET_TEST_TCP_KEEP_ALIVE", "GNET_TEST_REUSE_PORT"} {
+			_ = os.Unsetenv(name)
+		}
+	}()
+	must(cfg.LoadEnv("GNET_TEST_"))
+	must(cfg.Validate())
+
+	codec := new(LineBasedFrameCodec)
+	opts := loadOptions(WithCodec(codec), WithConfig(cfg))
+	expected := Options{
+		Multicore:             true,
+		NumEventLoop:          4,
+		LB:                    LeastConnections,
+		ReusePort:             true,
+		TCPKeepAlive:          15 * time.Second,
+		IdleTimeout:           90 * time.Second,
+		Codec:                 codec,
+		CompressionLevel:      flate.DefaultCompression,
+		FDWatermark:           0.8,
+		SlowConsumerThreshold: 1024,
+		SlowConsumerTimeout:   2 * time.Second,
+		SlowConsumerPolicy:    SlowConsumerPause,
+	}
+	if !reflect.DeepEqual(*opts, expected) {
+		t.Fatalf("expected options %+v, got %+v", expected, *opts)
+	}
+	if out, err := json.Marshal(cfg); err != nil || !strings.Contains(string(out), `"idle_timeout":"1m30s"`) {
+		t.Fatalf("the durations are not marshaled as strings: %s, %v", out, err)
+	}
+
+	for _, invalid := range []func(cfg *Config){
+		func(cfg *Config) { cfg.NumEventLoop = -1 },
+		func(cfg *Config) { cfg.FDWatermark = 2 },
+		func(cfg *Config) { cfg.MemoryPolicy = "unknown" },
+		func(cfg *Config) { cfg.SlowConsumerTimeout = 0 },
+		func(cfg *Config) { cfg.UDPSendQueue, cfg.UDPSendPolicy = 8, "block" },
+	} {
+		bad := cfg
+		invalid(&bad)
+		if err := bad.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected %v, got %v", ErrInvalidConfig, err)
+		}
+		if err := Serve(new(EventServer), "tcp://:9955", WithConfig(bad)); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected Serve to fail with %v, got %v", ErrInvalidConfig, err)
+		}
+	}
+	must(os.Setenv("GNET_TEST_NUM_ACCEPTORS", "many"))
+	defer os.Unsetenv("GNET_TEST_NUM_ACCEPTORS")
+	if err := cfg.LoadEnv("GNET_TEST_"); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected %v, got %v", ErrInvalidConfig, err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	events := &testStartServer{}
+	s, err := Start(events, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, ok := s.Addr.(*net.TCPAddr)
+	if !ok || addr.Port == 0 {
+		t.Fatalf("the bound address is not populated: %v", s.Addr)
+	}
+	c, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	first := s.Wait()
+	if !events.reacted || events.shutdown != 1 {
+		t.Fatalf("the server started by Start is not served: reacted=%t, shutdown=%d", events.reacted, events.shutdown)
+	}
+	// Waiting again neither stops the server twice nor tells a different story.
+	if again := s.Wait(); events.shutdown != 1 || again.Connections != first.Connections || again.Reason != first.Reason {
+		t.Fatalf("expected the same report from the second Wait, got %+v after %+v", again, first)
+	}
+}
+
+type testStartServer struct {
+	*EventServer
+	reacted  bool
+	shutdown int
+}
+
+func (s *testStartServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.reacted = string(frame) == "ping"
+	return nil, Shutdown
+}
+
+func (s *testStartServer) OnShutdown(svr Server) {
+	s.shutdown++
+}
+
+func TestPubSub(t *testing.T) {
+	events := &testPubSubServer{network: "tcp", addr: ":9966", N: 3}
+	must(Serve(events, "tcp://:9966", WithNumEventLoop(2)))
+	if atomic.LoadInt32(&events.received) != int32(events.N) {
+		t.Fatalf("%d of %d subscribers received the published data", events.received, events.N)
+	}
+	if len(events.svr.svr.bus.subs) != 0 || len(events.svr.svr.bus.topics) != 0 {
+		t.Fatal("the subscriptions are not removed on closing connections")
+	}
+}
+
+type testPubSubServer struct {
+	*EventServer
+	svr                  Server
+	network, addr        string
+	N                    int
+	subscribed, received int32
+}
+
+func (s *testPubSubServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	for i := 0; i < s.N; i++ {
+		go func() {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer c.Close()
+			_, err = c.Write([]byte("sub"))
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+			_, err = c.Write(buf)
+			must(err)
+			_, _ = c.Read(make([]byte, 1))
+		}()
+	}
+	return
+}
+
+func (s *testPubSubServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "sub":
+		must(s.svr.Subscribe(c, "news"))
+		if atomic.AddInt32(&s.subscribed, 1) == int32(s.N) {
+			go func() { must(s.svr.Publish("news", []byte("news"))) }()
+		}
+	case "news":
+		if atomic.AddInt32(&s.received, 1) == int32(s.N) {
+			action = Shutdown
+		}
+	}
+	return
+}
+
+func TestLazyInboundBuffer(t *testing.T) {
+	events := &testLazyInboundBufferServer{network: "tcp", addr: ":9965"}
+	must(Serve(events, "tcp://:9965", WithCodec(new(LineBasedFrameCodec)), WithLazyInboundBuffer(true)))
+	if events.result != "ping,partial,pong" {
+		t.Fatalf("the partial frames are not kept: %s", events.result)
+	}
+}
+
+type testLazyInboundBufferServer struct {
+	*EventServer
+	network, addr string
+	frames        []string
+	result        string
+}
+
+func (s *testLazyInboundBufferServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		// The partial frames are held across the reads, both alone and after a whole frame.
+		for _, piece := range []string{"ping\npar", "tial\n", "po", "ng\n"} {
+			_, err = c.Write([]byte(piece))
+			must(err)
+			time.Sleep(time.Millisecond * 50)
+		}
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testLazyInboundBufferServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if s.frames = append(s.frames, string(frame)); len(s.frames) == 3 {
+		s.result = strings.Join(s.frames, ",")
+		action = Shutdown
+	}
+	return
+}
+
+func TestLoopTime(t *testing.T) {
+	events := &testLoopTimeServer{network: "tcp", addr: ":9964"}
+	must(Serve(events, "tcp://:9964", WithCodec(new(LineBasedFrameCodec))))
+	if len(events.times) != 3 || !events.times[0].Equal(events.times[1]) || !events.times[2].After(events.times[1]) {
+		t.Fatalf("the time is not cached per iteration of polling: %v", events.times)
+	}
+}
+
+type testLoopTimeServer struct {
+	*EventServer
+	network, addr string
+	times         []time.Time
+}
+
+func (s *testLoopTimeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("a\nb\n"))
+		must(err)
+		time.Sleep(time.Millisecond * 50)
+		_, err = c.Write([]byte("c\n"))
+		must(err)
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testLoopTimeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if s.times = append(s.times, c.LoopTime()); len(s.times) == 3 {
+		action = Shutdown
+	}
+	return
+}
+
+func TestLoopTaskQueueCap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("LoopTaskQueueCap is not supported on Windows")
+	}
+	for _, overload := range []OverloadPolicy{OverloadReject, OverloadDropOldest} {
+		events := &testLoopTaskQueueServer{network: "tcp", addr: ":9963"}
+		must(Serve(events, "tcp://:9963", WithLoopTaskQueueCap(2, overload)))
+		if events.queued != 2 {
+			t.Fatalf("%d tasks are queued with the capacity of 2", events.queued)
+		}
+		switch overload {
+		case OverloadReject:
+			if events.errs[2] != ErrTaskQueueFull || events.result != "0,1" {
+				t.Fatalf("the task is not rejected: %v, %s", events.errs, events.result)
+			}
+		case OverloadDropOldest:
+			if events.errs[2] != nil || events.result != "1,2" {
+				t.Fatalf("the oldest task is not dropped: %v, %s", events.errs, events.result)
+			}
+		}
+	}
+}
+
+type testLoopTaskQueueServer struct {
+	*EventServer
+	svr           Server
+	network, addr string
+	errs          []error
+	ran           []string
+	queued        int
+	result        string
+}
+
+func (s *testLoopTaskQueueServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testLoopTaskQueueServer) OnOpened(c Conn) (out []byte, action Action) {
+	// The tasks are submitted on the event-loop, so none of them runs before the last one is submitted.
+	for i := 0; i < 3; i++ {
+		i := i
+		s.errs = append(s.errs, c.Execute(func(c Conn) {
+			if s.ran = append(s.ran, strconv.Itoa(i)); len(s.ran) == 2 {
+				s.result = strings.Join(s.ran, ",")
+				_ = c.Close()
+			}
+		}))
+	}
+	s.queued = s.svr.QueuedTasks()
+	return
+}
+
+func (s *testLoopTaskQueueServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestTCPUserTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_USER_TIMEOUT is only supported on Linux")
+	}
+	events := &testTCPUserTimeoutServer{network: "tcp", addr: ":9962"}
+	must(Serve(events, "tcp://:9962", WithTCPUserTimeout(time.Second*5)))
+	if events.err != nil {
+		t.Fatalf("failed to set up TCP_USER_TIMEOUT: %v", events.err)
+	}
+}
+
+type testTCPUserTimeoutServer struct {
+	*EventServer
+	network, addr string
+	err           error
+}
+
+func (s *testTCPUserTimeoutServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testTCPUserTimeoutServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.err = c.SetUserTimeout(time.Second)
+	return nil, Shutdown
+}
+
+func TestPanicRecovery(t *testing.T) {
+	events := &testPanicServer{network: "tcp", addr: ":9952"}
+	must(Serve(events, "tcp://:9952", WithCodec(new(LineBasedFrameCodec)),
+		WithPanicHandler(func(c Conn, p interface{}, stack []byte) {
+			if c == nil || p != "bad frame" || len(stack) == 0 {
+				panic(fmt.Sprintf("unexpected panic reported: %v, %v", c, p))
+			}
+			events.panics++
+		})))
+	if events.panics != 1 {
+		t.Fatalf("expected 1 panic reported, got %d", events.panics)
+	}
+	if len(events.reasons) != 2 || events.reasons[0] != ErrPanic || events.reasons[1] != ErrEOF {
+		t.Fatalf("unexpected reasons of closing: %v", events.reasons)
+	}
+}
+
+type testPanicServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	panics        int
+	reasons       []error
+}
+
+func (s *testPanicServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		bystander, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer bystander.Close()
+		must(bystander.SetReadDeadline(time.Now().Add(time.Second * 3)))
+
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("ping\npanic\nlost\n"))
+		must(err)
+		out, err := ioutil.ReadAll(c)
+		must(err)
+		if string(out) != "ping\n" {
+			panic(fmt.Sprintf("expected %q before closing, got %q", "ping\n", out))
+		}
+		must(c.Close())
+
+		// The event-loop goes on serving the other connections.
+		_, err = bystander.Write([]byte("pong\n"))
+		must(err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(bystander, buf)
+		must(err)
+		if string(buf) != "pong\n" {
+			panic(fmt.Sprintf("expected %q, got %q", "pong\n", buf))
+		}
+	}()
+	return
+}
+
+func (s *testPanicServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "panic" {
+		panic("bad frame")
+	}
+	return frame, None
+}
+
+func (s *testPanicServer) OnClosed(c Conn, err error) (action Action) {
+	if s.reasons = append(s.reasons, err); len(s.reasons) == 2 {
+		return Shutdown
+	}
+	return
+}
+
+func TestLoopProfiling(t *testing.T) {
+	events := &testProfilingServer{network: "tcp", addr: ":9951"}
+	must(Serve(events, "tcp://:9951", WithCodec(new(LineBasedFrameCodec)), WithLoopProfiling(true)))
+	stats := events.svr.LoopStats()
+	if len(stats) != 1 {
+		t.Fatalf("expected the stats of 1 event-loop, got %d", len(stats))
+	}
+	st := stats[0]
+	if st.Handler < 3*10*time.Millisecond || st.Busy < st.Handler || st.Flush <= 0 || st.PollWait <= 0 {
+		t.Fatalf("unexpected stats of event-loop: %+v", st)
+	}
+}
+
+type testProfilingServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+}
+
+func (s *testProfilingServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 2)
+		for i := 0; i < 3; i++ {
+			// Idle for a while between the frames, which is taken as the time of polling.
+			time.Sleep(10 * time.Millisecond)
+			_, err = c.Write([]byte("a\n"))
+			must(err)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+		}
+	}()
+	return
+}
+
+func (s *testProfilingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	time.Sleep(10 * time.Millisecond)
+	return frame, None
+}
+
+func (s *testProfilingServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestPeerStats(t *testing.T) {
+	events := &testPeerServer{network: "tcp", addr: "127.0.0.1:9950"}
+	must(Serve(events, "tcp://127.0.0.1:9950", WithCodec(new(LineBasedFrameCodec)), WithPeerStats(24, 64),
+		WithPerIPMaxConnections(2)))
+	if events.opened != 2 {
+		t.Fatalf("expected 2 connections opened, got %d", events.opened)
+	}
+	if events.leftover {
+		t.Fatal("the statistics of peer are left after its connections are closed")
+	}
+}
+
+type testPeerServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	opened        int
+	closed        int
+	leftover      bool
+}
+
+func (s *testPeerServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = c.Write([]byte("hello\n"))
+			must(err)
+			buf := make([]byte, 6)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+			conns = append(conns, c)
+		}
+
+		// The third connection from the same IP is over the limit.
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection over the limit to be closed, got %v", err))
+		}
+		must(c.Close())
+
+		// The IPs in the same /24 share the statistics.
+		st, ok := s.svr.PeerStats(net.ParseIP("127.0.0.9"))
+		if !ok || st.Connections != 2 || st.BytesIn != 12 || st.BytesOut != 12 || st.InRate <= 0 || st.OutRate <= 0 {
+			panic(fmt.Sprintf("unexpected statistics of peer: %+v, %t", st, ok))
+		}
+		if _, ok = s.svr.PeerStats(net.ParseIP("127.0.1.1")); ok {
+			panic("unexpected statistics of another /24")
+		}
+		for _, c := range conns {
+			must(c.Close())
+		}
+	}()
+	return
+}
+
+func (s *testPeerServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.opened++
+	return
+}
+
+func (s *testPeerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return frame, None
+}
+
+func (s *testPeerServer) OnClosed(c Conn, err error) (action Action) {
+	if s.closed++; s.closed == 2 {
+		_, s.leftover = s.svr.PeerStats(net.ParseIP("127.0.0.1"))
+		return Shutdown
+	}
+	return
+}
+
+func TestMux(t *testing.T) {
+	events := &testMuxServer{Mux: NewMux(), addr: "127.0.0.1:9948", routed: make(map[string]int)}
+	events.Handle(MatchALPN("h2"), &testMuxRoute{name: "h2", routed: events.routed}, nil)
+	events.Handle(MatchTLS("a.example"), &testMuxRoute{name: "tls", routed: events.routed}, nil)
+	events.Handle(MatchHTTP1(), &testMuxRoute{name: "http", routed: events.routed}, nil)
+	events.Handle(MatchPrefix([]byte("MAGIC")), &testMuxRoute{name: "magic", routed: events.routed},
+		new(LineBasedFrameCodec))
+	must(Serve(events, "tcp://"+events.addr, WithTicker(true)))
+	expected := map[string]int{"h2": 1, "tls": 1, "http": 1, "magic": 1}
+	if !reflect.DeepEqual(events.routed, expected) {
+		t.Fatalf("expected the connections routed as %v, got %v", expected, events.routed)
+	}
+}
+
+type testMuxServer struct {
+	*Mux
+	addr   string
+	routed map[string]int
+	done   int32
+}
+
+func (s *testMuxServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&s.done, 1)
+		dial := func() net.Conn {
+			c, err := net.Dial("tcp", s.addr)
+			must(err)
+			must(c.SetDeadline(time.Now().Add(time.Second * 3)))
+			return c
+		}
+		expectEOF := func(c net.Conn) {
+			defer c.Close()
+			if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+				panic(fmt.Sprintf("expected the connection to be closed, got %v", err))
+			}
+		}
+
+		// The routes of TLS close the connections right after they are routed.
+		for _, config := range []*tls.Config{
+			{ServerName: "b.example", NextProtos: []string{"h2"}},
+			{ServerName: "a.example"},
+		} {
+			c := tls.Client(dial(), config)
+			if err := c.Handshake(); err == nil {
+				panic("unexpected handshake with the route of TLS")
+			}
+			_ = c.Close()
+		}
+		// Neither the server name nor the protocol matches.
+		c := dial()
+		go func() { _ = tls.Client(c, &tls.Config{ServerName: "c.example"}).Handshake() }()
+		expectEOF(c)
+
+		// The request of HTTP is sniffed across reads.
+		c = dial()
+		_, err := c.Write([]byte("GE"))
+		must(err)
+		time.Sleep(time.Millisecond * 50)
+		_, err = c.Write([]byte("T / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		must(err)
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		must(err)
+		if resp.StatusCode != http.StatusOK {
+			panic(fmt.Sprintf("unexpected status of HTTP: %d", resp.StatusCode))
+		}
+		must(c.Close())
+
+		c = dial()
+		_, err = c.Write([]byte("MAGIC hello\n"))
+		must(err)
+		if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "MAGIC hello\n" {
+			panic(fmt.Sprintf("unexpected echo of the custom protocol: %q, %v", line, err))
+		}
+		must(c.Close())
+
+		c = dial()
+		_, err = c.Write([]byte("xyz\n"))
+		must(err)
+		expectEOF(c)
+	}()
+	return s.Mux.OnInitComplete(svr)
+}
+
+func (s *testMuxServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = Shutdown
+	}
+	delay = time.Millisecond * 100
+	return
+}
+
+type testMuxRoute struct {
+	*EventServer
+	name   string
+	routed map[string]int
+}
+
+func (r *testMuxRoute) OnOpened(c Conn) (out []byte, action Action) {
+	r.routed[r.name]++
+	if r.name == "h2" || r.name == "tls" {
+		action = Close
+	}
+	return
+}
+
+func (r *testMuxRoute) React(frame []byte, c Conn) (out []byte, action Action) {
+	if r.name == "http" {
+		return []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), None
+	}
+	return frame, None
+}
+
+func TestByteCounters(t *testing.T) {
+	events := &testByteCountersServer{network: "tcp", addr: ":9942"}
+	start := time.Now()
+	must(Serve(events, "tcp://:9942"))
+	if events.read != 10 || events.written != 10 || events.svrRead != 10 || events.svrWritten != 10 {
+		t.Fatalf("expected 10 bytes read and written, got conn: %d/%d, server: %d/%d",
+			events.read, events.written, events.svrRead, events.svrWritten)
+	}
+	if events.openedAt.Before(start) || events.openedAt.After(time.Now()) {
+		t.Fatalf("unexpected time of opening the connection: %v", events.openedAt)
+	}
+}
+
+type testByteCountersServer struct {
+	*EventServer
+	network, addr       string
+	svr                 Server
+	read, written       uint64
+	svrRead, svrWritten uint64
+	openedAt            time.Time
+}
+
+func (s *testByteCountersServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 5)
+		for _, msg := range []string{"hello", "world"} {
+			_, err = c.Write([]byte(msg))
+			must(err)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+		}
+		must(c.Close())
+	}()
+	return
+}
+
+func (s *testByteCountersServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testByteCountersServer) OnClosed(c Conn, err error) (action Action) {
+	s.read, s.written, s.openedAt = c.BytesRead(), c.BytesWritten(), c.OpenedAt()
+	s.svrRead, s.svrWritten = s.svr.BytesRead(), s.svr.BytesWritten()
+	return Shutdown
+}
+
+func TestConnServer(t *testing.T) {
+	events := &testConnServerServer{network: "tcp", addr: ":9939"}
+	must(Serve(events, "tcp://:9939", WithNumEventLoop(2)))
+	if len(events.loops) != 2 || events.loops[0] == events.loops[1] {
+		t.Fatalf("expected the connections on both event-loops, got the indexes %v", events.loops)
+	}
+}
+
+type testConnServerServer struct {
+	*EventServer
+	network, addr string
+	mu            sync.Mutex
+	loops         []int
+	closed        int32
+}
+
+func (s *testConnServerServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = c.Write([]byte("ping"))
+			must(err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+			conns = append(conns, c)
+		}
+		for _, c := range conns {
+			must(c.Close())
+		}
+	}()
+	return
+}
+
+func (s *testConnServerServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	svr := c.Server()
+	if svr.NumEventLoop != 2 || !strings.HasSuffix(svr.Addr.String(), ":9939") {
+		panic("unexpected server of the connection")
+	}
+	idx := c.LoopIndex()
+	if stats := svr.LoopStats(); idx < 0 || idx >= len(stats) || stats[idx].Connections != 1 {
+		panic("unexpected index of the event-loop")
+	}
+	s.mu.Lock()
+	s.loops = append(s.loops, idx)
+	s.mu.Unlock()
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testConnServerServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&s.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func TestAsyncWriteAfter(t *testing.T) {
+	events := &testAsyncWriteAfterServer{network: "tcp", addr: ":9941", delay: make(chan time.Duration, 1)}
+	must(Serve(events, "tcp://:9941"))
+	if delay := <-events.delay; delay < time.Millisecond*90 {
+		t.Fatalf("expected the delayed write after 100ms, got it after %v", delay)
+	}
+}
+
+type testAsyncWriteAfterServer struct {
+	*EventServer
+	network, addr string
+	delay         chan time.Duration
+}
+
+func (s *testAsyncWriteAfterServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if string(buf) != "pong" {
+			panic("expected the immediate write first, got " + string(buf))
+		}
+		start := time.Now()
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if string(buf) != "late" {
+			panic("expected the delayed write, got " + string(buf))
+		}
+		s.delay <- time.Since(start)
+		_, err = c.Write([]byte("stop"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testAsyncWriteAfterServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "stop" {
+		return nil, Shutdown
+	}
+	must(c.AsyncWriteAfter(time.Millisecond*100, []byte("late")))
+	return []byte("pong"), None
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	t.Run("length-field", func(t *testing.T) {
+		codec := NewLengthFieldBasedFrameCodec(
+			EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+			DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4, InitialBytesToStrip: 4})
+		// The frame declaring a gigabyte is refused by its header.
+		events := &testMaxFrameSizeServer{
+			network: "tcp", addr: ":9936",
+			frame: []byte("\x00\x00\x00\x05hello"), echo: []byte("\x00\x00\x00\x05hello"),
+			large: []byte("\x40\x00\x00\x00"),
+		}
+		must(Serve(events, "tcp://:9936", WithCodec(codec), WithMaxFrameSize(8)))
+		events.check(t)
+	})
+	t.Run("line", func(t *testing.T) {
+		// The partial line growing beyond the limit is refused before the delimiter arrives.
+		events := &testMaxFrameSizeServer{
+			network: "tcp", addr: ":9935",
+			frame: []byte("hello\n"), echo: []byte("hello\n"), large: []byte("0123456789"),
+		}
+		must(Serve(events, "tcp://:9935", WithCodec(new(LineBasedFrameCodec)), WithMaxFrameSize(8)))
+		events.check(t)
+	})
+}
+
+type testMaxFrameSizeServer struct {
+	*EventServer
+	network, addr      string
+	frame, echo, large []byte
+	reacted            int32
+	reason             error
+}
+
+func (s *testMaxFrameSizeServer) check(t *testing.T) {
+	if s.reason != ErrFrameTooLarge {
+		t.Fatalf("expected the connection closed with ErrFrameTooLarge, got %v", s.reason)
+	}
+	if reacted := atomic.LoadInt32(&s.reacted); reacted != 1 {
+		t.Fatalf("expected React for the frame within the limit only, got %d calls", reacted)
+	}
+}
+
+func (s *testMaxFrameSizeServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write(s.frame)
+		must(err)
+		buf := make([]byte, len(s.echo))
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if !bytes.Equal(buf, s.echo) {
+			panic("unexpected echo: " + string(buf))
+		}
+		_, err = c.Write(s.large)
+		must(err)
+		// The server closes the connection without waiting for the rest of the large frame.
+		if _, err = c.Read(buf); err == nil {
+			panic("expected the connection closed by server")
+		}
+	}()
+	return
+}
+
+func (s *testMaxFrameSizeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&s.reacted, 1)
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testMaxFrameSizeServer) OnClosed(c Conn, err error) (action Action) {
+	s.reason = err
+	return Shutdown
+}
+
+func TestOptionGroup(t *testing.T) {
+	opts := loadOptions(PresetLowLatency(), WithMaxFramesPerPoll(8))
+	if !opts.BusyPoll || opts.WriteCoalesceWindow != 0 || opts.MaxFramesPerPoll != 8 {
+		t.Fatalf("expected the preset overridden by the options after it, got %+v", opts)
+	}
+	opts = loadOptions(WithBusyPoll(true), PresetHighThroughput())
+	if opts.BusyPoll || opts.WriteCoalesceWindow == 0 || !opts.BatchPollerUpdates {
+		t.Fatalf("expected the preset overriding the options before it, got %+v", opts)
+	}
+	opts = loadOptions(PresetManyIdleConns())
+	if !opts.LazyInboundBuffer || opts.TCPKeepAlive == 0 {
+		t.Fatalf("expected the options of the preset, got %+v", opts)
+	}
+
+	mine := OptionGroup(PresetLowLatency(), WithMulticore(true), WithNumEventLoop(4))
+	opts = loadOptions(mine, WithNumEventLoop(2))
+	if !opts.Multicore || !opts.BusyPoll || opts.NumEventLoop != 2 {
+		t.Fatalf("expected the options of the group in order, got %+v", opts)
+	}
+}
+
+func TestShutdownReport(t *testing.T) {
+	events := &testShutdownReportServer{network: "tcp", addr: ":9933", eof: make(chan struct{})}
+	svr, err := Start(events, "tcp://:9933")
+	must(err)
+	r := svr.Wait()
+	if r.Reason != ErrServerShutdown {
+		t.Fatalf("expected the shutdown by handler, got %v", r.Reason)
+	}
+	if r.Connections != 2 || r.BytesRead != uint64(len("hello")+len("quit")) || r.BytesWritten != uint64(len("hello")) {
+		t.Fatalf("expected 2 connections, 9 bytes read and 5 bytes written, got %d, %d and %d",
+			r.Connections, r.BytesRead, r.BytesWritten)
+	}
+	if len(r.Closed) != 2 || r.Closed[ErrEOF] != 1 || r.Closed[ErrServerShutdown] != 1 || r.OtherErrors != 0 {
+		t.Fatalf("expected a connection closed by peer and another one by shutdown, got %v and %d others",
+			r.Closed, r.OtherErrors)
+	}
+	if r.Uptime <= 0 {
+		t.Fatalf("expected the uptime of server, got %v", r.Uptime)
+	}
+}
+
+type testShutdownReportServer struct {
+	*EventServer
+	network, addr string
+	eof           chan struct{}
+}
+
+func (s *testShutdownReportServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(c, make([]byte, 5))
+		must(err)
+		must(c.Close())
+		<-s.eof
+
+		c, err = net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("quit"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testShutdownReportServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "quit" {
+		return nil, Shutdown
+	}
+	return append([]byte(nil), frame...), None
+}
+
+func (s *testShutdownReportServer) OnClosed(c Conn, err error) (action Action) {
+	if err == ErrEOF {
+		close(s.eof)
+	}
+	return
+}
+
+func TestTickAfterPanic(t *testing.T) {
+	events := &testTickAfterPanicServer{}
+	must(Serve(events, "tcp://:9932", WithTicker(true)))
+	if events.ticks != 3 {
+		t.Fatalf("expected ticking to go on after the panic in Tick, got %d ticks", events.ticks)
+	}
+}
+
+type testTickAfterPanicServer struct {
+	*EventServer
+	ticks int
+}
+
+func (s *testTickAfterPanicServer) Tick() (delay time.Duration, action Action) {
+	if s.ticks++; s.ticks == 1 {
+		panic("tick")
+	}
+	if s.ticks == 3 {
+		action = Shutdown
+	}
+	return time.Millisecond * 10, action
+}
+
+func TestByteBufferPool(t *testing.T) {
+	pool := &countingBufferPool{live: make(map[*bytebuffer.ByteBuffer]bool), seen: make(map[*bytebuffer.ByteBuffer]bool)}
+	events := &testByteBufferPoolServer{network: "tcp", addr: ":9929", pool: pool}
+	must(Serve(events, "tcp://:9929", WithCodec(NewFixedLengthFrameCodec(8)), WithByteBufferPool(pool)))
+	if events.frames != 4 || atomic.LoadInt32(&events.foreign) != 0 {
+		t.Fatalf("expected 4 frames in the buffers of pool, got %d frames and %d foreign buffers",
+			events.frames, events.foreign)
+	}
+	if pool.gets < 8 || pool.gets != pool.puts {
+		t.Fatalf("expected the buffers of frames and AsyncWrite to be put back, got %d gets and %d puts",
+			pool.gets, pool.puts)
+	}
+}
+
+// countingBufferPool is a ByteBufferPool which keeps track of the buffers handed out.
+type countingBufferPool struct {
+	mu         sync.Mutex
+	gets, puts int
+	live       map[*bytebuffer.ByteBuffer]bool // buffers handed out and not put back yet
+	seen       map[*bytebuffer.ByteBuffer]bool // buffers ever handed out
+}
+
+func (p *countingBufferPool) Get() *bytebuffer.ByteBuffer {
+	b := bytebuffer.Get()
+	p.mu.Lock()
+	p.gets++
+	p.live[b], p.seen[b] = true, true
+	p.mu.Unlock()
+	return b
+}
+
+func (p *countingBufferPool) Put(b *bytebuffer.ByteBuffer) {
+	p.mu.Lock()
+	if !p.live[b] {
+		p.mu.Unlock()
+		panic("put back a buffer which doesn't come from the pool")
+	}
+	delete(p.live, b)
+	p.puts++
+	p.mu.Unlock()
+	bytebuffer.Put(b)
+}
+
+// owns reports whether the given data starts at a buffer handed out by the pool, the buffer of frame may have
+// been put back by the codec already.
+func (p *countingBufferPool) owns(data []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for b := range p.seen {
+		if len(data) > 0 && cap(b.B) > 0 && &b.B[:1][0] == &data[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *countingBufferPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gets
+}
+
+type testByteBufferPoolServer struct {
+	*EventServer
+	network, addr string
+	pool          *countingBufferPool
+	frames        int
+	foreign       int32
+}
+
+func (s *testByteBufferPoolServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		for i := 0; i < 4; i++ {
+			// Split the frame, so that it's assembled in a buffer of the pool.
+			_, err = c.Write([]byte("abcd"))
+			must(err)
+			time.Sleep(time.Millisecond * 20)
+			_, err = c.Write([]byte("efgh"))
+			must(err)
+			buf := make([]byte, 8)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+			if string(buf) != "abcdefgh" {
+				panic(fmt.Sprintf("unexpected echo: %q", buf))
+			}
+		}
+	}()
+	return
+}
+
+func (s *testByteBufferPoolServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.frames++
+	if !s.pool.owns(frame) {
+		atomic.AddInt32(&s.foreign, 1)
+	}
+	data := append([]byte(nil), frame...)
+	go func() {
+		gets := s.pool.count()
+		must(c.AsyncWrite(data))
+		if s.pool.count() == gets {
+			atomic.AddInt32(&s.foreign, 1)
+		}
+	}()
+	return
+}
+
+func (s *testByteBufferPoolServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestAsyncWriteRejected(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("LoopTaskQueueCap is not supported on Windows")
+	}
+	pool := &countingBufferPool{live: make(map[*bytebuffer.ByteBuffer]bool), seen: make(map[*bytebuffer.ByteBuffer]bool)}
+	events := &testAsyncWriteRejectedServer{network: "tcp", addr: ":9926"}
+	must(Serve(events, "tcp://:9926", WithByteBufferPool(pool), WithLoopTaskQueueCap(1, OverloadReject)))
+	for i, err := range events.errs {
+		if expected := ErrTaskQueueFull; i > 0 && err != expected || i == 0 && err != nil {
+			t.Fatalf("write %d: expected the writes after the first one to be rejected, got %v", i, events.errs)
+		}
+	}
+	if pool.gets != pool.puts {
+		t.Fatalf("expected the buffers of the rejected writes to be put back, got %d gets and %d puts",
+			pool.gets, pool.puts)
+	}
+}
+
+type testAsyncWriteRejectedServer struct {
+	*EventServer
+	network, addr string
+	errs          []error
+}
+
+func (s *testAsyncWriteRejectedServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = io.ReadFull(c, make([]byte, 1))
+		must(err)
+	}()
+	return
+}
+
+func (s *testAsyncWriteRejectedServer) OnOpened(c Conn) (out []byte, action Action) {
+	// The writes are submitted on the event-loop, so the first one stays in the queue.
+	s.errs = append(s.errs, c.AsyncWrite([]byte("a")), c.AsyncWrite([]byte("b")),
+		c.AsyncWriteWithTimeout([]byte("c"), time.Second), c.AsyncWriteAfter(time.Millisecond, []byte("d")),
+		c.Wake())
+	return
+}
+
+func (s *testAsyncWriteRejectedServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestOpenedOutput(t *testing.T) {
+	events := &testOpenedOutputServer{network: "tcp", addr: ":9924"}
+	must(Serve(events, "tcp://:9924"))
+	if !events.delivered {
+		t.Fatal("the output of OnOpened is not fully delivered")
+	}
+}
+
+type testOpenedOutputServer struct {
+	*EventServer
+	network, addr string
+	delivered     bool
+}
+
+// openedOutput is far larger than the socket buffers, so that the rest of it is flushed on the writable events.
+var openedOutput = bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+
+func (s *testOpenedOutputServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		// The client sends nothing, so the output can't ride on the readable events.
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 5)))
+		buf := make([]byte, len(openedOutput))
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if bytes.Equal(buf, openedOutput) {
+			_, err = c.Write([]byte("ok"))
+			must(err)
+		}
+	}()
+	return
+}
+
+func (s *testOpenedOutputServer) OnOpened(c Conn) (out []byte, action Action) {
+	return openedOutput, None
+}
+
+func (s *testOpenedOutputServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	s.delivered = string(frame) == "ok"
+	return nil, Shutdown
+}
+
+func (s *testOpenedOutputServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
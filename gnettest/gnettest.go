@@ -0,0 +1,565 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package gnettest drives a gnet.EventHandler in memory without sockets, which makes it possible to unit-test
+// the protocol logic of handlers deterministically: the tests inject the inbound data of connections in the
+// segments of their choice, assert the output and actions of the handler, and simulate Wake, Tick and timers.
+package gnettest
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// Server drives a gnet.EventHandler like an event-loop of gnet with the connections opened by Dial, all events
+// are handled synchronously by the calling goroutine, so the Server must be used by one goroutine at a time.
+// The asynchronous APIs of connections (AsyncWrite, Wake, Execute, Close, etc.) queue their tasks like gnet,
+// which are run at the end of every call of Server and Conn, or by Poll.
+//
+// The time of Server is virtual, it starts at the time of NewServer and only moves forward by Advance, which
+// fires the timers of Conn.AfterFunc and is returned by Conn.LoopTime.
+type Server struct {
+	handler gnet.EventHandler
+	traffic gnet.TrafficHandler
+	batch   gnet.BatchHandler
+	first   gnet.FirstDataHandler
+	codec   gnet.ICodec
+	loopCtx interface{}
+	now     time.Time
+	port    int
+	timers  []*timer
+	seq     uint64
+	stopped bool
+
+	mu    sync.Mutex
+	tasks []func()
+}
+
+type timer struct {
+	at      time.Time
+	seq     uint64
+	fn      func()
+	stopped bool
+}
+
+// NewServer returns a Server driving the given handler with the codec, gnet.BuiltInFrameCodec is used if it is
+// nil as gnet.Serve does. OnInitComplete of the handler is not called.
+func NewServer(handler gnet.EventHandler, codec gnet.ICodec) *Server {
+	if codec == nil {
+		codec = new(gnet.BuiltInFrameCodec)
+	}
+	s := &Server{handler: handler, codec: codec, now: time.Now(), port: 10000}
+	// The optional interfaces are served by the handler wrapped by gnet.Chain like gnet.Serve does.
+	base := handler
+	for {
+		u, ok := base.(interface{ Unwrap() gnet.EventHandler })
+		if !ok {
+			break
+		}
+		base = u.Unwrap()
+	}
+	s.traffic, _ = base.(gnet.TrafficHandler)
+	s.batch, _ = base.(gnet.BatchHandler)
+	s.first, _ = base.(gnet.FirstDataHandler)
+	return s
+}
+
+// Dial opens a new connection to the Server, OnOpened is called with it and its output is written as is.
+func (s *Server) Dial() *Conn {
+	s.port++
+	c := &Conn{
+		svr:        s,
+		opened:     true,
+		codec:      s.codec,
+		openedAt:   s.now,
+		localAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000},
+		remoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.port},
+	}
+	out, action := s.handler.OnOpened(c)
+	if out != nil {
+		s.handler.PreWrite()
+		c.emit(out)
+	}
+	c.handle(action)
+	s.Poll()
+	return c
+}
+
+// Tick calls Tick of the handler and returns its result, the Server is marked as shut down if it asks for it.
+func (s *Server) Tick() (delay time.Duration, action gnet.Action) {
+	delay, action = s.handler.Tick()
+	if action == gnet.Shutdown {
+		s.stopped = true
+	}
+	s.Poll()
+	return
+}
+
+// Advance moves the virtual time of Server forward by d, the timers of Conn.AfterFunc which expire by then
+// are fired in the order of their expiry.
+func (s *Server) Advance(d time.Duration) {
+	deadline := s.now.Add(d)
+	for {
+		sort.Slice(s.timers, func(i, j int) bool {
+			ti, tj := s.timers[i], s.timers[j]
+			return ti.at.Before(tj.at) || ti.at.Equal(tj.at) && ti.seq < tj.seq
+		})
+		if len(s.timers) == 0 || s.timers[0].at.After(deadline) {
+			break
+		}
+		t := s.timers[0]
+		s.timers = s.timers[1:]
+		if t.at.After(s.now) {
+			s.now = t.at
+		}
+		t.fn()
+		s.Poll()
+	}
+	s.now = deadline
+}
+
+// Poll runs the tasks queued by the asynchronous APIs of connections until there are none, which is the way
+// of waiting for the tasks submitted by other goroutines, e.g. the goroutine pools of handlers.
+func (s *Server) Poll() {
+	for {
+		s.mu.Lock()
+		tasks := s.tasks
+		s.tasks = nil
+		s.mu.Unlock()
+		if len(tasks) == 0 {
+			return
+		}
+		for _, task := range tasks {
+			task()
+		}
+	}
+}
+
+// Stopped reports whether the handler has asked for shutting down the Server.
+func (s *Server) Stopped() bool {
+	return s.stopped
+}
+
+func (s *Server) submit(task func()) error {
+	s.mu.Lock()
+	s.tasks = append(s.tasks, task)
+	s.mu.Unlock()
+	return nil
+}
+
+// Conn is the in-memory connection of Server which implements gnet.Conn, it also plays the peer of the
+// connection: Send injects the inbound data, Output collects the data written by the handler and Hangup
+// closes it from the peer.
+type Conn struct {
+	svr        *Server
+	opened     bool
+	reason     error
+	ctx        interface{}
+	state      gnet.StateHandler
+	codec      gnet.ICodec
+	previewed  bool
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	inbound    []byte
+	output     []byte
+	read       uint64
+	written    uint64
+	openedAt   time.Time
+	frameSeq   uint64
+	writeSeq   uint64
+	pending    map[uint64][]byte
+}
+
+// Send injects the given segments of inbound data into the connection one after another, each one is handled
+// like the data of a read from socket, which is the way of simulating the data that is split or merged by the
+// network. It returns the last action other than None returned by the handler.
+func (c *Conn) Send(segments ...[]byte) (action gnet.Action) {
+	for _, seg := range segments {
+		if !c.opened {
+			break
+		}
+		c.inbound = append(c.inbound, seg...)
+		c.read += uint64(len(seg))
+		if a := c.react(); a != gnet.None {
+			action = a
+		}
+		c.svr.Poll()
+	}
+	return
+}
+
+// Output returns the data written to the connection since the last call of Output.
+func (c *Conn) Output() []byte {
+	out := c.output
+	c.output = nil
+	return out
+}
+
+// Hangup closes the connection from the peer, OnClosed is called with gnet.ErrEOF.
+func (c *Conn) Hangup() {
+	c.close(gnet.ErrEOF)
+	c.svr.Poll()
+}
+
+// Closed reports whether the connection has been closed and the reason passed to OnClosed.
+func (c *Conn) Closed() (closed bool, reason error) {
+	return !c.opened, c.reason
+}
+
+// react passes the inbound data to OnTraffic, or decodes frames from it and passes them to ReactBatch or React.
+// The inbound data of the first segment is previewed by OnFirstData before that, like gnet.Options.FirstDataBytes
+// of 0.
+func (c *Conn) react() gnet.Action {
+	if fh := c.svr.first; fh != nil && !c.previewed && len(c.inbound) > 0 {
+		c.previewed = true
+		action := fh.OnFirstData(c, c.inbound)
+		if c.handle(action); action != gnet.None || !c.opened {
+			return action
+		}
+	}
+	if th := c.svr.traffic; th != nil {
+		action := th.OnTraffic(c)
+		c.handle(action)
+		return action
+	}
+	if bh := c.svr.batch; bh != nil && c.state == nil {
+		var frames [][]byte
+		frame, err := c.codec.Decode(c)
+		for ; frame != nil; frame, err = c.codec.Decode(c) {
+			c.frameSeq++
+			frames = append(frames, c.RetainFrame(frame))
+		}
+		action := gnet.None
+		if len(frames) > 0 {
+			var outs [][]byte
+			outs, action = bh.ReactBatch(frames, c)
+			for _, out := range outs {
+				c.writeFrame(out)
+			}
+			c.handle(action)
+		}
+		c.refuseFrame(err)
+		return action
+	}
+	frame, err := c.codec.Decode(c)
+	for ; frame != nil; frame, err = c.codec.Decode(c) {
+		c.frameSeq++
+		out, action := c.reactFrame(frame)
+		c.writeFrame(out)
+		if c.handle(action); action != gnet.None || !c.opened {
+			return action
+		}
+	}
+	c.refuseFrame(err)
+	return gnet.None
+}
+
+// refuseFrame closes the connection with gnet.ErrFrameTooLarge if the codec fails with it, the server of
+// gnettest has no gnet.Options.MaxFrameSize, so it is up to the codec.
+func (c *Conn) refuseFrame(err error) {
+	if err == gnet.ErrFrameTooLarge {
+		c.close(err)
+	}
+}
+
+func (c *Conn) reactFrame(frame []byte) ([]byte, gnet.Action) {
+	if c.state != nil {
+		return c.state(frame, c)
+	}
+	return c.svr.handler.React(frame, c)
+}
+
+func (c *Conn) writeFrame(out []byte) {
+	if out == nil {
+		return
+	}
+	frame, _ := c.codec.Encode(c, out)
+	c.svr.handler.PreWrite()
+	c.emit(frame)
+}
+
+func (c *Conn) handle(action gnet.Action) {
+	switch action {
+	case gnet.Close:
+		c.close(gnet.ErrClosedByHandler)
+	case gnet.Shutdown:
+		c.svr.stopped = true
+	}
+}
+
+func (c *Conn) close(reason error) {
+	if !c.opened {
+		return
+	}
+	c.opened = false
+	c.reason = reason
+	if c.svr.handler.OnClosed(c, reason) == gnet.Shutdown {
+		c.svr.stopped = true
+	}
+}
+
+// ================================= Public APIs of gnet.Conn =================================
+
+func (c *Conn) Read() []byte {
+	return c.inbound
+}
+
+func (c *Conn) ResetBuffer() {
+	c.inbound = nil
+}
+
+func (c *Conn) ReadN(n int) (size int, buf []byte) {
+	if n <= 0 || n > len(c.inbound) {
+		n = len(c.inbound)
+	}
+	return n, c.inbound[:n]
+}
+
+func (c *Conn) ShiftN(n int) (size int) {
+	if n <= 0 || n > len(c.inbound) {
+		size = len(c.inbound)
+		c.ResetBuffer()
+		return
+	}
+	c.inbound = c.inbound[n:]
+	return n
+}
+
+func (c *Conn) Peek(n int) (buf []byte, err error) {
+	var size int
+	if size, buf = c.ReadN(n); size < n {
+		err = io.ErrShortBuffer
+	}
+	return
+}
+
+func (c *Conn) Next(n int) (buf []byte, err error) {
+	if len(c.inbound) < n {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = len(c.inbound)
+	}
+	buf, c.inbound = c.inbound[:n], c.inbound[n:]
+	return
+}
+
+func (c *Conn) BufferLength() int {
+	return len(c.inbound)
+}
+
+func (c *Conn) OutboundBuffered() int {
+	return 0
+}
+
+func (c *Conn) BytesRead() uint64    { return c.read }
+func (c *Conn) BytesWritten() uint64 { return c.written }
+func (c *Conn) OpenedAt() time.Time  { return c.openedAt }
+
+// emit writes the data to the output of the connection.
+func (c *Conn) emit(data []byte) {
+	c.output = append(c.output, data...)
+	c.written += uint64(len(data))
+}
+
+func (c *Conn) SendTo(buf []byte) error {
+	c.emit(buf)
+	return nil
+}
+
+func (c *Conn) SendFD(fd int) error {
+	return gnet.ErrUnsupportedProtocol
+}
+
+// Server returns a gnet.Server with the fields describing the Server, i.e. a single event-loop listening on the
+// local address of connections, its methods must not be called since there is no gnet server behind it.
+func (c *Conn) Server() gnet.Server {
+	return gnet.Server{Addr: c.localAddr, NumEventLoop: 1}
+}
+
+// MigrateTo does nothing for the only event-loop of Server, whose index is 0.
+func (c *Conn) MigrateTo(loopIndex int) error {
+	if loopIndex != 0 {
+		return gnet.ErrInvalidLoopIndex
+	}
+	return nil
+}
+
+func (c *Conn) SendToWithControl(buf []byte, cm *gnet.ControlMessage) error {
+	return gnet.ErrUnsupportedProtocol
+}
+
+func (c *Conn) ControlMessage() *gnet.ControlMessage {
+	return nil
+}
+
+func (c *Conn) AsyncWrite(buf []byte) error {
+	frame, err := c.codec.Encode(c, buf)
+	if err != nil {
+		return err
+	}
+	frame = append([]byte(nil), frame...)
+	return c.svr.submit(func() {
+		if c.opened {
+			c.emit(frame)
+		}
+	})
+}
+
+func (c *Conn) AsyncWriteWithTimeout(buf []byte, d time.Duration) error {
+	return c.AsyncWrite(buf)
+}
+
+// AsyncWriteAfter writes the data once the virtual time of Server is advanced by d.
+func (c *Conn) AsyncWriteAfter(d time.Duration, buf []byte) error {
+	frame, err := c.codec.Encode(c, buf)
+	if err != nil {
+		return err
+	}
+	frame = append([]byte(nil), frame...)
+	return c.svr.submit(func() {
+		c.AfterFunc(d, func(gnet.Conn) {
+			c.emit(frame)
+		})
+	})
+}
+
+func (c *Conn) FrameSeq() uint64 {
+	return c.frameSeq
+}
+
+func (c *Conn) AsyncWriteSeq(seq uint64, buf []byte) error {
+	var frame []byte
+	if buf != nil {
+		encoded, err := c.codec.Encode(c, buf)
+		if err != nil {
+			return err
+		}
+		frame = append([]byte(nil), encoded...)
+	}
+	return c.svr.submit(func() {
+		if !c.opened {
+			return
+		}
+		if c.pending == nil {
+			c.pending = make(map[uint64][]byte)
+		}
+		c.pending[seq] = frame
+		for {
+			frame, ok := c.pending[c.writeSeq+1]
+			if !ok {
+				return
+			}
+			delete(c.pending, c.writeSeq+1)
+			c.writeSeq++
+			c.emit(frame)
+		}
+	})
+}
+
+func (c *Conn) Wake() error {
+	return c.svr.submit(func() {
+		if c.opened {
+			out, action := c.reactFrame(nil)
+			c.writeFrame(out)
+			c.handle(action)
+		}
+	})
+}
+
+func (c *Conn) Execute(fn func(c gnet.Conn)) error {
+	return c.svr.submit(func() {
+		if c.opened {
+			fn(c)
+		}
+	})
+}
+
+func (c *Conn) TCPInfo() (*gnet.TCPInfo, error) {
+	return nil, gnet.ErrUnsupportedProtocol
+}
+
+func (c *Conn) SetUserTimeout(d time.Duration) error {
+	return gnet.ErrUnsupportedProtocol
+}
+
+func (c *Conn) SetTOS(tos int) error {
+	return gnet.ErrUnsupportedProtocol
+}
+
+func (c *Conn) RetainFrame(frame []byte) []byte {
+	if frame == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(frame)), frame...)
+}
+
+func (c *Conn) AfterFunc(d time.Duration, fn func(c gnet.Conn)) (stop func() bool) {
+	if !c.opened {
+		return func() bool { return false }
+	}
+	s := c.svr
+	s.seq++
+	t := &timer{at: s.now.Add(d), seq: s.seq}
+	t.fn = func() {
+		if c.opened && !t.stopped {
+			fn(c)
+		}
+	}
+	s.timers = append(s.timers, t)
+	return func() bool {
+		for i, pending := range s.timers {
+			if pending == t {
+				s.timers = append(s.timers[:i], s.timers[i+1:]...)
+				t.stopped = true
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (c *Conn) Handle() gnet.ConnHandle {
+	return gnet.HandleOf(c)
+}
+
+// Alive reports whether the connection is still open for the handles of it.
+func (c *Conn) Alive() bool {
+	return c.opened
+}
+
+func (c *Conn) Close() error {
+	return c.svr.submit(func() {
+		c.close(gnet.ErrClosedByHandler)
+	})
+}
+
+func (c *Conn) SetCodec(codec gnet.ICodec) {
+	if codec == nil {
+		codec = c.svr.codec
+	}
+	c.codec = codec
+}
+
+func (c *Conn) Fd() int                             { return -1 }
+func (c *Conn) Flush() error                        { return nil }
+func (c *Conn) SetPriority(p gnet.Priority)         {}
+func (c *Conn) SetWritePacing(int, time.Duration)   {}
+func (c *Conn) SetLowLatency(bool)                  {}
+func (c *Conn) LoopTime() time.Time                 { return c.svr.now }
+func (c *Conn) LoopIndex() int                      { return 0 }
+func (c *Conn) Context() interface{}                { return c.ctx }
+func (c *Conn) SetState(h gnet.StateHandler)        { c.state = h }
+func (c *Conn) SetContext(ctx interface{})          { c.ctx = ctx }
+func (c *Conn) LocalAddr() net.Addr                 { return c.localAddr }
+func (c *Conn) RemoteAddr() net.Addr                { return c.remoteAddr }
+func (c *Conn) RecvAddr() net.Addr                  { return nil }
+func (c *Conn) EventLoopContext() interface{}       { return c.svr.loopCtx }
+func (c *Conn) SetEventLoopContext(ctx interface{}) { c.svr.loopCtx = ctx }
+
+var _ gnet.Conn = (*Conn)(nil)
@@ -0,0 +1,163 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnettest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+type testHandler struct {
+	*gnet.EventServer
+	closed error
+}
+
+func (h *testHandler) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	return []byte("hi\n"), gnet.None
+}
+
+func (h *testHandler) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	switch string(frame) {
+	case "quit":
+		return []byte("bye"), gnet.Close
+	case "later":
+		c.AfterFunc(time.Second, func(c gnet.Conn) {
+			_ = c.AsyncWrite([]byte("timer"))
+		})
+		return
+	case "":
+		return []byte("woken"), gnet.None
+	}
+	return c.RetainFrame(frame), gnet.None
+}
+
+func (h *testHandler) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	h.closed = err
+	return gnet.Shutdown
+}
+
+func TestServer(t *testing.T) {
+	h := new(testHandler)
+	s := NewServer(h, new(gnet.LineBasedFrameCodec))
+	c := s.Dial()
+	if out := string(c.Output()); out != "hi\n" {
+		t.Fatalf("expected the output of OnOpened, got %q", out)
+	}
+
+	// The frames split and merged by the network are decoded the same.
+	if action := c.Send([]byte("he"), []byte("llo\nwor"), []byte("ld\n")); action != gnet.None {
+		t.Fatalf("expected None, got %v", action)
+	}
+	if out := string(c.Output()); out != "hello\nworld\n" {
+		t.Fatalf("expected the echoed frames, got %q", out)
+	}
+
+	must(t, c.Wake())
+	s.Poll()
+	if out := string(c.Output()); out != "woken\n" {
+		t.Fatalf("expected the output of waking, got %q", out)
+	}
+
+	c.Send([]byte("later\n"))
+	s.Advance(time.Second / 2)
+	if out := string(c.Output()); out != "" {
+		t.Fatalf("expected no output before the timer fires, got %q", out)
+	}
+	s.Advance(time.Second / 2)
+	if out := string(c.Output()); out != "timer\n" {
+		t.Fatalf("expected the output of timer, got %q", out)
+	}
+
+	if action := c.Send([]byte("quit\nignored\n")); action != gnet.Close {
+		t.Fatalf("expected Close, got %v", action)
+	}
+	if out := string(c.Output()); out != "bye\n" {
+		t.Fatalf("expected the output before closing, got %q", out)
+	}
+	if closed, reason := c.Closed(); !closed || reason != gnet.ErrClosedByHandler || h.closed != reason {
+		t.Fatalf("expected the connection closed by handler, got %v %v", closed, reason)
+	}
+	if !s.Stopped() {
+		t.Fatal("expected the server to be stopped by OnClosed")
+	}
+}
+
+func TestAsyncWriteSeq(t *testing.T) {
+	s := NewServer(new(gnet.EventServer), nil)
+	c := s.Dial()
+	must(t, c.AsyncWriteSeq(2, []byte("b")))
+	must(t, c.AsyncWriteSeq(3, nil))
+	s.Poll()
+	if out := string(c.Output()); out != "" {
+		t.Fatalf("expected the responses to be held, got %q", out)
+	}
+	must(t, c.AsyncWriteSeq(1, []byte("a")))
+	s.Poll()
+	if out := string(c.Output()); out != "ab" {
+		t.Fatalf("expected the responses in order, got %q", out)
+	}
+	c.Hangup()
+	if closed, reason := c.Closed(); !closed || reason != gnet.ErrEOF {
+		t.Fatalf("expected the connection closed by peer, got %v %v", closed, reason)
+	}
+}
+
+type firstDataHandler struct {
+	*gnet.EventServer
+	previews []string
+}
+
+func (h *firstDataHandler) OnFirstData(c gnet.Conn, preview []byte) gnet.Action {
+	h.previews = append(h.previews, string(preview))
+	if string(preview[:1]) != "L" {
+		return gnet.Close
+	}
+	c.SetCodec(new(gnet.LineBasedFrameCodec))
+	return gnet.None
+}
+
+func (h *firstDataHandler) React(frame []byte, c gnet.Conn) ([]byte, gnet.Action) {
+	return frame, gnet.None
+}
+
+func TestFirstData(t *testing.T) {
+	h := new(firstDataHandler)
+	s := NewServer(h, nil)
+	c := s.Dial()
+	c.Send([]byte("LINE\nhel"), []byte("lo\n"))
+	if out := string(c.Output()); out != "LINE\nhello\n" {
+		t.Fatalf("expected the frames decoded by the codec picked by OnFirstData, got %q", out)
+	}
+	if action := s.Dial().Send([]byte("\x16\x03")); action != gnet.Close {
+		t.Fatalf("expected Close, got %v", action)
+	}
+	if len(h.previews) != 2 || h.previews[0] != "LINE\nhel" {
+		t.Fatalf("expected a preview of the first segment per connection, got %q", h.previews)
+	}
+}
+
+func TestAsyncWriteAfter(t *testing.T) {
+	s := NewServer(new(gnet.EventServer), nil)
+	c := s.Dial()
+	must(t, c.AsyncWriteAfter(time.Second, []byte("late")))
+	// The timer is set up by the task of AsyncWriteAfter like gnet does.
+	s.Poll()
+	s.Advance(time.Millisecond * 999)
+	if out := string(c.Output()); out != "" {
+		t.Fatalf("expected the write to be delayed, got %q", out)
+	}
+	s.Advance(time.Millisecond)
+	if out := string(c.Output()); out != "late" {
+		t.Fatalf("expected the delayed write, got %q", out)
+	}
+}
+
+func must(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// ConnHandle is a reference to a connection which is safe to be kept and used by any goroutine, e.g. in the maps
+// of sessions or subscribers, it is obtained by Conn.Handle. Unlike Conn, it stays valid after the connection
+// is closed, its methods return ErrConnClosed from then on instead of touching the closed connection, so a handle
+// never reaches a connection which takes over the file descriptor of the closed one. The zero value refers to
+// no connection, and handles are comparable, the handles of the same connection are equal.
+type ConnHandle struct {
+	c Conn
+}
+
+// HandleOf returns the handle of the given connection, which is what Conn.Handle returns, it is meant for the
+// implementations of Conn outside gnet (e.g. gnettest), whose handles tell the closing by their method of
+// "Alive() bool" if there is one, they are taken as alive otherwise.
+func HandleOf(c Conn) ConnHandle {
+	return ConnHandle{c}
+}
+
+// closeOpener is implemented by the connections of gnet, which close themselves on their event-loops unless they
+// have been closed by then.
+type closeOpener interface {
+	closeOpened() error
+}
+
+// Alive reports whether the connection is still open, it is only a hint outside the event-loop since the
+// connection may be closed right after.
+func (h ConnHandle) Alive() bool {
+	if h.c == nil {
+		return false
+	}
+	if c, ok := h.c.(interface{ Alive() bool }); ok {
+		return c.Alive()
+	}
+	return true
+}
+
+// AsyncWrite is the same as Conn.AsyncWrite, the data is dropped if the connection is closed before it is written.
+func (h ConnHandle) AsyncWrite(buf []byte) error {
+	if !h.Alive() {
+		return ErrConnClosed
+	}
+	return h.c.AsyncWrite(buf)
+}
+
+// Wake is the same as Conn.Wake, the React event is not triggered if the connection is closed by then.
+func (h ConnHandle) Wake() error {
+	if !h.Alive() {
+		return ErrConnClosed
+	}
+	return h.c.Wake()
+}
+
+// Execute is the same as Conn.Execute, the function is not run if the connection is closed by then.
+func (h ConnHandle) Execute(fn func(c Conn)) error {
+	if !h.Alive() {
+		return ErrConnClosed
+	}
+	return h.c.Execute(fn)
+}
+
+// Close closes the connection like Conn.Close unless it has been closed.
+func (h ConnHandle) Close() error {
+	if !h.Alive() {
+		return ErrConnClosed
+	}
+	if c, ok := h.c.(closeOpener); ok {
+		return c.closeOpened()
+	}
+	return h.c.Close()
+}
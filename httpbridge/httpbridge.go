@@ -0,0 +1,285 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package httpbridge serves the handlers written against net/http on top of gnet, which makes it possible
+// to migrate the routes of an existing HTTP service to gnet incrementally instead of rewriting them against React.
+package httpbridge
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// DefaultMaxBodyBytes is the upper bound of the body of a request if Handler.MaxBodyBytes is 0.
+const DefaultMaxBodyBytes = 10 << 20
+
+var (
+	errHeaderTooLarge = errors.New("httpbridge: request header too large")
+	errBodyTooLarge   = errors.New("httpbridge: request body too large")
+	errBadChunk       = errors.New("httpbridge: malformed chunked encoding")
+)
+
+var (
+	headerEnd    = []byte("\r\n\r\n")
+	crlf         = []byte("\r\n")
+	tooLarge     = []byte("HTTP/1.1 431 Request Header Fields Too Large\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+	bodyTooLarge = []byte("HTTP/1.1 413 Request Entity Too Large\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+	badRequest   = []byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+)
+
+// Handler is a gnet.EventHandler which parses HTTP/1.x requests from the inbound data of connections
+// and serves them with an http.Handler, pipelined requests are served in order.
+//
+// The http.Handler runs on the event-loop like React, so it must not block, hand the blocking work over
+// to a goroutine pool instead. Requests are served once their bodies arrive entirely and responses are buffered
+// entirely before they are written, streaming, hijacking and flushing are not supported. Handler keeps the state
+// of the request being received in the context of connection.
+type Handler struct {
+	*gnet.EventServer
+
+	// MaxHeaderBytes is the upper bound of the bytes buffered for the request line and headers of a request,
+	// the connection is closed with 431 if it is exceeded, http.DefaultMaxHeaderBytes is used if it is 0.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes is the upper bound of the body of a request, the connection is closed with 413 if it is
+	// exceeded, DefaultMaxBodyBytes is used if it is 0.
+	MaxBodyBytes int64
+
+	handler http.Handler
+}
+
+// pending is the framing of the request being received, which is parsed from its header once.
+type pending struct {
+	headerLen int   // bytes of the request line and headers
+	length    int   // bytes of the whole request, or of the chunks scanned so far if it's chunked
+	chunked   bool  // body is in the chunked transfer encoding
+	bodyLen   int64 // bytes of data in the chunks scanned so far
+}
+
+// New returns a Handler serving HTTP requests with the given http.Handler,
+// it must be passed to gnet.Serve with the default codec.
+func New(handler http.Handler) *Handler {
+	return &Handler{handler: handler}
+}
+
+// OnTraffic serves all complete requests in the inbound data of the connection.
+func (h *Handler) OnTraffic(c gnet.Conn) (action gnet.Action) {
+	var out []byte
+	for {
+		buf, _ := c.Peek(-1)
+		req, n, err := h.readRequest(c, buf)
+		if err == errHeaderTooLarge {
+			h.flush(c, append(out, tooLarge...), true)
+			return
+		}
+		if err == errBodyTooLarge {
+			h.flush(c, append(out, bodyTooLarge...), true)
+			return
+		}
+		if err != nil {
+			h.flush(c, append(out, badRequest...), true)
+			return
+		}
+		if req == nil {
+			break
+		}
+		req.RemoteAddr = c.RemoteAddr().String()
+		_, _ = c.Next(n)
+		out = h.serve(out, req)
+		if req.Close {
+			h.flush(c, out, true)
+			return
+		}
+	}
+	h.flush(c, out, false)
+	return
+}
+
+// flush writes the responses to the connection and closes it afterwards if needed.
+func (h *Handler) flush(c gnet.Conn, out []byte, close bool) {
+	if len(out) > 0 {
+		_ = c.AsyncWrite(out)
+	}
+	if close {
+		_ = c.Close()
+	}
+}
+
+// readRequest parses a complete request with its body from the given data and returns the number of bytes
+// it takes up, a nil request is returned if the data doesn't make up a request yet. The header is parsed once
+// for the framing and the data is parsed into the request once it is complete, so that the requests arriving
+// in many pieces are not parsed over and over again.
+func (h *Handler) readRequest(c gnet.Conn, buf []byte) (*http.Request, int, error) {
+	p, _ := c.Context().(*pending)
+	if p == nil {
+		var err error
+		if p, err = h.readHeader(buf); p == nil || err != nil {
+			return nil, 0, err
+		}
+		c.SetContext(p)
+	}
+	if p.chunked {
+		done, err := h.scanChunks(p, buf)
+		if !done || err != nil {
+			return nil, 0, err
+		}
+	} else if len(buf) < p.length {
+		return nil, 0, nil
+	}
+	c.SetContext(nil)
+
+	r := bytes.NewReader(buf[:p.length])
+	req, err := http.ReadRequest(bufio.NewReader(r))
+	if err != nil {
+		return nil, 0, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return req, p.length, nil
+}
+
+// readHeader parses the framing of the request from its header, nil is returned if the header is not complete.
+func (h *Handler) readHeader(buf []byte) (*pending, error) {
+	end := bytes.Index(buf, headerEnd)
+	if end < 0 {
+		maxHeaderBytes := h.MaxHeaderBytes
+		if maxHeaderBytes <= 0 {
+			maxHeaderBytes = http.DefaultMaxHeaderBytes
+		}
+		if len(buf) > maxHeaderBytes {
+			return nil, errHeaderTooLarge
+		}
+		return nil, nil
+	}
+	end += len(headerEnd)
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:end])))
+	if err != nil {
+		return nil, err
+	}
+	p := &pending{headerLen: end, length: end}
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		p.chunked = true
+		return p, nil
+	}
+	if req.ContentLength > h.maxBodyBytes() {
+		return nil, errBodyTooLarge
+	}
+	if req.ContentLength > 0 {
+		p.length += int(req.ContentLength)
+	}
+	return p, nil
+}
+
+// scanChunks scans the chunks of the body following those scanned last time, it reports whether the body
+// is complete along with its trailer, in which case p.length is the length of the whole request.
+func (h *Handler) scanChunks(p *pending, buf []byte) (bool, error) {
+	for {
+		line := bytes.Index(buf[p.length:], crlf)
+		if line < 0 {
+			if len(buf)-p.length > http.DefaultMaxHeaderBytes {
+				return false, errBadChunk
+			}
+			return false, nil
+		}
+		sizeField := buf[p.length : p.length+line]
+		if ext := bytes.IndexByte(sizeField, ';'); ext >= 0 {
+			sizeField = sizeField[:ext]
+		}
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(sizeField)), 16, 64)
+		if err != nil || size < 0 {
+			return false, errBadChunk
+		}
+		data := p.length + line + len(crlf)
+		if size == 0 {
+			// The last chunk is followed by the trailer, which ends with an empty line.
+			if bytes.HasPrefix(buf[data:], crlf) {
+				p.length = data + len(crlf)
+				return true, nil
+			}
+			if end := bytes.Index(buf[data:], headerEnd); end >= 0 {
+				p.length = data + end + len(headerEnd)
+				return true, nil
+			}
+			return false, nil
+		}
+		if p.bodyLen += size; p.bodyLen > h.maxBodyBytes() {
+			return false, errBodyTooLarge
+		}
+		if int64(len(buf)-data) < size+int64(len(crlf)) {
+			p.bodyLen -= size
+			return false, nil
+		}
+		p.length = data + int(size) + len(crlf)
+	}
+}
+
+func (h *Handler) maxBodyBytes() int64 {
+	if h.MaxBodyBytes > 0 {
+		return h.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// serve runs the http.Handler with the given request and appends the response to out.
+func (h *Handler) serve(out []byte, req *http.Request) []byte {
+	w := &responseWriter{header: make(http.Header)}
+	h.handler.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.header.Get("Date") == "" {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if w.header.Get("Content-Type") == "" && w.body.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+	resp := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        w.header,
+		ContentLength: int64(w.body.Len()),
+		Body:          ioutil.NopCloser(&w.body),
+		Close:         req.Close,
+	}
+	buf := bytes.NewBuffer(out)
+	_ = resp.Write(buf)
+	return buf.Bytes()
+}
+
+// responseWriter buffers the response of the http.Handler.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return 0, http.ErrBodyNotAllowed
+	}
+	return w.body.Write(p)
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package httpbridge
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+type testServer struct {
+	*Handler
+	addr string
+	done int32
+}
+
+func (s *testServer) OnInitComplete(svr gnet.Server) (action gnet.Action) {
+	go func() {
+		defer atomic.StoreInt32(&s.done, 1)
+		client := &http.Client{Timeout: time.Second * 3}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Post("http://"+s.addr+"/echo", "text/plain", strings.NewReader(fmt.Sprint("body-", i)))
+			if err != nil {
+				panic(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != fmt.Sprint("/echo:body-", i) {
+				panic(fmt.Sprintf("unexpected response: %d %q", resp.StatusCode, body))
+			}
+		}
+
+		// Pipelined requests are answered in order on the same connection.
+		c, err := net.Dial("tcp", s.addr)
+		if err != nil {
+			panic(err)
+		}
+		defer c.Close()
+		_, _ = c.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /missing HTTP/1.1\r\nHost: x\r\n\r\n"))
+		br := bufio.NewReader(c)
+		for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				panic(err)
+			}
+			_, _ = ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != expected {
+				panic(fmt.Sprintf("expected status %d, got %d", expected, resp.StatusCode))
+			}
+		}
+	}()
+	return
+}
+
+func (s *testServer) Tick() (delay time.Duration, action gnet.Action) {
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = gnet.Shutdown
+	}
+	delay = time.Millisecond * 100
+	return
+}
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s:%s", r.URL.Path, body)
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("a"))
+	})
+	s := &testServer{Handler: New(mux), addr: "127.0.0.1:9988"}
+	if err := gnet.Serve(s, "tcp://"+s.addr, gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testBodyServer struct {
+	*Handler
+	addr string
+	done int32
+}
+
+func (s *testBodyServer) OnInitComplete(svr gnet.Server) (action gnet.Action) {
+	go func() {
+		defer atomic.StoreInt32(&s.done, 1)
+		c, err := net.Dial("tcp", s.addr)
+		if err != nil {
+			panic(err)
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		// The bodies arriving in pieces are served once they are complete.
+		for _, pieces := range [][]string{
+			{"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\n01234", "56789"},
+			{"POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n01234\r\n", "5\r\n5678",
+				"9\r\n0\r\nX-Trailer: 1\r\n\r\n"},
+		} {
+			for _, piece := range pieces {
+				_, _ = c.Write([]byte(piece))
+				time.Sleep(time.Millisecond * 20)
+			}
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				panic(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(body) != "/echo:0123456789" {
+				panic(fmt.Sprintf("unexpected response: %d %q", resp.StatusCode, body))
+			}
+		}
+
+		// The bodies over MaxBodyBytes are rejected, without waiting for them if their lengths are known.
+		for _, req := range []string{
+			"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 17\r\n\r\n",
+			"POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\na\r\n0123456789\r\na\r\n0123456789\r\n",
+		} {
+			c, err := net.Dial("tcp", s.addr)
+			if err != nil {
+				panic(err)
+			}
+			_, _ = c.Write([]byte(req))
+			_ = c.SetReadDeadline(time.Now().Add(time.Second * 3))
+			br := bufio.NewReader(c)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				panic(err)
+			}
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				panic(fmt.Sprintf("expected status %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode))
+			}
+			if _, err = br.ReadByte(); err != io.EOF {
+				panic(fmt.Sprintf("expected the connection to be closed, got %v", err))
+			}
+			_ = c.Close()
+		}
+	}()
+	return
+}
+
+func (s *testBodyServer) Tick() (delay time.Duration, action gnet.Action) {
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = gnet.Shutdown
+	}
+	delay = time.Millisecond * 100
+	return
+}
+
+func TestHandlerBody(t *testing.T) {
+	h := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s:%s", r.URL.Path, body)
+	}))
+	h.MaxBodyBytes = 16
+	s := &testBodyServer{Handler: h, addr: "127.0.0.1:9917"}
+	if err := gnet.Serve(s, "tcp://"+s.addr, gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,6 +9,10 @@ package netpoll
 
 import (
 	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/panjf2000/gnet/internal"
@@ -17,15 +21,39 @@ import (
 
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
-	fd            int    // epoll fd
-	wfd           int    // wake fd
-	wfdBuf        []byte // wfd buffer to read packet
+	fd            int                        // epoll fd
+	wfd           int                        // wake fd
+	wfdBuf        []byte                     // wfd buffer to read packet
+	timeout       int                        // timeout of epoll_wait in milliseconds, -1 means infinite
+	busyPoll      bool                       // spin for a while before blocking in epoll_wait
+	priorities    map[int]bool               // file-descriptors whose events are handled ahead of the others
+	held          map[int]bool               // file-descriptors whose readable events are not polled
+	batchHook     func() error               // runs after each batch of events and jobs
+	waitHook      func(start, end time.Time) // runs after each wait for events
+	timers        *internal.TimingWheel      // timing wheel advanced after each wait for events
 	asyncJobQueue internal.AsyncJobQueue
+
+	requests, syscalls uint64 // changes of interest requested and epoll_ctl(2) calls made for them, accessed atomically
+
+	batching bool
+	mu       sync.Mutex        // guards pending and interest, changes may be requested by other goroutines
+	pending  map[int]ctlUpdate // changes of interest held until the next wait for events, coalesced by fd
+	interest map[int]uint32    // events registered by the batched changes
+}
+
+// ctlUpdate is a change of interest of a file-descriptor held by a batching poller.
+type ctlUpdate struct {
+	op     int
+	events uint32
 }
 
+// busyPollWindow is the period of time for which a busy-polling poller keeps spinning after the last events.
+const busyPollWindow = 50 * time.Microsecond
+
 // OpenPoller instantiates a poller.
 func OpenPoller() (poller *Poller, err error) {
 	poller = new(Poller)
+	poller.timeout = -1
 	if poller.fd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
 		poller = nil
 		return
@@ -45,6 +73,74 @@ func OpenPoller() (poller *Poller, err error) {
 	return
 }
 
+// SetPollTimeout sets up the maximum duration of blocking in waiting for network-events,
+// a negative duration means blocking until any event arrives. It must be called before Polling.
+func (p *Poller) SetPollTimeout(d time.Duration) {
+	if d < 0 {
+		p.timeout = -1
+		return
+	}
+	p.timeout = int(d / time.Millisecond)
+}
+
+// SetBusyPoll makes the poller keep spinning for a short while after handling network-events
+// before it blocks in waiting for new events, which trades CPU for lower latency.
+// It must be called before Polling.
+func (p *Poller) SetBusyPoll(busyPoll bool) {
+	p.busyPoll = busyPoll
+}
+
+// SetBatchUpdates makes the poller hold the changes of interest of the file-descriptors until it waits for
+// events next time, the changes of the same file-descriptor are coalesced and the ones which don't change
+// the registered events are dropped, which saves epoll_ctl(2) calls. The errors of the held changes are discarded,
+// the registrations by AddRead, AddReadWrite and AddWrite included. It must be called before Polling.
+func (p *Poller) SetBatchUpdates(batching bool) {
+	p.mu.Lock()
+	p.batching = batching
+	if batching && p.pending == nil {
+		p.pending = make(map[int]ctlUpdate)
+		p.interest = make(map[int]uint32)
+	}
+	p.mu.Unlock()
+}
+
+// Updates returns the number of the changes of interest requested to the poller and the number of
+// epoll_ctl(2) calls made for them, the latter is less than the former if SetBatchUpdates is set.
+func (p *Poller) Updates() (requests, syscalls uint64) {
+	return atomic.LoadUint64(&p.requests), atomic.LoadUint64(&p.syscalls)
+}
+
+// SetBatchHook sets up the function which runs after handling each batch of network-events and asynchronous jobs,
+// an error returned by it stops the polling like the callback. It must be called before Polling.
+func (p *Poller) SetBatchHook(hook func() error) {
+	p.batchHook = hook
+}
+
+// SetWaitHook sets up the function which is called with the start and the end of each wait for network-events,
+// which is the way of telling the idle time of poller from the busy time. It must be called before Polling.
+func (p *Poller) SetWaitHook(hook func(start, end time.Time)) {
+	p.waitHook = hook
+}
+
+// SetTimingWheel sets up the timing wheel which is advanced by the poller after each wait for network-events,
+// the wait is cut short when the wheel has to be advanced. It must be called in the polling goroutine.
+func (p *Poller) SetTimingWheel(tw *internal.TimingWheel) {
+	p.timers = tw
+}
+
+// SetPriority marks the given file-descriptor as prioritized or not, the events of prioritized file-descriptors
+// are handled ahead of the others in each batch of events. It must be called in the polling goroutine.
+func (p *Poller) SetPriority(fd int, prioritized bool) {
+	if !prioritized {
+		delete(p.priorities, fd)
+		return
+	}
+	if p.priorities == nil {
+		p.priorities = make(map[int]bool)
+	}
+	p.priorities[fd] = true
+}
+
 // Close closes the poller.
 func (p *Poller) Close() error {
 	if err := unix.Close(p.fd); err != nil {
@@ -60,26 +156,93 @@ var (
 	b        = (*(*[8]byte)(unsafe.Pointer(&u)))[:]
 )
 
-// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
+// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue,
+// an error means the poller is closed and the job will never run.
 func (p *Poller) Trigger(job internal.Job) error {
 	if p.asyncJobQueue.Push(job) == 1 {
-		_, err := unix.Write(p.wfd, b)
+		return p.Wake()
+	}
+	return nil
+}
+
+// TriggerTask is the same as Trigger except that it takes the function and its argument separately, which
+// saves the allocation of the closure, the tasks queued by it are counted by PendingTasks.
+func (p *Poller) TriggerTask(run internal.TaskFunc, arg interface{}) error {
+	if p.asyncJobQueue.PushTask(run, arg) == 1 {
+		return p.Wake()
+	}
+	return nil
+}
+
+// Wake wakes up the poller to run the jobs in asyncJobQueue, e.g. the ones put back by a panicking job.
+func (p *Poller) Wake() error {
+	// EAGAIN means the counter of eventfd is saturated, which wakes up the poller all the same.
+	if _, err := unix.Write(p.wfd, b); err != nil && err != unix.EAGAIN {
 		return err
 	}
 	return nil
 }
 
+// PendingTasks returns the number of the tasks queued by TriggerTask and not run yet.
+func (p *Poller) PendingTasks() int {
+	return p.asyncJobQueue.Pending()
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
 	el := newEventList(InitEvents)
-	var wakenUp bool
+	var (
+		wakenUp   bool
+		spinUntil time.Time
+	)
 	for {
-		n, err0 := unix.EpollWait(p.fd, el.events, -1)
+		if p.batching {
+			p.flushUpdates()
+		}
+		msec := p.timeout
+		if p.busyPoll && time.Now().Before(spinUntil) {
+			msec = 0
+		}
+		if p.timers != nil && msec != 0 {
+			if d := p.timers.Next(time.Now()); d >= 0 {
+				if ms := int((d + time.Millisecond - 1) / time.Millisecond); msec < 0 || ms < msec {
+					msec = ms
+				}
+			}
+		}
+		var waitStart time.Time
+		if p.waitHook != nil {
+			waitStart = time.Now()
+		}
+		n, err0 := unix.EpollWait(p.fd, el.events, msec)
+		if p.waitHook != nil {
+			p.waitHook(waitStart, time.Now())
+		}
 		if err0 != nil && err0 != unix.EINTR {
 			log.Println(err0)
 			continue
 		}
+		if p.busyPoll {
+			if n > 0 {
+				spinUntil = time.Now().Add(busyPollWindow)
+			} else if msec == 0 {
+				runtime.Gosched()
+			}
+		}
+		if len(p.priorities) > 0 {
+			for i := 0; i < n; i++ {
+				if ev := &el.events[i]; p.priorities[int(ev.Fd)] {
+					if err = callback(int(ev.Fd), ev.Events); err != nil {
+						return
+					}
+					ev.Events = 0 // handled already
+				}
+			}
+		}
 		for i := 0; i < n; i++ {
+			if el.events[i].Events == 0 {
+				continue
+			}
 			if fd := int(el.events[i].Fd); fd != p.wfd {
 				if err = callback(fd, el.events[i].Events); err != nil {
 					return
@@ -95,6 +258,16 @@ func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
 				return
 			}
 		}
+		if p.timers != nil && p.timers.Len() > 0 {
+			if err = p.timers.Advance(time.Now()); err != nil {
+				return
+			}
+		}
+		if p.batchHook != nil {
+			if err = p.batchHook(); err != nil {
+				return
+			}
+		}
 		if n == el.size {
 			el.increase()
 		}
@@ -107,32 +280,160 @@ const (
 	readWriteEvents = readEvents | writeEvents
 )
 
+// ctl calls epoll_ctl(2) and counts it.
+func (p *Poller) ctl(op, fd int, events uint32) error {
+	atomic.AddUint64(&p.syscalls, 1)
+	if op == unix.EPOLL_CTL_DEL {
+		return unix.EpollCtl(p.fd, op, fd, nil)
+	}
+	return unix.EpollCtl(p.fd, op, fd, &unix.EpollEvent{Fd: int32(fd), Events: events})
+}
+
+// add registers the file-descriptor with the given events, the registration is held if the poller is batching.
+func (p *Poller) add(fd int, events uint32) error {
+	atomic.AddUint64(&p.requests, 1)
+	if !p.batching {
+		return p.ctl(unix.EPOLL_CTL_ADD, fd, events)
+	}
+	p.mu.Lock()
+	p.pending[fd] = ctlUpdate{unix.EPOLL_CTL_ADD, events}
+	p.mu.Unlock()
+	return nil
+}
+
+// mod renews the file-descriptor with the given events, the change is merged into the one held for
+// the file-descriptor if the poller is batching.
+func (p *Poller) mod(fd int, events uint32) error {
+	atomic.AddUint64(&p.requests, 1)
+	if p.held[fd] {
+		events &^= readEvents
+	}
+	if !p.batching {
+		return p.ctl(unix.EPOLL_CTL_MOD, fd, events)
+	}
+	p.mu.Lock()
+	if u, ok := p.pending[fd]; ok {
+		u.events = events
+		p.pending[fd] = u
+	} else if registered, ok := p.interest[fd]; !ok || registered != events {
+		p.pending[fd] = ctlUpdate{unix.EPOLL_CTL_MOD, events}
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+// del removes the file-descriptor from the poller, the change held for it is dropped if the poller is batching,
+// and so is the removal if the file-descriptor has not been registered yet.
+func (p *Poller) del(fd int) error {
+	atomic.AddUint64(&p.requests, 1)
+	if !p.batching {
+		return p.ctl(unix.EPOLL_CTL_DEL, fd, 0)
+	}
+	p.mu.Lock()
+	u, held := p.pending[fd]
+	delete(p.pending, fd)
+	delete(p.interest, fd)
+	p.mu.Unlock()
+	if held && u.op == unix.EPOLL_CTL_ADD {
+		return nil
+	}
+	return p.ctl(unix.EPOLL_CTL_DEL, fd, 0)
+}
+
+// flushUpdates applies the changes held by the batching poller.
+func (p *Poller) flushUpdates() {
+	p.mu.Lock()
+	for fd, u := range p.pending {
+		delete(p.pending, fd)
+		if registered, ok := p.interest[fd]; ok && u.op == unix.EPOLL_CTL_MOD && registered == u.events {
+			continue
+		}
+		if err := p.ctl(u.op, fd, u.events); err != nil {
+			delete(p.interest, fd)
+			continue
+		}
+		p.interest[fd] = u.events
+	}
+	p.mu.Unlock()
+}
+
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
 func (p *Poller) AddReadWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
+	return p.add(fd, readWriteEvents)
 }
 
 // AddRead registers the given file-descriptor with readable event to the poller.
 func (p *Poller) AddRead(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
+	return p.add(fd, readEvents)
+}
+
+// AddReadExclusive registers the given file-descriptor with readable event to the poller in exclusive mode,
+// only one of the pollers which monitor the same file-descriptor exclusively will be woken up on an event.
+// It falls back to AddRead on the kernels which don't support EPOLLEXCLUSIVE (prior to Linux 4.5).
+func (p *Poller) AddReadExclusive(fd int) error {
+	atomic.AddUint64(&p.requests, 1)
+	err := p.ctl(unix.EPOLL_CTL_ADD, fd, unix.EPOLLIN|unix.EPOLLEXCLUSIVE)
+	if err == unix.EINVAL {
+		return p.ctl(unix.EPOLL_CTL_ADD, fd, readEvents)
+	}
+	return err
 }
 
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: writeEvents})
+	return p.add(fd, writeEvents)
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
 func (p *Poller) ModRead(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
+	return p.mod(fd, readEvents)
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
 func (p *Poller) ModReadWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
+	return p.mod(fd, readWriteEvents)
+}
+
+// HoldRead stops polling the readable events of the given file-descriptor until ReleaseRead, which are kept off
+// by ModRead and ModReadWrite in the meantime, writing tells whether the writable events are polled at the moment.
+// It must be called in the polling goroutine.
+func (p *Poller) HoldRead(fd int, writing bool) error {
+	if p.held == nil {
+		p.held = make(map[int]bool)
+	}
+	p.held[fd] = true
+	if writing {
+		return p.mod(fd, writeEvents)
+	}
+	return p.mod(fd, 0)
+}
+
+// ReleaseRead polls the readable events of the given file-descriptor held by HoldRead again, writing tells whether
+// the writable events are polled at the moment. It must be called in the polling goroutine.
+func (p *Poller) ReleaseRead(fd int, writing bool) error {
+	if !p.held[fd] {
+		return nil
+	}
+	delete(p.held, fd)
+	if writing {
+		return p.mod(fd, readWriteEvents)
+	}
+	return p.mod(fd, readEvents)
 }
 
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
+	delete(p.priorities, fd)
+	delete(p.held, fd)
+	return p.del(fd)
+}
+
+// Detach removes the given file-descriptor from the poller while it stays open, e.g. to be polled by another poller.
+func (p *Poller) Detach(fd int) error {
+	return p.Delete(fd)
+}
+
+// DeleteRead stops the poller from monitoring the readable event of the given file-descriptor.
+func (p *Poller) DeleteRead(fd int) error {
+	return p.del(fd)
 }
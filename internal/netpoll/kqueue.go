@@ -9,6 +9,8 @@ package netpoll
 
 import (
 	"log"
+	"runtime"
+	"time"
 
 	"github.com/panjf2000/gnet/internal"
 	"golang.org/x/sys/unix"
@@ -17,9 +19,22 @@ import (
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
 	fd            int
+	timerJob      internal.Job               // job to run when the timer expires
+	timeout       *unix.Timespec             // timeout of kevent, nil means infinite
+	timerTimeout  unix.Timespec              // timeout of kevent cut short by the timing wheel
+	busyPoll      bool                       // spin for a while before blocking in kevent
+	priorities    map[int]bool               // file-descriptors whose events are handled ahead of the others
+	batchHook     func() error               // runs after each batch of events and jobs
+	waitHook      func(start, end time.Time) // runs after each wait for events
+	timers        *internal.TimingWheel      // timing wheel advanced after each wait for events
 	asyncJobQueue internal.AsyncJobQueue
 }
 
+// busyPollWindow is the period of time for which a busy-polling poller keeps spinning after the last events.
+const busyPollWindow = 50 * time.Microsecond
+
+var zeroTimeout = unix.NsecToTimespec(0)
+
 // OpenPoller instantiates a poller.
 func OpenPoller() (poller *Poller, err error) {
 	poller = new(Poller)
@@ -40,6 +55,63 @@ func OpenPoller() (poller *Poller, err error) {
 	return
 }
 
+// SetBatchHook sets up the function which runs after handling each batch of network-events and asynchronous jobs,
+// an error returned by it stops the polling like the callback. It must be called before Polling.
+func (p *Poller) SetBatchHook(hook func() error) {
+	p.batchHook = hook
+}
+
+// SetWaitHook sets up the function which is called with the start and the end of each wait for network-events,
+// which is the way of telling the idle time of poller from the busy time. It must be called before Polling.
+func (p *Poller) SetWaitHook(hook func(start, end time.Time)) {
+	p.waitHook = hook
+}
+
+// SetTimingWheel sets up the timing wheel which is advanced by the poller after each wait for network-events,
+// the wait is cut short when the wheel has to be advanced. It must be called in the polling goroutine.
+func (p *Poller) SetTimingWheel(tw *internal.TimingWheel) {
+	p.timers = tw
+}
+
+// SetPriority marks the given file-descriptor as prioritized or not, the events of prioritized file-descriptors
+// are handled ahead of the others in each batch of events. It must be called in the polling goroutine.
+func (p *Poller) SetPriority(fd int, prioritized bool) {
+	if !prioritized {
+		delete(p.priorities, fd)
+		return
+	}
+	if p.priorities == nil {
+		p.priorities = make(map[int]bool)
+	}
+	p.priorities[fd] = true
+}
+
+// SetPollTimeout sets up the maximum duration of blocking in waiting for network-events,
+// a negative duration means blocking until any event arrives. It must be called before Polling.
+func (p *Poller) SetPollTimeout(d time.Duration) {
+	if d < 0 {
+		p.timeout = nil
+		return
+	}
+	ts := unix.NsecToTimespec(int64(d))
+	p.timeout = &ts
+}
+
+// SetBusyPoll makes the poller keep spinning for a short while after handling network-events
+// before it blocks in waiting for new events, which trades CPU for lower latency.
+// It must be called before Polling.
+func (p *Poller) SetBusyPoll(busyPoll bool) {
+	p.busyPoll = busyPoll
+}
+
+// SetBatchUpdates is a no-op, the changes of interest are applied by kevent(2) right away.
+func (p *Poller) SetBatchUpdates(batching bool) {}
+
+// Updates returns zeros, the changes of interest are not counted by kqueue.
+func (p *Poller) Updates() (requests, syscalls uint64) {
+	return 0, 0
+}
+
 // Close closes the poller.
 func (p *Poller) Close() error {
 	return unix.Close(p.fd)
@@ -51,7 +123,8 @@ var wakeChanges = []unix.Kevent_t{{
 	Fflags: unix.NOTE_TRIGGER,
 }}
 
-// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
+// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue,
+// an error means the poller is closed and the job will never run.
 func (p *Poller) Trigger(job internal.Job) error {
 	if p.asyncJobQueue.Push(job) == 1 {
 		_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
@@ -60,19 +133,92 @@ func (p *Poller) Trigger(job internal.Job) error {
 	return nil
 }
 
+// TriggerTask is the same as Trigger except that it takes the function and its argument separately, which
+// saves the allocation of the closure, the tasks queued by it are counted by PendingTasks.
+func (p *Poller) TriggerTask(run internal.TaskFunc, arg interface{}) error {
+	if p.asyncJobQueue.PushTask(run, arg) == 1 {
+		_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
+		return err
+	}
+	return nil
+}
+
+// Wake wakes up the poller to run the jobs in asyncJobQueue, e.g. the ones put back by a panicking job.
+func (p *Poller) Wake() error {
+	_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
+	return err
+}
+
+// PendingTasks returns the number of the tasks queued by TriggerTask and not run yet.
+func (p *Poller) PendingTasks() int {
+	return p.asyncJobQueue.Pending()
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
 func (p *Poller) Polling(callback func(fd int, filter int16) error) (err error) {
 	el := newEventList(InitEvents)
-	var wakenUp bool
+	var (
+		wakenUp   bool
+		spinUntil time.Time
+	)
 	for {
-		n, err0 := unix.Kevent(p.fd, nil, el.events, nil)
+		timeout := p.timeout
+		if p.busyPoll && time.Now().Before(spinUntil) {
+			timeout = &zeroTimeout
+		}
+		if p.timers != nil && timeout != &zeroTimeout {
+			if d := p.timers.Next(time.Now()); d >= 0 && (timeout == nil || d < time.Duration(timeout.Nano())) {
+				p.timerTimeout = unix.NsecToTimespec(int64(d))
+				timeout = &p.timerTimeout
+			}
+		}
+		var waitStart time.Time
+		if p.waitHook != nil {
+			waitStart = time.Now()
+		}
+		n, err0 := unix.Kevent(p.fd, nil, el.events, timeout)
+		if p.waitHook != nil {
+			p.waitHook(waitStart, time.Now())
+		}
 		if err0 != nil && err0 != unix.EINTR {
 			log.Println(err0)
 			continue
 		}
+		if p.busyPoll {
+			if n > 0 {
+				spinUntil = time.Now().Add(busyPollWindow)
+			} else if timeout == &zeroTimeout {
+				runtime.Gosched()
+			}
+		}
 		var evFilter int16
+		if len(p.priorities) > 0 {
+			for i := 0; i < n; i++ {
+				ev := &el.events[i]
+				if ev.Filter == unix.EVFILT_USER || ev.Filter == unix.EVFILT_TIMER || !p.priorities[int(ev.Ident)] {
+					continue
+				}
+				evFilter = ev.Filter
+				if (ev.Flags&unix.EV_EOF != 0) || (ev.Flags&unix.EV_ERROR != 0) {
+					evFilter = EVFilterSock
+				}
+				if err = callback(int(ev.Ident), evFilter); err != nil {
+					return
+				}
+				ev.Filter = 0 // handled already
+			}
+		}
 		for i := 0; i < n; i++ {
-			if fd := int(el.events[i].Ident); fd != 0 {
+			switch el.events[i].Filter {
+			case 0:
+			case unix.EVFILT_USER:
+				wakenUp = true
+			case unix.EVFILT_TIMER:
+				if err = p.runTimer(); err != nil {
+					return
+				}
+			default:
+				fd := int(el.events[i].Ident)
 				evFilter = el.events[i].Filter
 				if (el.events[i].Flags&unix.EV_EOF != 0) || (el.events[i].Flags&unix.EV_ERROR != 0) {
 					evFilter = EVFilterSock
@@ -80,8 +226,6 @@ func (p *Poller) Polling(callback func(fd int, filter int16) error) (err error)
 				if err = callback(fd, evFilter); err != nil {
 					return
 				}
-			} else {
-				wakenUp = true
 			}
 		}
 		if wakenUp {
@@ -90,12 +234,48 @@ func (p *Poller) Polling(callback func(fd int, filter int16) error) (err error)
 				return
 			}
 		}
+		if p.timers != nil && p.timers.Len() > 0 {
+			if err = p.timers.Advance(time.Now()); err != nil {
+				return
+			}
+		}
+		if p.batchHook != nil {
+			if err = p.batchHook(); err != nil {
+				return
+			}
+		}
 		if n == el.size {
 			el.increase()
 		}
 	}
 }
 
+// SetTimer arms a one-shot timer with EVFILT_TIMER, the given job will be run in the polling goroutine
+// once the timer expires, the timer armed previously will be replaced if it has not expired yet.
+// It must be called in the polling goroutine.
+func (p *Poller) SetTimer(delay time.Duration, job internal.Job) error {
+	if delay < 0 {
+		delay = 0
+	}
+	p.timerJob = job
+	_, err := unix.Kevent(p.fd, []unix.Kevent_t{{
+		Ident:  0,
+		Filter: unix.EVFILT_TIMER,
+		Flags:  unix.EV_ADD | unix.EV_ONESHOT,
+		Data:   int64(delay / time.Millisecond),
+	}}, nil, nil)
+	return err
+}
+
+func (p *Poller) runTimer() error {
+	job := p.timerJob
+	if job == nil {
+		return nil
+	}
+	p.timerJob = nil
+	return job()
+}
+
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
 func (p *Poller) AddReadWrite(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
@@ -116,6 +296,12 @@ func (p *Poller) AddRead(fd int) error {
 	return nil
 }
 
+// AddReadExclusive registers the given file-descriptor with readable event to the poller,
+// kqueue has no equivalent of EPOLLEXCLUSIVE, so it is the same as AddRead.
+func (p *Poller) AddReadExclusive(fd int) error {
+	return p.AddRead(fd)
+}
+
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
@@ -143,7 +329,50 @@ func (p *Poller) ModReadWrite(fd int) error {
 	return nil
 }
 
+// HoldRead stops polling the readable events of the given file-descriptor until ReleaseRead, writing is not needed
+// by kqueue whose filters are changed one by one. It must be called in the polling goroutine.
+func (p *Poller) HoldRead(fd int, writing bool) error {
+	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ}}, nil, nil); err != nil && err != unix.ENOENT {
+		return err
+	}
+	return nil
+}
+
+// ReleaseRead polls the readable events of the given file-descriptor held by HoldRead again.
+// It must be called in the polling goroutine.
+func (p *Poller) ReleaseRead(fd int, writing bool) error {
+	return p.AddRead(fd)
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
+	delete(p.priorities, fd)
+	return nil
+}
+
+// Detach removes the given file-descriptor from the poller while it stays open, e.g. to be polled by another poller,
+// the events are not removed by Delete which counts on closing the file-descriptor.
+func (p *Poller) Detach(fd int) error {
+	delete(p.priorities, fd)
+	changes := []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ},
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE},
+	}
+	// The filters are deleted one by one since the one of writing may not be there.
+	for i := range changes {
+		if _, err := unix.Kevent(p.fd, changes[i:i+1], nil, nil); err != nil && err != unix.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRead stops the poller from monitoring the readable event of the given file-descriptor.
+func (p *Poller) DeleteRead(fd int) error {
+	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ}}, nil, nil); err != nil {
+		return err
+	}
 	return nil
 }
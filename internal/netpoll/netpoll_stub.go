@@ -10,6 +10,7 @@ package netpoll
 import (
 	"errors"
 	"net"
+	"syscall"
 )
 
 // SetKeepAlive sets the keepalive for the connection.
@@ -27,3 +28,8 @@ func ReusePortListenPacket(proto, addr string) (net.PacketConn, error) {
 func ReusePortListen(proto, addr string) (net.Listener, error) {
 	return nil, errors.New("SO_REUSEPORT/SO_REUSEADDR is not supported on this platform")
 }
+
+// ReusePortControl sets up SO_REUSEPORT and SO_REUSEADDR on the socket, it serves as net.ListenConfig.Control.
+func ReusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT/SO_REUSEADDR is not supported on this platform")
+}
@@ -8,6 +8,7 @@ package netpoll
 
 import (
 	"net"
+	"syscall"
 
 	"github.com/libp2p/go-reuseport"
 )
@@ -21,3 +22,8 @@ func ReusePortListenPacket(proto, addr string) (net.PacketConn, error) {
 func ReusePortListen(proto, addr string) (net.Listener, error) {
 	return reuseport.Listen(proto, addr)
 }
+
+// ReusePortControl sets up SO_REUSEPORT and SO_REUSEADDR on the socket, it serves as net.ListenConfig.Control.
+func ReusePortControl(network, address string, c syscall.RawConn) error {
+	return reuseport.Control(network, address, c)
+}
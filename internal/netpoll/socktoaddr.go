@@ -42,6 +42,35 @@ func SockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
 	return nil
 }
 
+// SockaddrToIPAddr converts a Sockaddr to a net.IPAddr of the raw IP sockets.
+// Returns nil if conversion fails.
+func SockaddrToIPAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.IPAddr{IP: sockaddrInet4ToIP(sa)}
+	case *unix.SockaddrInet6:
+		ip, zone := sockaddrInet6ToIPAndZone(sa)
+		return &net.IPAddr{IP: ip, Zone: zone}
+	}
+	return nil
+}
+
+// SockaddrToUDPOrUnixgramAddr converts a Sockaddr to a net.UDPAddr or net.UnixAddr of unixgram.
+// Returns nil if conversion fails.
+func SockaddrToUDPOrUnixgramAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip := sockaddrInet4ToIP(sa)
+		return &net.UDPAddr{IP: ip, Port: sa.Port}
+	case *unix.SockaddrInet6:
+		ip, zone := sockaddrInet6ToIPAndZone(sa)
+		return &net.UDPAddr{IP: ip, Port: sa.Port, Zone: zone}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unixgram"}
+	}
+	return nil
+}
+
 // sockaddrInet4ToIPAndZone converts a SockaddrInet4 to a net.IP.
 // It returns nil if conversion fails.
 func sockaddrInet4ToIP(sa *unix.SockaddrInet4) net.IP {
@@ -0,0 +1,61 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// ConnectUDP opens a non-blocking UDP socket bound to the local address of the given listener
+// and connected to the remote address, the listener must be set up with SO_REUSEPORT. The setup
+// is called with the socket before it is bound if it is not nil.
+func ConnectUDP(lfd int, sa unix.Sockaddr, setup func(fd int) error) (fd int, err error) {
+	lsa, err := unix.Getsockname(lfd)
+	if err != nil {
+		return -1, err
+	}
+	family := unix.AF_INET
+	if _, ok := lsa.(*unix.SockaddrInet6); ok {
+		family = unix.AF_INET6
+	}
+	if fd, err = unix.Socket(family, unix.SOCK_DGRAM, unix.IPPROTO_UDP); err != nil {
+		return -1, err
+	}
+	defer func() {
+		if err != nil {
+			_ = unix.Close(fd)
+			fd = -1
+		}
+	}()
+	unix.CloseOnExec(fd)
+	if err = unix.SetNonblock(fd, true); err != nil {
+		return
+	}
+	if family == unix.AF_INET6 {
+		var v6only int
+		if v6only, err = unix.GetsockoptInt(lfd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY); err != nil {
+			return
+		}
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6only); err != nil {
+			return
+		}
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		return
+	}
+	if setup != nil {
+		if err = setup(fd); err != nil {
+			return
+		}
+	}
+	if err = unix.Bind(fd, lsa); err != nil {
+		return
+	}
+	err = unix.Connect(fd, sa)
+	return
+}
@@ -5,15 +5,32 @@
 
 package internal
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // Job is a asynchronous function.
 type Job func() error
 
+// TaskFunc is a asynchronous function taking its argument instead of capturing it, it is meant to be a
+// top-level function with a pointer as the argument, so that queuing it allocates nothing.
+type TaskFunc func(arg interface{}) error
+
+// task is an entry of AsyncJobQueue, which is either a Job or a TaskFunc with its argument.
+type task struct {
+	job     Job
+	run     TaskFunc
+	arg     interface{}
+	counted bool // pushed by PushTask and accounted in pending
+}
+
 // AsyncJobQueue queues pending tasks.
 type AsyncJobQueue struct {
-	lock sync.Locker
-	jobs []func() error
+	lock    sync.Locker
+	tasks   []task
+	spare   []task // drained tasks reused by the next batch, only accessed by ForEach
+	pending int32  // number of the tasks pushed by PushTask and not run yet, accessed atomically
 }
 
 // NewAsyncJobQueue creates a note-queue.
@@ -24,22 +41,70 @@ func NewAsyncJobQueue() AsyncJobQueue {
 // Push pushes a item into queue.
 func (q *AsyncJobQueue) Push(job Job) (jobsNum int) {
 	q.lock.Lock()
-	q.jobs = append(q.jobs, job)
-	jobsNum = len(q.jobs)
+	q.tasks = append(q.tasks, task{job: job})
+	jobsNum = len(q.tasks)
+	q.lock.Unlock()
+	return
+}
+
+// PushTask pushes a function with its argument into queue, the tasks pushed by it are counted by Pending.
+func (q *AsyncJobQueue) PushTask(run TaskFunc, arg interface{}) (jobsNum int) {
+	atomic.AddInt32(&q.pending, 1)
+	q.lock.Lock()
+	q.tasks = append(q.tasks, task{run: run, arg: arg, counted: true})
+	jobsNum = len(q.tasks)
 	q.lock.Unlock()
 	return
 }
 
+// Pending returns the number of the tasks pushed by PushTask and not run yet.
+func (q *AsyncJobQueue) Pending() int {
+	return int(atomic.LoadInt32(&q.pending))
+}
+
 // ForEach iterates this queue and executes each note with a given func.
+//
+// If a task panics, the tasks behind it are put back to the front of the queue before the panic goes on, so that
+// they are run by the next ForEach once the panic is recovered, the caller is supposed to wake up the poller then.
 func (q *AsyncJobQueue) ForEach() (err error) {
 	q.lock.Lock()
-	jobs := q.jobs
-	q.jobs = nil
+	tasks := q.tasks
+	q.tasks = q.spare
 	q.lock.Unlock()
-	for i := range jobs {
-		if err = jobs[i](); err != nil {
-			return err
+	var i int
+	panicking := true
+	defer func() {
+		if panicking {
+			q.lock.Lock()
+			q.tasks = append(append(make([]task, 0, len(tasks)-i-1+len(q.tasks)), tasks[i+1:]...), q.tasks...)
+			q.lock.Unlock()
+			i = len(tasks)
+		}
+		// The slice is swapped with the one taking new tasks, drop the references held by it for GC, and discount
+		// the tasks skipped by the error.
+		for j := range tasks {
+			if j > i && tasks[j].counted {
+				atomic.AddInt32(&q.pending, -1)
+			}
+			tasks[j] = task{}
+		}
+		q.spare = tasks[:0]
+	}()
+	for ; i < len(tasks); i++ {
+		t := &tasks[i]
+		if t.counted {
+			t.counted = false
+			atomic.AddInt32(&q.pending, -1)
+		}
+		if t.run != nil {
+			err = t.run(t.arg)
+		} else {
+			err = t.job()
+		}
+		if err != nil {
+			break
 		}
 	}
+	panicking = false
 	return
 }
@@ -0,0 +1,96 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+type counter struct{ n int }
+
+func incr(arg interface{}) error {
+	arg.(*counter).n++
+	return nil
+}
+
+func TestAsyncJobQueue(t *testing.T) {
+	q := NewAsyncJobQueue()
+	c := new(counter)
+	q.Push(func() error { c.n += 10; return nil })
+	q.PushTask(incr, c)
+	if n := q.PushTask(incr, c); n != 3 {
+		t.Fatalf("expected 3 tasks queued, got %d", n)
+	}
+	if q.Pending() != 2 {
+		t.Fatalf("expected 2 pending tasks, got %d", q.Pending())
+	}
+	if err := q.ForEach(); err != nil || c.n != 12 || q.Pending() != 0 {
+		t.Fatalf("unexpected result of running tasks: %v, %d, %d", err, c.n, q.Pending())
+	}
+
+	// The tasks skipped by the error are discounted as well.
+	stop := errors.New("stop")
+	q.PushTask(func(interface{}) error { return stop }, nil)
+	q.PushTask(incr, c)
+	if err := q.ForEach(); err != stop || c.n != 12 || q.Pending() != 0 {
+		t.Fatalf("unexpected result of running tasks: %v, %d, %d", err, c.n, q.Pending())
+	}
+
+	// The tasks behind the panicking one are kept for the next round.
+	q.PushTask(incr, c)
+	q.Push(func() error { panic("task") })
+	q.PushTask(incr, c)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic of task is swallowed")
+			}
+		}()
+		_ = q.ForEach()
+	}()
+	if c.n != 13 || q.Pending() != 1 {
+		t.Fatalf("unexpected result of running tasks before the panic: %d, %d", c.n, q.Pending())
+	}
+	if err := q.ForEach(); err != nil || c.n != 14 || q.Pending() != 0 {
+		t.Fatalf("unexpected result of running tasks after the panic: %v, %d, %d", err, c.n, q.Pending())
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := 0; i < 16; i++ {
+			q.PushTask(incr, c)
+		}
+		_ = q.ForEach()
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocation in the steady state, got %v", allocs)
+	}
+}
+
+// BenchmarkAsyncJobQueue compares the closures of Push with the functions of PushTask, 16 tasks per batch.
+func BenchmarkAsyncJobQueue(b *testing.B) {
+	b.Run("Push", func(b *testing.B) {
+		q := NewAsyncJobQueue()
+		c := new(counter)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 16; j++ {
+				q.Push(func() error { c.n++; return nil })
+			}
+			_ = q.ForEach()
+		}
+	})
+	b.Run("PushTask", func(b *testing.B) {
+		q := NewAsyncJobQueue()
+		c := new(counter)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 16; j++ {
+				q.PushTask(incr, c)
+			}
+			_ = q.ForEach()
+		}
+	})
+}
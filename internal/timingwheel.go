@@ -0,0 +1,205 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	wheelBits   = 8
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+	maxTicks    = 1<<(wheelBits*wheelLevels) - 1
+)
+
+// Timer is a timer in the TimingWheel.
+type Timer struct {
+	expire     uint64
+	job        Job
+	prev, next *Timer
+	list       *timerList
+}
+
+// Stop prevents the timer from firing, it returns false if the timer has already fired or been stopped.
+func (t *Timer) Stop() bool {
+	if t.list == nil {
+		return false
+	}
+	t.list.remove(t)
+	return true
+}
+
+// timerList is the doubly linked list of timers in a bucket of the TimingWheel.
+type timerList struct {
+	head, tail *Timer
+	tw         *TimingWheel
+	level      int
+}
+
+func (l *timerList) push(t *Timer) {
+	t.list, t.prev, t.next = l, l.tail, nil
+	if l.tail != nil {
+		l.tail.next = t
+	} else {
+		l.head = t
+	}
+	l.tail = t
+	l.tw.levels[l.level]++
+	atomic.AddInt64(&l.tw.count, 1)
+}
+
+func (l *timerList) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	} else {
+		l.tail = t.prev
+	}
+	t.list, t.prev, t.next = nil, nil, nil
+	l.tw.levels[l.level]--
+	atomic.AddInt64(&l.tw.count, -1)
+}
+
+// TimingWheel is a hierarchical timing wheel, which inserts and stops timers in O(1), it is not safe for
+// concurrent use and is supposed to be owned by an event-loop, only Len can be called from other goroutines.
+type TimingWheel struct {
+	tick    time.Duration
+	start   time.Time
+	current uint64
+	count   int64
+	levels  [wheelLevels]int // number of pending timers in each level
+	buckets [wheelLevels][wheelSize]timerList
+	firing  timerList // expired timers whose jobs are running
+}
+
+// NewTimingWheel creates a timing wheel with the given precision, which starts ticking from now on.
+func NewTimingWheel(tick time.Duration, now time.Time) *TimingWheel {
+	tw := &TimingWheel{tick: tick, start: now}
+	tw.firing.tw = tw
+	for i := range tw.buckets {
+		for j := range tw.buckets[i] {
+			tw.buckets[i][j].tw, tw.buckets[i][j].level = tw, i
+		}
+	}
+	return tw
+}
+
+// Len returns the number of pending timers.
+func (tw *TimingWheel) Len() int {
+	return int(atomic.LoadInt64(&tw.count))
+}
+
+// Next returns the duration from now until the wheel has to be advanced next, which is when the earliest timer
+// expires or the timers of higher levels are moved down, it returns a negative duration if there are no pending timers.
+func (tw *TimingWheel) Next(now time.Time) time.Duration {
+	if tw.Len() == 0 {
+		return -1
+	}
+	next := tw.current + wheelSize
+	if tw.levels[0] > 0 {
+		for i := tw.current + 1; i < tw.current+wheelSize; i++ {
+			if tw.buckets[0][i&wheelMask].head != nil {
+				next = i
+				break
+			}
+		}
+	}
+	// The timers of higher levels are moved down when level 0 wraps around.
+	if wrap := tw.current | wheelMask + 1; tw.levels[0] < tw.Len() && wrap < next {
+		next = wrap
+	}
+	if d := tw.start.Add(time.Duration(next) * tw.tick).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// AfterFunc runs the job after the given duration, which is rounded up to the tick.
+func (tw *TimingWheel) AfterFunc(d time.Duration, job Job) *Timer {
+	ticks := uint64((d + tw.tick - 1) / tw.tick)
+	if d <= 0 {
+		ticks = 1
+	} else if ticks > maxTicks {
+		ticks = maxTicks
+	}
+	t := &Timer{expire: tw.current + ticks, job: job}
+	tw.add(t)
+	return t
+}
+
+func (tw *TimingWheel) add(t *Timer) {
+	delta := t.expire - tw.current
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	tw.buckets[level][(t.expire>>(wheelBits*level))&wheelMask].push(t)
+}
+
+// Advance fires all timers expiring by the given time, it stops at the first error returned by the jobs.
+func (tw *TimingWheel) Advance(now time.Time) error {
+	target := uint64(now.Sub(tw.start) / tw.tick)
+	if tw.Len() == 0 {
+		// Nothing to fire or move down, jump to the target at once.
+		if tw.current < target {
+			tw.current = target
+		}
+		return nil
+	}
+	for tw.current < target {
+		tw.current++
+		// Move the timers of the higher level bucket down whenever the lower level wraps around.
+		for level := 1; level < wheelLevels; level++ {
+			if (tw.current>>(wheelBits*(level-1)))&wheelMask != 0 {
+				break
+			}
+			l := &tw.buckets[level][(tw.current>>(wheelBits*level))&wheelMask]
+			for t := l.head; t != nil; t = l.head {
+				l.remove(t)
+				tw.add(t)
+			}
+		}
+		if l := &tw.buckets[0][tw.current&wheelMask]; l.head != nil {
+			if err := tw.fire(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fire runs the jobs of the expired timers in the given bucket, the timers left by an error or a panic of
+// the jobs are put back, and the wheel steps back to fire them on the next Advance.
+func (tw *TimingWheel) fire(l *timerList) (err error) {
+	f := &tw.firing
+	for t := l.head; t != nil; t = l.head {
+		l.remove(t)
+		f.push(t)
+	}
+	defer func() {
+		if f.head == nil {
+			return
+		}
+		for t := f.head; t != nil; t = f.head {
+			f.remove(t)
+			l.push(t)
+		}
+		tw.current--
+	}()
+	for t := f.head; t != nil; t = f.head {
+		f.remove(t)
+		if err = t.job(); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	start := time.Now()
+	tw := NewTimingWheel(time.Millisecond, start)
+	var (
+		fired   = make(map[int]uint64)
+		stopped = make(map[int]bool)
+		timers  []*Timer
+	)
+	// Cover all levels of the wheel, including the timers expiring right at the boundaries.
+	ticks := []int{1, 2, 255, 256, 257, 65535, 65536, 65537, 1 << 20}
+	for i := 0; i < 1000; i++ {
+		ticks = append(ticks, 1+rand.Intn(1<<18))
+	}
+	for i, n := range ticks {
+		i := i
+		timers = append(timers, tw.AfterFunc(time.Duration(n)*time.Millisecond, func() error {
+			fired[i] = tw.current
+			return nil
+		}))
+	}
+	for i := range ticks {
+		if i%7 == 3 {
+			if !timers[i].Stop() {
+				t.Fatalf("failed to stop timer %d", i)
+			}
+			stopped[i] = true
+		}
+	}
+	if tw.Len() != len(ticks)-len(stopped) {
+		t.Fatalf("expected %d pending timers, got %d", len(ticks)-len(stopped), tw.Len())
+	}
+	// Advance the wheel unevenly like a busy event-loop does.
+	for now := start; tw.Len() > 0; now = now.Add(time.Duration(1+rand.Intn(3000)) * time.Millisecond) {
+		if err := tw.Advance(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, n := range ticks {
+		if stopped[i] {
+			if _, ok := fired[i]; ok {
+				t.Fatalf("stopped timer %d fired", i)
+			}
+			continue
+		}
+		if fired[i] != uint64(n) {
+			t.Fatalf("timer %d is expected to fire at tick %d, fired at %d", i, n, fired[i])
+		}
+	}
+	if timers[0].Stop() {
+		t.Fatalf("fired timer is stopped")
+	}
+}
+
+func TestTimingWheelCatchUp(t *testing.T) {
+	start := time.Now()
+	tw := NewTimingWheel(time.Millisecond, start)
+	_ = tw.Advance(start.Add(time.Hour))
+	var fired bool
+	tw.AfterFunc(time.Second, func() error {
+		fired = true
+		return nil
+	})
+	if _ = tw.Advance(start.Add(time.Hour + time.Second/2)); fired {
+		t.Fatalf("timer fired early after the idle wheel caught up")
+	}
+	if _ = tw.Advance(start.Add(time.Hour + time.Second)); !fired {
+		t.Fatalf("timer didn't fire in time")
+	}
+}
+
+func TestTimingWheelNext(t *testing.T) {
+	start := time.Now()
+	tw := NewTimingWheel(time.Millisecond, start)
+	if d := tw.Next(start); d >= 0 {
+		t.Fatalf("expected no deadline for the empty wheel, got %v", d)
+	}
+	far := tw.AfterFunc(time.Second, func() error { return nil })
+	// The timers of higher levels wake the wheel up when level 0 wraps around.
+	if d := tw.Next(start); d != wheelSize*time.Millisecond {
+		t.Fatalf("expected %v until the timers are moved down, got %v", wheelSize*time.Millisecond, d)
+	}
+	tw.AfterFunc(10*time.Millisecond, func() error { return nil })
+	if d := tw.Next(start.Add(4 * time.Millisecond)); d != 6*time.Millisecond {
+		t.Fatalf("expected %v until the earliest timer, got %v", 6*time.Millisecond, d)
+	}
+	if d := tw.Next(start.Add(time.Second)); d != 0 {
+		t.Fatalf("expected the overdue wheel to be advanced at once, got %v", d)
+	}
+	// Follow the deadlines like the poller does, which must reach the far timer without any early wake-ups.
+	var wakeups int
+	for now := start; tw.Len() > 0; wakeups++ {
+		now = now.Add(tw.Next(now))
+		if err := tw.Advance(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wakeups != 5 || far.Stop() {
+		t.Fatalf("expected the timers to fire in 5 wake-ups, got %d", wakeups)
+	}
+}
+
+func TestTimingWheelPanic(t *testing.T) {
+	start := time.Now()
+	tw := NewTimingWheel(time.Millisecond, start)
+	var fired int
+	tw.AfterFunc(time.Millisecond, func() error { panic("boom") })
+	tw.AfterFunc(time.Millisecond, func() error {
+		fired++
+		return nil
+	})
+	func() {
+		defer func() { _ = recover() }()
+		_ = tw.Advance(start.Add(time.Millisecond))
+	}()
+	if fired != 0 || tw.Len() != 1 {
+		t.Fatalf("expected the timer after the panicking one to be pending, got %d fired and %d pending", fired, tw.Len())
+	}
+	// The timer left by the panic is due right away rather than a revolution later.
+	if d := tw.Next(start.Add(time.Millisecond)); d != 0 {
+		t.Fatalf("expected the timer left by the panic to be due, got %v", d)
+	}
+	if _ = tw.Advance(start.Add(time.Millisecond)); fired != 1 || tw.Len() != 0 {
+		t.Fatalf("expected the timer left by the panic to fire, got %d fired and %d pending", fired, tw.Len())
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// The states of a connection whose OnOpened runs on the workers of LifecycleWorkers.
+const (
+	lifecycleOpening = 1 + iota // OnOpened is running or queued
+	lifecycleClosing            // closed while OnOpened is running, OnClosed is run right after it
+)
+
+// lifecyclePool runs OnOpened and OnClosed of the connections on a fixed number of goroutines,
+// the jobs beyond them are queued rather than blocking the event-loops.
+type lifecyclePool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []func()
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newLifecyclePool(workers int) *lifecyclePool {
+	p := new(lifecyclePool)
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *lifecyclePool) submit(job func()) {
+	p.mu.Lock()
+	p.jobs = append(p.jobs, job)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+func (p *lifecyclePool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.jobs) == 0 && !p.stopped {
+			p.cond.Wait()
+		}
+		if len(p.jobs) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := p.jobs[0]
+		p.jobs[0] = nil
+		p.jobs = p.jobs[1:]
+		p.mu.Unlock()
+		job()
+	}
+}
+
+// stop waits for the queued jobs to be done and the workers to exit.
+func (p *lifecyclePool) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	p.wg.Wait()
+}
+
+// runLifecycle runs the handler on a worker, the panic in it is reported unless DisablePanicRecovery is set.
+func (el *eventloop) runLifecycle(c *conn, handler func()) (panicked bool) {
+	if !el.svr.opts.DisablePanicRecovery {
+		defer func() {
+			if p := recover(); p != nil {
+				el.svr.reportPanic(el.idx, c, p, debug.Stack())
+				panicked = true
+			}
+		}()
+	}
+	handler()
+	return
+}
+
+// openAsync runs OnOpened of the connection on the workers, the connection is not polled until it returns.
+func (el *eventloop) openAsync(c *conn) {
+	atomic.StoreInt32(&c.lifecycle, lifecycleOpening)
+	el.svr.lifecycle.submit(func() {
+		var (
+			out    []byte
+			action Action
+		)
+		panicked := el.runLifecycle(c, func() {
+			out, action = el.eventHandler.OnOpened(c)
+		})
+		if !atomic.CompareAndSwapInt32(&c.lifecycle, lifecycleOpening, 0) {
+			c.closedJob() // closed while opening
+			return
+		}
+		_ = c.trigger(func() error {
+			if panicked {
+				return c.loop.loopCloseConn(c, ErrPanic)
+			}
+			return c.loop.loopOpened(c, out, action)
+		})
+	})
+}
+
+// closeAsync runs OnClosed of the closed connection on the workers, right after OnOpened if it is still running,
+// the context, the addresses and the buffers of the connection are released after OnClosed.
+func (el *eventloop) closeAsync(c *conn, err error) {
+	c.stopTCP()
+	c.closedJob = func() {
+		var action Action
+		_ = el.runLifecycle(c, func() {
+			action = el.eventHandler.OnClosed(c, err)
+		})
+		c.releaseBuffers()
+		if action == Shutdown {
+			_ = el.execute(el.shutdown)
+		}
+	}
+	if !atomic.CompareAndSwapInt32(&c.lifecycle, lifecycleOpening, lifecycleClosing) {
+		el.svr.lifecycle.submit(c.closedJob)
+	}
+}
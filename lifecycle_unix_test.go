@@ -0,0 +1,91 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycleWorkers(t *testing.T) {
+	events := &testLifecycleServer{network: "tcp", addr: "127.0.0.1:9945", release: make(chan struct{})}
+	must(Serve(events, "tcp://127.0.0.1:9945", WithLifecycleWorkers(2), WithNumEventLoop(1)))
+	if closed := atomic.LoadInt32(&events.closed); closed != 2 {
+		t.Fatalf("expected OnClosed of 2 opened connections, got %d", closed)
+	}
+}
+
+type testLifecycleServer struct {
+	*EventServer
+	network, addr string
+	opened        int32
+	closed        int32
+	release       chan struct{}
+}
+
+func (s *testLifecycleServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		conns := make([]net.Conn, 2)
+		for i := range conns {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			_, err = c.Write([]byte("ping"))
+			must(err)
+			conns[i] = c
+		}
+		// The connection opened first is served after the other one, which is served on the same event-loop
+		// while the first one is still opening.
+		for _, c := range conns {
+			if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "pong\n" {
+				panic(fmt.Sprintf("unexpected response: %q, %v", line, err))
+			}
+			must(c.Close())
+		}
+	}()
+	return
+}
+
+func (s *testLifecycleServer) OnOpened(c Conn) (out []byte, action Action) {
+	if atomic.AddInt32(&s.opened, 1) == 1 {
+		select {
+		case <-s.release:
+		case <-time.After(time.Second * 3):
+			panic("the other connection is not served while the first one is opening")
+		}
+	}
+	c.SetContext("opened")
+	return
+}
+
+func (s *testLifecycleServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.Context() != "opened" {
+		panic("connection is read before OnOpened returns")
+	}
+	if atomic.LoadInt32(&s.opened) == 2 {
+		select {
+		case <-s.release:
+		default:
+			close(s.release)
+		}
+	}
+	return []byte("pong\n"), None
+}
+
+func (s *testLifecycleServer) OnClosed(c Conn, err error) (action Action) {
+	if c.Context() != "opened" {
+		panic("OnClosed fires before OnOpened returns")
+	}
+	if atomic.AddInt32(&s.closed, 1) == 2 {
+		action = Shutdown
+	}
+	return
+}
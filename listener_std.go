@@ -3,7 +3,7 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// +build windows
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
 
 package gnet
 
@@ -33,7 +33,7 @@ func (ln *listener) close() {
 		if ln.pconn != nil {
 			sniffErrorAndLog(ln.pconn.Close())
 		}
-		if ln.network == "unix" {
+		if isUnixNetwork(ln.network) {
 			sniffErrorAndLog(os.RemoveAll(ln.addr))
 		}
 	})
@@ -8,6 +8,7 @@
 package gnet
 
 import (
+	"context"
 	"net"
 	"os"
 	"sync"
@@ -34,6 +35,12 @@ func (ln *listener) renormalize() error {
 		switch pconn := ln.pconn.(type) {
 		case *net.UDPConn:
 			ln.f, err = pconn.File()
+		case *net.UnixConn:
+			ln.f, err = pconn.File()
+		case *net.IPConn:
+			ln.f, err = pconn.File()
+		case interface{ File() (*os.File, error) }:
+			ln.f, err = pconn.File()
 		}
 	case *net.TCPListener:
 		ln.f, err = netln.File()
@@ -48,6 +55,24 @@ func (ln *listener) renormalize() error {
 	return unix.SetNonblock(ln.fd, true)
 }
 
+// clone opens another listener on the same address in the SO_REUSEPORT group of this listener.
+func (ln *listener) clone(opts *Options) (*listener, error) {
+	var (
+		err error
+		nl  = &listener{network: ln.network, addr: ln.lnaddr.String(), lnaddr: ln.lnaddr}
+		lc  = listenConfig(opts, true)
+	)
+	if ln.pconn != nil {
+		nl.pconn, err = lc.ListenPacket(context.Background(), nl.network, nl.addr)
+	} else {
+		nl.ln, err = lc.Listen(context.Background(), nl.network, nl.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nl, nl.renormalize()
+}
+
 func (ln *listener) close() {
 	ln.once.Do(
 		func() {
@@ -60,7 +85,7 @@ func (ln *listener) close() {
 			if ln.pconn != nil {
 				sniffErrorAndLog(ln.pconn.Close())
 			}
-			if ln.network == "unix" {
+			if isUnixNetwork(ln.network) {
 				sniffErrorAndLog(os.RemoveAll(ln.addr))
 			}
 		})
@@ -6,6 +6,7 @@ package gnet
 
 import (
 	"container/heap"
+	"net"
 	"sync"
 	"sync/atomic"
 )
@@ -37,7 +38,7 @@ type (
 
 	// roundRobinEventLoopSet with Round-Robin algorithm.
 	roundRobinEventLoopSet struct {
-		nextLoopIndex int
+		nextLoopIndex uint32
 		eventLoops    []*eventloop
 		size          int
 	}
@@ -58,6 +59,17 @@ type (
 	}
 )
 
+// pickEventLoop returns the event-loop picked by EventLoopPicker for the connection from the given address,
+// nil is returned if it is not set or the picked index is out of range.
+func (svr *server) pickEventLoop(addr net.Addr) *eventloop {
+	if picker := svr.opts.EventLoopPicker; picker != nil {
+		if i := picker(addr, len(svr.loops)); i >= 0 && i < len(svr.loops) {
+			return svr.loops[i]
+		}
+	}
+	return nil
+}
+
 // ==================================== Implementation of Round-Robin load-balancer ====================================
 
 func (set *roundRobinEventLoopSet) register(el *eventloop) {
@@ -67,12 +79,10 @@ func (set *roundRobinEventLoopSet) register(el *eventloop) {
 }
 
 // next returns the eligible event-loop based on Round-Robin algorithm.
+// It is safe to be called by multiple acceptors concurrently.
 func (set *roundRobinEventLoopSet) next(_ int) (el *eventloop) {
-	el = set.eventLoops[set.nextLoopIndex]
-	if set.nextLoopIndex++; set.nextLoopIndex >= set.size {
-		set.nextLoopIndex = 0
-	}
-	return
+	idx := atomic.AddUint32(&set.nextLoopIndex, 1) - 1
+	return set.eventLoops[int(idx%uint32(set.size))]
 }
 
 func (set *roundRobinEventLoopSet) iterate(f func(int, *eventloop) bool) {
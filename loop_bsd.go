@@ -6,28 +6,78 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
+import (
+	"time"
 
-func (el *eventloop) handleEvent(fd int, filter int16) error {
+	"github.com/panjf2000/gnet/internal/netpoll"
+)
+
+func (el *eventloop) handleEvent(fd int, filter int16) (err error) {
 	if c, ok := el.connections[fd]; ok {
+		el.current = c
 		if filter == netpoll.EVFilterSock {
-			return el.loopCloseConn(c, nil)
+			err = el.loopCloseConn(c, ErrEOF)
+			el.current = nil
+			return
 		}
 		switch c.outboundBuffer.IsEmpty() {
 		// Don't change the ordering of processing EVFILT_WRITE | EVFILT_READ | EV_ERROR/EV_EOF unless you're 100%
 		// sure what you're doing!
 		// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
 		case false:
-			if filter == netpoll.EVFilterWrite {
-				return el.loopWrite(c)
+			switch {
+			case c.writesHeld():
+				// Pacing holds the writes but not the reads, which are not polled otherwise.
+				if filter == netpoll.EVFilterRead {
+					err = el.loopRead(c)
+				}
+			case filter == netpoll.EVFilterWrite:
+				err = el.loopWrite(c)
 			}
-			return nil
 		case true:
 			if filter == netpoll.EVFilterRead {
-				return el.loopRead(c)
+				err = el.loopRead(c)
 			}
-			return nil
 		}
+		el.current = nil
+		return
+	}
+	if fd == el.ln.fd && el.ln.pconn != nil && filter == netpoll.EVFilterWrite {
+		el.flushUDP(fd)
+		return
 	}
 	return el.loopAccept(fd)
 }
+
+// startTicker fires the first Tick in the event-loop, the subsequent ones are scheduled with EVFILT_TIMER
+// in kqueue, which saves the extra goroutine and the wake-ups of poller for ticking.
+func (el *eventloop) startTicker() {
+	sniffErrorAndLog(el.poller.Trigger(el.tick))
+}
+
+// stopTicker does nothing since the timer will be released along with kqueue.
+func (el *eventloop) stopTicker() {
+}
+
+// tick fires Tick and arms the timer for the next one, the timer is armed in a deferred call so that ticking
+// goes on with the last delay if Tick panics and the panic is recovered by the event-loop.
+func (el *eventloop) tick() (err error) {
+	var (
+		delay  = el.lastTick
+		action Action
+	)
+	defer func() {
+		if action == Shutdown {
+			return
+		}
+		el.lastTick = delay
+		if e := el.poller.SetTimer(tickDelay(el.svr.opts, delay, time.Now()), el.tick); e != nil {
+			el.svr.logger().Printf("failed to set timer with error:%v, stopping ticker\n", e)
+		}
+	}()
+	delay, action = el.eventHandler.Tick()
+	if action == Shutdown {
+		return el.shutdown()
+	}
+	return nil
+}
@@ -6,25 +6,84 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
+import (
+	"time"
 
-func (el *eventloop) handleEvent(fd int, ev uint32) error {
+	"github.com/panjf2000/gnet/internal/netpoll"
+)
+
+func (el *eventloop) handleEvent(fd int, ev uint32) (err error) {
 	if c, ok := el.connections[fd]; ok {
+		el.current = c
 		switch c.outboundBuffer.IsEmpty() {
 		// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 		// sure what you're doing!
 		// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
 		case false:
-			if ev&netpoll.OutEvents != 0 {
-				return el.loopWrite(c)
+			switch {
+			case c.writesHeld():
+				// Pacing holds the writes but not the reads, which are not polled otherwise.
+				if ev&netpoll.InEvents != 0 {
+					err = el.loopRead(c)
+				}
+			case ev&netpoll.OutEvents != 0:
+				err = el.loopWrite(c)
 			}
-			return nil
 		case true:
 			if ev&netpoll.InEvents != 0 {
-				return el.loopRead(c)
+				err = el.loopRead(c)
 			}
-			return nil
+		}
+		el.current = nil
+		return
+	}
+	if fd == el.ln.fd && el.ln.pconn != nil && ev&netpoll.OutEvents != 0 {
+		el.flushUDP(fd)
+		if ev&netpoll.InEvents == 0 {
+			return
 		}
 	}
 	return el.loopAccept(fd)
 }
+
+// startTicker starts the goroutine which fires Tick in the event-loop.
+func (el *eventloop) startTicker() {
+	go el.loopTicker()
+}
+
+// stopTicker stops the goroutine which fires Tick.
+func (el *eventloop) stopTicker() {
+	close(el.svr.ticktock)
+}
+
+func (el *eventloop) loopTicker() {
+	var (
+		delay time.Duration
+		open  bool
+		err   error
+	)
+	for {
+		err = el.poller.Trigger(func() (err error) {
+			// The delay is handed over in a deferred call, so that ticking goes on with the last delay if Tick
+			// panics and the panic is recovered by the event-loop.
+			next, action := delay, None
+			defer func() { el.svr.ticktock <- next }()
+			next, action = el.eventHandler.Tick()
+			switch action {
+			case None:
+			case Shutdown:
+				err = el.shutdown()
+			}
+			return
+		})
+		if err != nil {
+			el.svr.logger().Printf("failed to awake poller with error:%v, stopping ticker\n", err)
+			break
+		}
+		if delay, open = <-el.svr.ticktock; open {
+			time.Sleep(tickDelay(el.svr.opts, delay, time.Now()))
+		} else {
+			break
+		}
+	}
+}
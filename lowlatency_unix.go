@@ -0,0 +1,134 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// The states of the direct writes of SetLowLatency, a goroutine writes the socket once it switches the state from
+// directIdle to directBusy, and the event-loop takes the socket from directIdle to directLoop, the other goroutines
+// queue their writes to the event-loop as usual. No goroutine writes directly while any AsyncWrite is queued, so
+// that the writes of a goroutine are never reordered.
+const (
+	directOff     int32 = iota // low-latency mode is off
+	directIdle                 // the outbound buffer is empty and nobody is writing the socket
+	directBusy                 // a goroutine is writing the socket
+	directPending              // a direct write left its rest in directRest for the event-loop
+	directLoop                 // the event-loop is writing the socket or the outbound buffer is not empty
+)
+
+func (c *conn) SetLowLatency(on bool) {
+	if c.loop == nil || !c.opened {
+		return
+	}
+	if !on {
+		c.stopDirect()
+		return
+	}
+	opts := c.loop.svr.opts
+	if c.loop.svr.ln.pconn != nil || c.udpPeer != "" || c.deflater != nil || c.pacer != nil || opts.WriteBuffering ||
+		opts.WriteCoalesceWindow > 0 || opts.TrafficTap != nil || opts.Tracer != nil ||
+		opts.LoopTaskQueueCap > 0 && opts.LoopTaskQueueOverload == OverloadDropOldest {
+		// The queued writes dropped by OverloadDropOldest would hold the direct writes off for good.
+		return
+	}
+	if atomic.LoadInt32(&c.direct) == directOff {
+		c.releaseSocket()
+	}
+}
+
+// writeDirect writes the data to the socket from the calling goroutine if the connection is in low-latency mode
+// and the socket is free, the rest which can't be written at once is handed over to the event-loop, which
+// writes it before anything else. It returns false if the data must be queued to the event-loop instead.
+func (c *conn) writeDirect(buf []byte) bool {
+	if atomic.LoadInt32(&c.direct) != directIdle || atomic.LoadInt32(&c.queuedWrites) != 0 ||
+		!atomic.CompareAndSwapInt32(&c.direct, directIdle, directBusy) {
+		return false
+	}
+	n, err := unix.Write(c.fd, buf)
+	if n < 0 {
+		n = 0
+	}
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		atomic.AddUint64(&c.owner().bytesOut, uint64(n))
+		if c.peer != nil {
+			c.peer.addOut(n)
+		}
+	}
+	if err == nil && n == len(buf) {
+		atomic.StoreInt32(&c.direct, directIdle)
+		return true
+	}
+	// The event-loop fails the connection if the error is not EAGAIN when it writes the rest.
+	c.directRest = append(c.directRest[:0], buf[n:]...)
+	atomic.StoreInt32(&c.direct, directPending)
+	_ = c.trigger(func() error {
+		if c.opened {
+			if c.takeSocket() {
+				c.releaseSocket()
+			}
+			c.loop.accountMemory(c)
+		}
+		return nil
+	})
+	return true
+}
+
+// takeSocket is called by the event-loop before writing to the socket of the connection in low-latency mode, it
+// waits for the direct write in progress and buffers the rest of it. It returns true if the socket is taken from
+// directIdle, which must be released by releaseSocket after the write.
+func (c *conn) takeSocket() bool {
+	for {
+		switch atomic.LoadInt32(&c.direct) {
+		case directIdle:
+			if atomic.CompareAndSwapInt32(&c.direct, directIdle, directLoop) {
+				return true
+			}
+		case directBusy:
+			// The direct write is a single non-blocking write(2).
+			runtime.Gosched()
+		case directPending:
+			c.bufferOutbound(c.directRest)
+			c.directRest = c.directRest[:0]
+			atomic.StoreInt32(&c.direct, directLoop)
+			_ = c.loop.poller.ModReadWrite(c.fd)
+			return false
+		default:
+			return false
+		}
+	}
+}
+
+// releaseSocket lets the goroutines write the socket directly again if the outbound buffer is empty.
+func (c *conn) releaseSocket() {
+	if c.outboundBuffer.IsEmpty() {
+		atomic.StoreInt32(&c.direct, directIdle)
+	} else {
+		atomic.StoreInt32(&c.direct, directLoop)
+	}
+}
+
+// drainedSocket is called by the event-loop once the outbound buffer is flushed.
+func (c *conn) drainedSocket() {
+	if atomic.LoadInt32(&c.direct) == directLoop {
+		atomic.StoreInt32(&c.direct, directIdle)
+	}
+}
+
+// stopDirect turns low-latency mode off, the rest of the direct write is buffered, it must be called before
+// the socket is closed, so that no goroutine writes to the file descriptor reused by another connection.
+func (c *conn) stopDirect() {
+	if atomic.LoadInt32(&c.direct) != directOff {
+		c.takeSocket()
+		atomic.StoreInt32(&c.direct, directOff)
+	}
+}
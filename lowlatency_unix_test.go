@@ -0,0 +1,147 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLowLatency(t *testing.T) {
+	events := &testLowLatencyServer{network: "tcp", addr: ":9937", written: make(chan uint64, 1)}
+	must(Serve(events, "tcp://:9937"))
+	if n := <-events.written; n != uint64(len("hello")) {
+		t.Fatalf("expected the direct write to be done when AsyncWrite returns, got %d bytes written", n)
+	}
+}
+
+type testLowLatencyServer struct {
+	*EventServer
+	network, addr string
+	written       chan uint64
+}
+
+// lowLatencyBig is larger than the socket buffers, so that the direct write leaves the rest to the event-loop.
+var lowLatencyBig = bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+
+func (s *testLowLatencyServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 10)))
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		expected := append(append([]byte("hello"), lowLatencyBig...), "pongbye"...)
+		buf := make([]byte, len(expected))
+		// Let the direct write fill up the socket buffers.
+		time.Sleep(time.Millisecond * 100)
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if !bytes.Equal(buf, expected) {
+			panic("the writes are out of order")
+		}
+	}()
+	return
+}
+
+func (s *testLowLatencyServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	c.SetLowLatency(true)
+	done := make(chan struct{})
+	go func() {
+		must(c.AsyncWrite([]byte("hello")))
+		s.written <- c.BytesWritten()
+		must(c.AsyncWrite(lowLatencyBig))
+		close(done)
+	}()
+	<-done
+	// The reply of React follows the rest of the direct write, so does the write after it.
+	must(c.AsyncWrite([]byte("bye")))
+	return []byte("pong"), None
+}
+
+func (s *testLowLatencyServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestLowLatencyOrder(t *testing.T) {
+	events := &testLowLatencyOrderServer{network: "tcp", addr: ":9931"}
+	must(Serve(events, "tcp://:9931"))
+}
+
+type testLowLatencyOrderServer struct {
+	*EventServer
+	network, addr string
+}
+
+const (
+	lowLatencyWriters = 8
+	lowLatencyFrames  = 20000
+)
+
+func (s *testLowLatencyOrderServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 10)))
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		// Let the big write fill up the socket buffers, then start the writers while the rest of it is buffered.
+		time.Sleep(time.Millisecond * 100)
+		_, err = c.Write([]byte("go"))
+		must(err)
+		_, err = io.ReadFull(c, make([]byte, len(lowLatencyBig)))
+		must(err)
+		// Each frame carries its writer and its sequence number, the frames of a writer must arrive in order.
+		var next [lowLatencyWriters]uint32
+		r, frame := bufio.NewReader(c), make([]byte, 8)
+		for i := 0; i < lowLatencyWriters*lowLatencyFrames; i++ {
+			_, err = io.ReadFull(r, frame)
+			must(err)
+			writer, seq := binary.BigEndian.Uint32(frame), binary.BigEndian.Uint32(frame[4:])
+			if seq != next[writer] {
+				panic(fmt.Sprintf("writer %d: expected frame %d, got %d", writer, next[writer], seq))
+			}
+			next[writer]++
+		}
+	}()
+	return
+}
+
+func (s *testLowLatencyOrderServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "ping" {
+		c.SetLowLatency(true)
+		must(c.AsyncWrite(lowLatencyBig))
+		return
+	}
+	for i := 0; i < lowLatencyWriters; i++ {
+		go func(writer uint32) {
+			frame := make([]byte, 8)
+			binary.BigEndian.PutUint32(frame, writer)
+			for seq := uint32(0); seq < lowLatencyFrames; seq++ {
+				binary.BigEndian.PutUint32(frame[4:], seq)
+				must(c.AsyncWrite(frame))
+				// Keep writing after the big write is flushed.
+				if seq%1000 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(uint32(i))
+	}
+	return
+}
+
+func (s *testLowLatencyOrderServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
@@ -0,0 +1,719 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package memcache is the toolkit of building memcached-compatible servers on gnet, it decodes the complete
+// commands of both the text and the binary protocols of memcached from the stream, which are told apart by the
+// first byte of every command, and builds the replies in the protocol of the commands, so that a cache server is
+// written once for both protocols.
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/panjf2000/gnet"
+)
+
+const (
+	// HeaderLen is the length of the header of binary requests and responses.
+	HeaderLen = 24
+
+	// MaxKeyLength is the maximum length of keys.
+	MaxKeyLength = 250
+
+	// MaxLineLength is the maximum length of the command lines of text protocol.
+	MaxLineLength = 2048
+
+	// DefaultMaxValueLength is the maximum length of values if it is not given to NewCodec, which is the default
+	// item size limit of memcached.
+	DefaultMaxValueLength = 1 << 20
+
+	magicRequest  = 0x80
+	magicResponse = 0x81
+)
+
+// Protocol is the protocol of a request.
+type Protocol int
+
+const (
+	// Text is the text protocol of memcached.
+	Text Protocol = iota
+
+	// Binary is the binary protocol of memcached.
+	Binary
+)
+
+// Opcode is the command of binary requests.
+type Opcode byte
+
+// The opcodes of binary protocol, the ones ending with Q are the quiet versions and the ones ending with K
+// return the keys.
+const (
+	OpGet        Opcode = 0x00
+	OpSet        Opcode = 0x01
+	OpAdd        Opcode = 0x02
+	OpReplace    Opcode = 0x03
+	OpDelete     Opcode = 0x04
+	OpIncrement  Opcode = 0x05
+	OpDecrement  Opcode = 0x06
+	OpQuit       Opcode = 0x07
+	OpFlush      Opcode = 0x08
+	OpGetQ       Opcode = 0x09
+	OpNoop       Opcode = 0x0a
+	OpVersion    Opcode = 0x0b
+	OpGetK       Opcode = 0x0c
+	OpGetKQ      Opcode = 0x0d
+	OpAppend     Opcode = 0x0e
+	OpPrepend    Opcode = 0x0f
+	OpStat       Opcode = 0x10
+	OpSetQ       Opcode = 0x11
+	OpAddQ       Opcode = 0x12
+	OpReplaceQ   Opcode = 0x13
+	OpDeleteQ    Opcode = 0x14
+	OpIncrementQ Opcode = 0x15
+	OpDecrementQ Opcode = 0x16
+	OpQuitQ      Opcode = 0x17
+	OpFlushQ     Opcode = 0x18
+	OpAppendQ    Opcode = 0x19
+	OpPrependQ   Opcode = 0x1a
+	OpTouch      Opcode = 0x1c
+	OpGAT        Opcode = 0x1d
+	OpGATQ       Opcode = 0x1e
+	OpGATK       Opcode = 0x23
+	OpGATKQ      Opcode = 0x24
+)
+
+// opcodes maps the binary opcodes to the commands of text protocol.
+var opcodes = map[Opcode]struct {
+	command string
+	quiet   bool
+}{
+	OpGet:        {"get", false},
+	OpGetQ:       {"get", true},
+	OpGetK:       {"get", false},
+	OpGetKQ:      {"get", true},
+	OpSet:        {"set", false},
+	OpSetQ:       {"set", true},
+	OpAdd:        {"add", false},
+	OpAddQ:       {"add", true},
+	OpReplace:    {"replace", false},
+	OpReplaceQ:   {"replace", true},
+	OpAppend:     {"append", false},
+	OpAppendQ:    {"append", true},
+	OpPrepend:    {"prepend", false},
+	OpPrependQ:   {"prepend", true},
+	OpDelete:     {"delete", false},
+	OpDeleteQ:    {"delete", true},
+	OpIncrement:  {"incr", false},
+	OpIncrementQ: {"incr", true},
+	OpDecrement:  {"decr", false},
+	OpDecrementQ: {"decr", true},
+	OpQuit:       {"quit", false},
+	OpQuitQ:      {"quit", true},
+	OpFlush:      {"flush_all", false},
+	OpFlushQ:     {"flush_all", true},
+	OpNoop:       {"noop", false},
+	OpVersion:    {"version", false},
+	OpStat:       {"stats", false},
+	OpTouch:      {"touch", false},
+	OpGAT:        {"gat", false},
+	OpGATQ:       {"gat", true},
+	OpGATK:       {"gat", false},
+	OpGATKQ:      {"gat", true},
+}
+
+// Status is the status of replies, which is the status of binary responses and mapped to the replies of text
+// protocol.
+type Status uint16
+
+// The statuses of replies.
+const (
+	StatusOK             Status = 0x00
+	StatusKeyNotFound    Status = 0x01
+	StatusKeyExists      Status = 0x02
+	StatusValueTooLarge  Status = 0x03
+	StatusInvalidArgs    Status = 0x04
+	StatusNotStored      Status = 0x05
+	StatusNonNumeric     Status = 0x06
+	StatusUnknownCommand Status = 0x81
+	StatusOutOfMemory    Status = 0x82
+)
+
+var (
+	// ErrMalformedCommand occurs when the command doesn't follow the protocols of memcached.
+	ErrMalformedCommand = errors.New("memcache: malformed command")
+
+	// ErrLineTooLong occurs when the command line of text protocol is longer than MaxLineLength.
+	ErrLineTooLong = errors.New("memcache: line too long")
+
+	// ErrKeyTooLong occurs when the key is longer than MaxKeyLength.
+	ErrKeyTooLong = errors.New("memcache: key too long")
+
+	// ErrValueTooLarge occurs when the value is larger than the limit of the codec.
+	ErrValueTooLarge = errors.New("memcache: value too large")
+
+	errIncomplete = errors.New("memcache: incomplete command")
+)
+
+// NewCodec returns the codec decoding the complete commands of both protocols of memcached, the command line along
+// with the data block of text protocol, or the header along with the body of binary protocol. The values larger
+// than maxValueLength, DefaultMaxValueLength if it is not positive, are not buffered, the commands of them are
+// decoded without the values, on which Parse fails with ErrValueTooLarge. Encode writes the replies as they are.
+func NewCodec(maxValueLength int) gnet.ICodec {
+	if maxValueLength <= 0 {
+		maxValueLength = DefaultMaxValueLength
+	}
+	return &codec{maxValueLength: maxValueLength}
+}
+
+type codec struct {
+	maxValueLength int
+}
+
+func (cc *codec) Encode(c gnet.Conn, buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+func (cc *codec) Decode(c gnet.Conn) ([]byte, error) {
+	buf := c.Read()
+	n, err := cc.frameLength(buf)
+	if err != nil {
+		return nil, err
+	}
+	c.ShiftN(n)
+	return buf[:n], nil
+}
+
+// frameLength returns the length of the command at the beginning of buf, the malformed or oversized commands which
+// can't be framed are returned as the frames for Parse to report them.
+func (cc *codec) frameLength(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, errIncomplete
+	}
+	if buf[0] == magicRequest {
+		if len(buf) < HeaderLen {
+			return 0, errIncomplete
+		}
+		body := uint64(binary.BigEndian.Uint32(buf[8:]))
+		if body > uint64(cc.maxValueLength+MaxKeyLength+maxExtrasLen) {
+			return len(buf), nil
+		}
+		if n := HeaderLen + int(body); len(buf) >= n {
+			return n, nil
+		}
+		return 0, errIncomplete
+	}
+
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if len(buf) > MaxLineLength {
+			return len(buf), nil
+		}
+		return 0, errIncomplete
+	}
+	if i >= MaxLineLength {
+		return i + 1, nil
+	}
+	size, ok := dataLength(bytes.Fields(buf[:i]))
+	if !ok || size > cc.maxValueLength {
+		return i + 1, nil
+	}
+	if n := i + 1 + size + 2; len(buf) >= n {
+		return n, nil
+	}
+	return 0, errIncomplete
+}
+
+// maxExtrasLen is the length of the largest extras of binary requests, i.e. the ones of incr and decr.
+const maxExtrasLen = 20
+
+// dataLength returns the length of the data block of the text command, ok is false if it carries none.
+func dataLength(fields [][]byte) (size int, ok bool) {
+	if len(fields) == 0 {
+		return
+	}
+	var idx int
+	switch string(fields[0]) {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		idx = 4
+	case "ms":
+		idx = 2
+	default:
+		return
+	}
+	if len(fields) <= idx {
+		return
+	}
+	n, err := strconv.ParseUint(string(fields[idx]), 10, 31)
+	return int(n), err == nil
+}
+
+// Request is a command of memcached, the byte slices in it refer to the frame it is parsed from, thus they are
+// only valid until React returns, see gnet.Conn.RetainFrame if they need to be kept.
+type Request struct {
+	// Protocol is the protocol of the command.
+	Protocol Protocol
+
+	// Command is the command of text protocol, e.g. "get", "gets", "set", "cas", "incr", "mg", etc. The binary
+	// opcodes are mapped to the commands of text protocol, i.e. the get, set, add, replace, append, prepend,
+	// delete, incr, decr, touch and gat families and "flush_all", "noop", "version", "stats" and "quit",
+	// it is empty for the unknown opcodes.
+	Command string
+
+	// Opcode is the opcode of the binary command.
+	Opcode Opcode
+
+	// Keys are the keys of the command, which are more than one for the get and gat families of text protocol.
+	Keys [][]byte
+
+	// Flags are the client flags of the storage commands.
+	Flags uint32
+
+	// Exptime is the expiration time of the storage, touch and gat commands, and the delay of flush_all.
+	Exptime int64
+
+	// CAS is the unique value of cas, or the CAS of binary commands.
+	CAS uint64
+
+	// Delta is the amount of incr and decr.
+	Delta uint64
+
+	// Initial is the initial value of the binary incr and decr on the missing keys.
+	Initial uint64
+
+	// Value is the data of the storage commands.
+	Value []byte
+
+	// NoReply reports whether the successful command is not replied, i.e. noreply of text protocol or the quiet
+	// opcodes of binary protocol, for which the misses of get are not replied instead.
+	NoReply bool
+
+	// Opaque is the opaque of the binary command, which is copied into the response.
+	Opaque uint32
+
+	// Args are the fields of the text command which are not parsed into the fields above, e.g. the flags of the
+	// meta commands, the arguments of stats and verbosity.
+	Args [][]byte
+}
+
+// Key returns the first key of the request, nil if there is none.
+func (r *Request) Key() []byte {
+	if len(r.Keys) == 0 {
+		return nil
+	}
+	return r.Keys[0]
+}
+
+// Parse parses the command decoded by the codec of NewCodec. The returned request is never nil, the protocol and
+// the known fields of it are kept on failure for replying AppendError, after which the connection should be closed
+// because the rest of the stream can't be trusted.
+func Parse(frame []byte) (*Request, error) {
+	if len(frame) > 0 && frame[0] == magicRequest {
+		return parseBinary(frame)
+	}
+	return parseText(frame)
+}
+
+func parseBinary(frame []byte) (*Request, error) {
+	r := &Request{Protocol: Binary}
+	if len(frame) < HeaderLen {
+		return r, ErrMalformedCommand
+	}
+	r.Opcode = Opcode(frame[1])
+	r.Command = opcodes[r.Opcode].command
+	r.NoReply = opcodes[r.Opcode].quiet
+	r.Opaque = binary.BigEndian.Uint32(frame[12:])
+	r.CAS = binary.BigEndian.Uint64(frame[16:])
+
+	keyLen, extLen := int(binary.BigEndian.Uint16(frame[2:])), int(frame[4])
+	body := uint64(binary.BigEndian.Uint32(frame[8:]))
+	// The codec only decodes the oversized commands without their bodies.
+	if body > uint64(len(frame)-HeaderLen) {
+		return r, ErrValueTooLarge
+	}
+	if body != uint64(len(frame)-HeaderLen) || keyLen+extLen > int(body) {
+		return r, ErrMalformedCommand
+	}
+	if keyLen > MaxKeyLength {
+		return r, ErrKeyTooLong
+	}
+	extras := frame[HeaderLen : HeaderLen+extLen]
+	if keyLen > 0 {
+		r.Keys = [][]byte{frame[HeaderLen+extLen : HeaderLen+extLen+keyLen]}
+	}
+	if v := frame[HeaderLen+extLen+keyLen:]; len(v) > 0 {
+		r.Value = v
+	}
+
+	switch r.Command {
+	case "set", "add", "replace":
+		if extLen != 8 {
+			return r, ErrMalformedCommand
+		}
+		r.Flags = binary.BigEndian.Uint32(extras)
+		r.Exptime = int64(binary.BigEndian.Uint32(extras[4:]))
+	case "incr", "decr":
+		if extLen != maxExtrasLen {
+			return r, ErrMalformedCommand
+		}
+		r.Delta = binary.BigEndian.Uint64(extras)
+		r.Initial = binary.BigEndian.Uint64(extras[8:])
+		r.Exptime = int64(binary.BigEndian.Uint32(extras[16:]))
+	case "touch", "gat":
+		if extLen != 4 {
+			return r, ErrMalformedCommand
+		}
+		r.Exptime = int64(binary.BigEndian.Uint32(extras))
+	case "flush_all":
+		if extLen == 4 {
+			r.Exptime = int64(binary.BigEndian.Uint32(extras))
+		} else if extLen != 0 {
+			return r, ErrMalformedCommand
+		}
+	}
+	return r, nil
+}
+
+func parseText(frame []byte) (*Request, error) {
+	r := &Request{Protocol: Text}
+	i := bytes.IndexByte(frame, '\n')
+	if i < 0 || i >= MaxLineLength {
+		return r, ErrLineTooLong
+	}
+	fields := bytes.Fields(frame[:i])
+	if len(fields) == 0 {
+		return r, ErrMalformedCommand
+	}
+	r.Command = string(fields[0])
+	args := fields[1:]
+
+	// The data block follows the command line of the storage commands.
+	if size, ok := dataLength(fields); ok {
+		data := frame[i+1:]
+		// The codec only decodes the oversized commands without their data blocks.
+		if len(data) < size+2 {
+			return r, ErrValueTooLarge
+		}
+		if len(data) != size+2 || data[size] != '\r' || data[size+1] != '\n' {
+			return r, ErrMalformedCommand
+		}
+		r.Value = data[:size]
+	}
+
+	var err error
+	switch r.Command {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		n := 4
+		if r.Command == "cas" {
+			n = 5
+		}
+		args = r.parseNoReply(args)
+		if len(args) != n || r.Value == nil {
+			return r, ErrMalformedCommand
+		}
+		var flags uint64
+		if flags, err = strconv.ParseUint(string(args[1]), 10, 32); err != nil {
+			return r, ErrMalformedCommand
+		}
+		r.Flags = uint32(flags)
+		if r.Exptime, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			return r, ErrMalformedCommand
+		}
+		if n == 5 {
+			if r.CAS, err = strconv.ParseUint(string(args[4]), 10, 64); err != nil {
+				return r, ErrMalformedCommand
+			}
+		}
+		r.Keys = args[:1]
+	case "get", "gets":
+		if len(args) == 0 {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args
+	case "gat", "gats":
+		if len(args) < 2 {
+			return r, ErrMalformedCommand
+		}
+		if r.Exptime, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args[1:]
+	case "delete":
+		// "delete <key> 0" of the old clients is tolerated like memcached does.
+		args = r.parseNoReply(args)
+		if len(args) == 2 && string(args[1]) == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args
+	case "incr", "decr":
+		args = r.parseNoReply(args)
+		if len(args) != 2 {
+			return r, ErrMalformedCommand
+		}
+		if r.Delta, err = strconv.ParseUint(string(args[1]), 10, 64); err != nil {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args[:1]
+	case "touch":
+		args = r.parseNoReply(args)
+		if len(args) != 2 {
+			return r, ErrMalformedCommand
+		}
+		if r.Exptime, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args[:1]
+	case "mg", "ms", "md", "ma", "me":
+		if len(args) == 0 {
+			return r, ErrMalformedCommand
+		}
+		r.Keys = args[:1]
+		if args = args[1:]; r.Command == "ms" {
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			r.Args = args
+		}
+	case "flush_all":
+		args = r.parseNoReply(args)
+		if len(args) > 1 {
+			return r, ErrMalformedCommand
+		}
+		if len(args) == 1 {
+			if r.Exptime, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+				return r, ErrMalformedCommand
+			}
+		}
+	default:
+		if args = r.parseNoReply(args); len(args) > 0 {
+			r.Args = args
+		}
+	}
+	for _, key := range r.Keys {
+		if len(key) > MaxKeyLength {
+			return r, ErrKeyTooLong
+		}
+	}
+	return r, nil
+}
+
+// parseNoReply sets NoReply if the last field is noreply, the rest fields are returned.
+func (r *Request) parseNoReply(args [][]byte) [][]byte {
+	if n := len(args); n > 0 && string(args[n-1]) == "noreply" {
+		r.NoReply = true
+		return args[:n-1]
+	}
+	return args
+}
+
+// getFamily reports whether the request is a get, gets, gat or gats.
+func (r *Request) getFamily() bool {
+	switch r.Command {
+	case "get", "gets", "gat", "gats":
+		return true
+	}
+	return false
+}
+
+// quietGet reports whether the request is a quiet get or gat of binary protocol, of which the misses are not
+// replied rather than the hits.
+func (r *Request) quietGet() bool {
+	return r.NoReply && (r.Command == "get" || r.Command == "gat")
+}
+
+// withKey reports whether the response to the binary request carries the key.
+func (r *Request) withKey() bool {
+	switch r.Opcode {
+	case OpGetK, OpGetKQ, OpGATK, OpGATKQ:
+		return true
+	}
+	return false
+}
+
+// AppendStatus appends the reply of the status to the request, nothing is appended for the successful requests
+// with NoReply, the misses of the quiet get and gat of binary protocol, or the misses of get and gat of text
+// protocol whose replies are ended by AppendEnd. The status is mapped to the replies of text protocol by the
+// command, e.g. StatusOK to "STORED" for set, "DELETED" for delete and "HD" for the meta commands,
+// StatusKeyNotFound to "NOT_FOUND" for delete and "EN" for mg.
+func AppendStatus(dst []byte, r *Request, status Status) []byte {
+	if r.Protocol == Binary {
+		if (status == StatusOK && r.NoReply && !r.quietGet()) || (status == StatusKeyNotFound && r.quietGet()) {
+			return dst
+		}
+		var msg []byte
+		if status != StatusOK {
+			msg = []byte(statusText(status))
+		}
+		return AppendBinaryResponse(dst, r, status, 0, nil, nil, msg)
+	}
+	if (r.NoReply && status == StatusOK) || (status == StatusKeyNotFound && r.getFamily()) {
+		return dst
+	}
+	return append(dst, textStatus(r.Command, status)...)
+}
+
+func textStatus(command string, status Status) string {
+	meta := len(command) == 2 && command[0] == 'm'
+	switch status {
+	case StatusOK:
+		switch {
+		case meta:
+			return "HD\r\n"
+		case command == "set" || command == "add" || command == "replace" || command == "append" ||
+			command == "prepend" || command == "cas":
+			return "STORED\r\n"
+		case command == "delete":
+			return "DELETED\r\n"
+		case command == "touch":
+			return "TOUCHED\r\n"
+		}
+		return "OK\r\n"
+	case StatusKeyNotFound:
+		switch {
+		case command == "mg":
+			return "EN\r\n"
+		case meta:
+			return "NF\r\n"
+		}
+		return "NOT_FOUND\r\n"
+	case StatusKeyExists:
+		if meta {
+			return "EX\r\n"
+		}
+		return "EXISTS\r\n"
+	case StatusNotStored:
+		if meta {
+			return "NS\r\n"
+		}
+		return "NOT_STORED\r\n"
+	case StatusUnknownCommand:
+		return "ERROR\r\n"
+	case StatusValueTooLarge, StatusOutOfMemory:
+		return "SERVER_ERROR " + statusText(status) + "\r\n"
+	}
+	return "CLIENT_ERROR " + statusText(status) + "\r\n"
+}
+
+func statusText(status Status) string {
+	switch status {
+	case StatusKeyNotFound:
+		return "Not found"
+	case StatusKeyExists:
+		return "Data exists for key"
+	case StatusValueTooLarge:
+		return "object too large for cache"
+	case StatusInvalidArgs:
+		return "bad command line format"
+	case StatusNotStored:
+		return "Not stored"
+	case StatusNonNumeric:
+		return "cannot increment or decrement non-numeric value"
+	case StatusUnknownCommand:
+		return "Unknown command"
+	case StatusOutOfMemory:
+		return "out of memory"
+	}
+	return "Unknown error"
+}
+
+// AppendError appends the reply of the error returned by Parse, i.e. "SERVER_ERROR object too large for cache" or
+// StatusValueTooLarge for ErrValueTooLarge and "CLIENT_ERROR" or StatusInvalidArgs for the others, regardless of
+// NoReply.
+func AppendError(dst []byte, r *Request, err error) []byte {
+	if r.Protocol == Binary {
+		status := StatusInvalidArgs
+		if err == ErrValueTooLarge {
+			status = StatusValueTooLarge
+		}
+		return AppendBinaryResponse(dst, r, status, 0, nil, nil, []byte(statusText(status)))
+	}
+	if err == ErrValueTooLarge {
+		return append(dst, "SERVER_ERROR object too large for cache\r\n"...)
+	}
+	dst = append(dst, "CLIENT_ERROR "...)
+	switch err {
+	case ErrLineTooLong:
+		dst = append(dst, "line too long"...)
+	case ErrKeyTooLong:
+		dst = append(dst, "key too long"...)
+	default:
+		dst = append(dst, "bad command line format"...)
+	}
+	return append(dst, "\r\n"...)
+}
+
+// AppendValue appends the item of a hit of get or gat, i.e. "VALUE <key> <flags> <bytes> [<cas>]" along with the
+// data block of text protocol, where the CAS is only written for gets and gats, or the response with the flags,
+// the value and the CAS of binary protocol. Thus the get and gat of both protocols are replied by AppendValue for
+// every hit and AppendStatus with StatusKeyNotFound for every miss, followed by AppendEnd.
+func AppendValue(dst []byte, r *Request, key []byte, flags uint32, value []byte, cas uint64) []byte {
+	if r.Protocol == Binary {
+		var extras [4]byte
+		binary.BigEndian.PutUint32(extras[:], flags)
+		if !r.withKey() {
+			key = nil
+		}
+		return AppendBinaryResponse(dst, r, StatusOK, cas, extras[:], key, value)
+	}
+	dst = append(dst, "VALUE "...)
+	dst = append(dst, key...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendUint(dst, uint64(flags), 10)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(len(value)), 10)
+	if r.Command == "gets" || r.Command == "gats" {
+		dst = append(dst, ' ')
+		dst = strconv.AppendUint(dst, cas, 10)
+	}
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, value...)
+	return append(dst, "\r\n"...)
+}
+
+// AppendEnd appends "END" which ends the items of get and gat of text protocol, nothing is appended for binary
+// protocol.
+func AppendEnd(dst []byte, r *Request) []byte {
+	if r.Protocol == Binary {
+		return dst
+	}
+	return append(dst, "END\r\n"...)
+}
+
+// AppendCounter appends the value of incr or decr after the change, nothing is appended for the requests with
+// NoReply.
+func AppendCounter(dst []byte, r *Request, value, cas uint64) []byte {
+	if r.NoReply {
+		return dst
+	}
+	if r.Protocol == Binary {
+		var body [8]byte
+		binary.BigEndian.PutUint64(body[:], value)
+		return AppendBinaryResponse(dst, r, StatusOK, cas, nil, nil, body[:])
+	}
+	dst = strconv.AppendUint(dst, value, 10)
+	return append(dst, "\r\n"...)
+}
+
+// AppendBinaryResponse appends the response to the binary request with the given status, CAS, extras, key and
+// value, which is the way of replying the binary commands not covered by the other builders, e.g. version and stat.
+func AppendBinaryResponse(dst []byte, r *Request, status Status, cas uint64, extras, key, value []byte) []byte {
+	var h [HeaderLen]byte
+	h[0] = magicResponse
+	h[1] = byte(r.Opcode)
+	binary.BigEndian.PutUint16(h[2:], uint16(len(key)))
+	h[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(h[6:], uint16(status))
+	binary.BigEndian.PutUint32(h[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(h[12:], r.Opaque)
+	binary.BigEndian.PutUint64(h[16:], cas)
+	dst = append(dst, h[:]...)
+	dst = append(dst, extras...)
+	dst = append(dst, key...)
+	return append(dst, value...)
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/codectest"
+	"github.com/panjf2000/gnet/gnettest"
+)
+
+// request builds a binary request.
+func request(op Opcode, opaque uint32, extras, key, value []byte) []byte {
+	h := make([]byte, HeaderLen)
+	h[0] = magicRequest
+	h[1] = byte(op)
+	binary.BigEndian.PutUint16(h[2:], uint16(len(key)))
+	h[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(h[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(h[12:], opaque)
+	return append(append(append(h, extras...), key...), value...)
+}
+
+func setExtras(flags, exptime uint32) []byte {
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras, flags)
+	binary.BigEndian.PutUint32(extras[4:], exptime)
+	return extras
+}
+
+func TestCodec(t *testing.T) {
+	codectest.Run(t, NewCodec(0),
+		[]byte("get a b c\r\n"),
+		[]byte("set k 1 0 5\r\nhe\nlo\r\n"),
+		request(OpSet, 1, setExtras(1, 0), []byte("k"), []byte("value")),
+		[]byte("cas k 0 0 0 42 noreply\r\n\r\n"),
+		request(OpGetK, 2, nil, []byte("k"), nil),
+		[]byte("ms k 3 T0\r\nabc\r\n"),
+		request(OpNoop, 3, nil, nil, nil),
+		[]byte("version\r\n"),
+	)
+}
+
+func TestParseText(t *testing.T) {
+	r, err := Parse([]byte("set k 7 -1 5 noreply\r\nhello\r\n"))
+	if err != nil || r.Protocol != Text || r.Command != "set" || string(r.Key()) != "k" || r.Flags != 7 ||
+		r.Exptime != -1 || string(r.Value) != "hello" || !r.NoReply {
+		t.Fatalf("unexpected set: %+v, %v", r, err)
+	}
+	if r, err = Parse([]byte("cas k 0 0 0 42\r\n\r\n")); err != nil || r.CAS != 42 || r.Value == nil {
+		t.Fatalf("unexpected cas: %+v, %v", r, err)
+	}
+	if r, err = Parse([]byte("gats 60 a b\r\n")); err != nil || r.Exptime != 60 || len(r.Keys) != 2 {
+		t.Fatalf("unexpected gats: %+v, %v", r, err)
+	}
+	if r, err = Parse([]byte("incr n 5\r\n")); err != nil || r.Delta != 5 || string(r.Key()) != "n" {
+		t.Fatalf("unexpected incr: %+v, %v", r, err)
+	}
+	if r, err = Parse([]byte("ms k 2 T0 F1\r\nhi\r\n")); err != nil || string(r.Value) != "hi" || len(r.Args) != 2 {
+		t.Fatalf("unexpected ms: %+v, %v", r, err)
+	}
+	if r, err = Parse([]byte("delete k 0\r\n")); err != nil || string(r.Key()) != "k" {
+		t.Fatalf("unexpected delete: %+v, %v", r, err)
+	}
+	for frame, expected := range map[string]error{
+		"set k 0 0 5\r\nhello!\r\n": ErrMalformedCommand,
+		"set k 0 0\r\n":             ErrMalformedCommand,
+		"incr k x\r\n":              ErrMalformedCommand,
+		"get\r\n":                   ErrMalformedCommand,
+		"get " + strings.Repeat("k", 300) + "\r\n": ErrKeyTooLong,
+	} {
+		if _, err = Parse([]byte(frame)); err != expected {
+			t.Fatalf("expected %v parsing %q, got %v", expected, frame, err)
+		}
+	}
+}
+
+func TestParseBinary(t *testing.T) {
+	r, err := Parse(request(OpSetQ, 9, setExtras(3, 60), []byte("k"), []byte("v")))
+	if err != nil || r.Protocol != Binary || r.Command != "set" || !r.NoReply || r.Flags != 3 || r.Exptime != 60 ||
+		r.Opaque != 9 || string(r.Key()) != "k" || string(r.Value) != "v" {
+		t.Fatalf("unexpected set: %+v, %v", r, err)
+	}
+	extras := make([]byte, 20)
+	binary.BigEndian.PutUint64(extras, 2)
+	binary.BigEndian.PutUint64(extras[8:], 10)
+	if r, err = Parse(request(OpIncrement, 0, extras, []byte("n"), nil)); err != nil || r.Delta != 2 || r.Initial != 10 {
+		t.Fatalf("unexpected incr: %+v, %v", r, err)
+	}
+	if r, err = Parse(request(OpSet, 0, nil, []byte("k"), nil)); err != ErrMalformedCommand || r.Opcode != OpSet {
+		t.Fatalf("expected ErrMalformedCommand for set without extras, got %+v, %v", r, err)
+	}
+}
+
+func TestValueTooLarge(t *testing.T) {
+	cc := NewCodec(4).(*codec)
+	frame := []byte("set k 0 0 5\r\nhello\r\nget k\r\n")
+	n, err := cc.frameLength(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Parse(frame[:n])
+	if err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if out := string(AppendError(nil, r, err)); out != "SERVER_ERROR object too large for cache\r\n" {
+		t.Fatalf("unexpected reply: %q", out)
+	}
+
+	frame = request(OpSet, 7, setExtras(0, 0), []byte("k"), make([]byte, 1024))
+	if n, err = cc.frameLength(frame[:HeaderLen]); err != nil || n != HeaderLen {
+		t.Fatalf("expected the header of the oversized request, got %d, %v", n, err)
+	}
+	if r, err = Parse(frame[:n]); err != ErrValueTooLarge {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	out := AppendError(nil, r, err)
+	if Status(binary.BigEndian.Uint16(out[6:])) != StatusValueTooLarge || binary.BigEndian.Uint32(out[12:]) != 7 {
+		t.Fatalf("unexpected response: %q", out)
+	}
+}
+
+// cache is a memcached server on a map, serving both protocols with the same code.
+type cache struct {
+	*gnet.EventServer
+	items map[string][]byte
+}
+
+func (h *cache) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	r, err := Parse(frame)
+	if err != nil {
+		return AppendError(nil, r, err), gnet.Close
+	}
+	switch r.Command {
+	case "get", "gets":
+		for _, key := range r.Keys {
+			if v, ok := h.items[string(key)]; ok {
+				out = AppendValue(out, r, key, 0, v, 1)
+			} else {
+				out = AppendStatus(out, r, StatusKeyNotFound)
+			}
+		}
+		out = AppendEnd(out, r)
+	case "set":
+		h.items[string(r.Key())] = append([]byte(nil), r.Value...)
+		out = AppendStatus(out, r, StatusOK)
+	case "incr":
+		n, _ := strconv.ParseUint(string(h.items[string(r.Key())]), 10, 64)
+		n += r.Delta
+		h.items[string(r.Key())] = strconv.AppendUint(nil, n, 10)
+		out = AppendCounter(out, r, n, 0)
+	case "noop":
+		out = AppendStatus(out, r, StatusOK)
+	default:
+		out = AppendStatus(out, r, StatusUnknownCommand)
+	}
+	return
+}
+
+func TestServer(t *testing.T) {
+	s := gnettest.NewServer(&cache{items: make(map[string][]byte)}, NewCodec(0))
+	c := s.Dial()
+	c.Send([]byte("set a 0 0 1 noreply\r\n1\r\nset b 0 0 1\r\n"), []byte("2\r\nget a x b\r\ngets a\r\nincr a 2\r\nfoo\r\n"))
+	if out := string(c.Output()); out != "STORED\r\nVALUE a 0 1\r\n1\r\nVALUE b 0 1\r\n2\r\nEND\r\n"+
+		"VALUE a 0 1 1\r\n1\r\nEND\r\n3\r\nERROR\r\n" {
+		t.Fatalf("unexpected replies of text protocol: %q", out)
+	}
+
+	// The quiet gets only reply the hits, the noop after them tells the end.
+	c.Send(bytes.Join([][]byte{
+		request(OpSetQ, 1, setExtras(0, 0), []byte("c"), []byte("v")),
+		request(OpGetKQ, 2, nil, []byte("x"), nil),
+		request(OpGetKQ, 3, nil, []byte("c"), nil),
+		request(OpNoop, 4, nil, nil, nil),
+	}, nil))
+	out := c.Output()
+	var replies []uint32
+	for len(out) >= HeaderLen {
+		if out[0] != magicResponse {
+			t.Fatalf("unexpected magic of response: %x", out[0])
+		}
+		replies = append(replies, binary.BigEndian.Uint32(out[12:]))
+		if Opcode(out[1]) == OpGetKQ {
+			if key := out[HeaderLen+4 : HeaderLen+5]; string(key) != "c" || string(out[HeaderLen+5:HeaderLen+6]) != "v" {
+				t.Fatalf("unexpected response of getkq: %q", out)
+			}
+		}
+		out = out[HeaderLen+int(binary.BigEndian.Uint32(out[8:])):]
+	}
+	if len(replies) != 2 || replies[0] != 3 || replies[1] != 4 {
+		t.Fatalf("expected the responses of the hit and noop, got the opaques %v", replies)
+	}
+
+	if action := c.Send([]byte("get " + strings.Repeat("k", 300) + "\r\n")); action != gnet.Close {
+		t.Fatalf("expected Close for the malformed command, got %v", action)
+	}
+	if out := string(c.Output()); out != "CLIENT_ERROR key too long\r\n" {
+		t.Fatalf("unexpected reply: %q", out)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "sync/atomic"
+
+// MemoryPolicy represents the action that is taken when the memory budget of server is exceeded.
+type MemoryPolicy int
+
+const (
+	// PauseAccept stops accepting new connections until the memory usage drops below the limit,
+	// pending connections stay in the backlog of listener in the meantime.
+	PauseAccept MemoryPolicy = iota
+
+	// CloseMostBuffered closes the connections which hold the most buffered bytes in the event-loop
+	// that exceeds the limit, until the memory usage drops below the limit.
+	CloseMostBuffered
+
+	// NotifyOnly does nothing but invoking the callback set up by WithMemoryLimitHandler.
+	NotifyOnly
+)
+
+// memAccountant tracks the total bytes held in inbound/outbound buffers of all connections.
+type memAccountant struct {
+	usage    int64 // bytes held in buffers currently
+	outbound int64 // bytes held in outbound buffers currently, which is a part of usage
+	limit    int64 // memory budget, 0 means unlimited
+	exceeded int32 // 1 if usage is over limit
+}
+
+func newMemAccountant(opts *Options) *memAccountant {
+	return &memAccountant{limit: opts.MemoryLimit}
+}
+
+// setLimit changes the memory budget and reports whether the usage crosses the new limit like add does.
+func (ma *memAccountant) setLimit(limit int64) (usage int64, crossed int) {
+	atomic.StoreInt64(&ma.limit, limit)
+	if limit <= 0 && atomic.CompareAndSwapInt32(&ma.exceeded, 1, 0) {
+		return ma.load(), -1
+	}
+	return ma.add(0)
+}
+
+// add adds delta to the memory usage and reports whether the usage crosses the limit
+// upward (1), downward (-1) or stays where it was (0).
+func (ma *memAccountant) add(delta int64) (usage int64, crossed int) {
+	usage = atomic.AddInt64(&ma.usage, delta)
+	limit := atomic.LoadInt64(&ma.limit)
+	if limit <= 0 {
+		return
+	}
+	if usage > limit {
+		if atomic.CompareAndSwapInt32(&ma.exceeded, 0, 1) {
+			crossed = 1
+		}
+	} else if atomic.CompareAndSwapInt32(&ma.exceeded, 1, 0) {
+		crossed = -1
+	}
+	return
+}
+
+func (ma *memAccountant) load() int64 {
+	return atomic.LoadInt64(&ma.usage)
+}
+
+// addOutbound adds delta to the bytes held in outbound buffers, which must be added to usage as well.
+func (ma *memAccountant) addOutbound(delta int64) {
+	atomic.AddInt64(&ma.outbound, delta)
+}
+
+func (ma *memAccountant) loadOutbound() int64 {
+	return atomic.LoadInt64(&ma.outbound)
+}
+
+func (ma *memAccountant) overLimit() bool {
+	limit := atomic.LoadInt64(&ma.limit)
+	return limit > 0 && atomic.LoadInt64(&ma.usage) > limit
+}
@@ -0,0 +1,27 @@
+package gnet
+
+import "testing"
+
+func TestMemAccountant(t *testing.T) {
+	ma := newMemAccountant(&Options{MemoryLimit: 100})
+	if _, crossed := ma.add(60); crossed != 0 {
+		t.Fatalf("expected no crossing, got %d", crossed)
+	}
+	if usage, crossed := ma.add(60); crossed != 1 || usage != 120 {
+		t.Fatalf("expected crossing upward with usage 120, got %d with usage %d", crossed, usage)
+	}
+	if _, crossed := ma.add(10); crossed != 0 {
+		t.Fatalf("expected no crossing when staying over limit, got %d", crossed)
+	}
+	if !ma.overLimit() {
+		t.Fatalf("expected memory usage to be over limit")
+	}
+	if usage, crossed := ma.add(-70); crossed != -1 || usage != 60 {
+		t.Fatalf("expected crossing downward with usage 60, got %d with usage %d", crossed, usage)
+	}
+
+	unlimited := newMemAccountant(&Options{})
+	if _, crossed := unlimited.add(1 << 40); crossed != 0 || unlimited.overLimit() {
+		t.Fatalf("expected no limit to be applied")
+	}
+}
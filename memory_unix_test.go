@@ -0,0 +1,158 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// memoryLimit is far below the data held for the slow readers, which is much larger than the socket buffers.
+const (
+	memoryLimit = 8 << 20
+	memoryFill  = 32 << 20
+)
+
+func TestMemoryPauseAccept(t *testing.T) {
+	events := &testMemoryPauseAcceptServer{network: "tcp", addr: ":9928", exceeded: make(chan struct{}, 1)}
+	must(Serve(events, "tcp://:9928", WithMemoryLimit(memoryLimit, PauseAccept),
+		WithMemoryLimitHandler(func(usage, limit int64) {
+			select {
+			case events.exceeded <- struct{}{}:
+			default:
+			}
+		})))
+	if events.opened != 2 {
+		t.Fatalf("expected 2 connections to be opened, got %d", events.opened)
+	}
+}
+
+type testMemoryPauseAcceptServer struct {
+	*EventServer
+	network, addr  string
+	exceeded       chan struct{}
+	opened, closed int
+}
+
+func (s *testMemoryPauseAcceptServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		slow, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer slow.Close()
+		must(slow.SetReadDeadline(time.Now().Add(time.Second * 10)))
+		_, err = slow.Write([]byte("fill"))
+		must(err)
+		// Don't read until the data held for the slow reader exceeds the limit and accepting is paused.
+		<-s.exceeded
+		time.Sleep(time.Millisecond * 50)
+
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Millisecond * 200)))
+		if _, err = c.Read(make([]byte, 4)); err == nil {
+			panic("the connection is accepted while the memory usage is over the limit")
+		}
+
+		// Accepting is resumed once the slow reader drains its data.
+		_, err = io.ReadFull(slow, make([]byte, memoryFill))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		must(err)
+		if string(buf) != "pong" {
+			panic("unexpected reply: " + string(buf))
+		}
+	}()
+	return
+}
+
+func (s *testMemoryPauseAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.opened++
+	return
+}
+
+func (s *testMemoryPauseAcceptServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "fill" {
+		return make([]byte, memoryFill), None
+	}
+	return []byte("pong"), None
+}
+
+func (s *testMemoryPauseAcceptServer) OnClosed(c Conn, err error) (action Action) {
+	if s.closed++; s.closed == 2 {
+		return Shutdown
+	}
+	return
+}
+
+func TestMemoryCloseMostBuffered(t *testing.T) {
+	events := &testMemoryCloseMostBufferedServer{network: "tcp", addr: ":9927", reasons: make(map[string]error)}
+	must(Serve(events, "tcp://:9927", WithMemoryLimit(memoryLimit, CloseMostBuffered)))
+	if events.reasons["big"] != ErrBufferLimit || events.reasons["small"] != ErrEOF {
+		t.Fatalf("expected the most buffered connection to be closed for %v alone, got %v", ErrBufferLimit,
+			events.reasons)
+	}
+}
+
+type testMemoryCloseMostBufferedServer struct {
+	*EventServer
+	network, addr string
+	reasons       map[string]error
+}
+
+// memorySmall is held for a slow reader which survives, it's below the limit even without the socket buffers.
+const memorySmall = 2 << 20
+
+func (s *testMemoryCloseMostBufferedServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		small, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer small.Close()
+		must(small.SetReadDeadline(time.Now().Add(time.Second * 10)))
+		_, err = small.Write([]byte("small"))
+		must(err)
+		time.Sleep(time.Millisecond * 100)
+
+		big, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer big.Close()
+		must(big.SetReadDeadline(time.Now().Add(time.Second * 10)))
+		_, err = big.Write([]byte("big"))
+		must(err)
+		// The big one is closed before all of its data is written.
+		if n, _ := io.Copy(ioutil.Discard, big); n >= memoryFill {
+			panic("the most buffered connection is not closed")
+		}
+
+		_, err = io.ReadFull(small, make([]byte, memorySmall))
+		must(err)
+	}()
+	return
+}
+
+func (s *testMemoryCloseMostBufferedServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	c.SetContext(string(frame))
+	if string(frame) == "big" {
+		return make([]byte, memoryFill), None
+	}
+	return make([]byte, memorySmall), None
+}
+
+func (s *testMemoryCloseMostBufferedServer) OnClosed(c Conn, err error) (action Action) {
+	s.reasons[c.Context().(string)] = err
+	if len(s.reasons) == 2 {
+		return Shutdown
+	}
+	return
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware wraps an EventHandler into another one which handles the events before and after the wrapped one,
+// e.g. to recover the panics or to log the frames, see Chain.
+type Middleware func(next EventHandler) EventHandler
+
+// Chain wraps the handler with the middlewares, the first one is the outermost, i.e. Chain(h, a, b) passes
+// the events to a, which passes them to b, which passes them to h.
+//
+// Only the callbacks of EventHandler go through the middlewares, the optional interfaces such as TrafficHandler,
+// BatchHandler and ErrorHandler are served by the handler directly. The server looks for them by unwrapping
+// the handler, the handlers implementing Unwrap() EventHandler are unwrapped the same way as the chains.
+func Chain(handler EventHandler, mws ...Middleware) EventHandler {
+	h := handler
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return &chain{EventHandler: h, base: handler}
+}
+
+type chain struct {
+	EventHandler
+	base EventHandler
+}
+
+// Unwrap returns the handler wrapped by the middlewares.
+func (c *chain) Unwrap() EventHandler {
+	return c.base
+}
+
+// unwrapHandler returns the innermost handler wrapped by Chain, which serves the optional interfaces.
+func unwrapHandler(h EventHandler) EventHandler {
+	for {
+		u, ok := h.(interface{ Unwrap() EventHandler })
+		if !ok {
+			return h
+		}
+		h = u.Unwrap()
+	}
+}
+
+// Recover is the middleware which recovers the panics in OnOpened, React and OnClosed, it logs the panic along
+// with the stack trace and closes the connection, the default logger is used if logger is nil.
+func Recover(logger Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		return &recoverer{EventHandler: next, logger: logger}
+	}
+}
+
+type recoverer struct {
+	EventHandler
+	logger Logger
+}
+
+func (r *recoverer) catch(callback string, c Conn, out *[]byte, action *Action) {
+	if p := recover(); p != nil {
+		logger := r.logger
+		if logger == nil {
+			logger = defaultLogger
+		}
+		logger.Printf("panic in %s of connection %v: %v\n%s", callback, c.RemoteAddr(), p, debug.Stack())
+		if out != nil {
+			*out = nil
+		}
+		*action = Close
+	}
+}
+
+func (r *recoverer) OnOpened(c Conn) (out []byte, action Action) {
+	defer r.catch("OnOpened", c, &out, &action)
+	return r.EventHandler.OnOpened(c)
+}
+
+func (r *recoverer) React(frame []byte, c Conn) (out []byte, action Action) {
+	defer r.catch("React", c, &out, &action)
+	return r.EventHandler.React(frame, c)
+}
+
+func (r *recoverer) OnClosed(c Conn, err error) (action Action) {
+	defer r.catch("OnClosed", c, nil, &action)
+	return r.EventHandler.OnClosed(c, err)
+}
+
+// Logging is the middleware which logs the opening and closing of connections and every frame handled by React
+// with the sizes of the frame and the output, the action and the time it takes, the default logger is used if
+// logger is nil.
+func Logging(logger Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		if logger == nil {
+			logger = defaultLogger
+		}
+		return &frameLogger{EventHandler: next, logger: logger}
+	}
+}
+
+type frameLogger struct {
+	EventHandler
+	logger Logger
+}
+
+var actionNames = [...]string{None: "none", Close: "close", Shutdown: "shutdown"}
+
+func (l *frameLogger) OnOpened(c Conn) (out []byte, action Action) {
+	out, action = l.EventHandler.OnOpened(c)
+	l.logger.Printf("opened %v, out=%dB action=%s\n", c.RemoteAddr(), len(out), actionNames[action])
+	return
+}
+
+func (l *frameLogger) React(frame []byte, c Conn) (out []byte, action Action) {
+	start := time.Now()
+	out, action = l.EventHandler.React(frame, c)
+	l.logger.Printf("frame from %v, in=%dB out=%dB action=%s took=%v\n", c.RemoteAddr(), len(frame), len(out),
+		actionNames[action], time.Since(start))
+	return
+}
+
+func (l *frameLogger) OnClosed(c Conn, err error) (action Action) {
+	l.logger.Printf("closed %v, reason=%v\n", c.RemoteAddr(), err)
+	return l.EventHandler.OnClosed(c, err)
+}
+
+// HandlerMetrics holds the counters of the events collected by the Metrics middleware, the fields are updated
+// atomically, so they must be read by sync/atomic while the server is running.
+type HandlerMetrics struct {
+	Opened    int64 // connections opened
+	Closed    int64 // connections closed
+	Frames    int64 // frames handled by React
+	BytesIn   int64 // bytes of the frames
+	BytesOut  int64 // bytes of the outputs of OnOpened and React
+	ReactTime int64 // nanoseconds spent in React
+}
+
+// Metrics is the middleware which counts the events into m.
+func Metrics(m *HandlerMetrics) Middleware {
+	return func(next EventHandler) EventHandler {
+		return &metrics{EventHandler: next, m: m}
+	}
+}
+
+type metrics struct {
+	EventHandler
+	m *HandlerMetrics
+}
+
+func (h *metrics) OnOpened(c Conn) (out []byte, action Action) {
+	atomic.AddInt64(&h.m.Opened, 1)
+	out, action = h.EventHandler.OnOpened(c)
+	atomic.AddInt64(&h.m.BytesOut, int64(len(out)))
+	return
+}
+
+func (h *metrics) React(frame []byte, c Conn) (out []byte, action Action) {
+	start := time.Now()
+	out, action = h.EventHandler.React(frame, c)
+	atomic.AddInt64(&h.m.ReactTime, int64(time.Since(start)))
+	atomic.AddInt64(&h.m.Frames, 1)
+	atomic.AddInt64(&h.m.BytesIn, int64(len(frame)))
+	atomic.AddInt64(&h.m.BytesOut, int64(len(out)))
+	return
+}
+
+func (h *metrics) OnClosed(c Conn, err error) (action Action) {
+	atomic.AddInt64(&h.m.Closed, 1)
+	return h.EventHandler.OnClosed(c, err)
+}
+
+// RateLimit is the middleware which limits the frames of every connection to rate per second with bursts
+// of up to burst frames, the connections sending frames faster than that are closed without the frames
+// passed to React. The datagrams of UDP are not limited unless they are in the sessions of UDPConnect.
+func RateLimit(rate, burst int) Middleware {
+	return func(next EventHandler) EventHandler {
+		return &rateLimiter{EventHandler: next, rate: rate, burst: burst}
+	}
+}
+
+type rateLimiter struct {
+	EventHandler
+	rate, burst int
+	buckets     sync.Map // Conn -> *tokenBucket
+}
+
+func (l *rateLimiter) React(frame []byte, c Conn) (out []byte, action Action) {
+	if c.RecvAddr() != nil && eventLoopOf(c) == nil {
+		// The Conn of every datagram is new and never closed.
+		return l.EventHandler.React(frame, c)
+	}
+	v, ok := l.buckets.Load(c)
+	if !ok {
+		v, _ = l.buckets.LoadOrStore(c, new(tokenBucket))
+	}
+	if v.(*tokenBucket).take(time.Now(), l.rate, l.burst) > 0 {
+		return nil, Close
+	}
+	return l.EventHandler.React(frame, c)
+}
+
+func (l *rateLimiter) OnClosed(c Conn, err error) (action Action) {
+	l.buckets.Delete(c)
+	return l.EventHandler.OnClosed(c, err)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+func (c *conn) MigrateTo(loopIndex int) error {
+	el := c.loop
+	if el.svr.ln.pconn != nil || c.udpPeer != "" {
+		return ErrUnsupportedProtocol
+	}
+	loops := el.svr.loops
+	if loopIndex < 0 || loopIndex >= len(loops) {
+		return ErrInvalidLoopIndex
+	}
+	if !c.opened {
+		return ErrConnClosed
+	}
+	target := loops[loopIndex]
+	if target == el {
+		return nil
+	}
+	// Hand over the connection after the current event, whose handling may go on with the connection.
+	return el.execute(func() error {
+		return el.loopMigrate(c, target)
+	})
+}
+
+// owner returns the event-loop of the connection for the goroutines other than the event-loops, which is
+// changed by MigrateTo.
+func (c *conn) owner() *eventloop {
+	return (*eventloop)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&c.loop))))
+}
+
+// moved reports whether the connection has left the given event-loop or is on its way to it, the jobs of
+// the connection queued to the event-loop follow the connection to its event-loop in that case.
+func (c *conn) moved(el *eventloop) bool {
+	return c.loop != el || c.migrating
+}
+
+// submit queues the job of the asynchronous APIs to the event-loop of the connection like eventloop.submit,
+// the job uses c.loop rather than the event-loop it's queued to and it's counted in pendingTasks until it runs.
+func (c *conn) submit(job func() error) error {
+	el := c.owner()
+	atomic.AddInt32(&c.pendingTasks, 1)
+	done := func() { atomic.AddInt32(&c.pendingTasks, -1) }
+	err := el.enqueue(c.follow(el, func() error {
+		done()
+		return job()
+	}), done)
+	if err != nil {
+		done()
+	}
+	return err
+}
+
+// trigger queues the job to the event-loop of the connection like eventloop.execute.
+func (c *conn) trigger(job func() error) error {
+	el := c.owner()
+	return el.execute(c.follow(el, job))
+}
+
+// follow wraps the job of the connection run on the given event-loop, which is passed on to the event-loop
+// of the connection if it has been migrated by then, the tasks passed on are not subject to LoopTaskQueueCap
+// again.
+func (c *conn) follow(el *eventloop, job func() error) func() error {
+	return func() error {
+		if c.moved(el) {
+			return c.trigger(job)
+		}
+		return job()
+	}
+}
+
+// loopMigrate quiesces the given connection and hands it over to the target event-loop along with its buffers,
+// the connection is registered with the poller of the target once it's adopted there.
+func (el *eventloop) loopMigrate(c *conn, target *eventloop) error {
+	if !c.opened || c.pendingOpen || c.moved(el) {
+		return nil
+	}
+	if c.staged {
+		c.staged = false
+		for i, sc := range el.staged {
+			if sc == c {
+				el.staged = append(el.staged[:i], el.staged[i+1:]...)
+				break
+			}
+		}
+	}
+	if err := el.poller.Detach(c.fd); err != nil {
+		el.svr.logger().Printf("failed to detach fd:%d from poller, error:%v\n", c.fd, err)
+		return nil
+	}
+	delete(el.connections, c.fd)
+	el.calibrateCallback(el, -1)
+
+	// The timers are kept by the timing wheel of this event-loop, they are set up again by the target.
+	firstByte := c.firstByteTimer != nil
+	c.stopFirstByteTimer()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	if c.slowTimer != nil {
+		c.slowTimer.Stop()
+		c.slowTimer = nil
+	}
+	if p := c.pacer; p != nil {
+		if p.timer != nil {
+			p.timer.Stop()
+			p.timer = nil
+		}
+		p.budget, p.held = p.quota, false
+	}
+	resuming := c.resuming
+	c.resuming = false
+
+	c.migrating = true
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&c.loop)), unsafe.Pointer(target))
+	return target.execute(func() error {
+		return target.loopAdopt(c, el, firstByte, resuming)
+	})
+}
+
+// loopAdopt takes over the connection migrated from the given event-loop, the connection is handed back to it if
+// the file descriptor can't be registered, from is nil when it has been handed back, the connection is closed
+// with the error if it can't be registered again.
+func (el *eventloop) loopAdopt(c *conn, from *eventloop, firstByte, resuming bool) error {
+	c.migrating = false
+	el.connections[c.fd] = c
+	el.calibrateCallback(el, 1)
+	// The events of the file descriptor are dropped by the poller until the connection is in connections.
+	var err error
+	if c.outboundBuffer.IsEmpty() {
+		err = el.poller.AddRead(c.fd)
+	} else {
+		err = el.poller.AddReadWrite(c.fd)
+	}
+	if err != nil {
+		if from == nil {
+			// Neither of the event-loops can poll the connection, which fails it rather than the event-loop.
+			el.svr.logger().Printf("failed to hand fd:%d back to event-loop:%d, error:%v\n", c.fd, el.idx, err)
+			c.unpolled = true
+			return el.loopCloseConn(c, err)
+		}
+		delete(el.connections, c.fd)
+		el.calibrateCallback(el, -1)
+		el.svr.logger().Printf("failed to migrate fd:%d to event-loop:%d, error:%v\n", c.fd, el.idx, err)
+		c.migrating = true
+		atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&c.loop)), unsafe.Pointer(from))
+		return from.execute(func() error {
+			return from.loopAdopt(c, nil, firstByte, resuming)
+		})
+	}
+	if c.priority > PriorityNormal {
+		el.poller.SetPriority(c.fd, true)
+	}
+	if c.readHolds != 0 {
+		_ = el.poller.HoldRead(c.fd, !c.outboundBuffer.IsEmpty())
+	}
+	if timeout := el.svr.options().FirstByteTimeout; firstByte && timeout > 0 {
+		c.firstByteTimer = el.timingWheel().AfterFunc(timeout, func() error {
+			c.firstByteTimer = nil
+			return el.loopCloseConn(c, ErrFirstByteTimeout)
+		})
+	}
+	if timeout := el.svr.options().IdleTimeout; timeout > 0 {
+		d := timeout - time.Since(c.lastActive)
+		if d < timerTick {
+			d = timerTick
+		}
+		el.armIdleTimer(c, d)
+	}
+	if resuming {
+		el.resumeReact(c)
+	}
+	el.accountMemory(c)
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMigrateTo(t *testing.T) {
+	events := &testMigrateServer{network: "tcp", addr: "127.0.0.1:9949"}
+	must(Serve(events, "tcp://127.0.0.1:9949", WithMulticore(true), WithNumEventLoop(2),
+		WithCodec(new(LineBasedFrameCodec)), WithIdleTimeout(time.Second*3)))
+	if events.from == events.to {
+		t.Fatalf("expected the connection to be handled by event-loop %d after migration, got %d",
+			1-events.from, events.to)
+	}
+}
+
+type testMigrateServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	moved         bool
+	from, to      int
+}
+
+func (s *testMigrateServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		r := bufio.NewReader(c)
+		request := func(line string) string {
+			_, err := c.Write([]byte(line + "\n"))
+			must(err)
+			reply, err := r.ReadString('\n')
+			must(err)
+			return strings.TrimSuffix(reply, "\n")
+		}
+		from := request("where")
+		if reply := request("move"); reply != "moving" {
+			panic(fmt.Sprintf("unexpected reply of migration: %q", reply))
+		}
+		if to := request("where"); to == from {
+			panic(fmt.Sprintf("expected the connection to leave event-loop %s", from))
+		}
+		if reply := request("async"); reply != "done" {
+			panic(fmt.Sprintf("unexpected reply of AsyncWrite: %q", reply))
+		}
+	}()
+	return
+}
+
+func (s *testMigrateServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	idx := -1
+	for i, el := range s.svr.svr.loops {
+		if el == eventLoopOf(c) {
+			idx = i
+		}
+	}
+	switch string(frame) {
+	case "where":
+		if s.moved {
+			s.to = idx
+		} else {
+			s.from = idx
+		}
+		out = []byte(strconv.Itoa(idx))
+	case "move":
+		if err := c.MigrateTo(2); err != ErrInvalidLoopIndex {
+			panic(fmt.Sprintf("expected ErrInvalidLoopIndex, got %v", err))
+		}
+		must(c.MigrateTo(1 - idx))
+		s.moved = true
+		out = []byte("moving")
+	case "async":
+		if stats := s.svr.LoopStats(); stats[idx].Connections != 1 || stats[1-idx].Connections != 0 {
+			panic(fmt.Sprintf("unexpected connections of event-loops after migration: %+v", stats))
+		}
+		go func() { must(c.AsyncWrite([]byte("done"))) }()
+	}
+	return
+}
+
+func (s *testMigrateServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestMigrateToInflight(t *testing.T) {
+	events := &testMigrateInflightServer{network: "tcp", addr: "127.0.0.1:9930"}
+	must(Serve(events, "tcp://127.0.0.1:9930", WithMulticore(true), WithNumEventLoop(2),
+		WithCodec(new(LineBasedFrameCodec))))
+}
+
+type testMigrateInflightServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+}
+
+func (s *testMigrateInflightServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		r := bufio.NewReader(c)
+		// Keep the requests coming while the connection is handed over, none of them may be lost.
+		const requests = 200
+		_, err = c.Write([]byte("move\n"))
+		must(err)
+		for i := 0; i < requests; i++ {
+			_, err = c.Write([]byte("where\n"))
+			must(err)
+		}
+		reply, err := r.ReadString('\n')
+		must(err)
+		target := strings.TrimPrefix(strings.TrimSuffix(reply, "\n"), "moving ")
+		// The requests read along with the move are handled before the connection leaves.
+		moved := false
+		for i := 0; i <= requests; i++ {
+			if i == requests {
+				_, err = c.Write([]byte("where\n"))
+				must(err)
+			}
+			reply, err = r.ReadString('\n')
+			must(err)
+			if reply = strings.TrimSuffix(reply, "\n"); reply == target {
+				moved = true
+			} else if moved || i == requests {
+				panic(fmt.Sprintf("request %d: expected to be handled by event-loop %s, got %s", i, target, reply))
+			}
+		}
+	}()
+	return
+}
+
+func (s *testMigrateInflightServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	idx := -1
+	for i, el := range s.svr.svr.loops {
+		if el == eventLoopOf(c) {
+			idx = i
+		}
+	}
+	if string(frame) == "move" {
+		must(c.MigrateTo(1 - idx))
+		return []byte("moving " + strconv.Itoa(1-idx)), None
+	}
+	return []byte(strconv.Itoa(idx)), None
+}
+
+func (s *testMigrateInflightServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
+
+func TestMigrateToUnpolled(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("epoll alone fails to register the file descriptor twice")
+	}
+	events := &testMigrateUnpolledServer{network: "tcp", addr: "127.0.0.1:9925"}
+	must(Serve(events, "tcp://127.0.0.1:9925", WithMulticore(true), WithNumEventLoop(2)))
+	if events.err != unix.EEXIST {
+		t.Fatalf("expected the connection to be closed with %v, got %v", unix.EEXIST, events.err)
+	}
+}
+
+type testMigrateUnpolledServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	once          sync.Once
+	err           error
+}
+
+func (s *testMigrateUnpolledServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("move"))
+		must(err)
+		if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection failed to migrate to be closed, got %v", err))
+		}
+		// The event-loops survive the failed migration.
+		c, err = net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		_, err = c.Write([]byte("quit"))
+		must(err)
+		_, err = io.ReadFull(c, make([]byte, 4))
+		must(err)
+	}()
+	return
+}
+
+func (s *testMigrateUnpolledServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "quit" {
+		return frame, Shutdown
+	}
+	el, fd := eventLoopOf(c), c.Fd()
+	target := s.svr.svr.loops[0]
+	if target == el {
+		target = s.svr.svr.loops[1]
+	}
+	// Hold the file descriptor in both pollers, so that neither of them can take the connection.
+	must(target.poller.AddRead(fd))
+	must(c.MigrateTo(target.idx))
+	must(el.execute(func() error { return el.poller.AddRead(fd) }))
+	return
+}
+
+func (s *testMigrateUnpolledServer) OnClosed(c Conn, err error) (action Action) {
+	s.once.Do(func() { s.err = err })
+	return
+}
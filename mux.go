@@ -0,0 +1,436 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Match is the result of a Matcher sniffing the first bytes of a connection.
+type Match int
+
+const (
+	// NoMatch means the connection doesn't belong to the route.
+	NoMatch Match = iota
+
+	// Matched means the connection belongs to the route.
+	Matched
+
+	// NeedMore means the bytes so far are not enough to tell.
+	NeedMore
+)
+
+// Matcher tells whether a connection belongs to a route of Mux by the bytes it has sent so far, which are
+// not consumed.
+type Matcher func(head []byte) Match
+
+// DefaultMaxSniffBytes is the default of Mux.MaxSniffBytes, which holds the ClientHello of TLS in most cases.
+const DefaultMaxSniffBytes = 16 << 10
+
+// Mux is an EventHandler which routes the connections sharing a port to the EventHandlers registered by Handle,
+// e.g. TLS and the plain health checks on 443, by sniffing their first bytes with the Matchers. The bytes are
+// not consumed by the sniffing, the handler of the route takes the connection over with all of them.
+//
+// The route of a connection is the first one in the order of Handle which matches, a route which needs more
+// bytes holds the connection until it tells, even if the routes after it match. OnOpened of the handler fires
+// once the route is found, the inbound data is then decoded with the codec of the route and passed to React,
+// or passed to OnTraffic if the handler is a TrafficHandler, e.g. httpbridge.Handler. The connections matching
+// no route are closed.
+//
+// Mux must be passed to Serve with the default codec, the outputs of the handlers are encoded by the codecs
+// of the routes and written by AsyncWrite. It works for the stream-oriented protocols only, and SetState and
+// the optional interfaces of the handlers other than TrafficHandler are not supported. OnInitComplete and
+// OnShutdown are passed to all the handlers, while Tick and PreWrite are not.
+type Mux struct {
+	*EventServer
+
+	// MaxSniffBytes is the upper bound of the bytes buffered for sniffing, the connection is closed if no route
+	// is found by then, DefaultMaxSniffBytes is used if it is 0.
+	MaxSniffBytes int
+
+	// SniffTimeout closes the connections whose routes are not found in time, 0 means no timeout.
+	SniffTimeout time.Duration
+
+	routes []*muxRoute
+	conns  sync.Map // Conn -> *muxConn
+}
+
+type muxRoute struct {
+	match   Matcher
+	handler EventHandler
+	traffic TrafficHandler
+	codec   ICodec
+}
+
+// muxConn is the state of a connection of Mux, it is only accessed on the event-loop of the connection.
+type muxConn struct {
+	route     *muxRoute
+	stopTimer func() bool
+}
+
+// NewMux returns a Mux without routes.
+func NewMux() *Mux {
+	return new(Mux)
+}
+
+// Handle registers the route of the connections matched by match to handler, whose frames are decoded and encoded
+// with codec, nil codec passes the inbound data as it is. It must be called before Serve.
+func (m *Mux) Handle(match Matcher, handler EventHandler, codec ICodec) {
+	if codec == nil {
+		codec = new(BuiltInFrameCodec)
+	}
+	r := &muxRoute{match: match, handler: handler, codec: codec}
+	r.traffic, _ = unwrapHandler(handler).(TrafficHandler)
+	m.routes = append(m.routes, r)
+}
+
+// OnInitComplete passes the event to the handlers of all routes, the server is shut down if any of them asks for.
+func (m *Mux) OnInitComplete(svr Server) (action Action) {
+	for _, r := range m.routes {
+		if r.handler.OnInitComplete(svr) == Shutdown {
+			action = Shutdown
+		}
+	}
+	return
+}
+
+// OnShutdown passes the event to the handlers of all routes.
+func (m *Mux) OnShutdown(svr Server) {
+	for _, r := range m.routes {
+		r.handler.OnShutdown(svr)
+	}
+}
+
+// OnOpened starts sniffing the connection.
+func (m *Mux) OnOpened(c Conn) (out []byte, action Action) {
+	mc := new(muxConn)
+	if m.SniffTimeout > 0 {
+		mc.stopTimer = c.AfterFunc(m.SniffTimeout, func(c Conn) {
+			if mc.route == nil {
+				_ = c.Close()
+			}
+		})
+	}
+	m.conns.Store(c, mc)
+	return
+}
+
+// OnTraffic sniffs the connection until its route is found, and passes the inbound data to the handler of the route
+// from then on.
+func (m *Mux) OnTraffic(c Conn) (action Action) {
+	v, ok := m.conns.Load(c)
+	if !ok {
+		return Close
+	}
+	mc := v.(*muxConn)
+	if mc.route == nil {
+		head, _ := c.Peek(-1)
+		r, match := m.sniff(head)
+		switch match {
+		case NeedMore:
+			return
+		case NoMatch:
+			return Close
+		}
+		mc.route = r
+		if mc.stopTimer != nil {
+			mc.stopTimer()
+		}
+		out, action := r.handler.OnOpened(c)
+		if m.write(c, r, out); action != None {
+			return action
+		}
+	}
+	return m.serve(c, mc.route)
+}
+
+// sniff returns the route of the connection which has sent the given bytes so far.
+func (m *Mux) sniff(head []byte) (*muxRoute, Match) {
+	maxSniffBytes := m.MaxSniffBytes
+	if maxSniffBytes <= 0 {
+		maxSniffBytes = DefaultMaxSniffBytes
+	}
+	for _, r := range m.routes {
+		switch r.match(head) {
+		case Matched:
+			return r, Matched
+		case NeedMore:
+			if len(head) >= maxSniffBytes {
+				return nil, NoMatch
+			}
+			return nil, NeedMore
+		}
+	}
+	return nil, NoMatch
+}
+
+// serve passes the inbound data of the connection to the handler of the given route.
+func (m *Mux) serve(c Conn, r *muxRoute) (action Action) {
+	if r.traffic != nil {
+		return r.traffic.OnTraffic(c)
+	}
+	for {
+		frame, err := r.codec.Decode(c)
+		if max := maxFrameSize(c); err == ErrFrameTooLarge || max > 0 && len(frame) > max {
+			return Close
+		}
+		if frame == nil {
+			return
+		}
+		var out []byte
+		out, action = r.handler.React(frame, c)
+		if m.write(c, r, out); action != None {
+			return
+		}
+	}
+}
+
+func (m *Mux) write(c Conn, r *muxRoute, out []byte) {
+	if out == nil {
+		return
+	}
+	if buf, err := r.codec.Encode(c, out); err == nil {
+		_ = c.AsyncWrite(buf)
+	}
+}
+
+// OnClosed passes the event to the handler of the route of the connection if it has been found.
+func (m *Mux) OnClosed(c Conn, err error) (action Action) {
+	v, ok := m.conns.Load(c)
+	if !ok {
+		return
+	}
+	m.conns.Delete(c)
+	if r := v.(*muxConn).route; r != nil {
+		return r.handler.OnClosed(c, err)
+	}
+	return
+}
+
+// MatchAny matches all connections, it is meant for the last route taking the rest of connections.
+func MatchAny() Matcher {
+	return func(head []byte) Match {
+		return Matched
+	}
+}
+
+// MatchPrefix matches the connections starting with any of the given prefixes, e.g. the magic of custom protocols
+// or the preface of HTTP/2 with prior knowledge.
+func MatchPrefix(prefixes ...[]byte) Matcher {
+	return func(head []byte) Match {
+		match := NoMatch
+		for _, p := range prefixes {
+			switch {
+			case bytes.HasPrefix(head, p):
+				return Matched
+			case len(head) < len(p) && bytes.HasPrefix(p, head):
+				match = NeedMore
+			}
+		}
+		return match
+	}
+}
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("CONNECT "), []byte("OPTIONS "), []byte("TRACE "), []byte("PATCH "),
+}
+
+// MatchHTTP1 matches the connections of HTTP/1.x by the methods of their first requests.
+func MatchHTTP1() Matcher {
+	return MatchPrefix(httpMethods...)
+}
+
+// MatchTLS matches the connections of TLS by the ClientHello, and by the server name of SNI if any server names
+// are given, which are compared case-insensitively.
+func MatchTLS(serverNames ...string) Matcher {
+	return func(head []byte) Match {
+		hello, match := parseClientHello(head)
+		if match != Matched || len(serverNames) == 0 {
+			return match
+		}
+		for _, name := range serverNames {
+			if strings.EqualFold(name, hello.serverName) {
+				return Matched
+			}
+		}
+		return NoMatch
+	}
+}
+
+// MatchALPN matches the connections of TLS whose ClientHello offers any of the given protocols by ALPN,
+// e.g. "h2" and "http/1.1".
+func MatchALPN(protos ...string) Matcher {
+	return func(head []byte) Match {
+		hello, match := parseClientHello(head)
+		if match != Matched {
+			return match
+		}
+		for _, offered := range hello.protos {
+			for _, proto := range protos {
+				if offered == proto {
+					return Matched
+				}
+			}
+		}
+		return NoMatch
+	}
+}
+
+// clientHello holds the fields of the ClientHello of TLS used by the matchers.
+type clientHello struct {
+	serverName string
+	protos     []string
+}
+
+const (
+	tlsRecordHandshake     = 0x16
+	tlsHandshakeHello      = 0x01
+	tlsExtServerName       = 0x0000
+	tlsExtALPN             = 0x0010
+	tlsRecordHeaderLen     = 5
+	tlsHandshakeHeaderLen  = 4
+	tlsServerNameHostName  = 0
+	tlsMaxHandshakeRecords = 4
+)
+
+// parseClientHello parses the ClientHello of TLS from the first bytes of a connection, which may span a few
+// records of handshake.
+func parseClientHello(head []byte) (*clientHello, Match) {
+	// Reassemble the handshake message from the records.
+	var msg []byte
+	for i := 0; i < tlsMaxHandshakeRecords; i++ {
+		if len(head) < tlsRecordHeaderLen {
+			if len(head) > 0 && head[0] != tlsRecordHandshake || len(head) > 1 && head[1] != 3 {
+				return nil, NoMatch
+			}
+			return nil, NeedMore
+		}
+		if head[0] != tlsRecordHandshake || head[1] != 3 {
+			return nil, NoMatch
+		}
+		n := int(head[3])<<8 | int(head[4])
+		if len(head) < tlsRecordHeaderLen+n {
+			return nil, NeedMore
+		}
+		msg = append(msg, head[tlsRecordHeaderLen:tlsRecordHeaderLen+n]...)
+		head = head[tlsRecordHeaderLen+n:]
+		if len(msg) < tlsHandshakeHeaderLen {
+			continue
+		}
+		if msg[0] != tlsHandshakeHello {
+			return nil, NoMatch
+		}
+		if len(msg) >= tlsHandshakeHeaderLen+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+			break
+		}
+	}
+	if len(msg) < tlsHandshakeHeaderLen {
+		return nil, NoMatch
+	}
+	n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg) < tlsHandshakeHeaderLen+n {
+		return nil, NoMatch
+	}
+	p := tlsParser(msg[tlsHandshakeHeaderLen : tlsHandshakeHeaderLen+n])
+	// client_version, random, session_id, cipher_suites and compression_methods.
+	if !p.skip(2+32) || !p.skipVector(1) || !p.skipVector(2) || !p.skipVector(1) {
+		return nil, NoMatch
+	}
+	hello := new(clientHello)
+	exts, ok := p.vector(2)
+	if !ok {
+		// No extensions.
+		return hello, Matched
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint16()
+		if !ok {
+			return nil, NoMatch
+		}
+		data, ok := exts.vector(2)
+		if !ok {
+			return nil, NoMatch
+		}
+		switch typ {
+		case tlsExtServerName:
+			names, _ := data.vector(2)
+			for len(names) > 0 {
+				nameType, ok := names.uint8()
+				name, ok2 := names.vector(2)
+				if !ok || !ok2 {
+					break
+				}
+				if nameType == tlsServerNameHostName {
+					hello.serverName = string(name)
+				}
+			}
+		case tlsExtALPN:
+			protos, _ := data.vector(2)
+			for len(protos) > 0 {
+				proto, ok := protos.vector(1)
+				if !ok {
+					break
+				}
+				hello.protos = append(hello.protos, string(proto))
+			}
+		}
+	}
+	return hello, Matched
+}
+
+// tlsParser reads the fields of the handshake messages of TLS.
+type tlsParser []byte
+
+func (p *tlsParser) skip(n int) bool {
+	if len(*p) < n {
+		return false
+	}
+	*p = (*p)[n:]
+	return true
+}
+
+func (p *tlsParser) uint8() (uint8, bool) {
+	if len(*p) < 1 {
+		return 0, false
+	}
+	v := (*p)[0]
+	*p = (*p)[1:]
+	return v, true
+}
+
+func (p *tlsParser) uint16() (uint16, bool) {
+	if len(*p) < 2 {
+		return 0, false
+	}
+	v := uint16((*p)[0])<<8 | uint16((*p)[1])
+	*p = (*p)[2:]
+	return v, true
+}
+
+// vector reads a vector of bytes prefixed by its length of the given bytes.
+func (p *tlsParser) vector(lenBytes int) (tlsParser, bool) {
+	if len(*p) < lenBytes {
+		return nil, false
+	}
+	var n int
+	for _, b := range (*p)[:lenBytes] {
+		n = n<<8 | int(b)
+	}
+	if len(*p) < lenBytes+n {
+		return nil, false
+	}
+	v := (*p)[lenBytes : lenBytes+n]
+	*p = (*p)[lenBytes+n:]
+	return v, true
+}
+
+func (p *tlsParser) skipVector(lenBytes int) bool {
+	_, ok := p.vector(lenBytes)
+	return ok
+}
@@ -4,7 +4,11 @@
 
 package gnet
 
-import "time"
+import (
+	"net"
+	"reflect"
+	"time"
+)
 
 // Option is a function that will set up option.
 type Option func(opts *Options)
@@ -17,6 +21,23 @@ func loadOptions(options ...Option) *Options {
 	return opts
 }
 
+// reloadOptions returns a copy of the current options with the given options applied, it fails with
+// ErrOptionNotReloadable if any option which can't be changed at runtime is given.
+func reloadOptions(cur *Options, options []Option) (*Options, error) {
+	probe := loadOptions(options...)
+	probe.TCPKeepAlive, probe.ShutdownTimeout, probe.FirstByteTimeout, probe.IdleTimeout = 0, 0, 0, 0
+	probe.MemoryLimit, probe.MemoryPolicy, probe.MemoryLimitHandler = 0, 0, nil
+	probe.AcceptRate, probe.AcceptBurst, probe.PerIPMaxConnections, probe.Logger = 0, 0, 0, nil
+	if !reflect.DeepEqual(*probe, Options{}) {
+		return nil, ErrOptionNotReloadable
+	}
+	opts := *cur
+	for _, option := range options {
+		option(&opts)
+	}
+	return &opts, nil
+}
+
 // Options are set when the client opens.
 type Options struct {
 	// Multicore indicates whether the server will be effectively created with multi-cores, if so,
@@ -28,6 +49,14 @@ type Options struct {
 	// LB represents the load-balancing algorithm used when assigning new connections.
 	LB LoadBalancing
 
+	// EventLoopPicker assigns the accepted connections to the event-loops instead of LB, it is called with
+	// the remote address and the number of event-loops and returns the index of event-loop, which pins the
+	// related connections (e.g. of a tenant, a shard key or a source subnet) to the same event-loop to share
+	// the loop-local state. LB is used for the indexes out of range. It is invoked by the goroutines accepting
+	// connections, concurrently with multiple acceptors or event-loops, and the connections accepted by other
+	// event-loops than the picked one are handed over to it. The datagrams of UDP are not assigned by it.
+	EventLoopPicker func(addr net.Addr, loops int) int
+
 	// NumEventLoop is set up to start the given number of event-loop goroutine.
 	// Note: Setting up NumEventLoop will override Multicore.
 	NumEventLoop int
@@ -35,18 +64,307 @@ type Options struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// ReusePortCPUAffinity makes every event-loop own a listener in the SO_REUSEPORT group and run on a CPU
+	// of its own in ReusePort mode, then a classic BPF program is attached to the group to steer new connections
+	// or packets to the listener whose event-loop runs on the CPU handling the softirq, which improves the locality
+	// on many-core machines, the steering only takes effect on Linux.
+	ReusePortCPUAffinity bool
+
+	// ReusePortEBPF is the file descriptor of a loaded eBPF program which selects the listener in the SO_REUSEPORT
+	// group for new connections or packets in ReusePort mode, every event-loop owns a listener in the group like
+	// ReusePortCPUAffinity and the index of listener in the group is the index of its event-loop. It takes
+	// precedence over the CPU-based selector of ReusePortCPUAffinity, 0 means not set, Linux only.
+	ReusePortEBPF int
+
+	// UDPConnect indicates whether to create a UDP socket connected to the remote peer on the first datagram
+	// from it, the connected socket is owned by the event-loop which receives the first datagram and the subsequent
+	// datagrams from the peer are demultiplexed to it by kernel, which spreads the load of stateful UDP services
+	// across sockets. The connected sockets fire OnOpened/OnClosed like TCP connections, Windows is not supported.
+	// The sessions are closed by IdleTimeout like TCP connections, and the peers over PerIPMaxConnections or
+	// UDPMaxSessions, or whose sockets would be over FDWatermark, are served by the shared socket of server instead.
+	UDPConnect bool
+
+	// UDPMaxSessions limits the connected UDP sockets of UDPConnect of the server, 0 means unlimited.
+	UDPMaxSessions int
+
+	// UDPControl indicates whether to receive the control messages along with the datagrams of UDP listeners,
+	// namely the destination address (IP_PKTINFO), the TTL or hop limit, the TOS/ECN bits and the timestamps
+	// of kernel (SO_TIMESTAMPNS), which are available via Conn.ControlMessage, Linux only.
+	UDPControl bool
+
+	// NumAcceptors is the number of main reactors which accept new connections from the same listener,
+	// it only takes effect on the TCP/unix listener without ReusePort, default 1.
+	NumAcceptors int
+
 	// Ticker indicates whether the ticker has been set up.
 	Ticker bool
 
+	// TickerAlign rounds the time of each Tick after the first up to a multiple of it on the wall clock, e.g. a
+	// second fires the ticks at the top of each second whatever delay Tick returns below it, the multiples are
+	// counted in UTC since the zero time, so the durations dividing a day align across the machines.
+	TickerAlign time.Duration
+
+	// TickerJitter delays each Tick after the first by a random duration in [0, TickerJitter) on top of the
+	// delay returned by Tick and TickerAlign, which keeps a fleet of servers from ticking all at the same time.
+	TickerJitter time.Duration
+
+	// ShutdownTimeout is the grace period for draining connections when the server is being shut down,
+	// if it is greater than 0, the server stops accepting new connections and closes the existing connections
+	// once they have neither buffered data nor asynchronous writes on the way and stop receiving data,
+	// the remaining connections are closed when the grace period expires.
+	// It is not supported on Windows yet.
+	ShutdownTimeout time.Duration
+
 	// TCPKeepAlive sets up a duration for (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// TCPUserTimeout sets up TCP_USER_TIMEOUT of the TCP connections, under which a connection is closed by kernel
+	// if its data stays unacknowledged for longer, see Conn.SetUserTimeout. It is only supported on Linux.
+	TCPUserTimeout time.Duration
+
+	// FirstByteTimeout is the duration within which a newly accepted connection must send the first bytes,
+	// otherwise it is closed with ErrFirstByteTimeout, which defends against the idle connections exhausting
+	// file descriptors. It only applies before the first bytes arrive, 0 means no timeout.
+	FirstByteTimeout time.Duration
+
+	// FirstDataBytes is the number of the leading bytes of a TCP connection passed to OnFirstData of
+	// FirstDataHandler, the connection waits for more data until they all arrive, 0 means the data of the first read
+	// whatever the size is.
+	FirstDataBytes int
+
+	// WriteCoalesceWindow is the time window within which the data of AsyncWrite calls to a connection
+	// are batched and written with one syscall, which saves syscalls for the servers pushing many small
+	// messages, 0 means every AsyncWrite is written on its own. The window is timed by the timing wheel of
+	// the event-loop on Unix, which is accurate to 10ms, the shorter windows end as soon as the event-loop gets
+	// to the batch.
+	WriteCoalesceWindow time.Duration
+
+	// WriteCoalesceMaxBytes makes the batch of WriteCoalesceWindow be written as soon as it reaches the size,
+	// 0 means no limit.
+	WriteCoalesceMaxBytes int
+
+	// ListenBacklog is the maximum length of the queue of pending connections of the listener, the limit of
+	// system (somaxconn) is used if it is not set and it also caps the given value, applied to every listener
+	// in the SO_REUSEPORT group. It is not supported on Windows.
+	ListenBacklog int
+
+	// DeferAccept indicates whether to set up TCP_DEFER_ACCEPT on the TCP listener, the connections are not delivered
+	// to event-loops until they have data to read, which saves resources on the connections only completing handshake
+	// and reduces wakeups for request/response protocols. FirstByteTimeout is used as the period of waiting if it is set,
+	// it is only supported on Linux.
+	DeferAccept bool
+
+	// AcceptFilter is the name of the accept filter (SO_ACCEPTFILTER) installed on the TCP listener, e.g. "dataready"
+	// which holds the new connections back in kernel until they have data to read, or "httpready" which holds them
+	// until a whole HTTP request is received, the kernel module of the filter (accf_data, accf_http, etc.) must be
+	// loaded. It is the counterpart of DeferAccept on BSD, it is only supported on FreeBSD, DragonFly and NetBSD.
+	AcceptFilter string
+
+	// IdleTimeout is the duration after which a connection receiving nothing is closed with ErrIdleTimeout,
+	// 0 means no timeout. It is accurate to 10ms and not supported on Windows yet.
+	IdleTimeout time.Duration
+
+	// PollTimeout is the maximum duration for which event-loops block in waiting for network-events,
+	// 0 means blocking until any event arrives.
+	PollTimeout time.Duration
+
+	// BusyPoll indicates whether event-loops keep spinning for a short while after handling network-events
+	// before blocking in waiting for new events, which trades CPU for lower latency.
+	BusyPoll bool
+
+	// BatchPollerUpdates makes event-loops hold the changes of interest in the events of connections until they
+	// wait for events next time, the changes of the same connection are coalesced and the ones which change
+	// nothing are dropped, which saves epoll_ctl(2) calls, counted by Server.LoopStats. It only takes effect on Linux.
+	BatchPollerUpdates bool
+
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
 
+	// ByteBufferPool allocates the byte buffers for frames and asynchronous writes, if it is not set,
+	// a built-in pool backed by sync.Pool is used.
+	ByteBufferPool ByteBufferPool
+
+	// TrafficTap is invoked on the event-loops with every chunk of data read from or written to connections,
+	// it observes the traffic for sniffing, debugging or audit mirroring without touching the handlers. The data
+	// is only valid during the call and must not be modified, the tap must be fast since it runs inline with I/O.
+	// The data written by SendTo and the bytes spliced in kernel by Splice are not tapped.
+	TrafficTap func(c Conn, dir Direction, data []byte)
+
+	// MaxFramesPerPoll is the maximum number of frames passed to React for a connection in one iteration of
+	// polling, the rest of frames are left in the inbound buffer and decoded in the next iteration after the events
+	// of the other connections sharing the event-loop, so that a chatty connection can't monopolize its event-loop.
+	// It doesn't apply to TrafficHandler, 0 means unlimited. It is not supported on Windows yet.
+	MaxFramesPerPoll int
+
+	// MaxFrameSize is the maximum size in bytes of the frame decoded by the codec, the connection sending a larger
+	// frame is closed with ErrFrameTooLarge. The built-in codecs fail early on the length field declaring a larger
+	// frame or on a partial frame growing beyond it, instead of buffering the whole frame, the custom codecs can do
+	// the same by returning ErrFrameTooLarge from Decode. It doesn't apply to TrafficHandler, 0 means unlimited.
+	MaxFrameSize int
+
+	// LazyInboundBuffer indicates whether to hold the inbound ring-buffer of connection only while a partial frame
+	// remains after the handler, it is meant for the strict request/response protocols whose frames are decoded
+	// straight from the read buffer of event-loop, which saves the memory of the idle connections. It is not
+	// supported on Windows yet.
+	LazyInboundBuffer bool
+
+	// LoopTaskQueueCap is the capacity of the queue of tasks submitted to every event-loop by the asynchronous APIs
+	// (AsyncWrite, AsyncWriteSeq, AsyncWriteWithTimeout, Wake, Execute, Server.Trigger and Server.Publish), the
+	// callers get the backpressure of LoopTaskQueueOverload when it is full instead of growing the queue without
+	// bound, 0 means unlimited. It is not supported on Windows, whose command channel is of fixed capacity.
+	LoopTaskQueueCap int
+
+	// LoopTaskQueueOverload is the policy applied when the queue of tasks is full with LoopTaskQueueCap.
+	LoopTaskQueueOverload OverloadPolicy
+
+	// LifecycleWorkers is the number of goroutines on which OnOpened and OnClosed of the TCP and unix connections
+	// run instead of the event-loops, so that the handlers calling slow external systems don't stall the other
+	// connections, 0 means running them on the event-loops. The connection is not read until its OnOpened returns,
+	// and its OnClosed runs after OnOpened, the connection is used in them like in any other goroutine.
+	// It is not supported on Windows yet.
+	LifecycleWorkers int
+
+	// WriteBuffering stages the data written to connections in their outbound buffers instead of writing them
+	// to the sockets right away, the staged data is flushed at the end of each batch of events handled by the
+	// event-loop or by Conn.Flush, so that the handlers writing many small pieces get them batched automatically.
+	// It is not supported on Windows yet.
+	WriteBuffering bool
+
+	// AcceptRate is the number of new connections accepted per second at most, which protects the rest of system
+	// from the connection storms, 0 means unlimited. The connections beyond the rate wait in the backlog of listener,
+	// and they are refused by the kernel once the backlog overflows, see ListenBacklog.
+	AcceptRate int
+
+	// AcceptBurst is the number of new connections that can be accepted at once in spite of AcceptRate.
+	AcceptBurst int
+
+	// IPv6Only restricts the listener of "tcp" or "udp" to IPv6 (IPV6_V6ONLY), which serves both IPv4 and IPv6
+	// by default when it is listening on the wildcard address, the same as the networks of "tcp6" and "udp6".
+	IPv6Only bool
+
+	// SocketMark is the mark (SO_MARK) set on the sockets of listeners and the connected UDP sockets of UDPConnect,
+	// which the rules of policy routing and the firewall match, 0 means not set. It requires CAP_NET_ADMIN and is
+	// only supported on Linux.
+	SocketMark int
+
+	// BindToDevice is the name of the network interface or the VRF device (SO_BINDTODEVICE) which the listeners
+	// and the connected UDP sockets of UDPConnect are bound to, they only receive the packets arriving on it and
+	// route the replies by its table. It is only supported on Linux.
+	BindToDevice string
+
+	// FreeBind indicates whether to set up IP_FREEBIND on the listeners and the connected UDP sockets of UDPConnect,
+	// which lets them bind to the addresses not assigned to the host yet, e.g. the floating addresses of a failover
+	// pair. It is only supported on Linux.
+	FreeBind bool
+
+	// ConnRecorder is invoked on the event-loops with the records of opening and closing connections, it feeds
+	// access logs or flow collectors without touching the handlers. It must be fast since it runs inline with
+	// the events, hand the records over to other goroutines for the slow sinks. The datagrams of UDP which are
+	// not in UDPConnect mode are not recorded, neither are the bytes spliced in kernel by Splice.
+	ConnRecorder func(rec ConnRecord)
+
+	// Tracer is the instrumentation of the lifecycle of connections and frames, see Tracer for the points
+	// of tracing. The datagrams of UDP which are not in UDPConnect mode are not traced.
+	Tracer Tracer
+
+	// LoopProfiling makes every event-loop measure the time it spends in waiting for events, in the handlers
+	// and in writing to the sockets, which is read by Server.LoopStats, and labels the goroutines of event-loops
+	// with "gnet-loop" for pprof, e.g. the CPU profiles can be broken down by event-loops with -tagfocus. It costs
+	// a few reads of clock per event.
+	LoopProfiling bool
+
+	// Compression compresses the outbound streams and decompresses the inbound streams of TCP connections
+	// transparently, so that the handlers and the codec deal with the plain data, the EventHandler may implement
+	// CompressionNegotiator to decide it for every connection. Every piece of outbound data is flushed in the
+	// compressed stream on its own, TrafficTap sees the plain data. The inbound stream of each connection is
+	// decompressed in a goroutine of its own, the connection is not read while 256 KiB are waiting for it, and
+	// the bytes held by it count toward MemoryLimit. It is not supported on Windows yet.
+	Compression Compression
+
+	// CompressionLevel is the level of Compression, which is one of the levels defined in compress/flate.
+	CompressionLevel int
+
 	// Logger is the customized logger for logging info, if it is not set,
 	// default standard logger from log package is used.
 	Logger Logger
+
+	// DisablePanicRecovery lets the panics in the event callbacks crash the program, otherwise the event-loop
+	// recovers them, closes the connection whose event is being handled with ErrPanic and goes on serving
+	// the others. The panics are logged with the stack traces by Logger unless PanicHandler is set.
+	DisablePanicRecovery bool
+
+	// DebugChecks instruments the connections for catching the misuse of gnet in development, which panics with
+	// the way of fixing it: the buffer methods of Conn (Read, ReadN, ShiftN, Peek, Next, etc.) called outside the
+	// event-loop of the connection or after it is closed, and the inbound data following the frame overwritten
+	// by React, e.g. appending to the frame. The data read by the event-loops is also overwritten with 0xDE once
+	// it's handled, so that the frames kept beyond React without RetainFrame read as garbage right away rather than
+	// by chance. It slows down the buffer methods a lot, so it must not be set in production. It is not supported
+	// on Windows yet.
+	DebugChecks bool
+
+	// PanicHandler is invoked on the event-loop with the panic recovered by it and the stack trace, c is the
+	// connection to be closed, which is nil if the panic is not caused by the events of a connection, e.g. Tick.
+	PanicHandler func(c Conn, p interface{}, stack []byte)
+
+	// FDWatermark is the fraction (0, 1] of the limit of file descriptors of process (RLIMIT_NOFILE) from which
+	// FDLimitPolicy is applied to the accepted connections, instead of failing to accept them with EMFILE once
+	// the limit is reached, 0 disables it. It is not supported on Windows.
+	FDWatermark float64
+
+	// FDLimitPolicy decides what to do when the file descriptors reach FDWatermark.
+	FDLimitPolicy FDLimitPolicy
+
+	// SlowConsumerThreshold is the bytes in the outbound buffer of a connection above which the connection is
+	// taken as a slow consumer once it stays there for SlowConsumerTimeout, 0 disables the detection.
+	// It is not supported on Windows.
+	SlowConsumerThreshold int
+
+	// SlowConsumerTimeout is how long the outbound buffer may stay above SlowConsumerThreshold.
+	SlowConsumerTimeout time.Duration
+
+	// SlowConsumerPolicy decides what to do with the slow consumers after OnSlowConsumer.
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// UDPSendQueue is the number of datagrams queued per event-loop when the UDP socket of server would block
+	// in sending them, which are sent once the socket becomes writable, 0 means no queue. The datagrams beyond
+	// it are dealt with by UDPSendPolicy. It only applies to the datagrams sent from the socket of server, i.e.
+	// the replies of React and SendTo, not the connected sockets of UDPConnect, and it is not supported on Windows.
+	UDPSendQueue int
+
+	// UDPSendPolicy decides what to do with the datagrams which can't be sent or queued.
+	UDPSendPolicy UDPSendPolicy
+
+	// UDPSendTimeout is how long UDPSendBlock blocks the sender at most.
+	UDPSendTimeout time.Duration
+
+	// MemoryLimit is the upper bound of bytes held in inbound/outbound buffers of all connections,
+	// MemoryPolicy will be applied when it is exceeded, 0 means unlimited.
+	MemoryLimit int64
+
+	// MemoryPolicy decides what to do when MemoryLimit is exceeded.
+	MemoryPolicy MemoryPolicy
+
+	// MemoryLimitHandler is invoked with the current memory usage every time MemoryLimit is exceeded,
+	// before MemoryPolicy is applied.
+	MemoryLimitHandler func(usage, limit int64)
+
+	// PeerStats keeps the statistics of the stream connections per remote IP, which are read by Server.PeerStats.
+	// The IPs are aggregated by PeerIPv4Prefix and PeerIPv6Prefix, and the statistics of an IP are dropped once
+	// its last connection is closed.
+	PeerStats bool
+
+	// PeerIPv4Prefix and PeerIPv6Prefix are the lengths of the prefixes by which the remote IPs are aggregated
+	// in PeerStats, e.g. 24 and 64, the whole addresses are taken if they are 0.
+	PeerIPv4Prefix, PeerIPv6Prefix int
+
+	// PerIPMaxConnections limits the stream connections from every remote IP, or the prefix of PeerIPv4Prefix
+	// and PeerIPv6Prefix, the connections over the limit are closed right after being accepted, before OnOpened.
+	// It turns on PeerStats, the connections opened before it is set by Server.ApplyOptions for the first time
+	// are not counted unless PeerStats is set.
+	PerIPMaxConnections int
+
+	// err is the error of the invalid Config given by WithConfig, which fails Serve.
+	err error
 }
 
 // WithOptions sets up all options.
@@ -63,6 +381,13 @@ func WithMulticore(multicore bool) Option {
 	}
 }
 
+// WithEventLoopPicker sets up the callback assigning the accepted connections to the event-loops.
+func WithEventLoopPicker(picker func(addr net.Addr, loops int) int) Option {
+	return func(opts *Options) {
+		opts.EventLoopPicker = picker
+	}
+}
+
 // WithLoadBalancing sets up the load-balancing algorithm in gnet server.
 func WithLoadBalancing(lb LoadBalancing) Option {
 	return func(opts *Options) {
@@ -84,6 +409,55 @@ func WithReusePort(reusePort bool) Option {
 	}
 }
 
+// WithReusePortCPUAffinity sets up the CPU-based listener selector for the SO_REUSEPORT group.
+func WithReusePortCPUAffinity(affinity bool) Option {
+	return func(opts *Options) {
+		opts.ReusePortCPUAffinity = affinity
+	}
+}
+
+// WithReusePortEBPF sets up the eBPF program which selects the listener in the SO_REUSEPORT group.
+func WithReusePortEBPF(progFd int) Option {
+	return func(opts *Options) {
+		opts.ReusePortEBPF = progFd
+	}
+}
+
+// WithUDPControl sets up receiving the control messages along with the datagrams of UDP listeners.
+func WithUDPControl(control bool) Option {
+	return func(opts *Options) {
+		opts.UDPControl = control
+	}
+}
+
+// WithUDPConnect sets up the connected UDP sockets for the remote peers.
+func WithUDPConnect(connect bool) Option {
+	return func(opts *Options) {
+		opts.UDPConnect = connect
+	}
+}
+
+// WithUDPMaxSessions sets up the maximum number of the connected UDP sockets of UDPConnect.
+func WithUDPMaxSessions(n int) Option {
+	return func(opts *Options) {
+		opts.UDPMaxSessions = n
+	}
+}
+
+// WithNumAcceptors sets up the number of acceptors for the listener.
+func WithNumAcceptors(numAcceptors int) Option {
+	return func(opts *Options) {
+		opts.NumAcceptors = numAcceptors
+	}
+}
+
+// WithTCPUserTimeout sets up TCP_USER_TIMEOUT of the TCP connections.
+func WithTCPUserTimeout(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPUserTimeout = d
+	}
+}
+
 // WithTCPKeepAlive sets up SO_KEEPALIVE socket option.
 func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	return func(opts *Options) {
@@ -91,6 +465,84 @@ func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	}
 }
 
+// WithFirstByteTimeout sets up the timeout for newly accepted connections to send the first bytes.
+func WithFirstByteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.FirstByteTimeout = timeout
+	}
+}
+
+// WithFirstDataBytes sets up the number of the leading bytes previewed by OnFirstData.
+func WithFirstDataBytes(n int) Option {
+	return func(opts *Options) {
+		opts.FirstDataBytes = n
+	}
+}
+
+// WithWriteCoalescing sets up the time window and the size limit of batching the asynchronous writes.
+func WithWriteCoalescing(window time.Duration, maxBytes int) Option {
+	return func(opts *Options) {
+		opts.WriteCoalesceWindow = window
+		opts.WriteCoalesceMaxBytes = maxBytes
+	}
+}
+
+// WithListenBacklog sets up the backlog of the listener.
+func WithListenBacklog(backlog int) Option {
+	return func(opts *Options) {
+		opts.ListenBacklog = backlog
+	}
+}
+
+// WithAcceptFilter sets up the accept filter of the given name on the TCP listener.
+func WithAcceptFilter(name string) Option {
+	return func(opts *Options) {
+		opts.AcceptFilter = name
+	}
+}
+
+// WithDeferAccept sets up TCP_DEFER_ACCEPT on the TCP listener.
+func WithDeferAccept(deferAccept bool) Option {
+	return func(opts *Options) {
+		opts.DeferAccept = deferAccept
+	}
+}
+
+// WithIdleTimeout sets up the duration after which idle connections are closed.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.IdleTimeout = timeout
+	}
+}
+
+// WithPollTimeout sets up the maximum duration of blocking in waiting for network-events.
+func WithPollTimeout(pollTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.PollTimeout = pollTimeout
+	}
+}
+
+// WithBusyPoll sets up busy-polling mode for event-loops.
+func WithBusyPoll(busyPoll bool) Option {
+	return func(opts *Options) {
+		opts.BusyPoll = busyPoll
+	}
+}
+
+// WithLifecycleWorkers sets up the number of goroutines running OnOpened and OnClosed.
+func WithLifecycleWorkers(workers int) Option {
+	return func(opts *Options) {
+		opts.LifecycleWorkers = workers
+	}
+}
+
+// WithBatchPollerUpdates sets up the batching of the changes of interest in events for event-loops.
+func WithBatchPollerUpdates(batch bool) Option {
+	return func(opts *Options) {
+		opts.BatchPollerUpdates = batch
+	}
+}
+
 // WithTicker indicates that a ticker is set.
 func WithTicker(ticker bool) Option {
 	return func(opts *Options) {
@@ -98,6 +550,27 @@ func WithTicker(ticker bool) Option {
 	}
 }
 
+// WithTickerAlign sets up the wall-clock alignment of ticks.
+func WithTickerAlign(align time.Duration) Option {
+	return func(opts *Options) {
+		opts.TickerAlign = align
+	}
+}
+
+// WithTickerJitter sets up the random delay added to each tick.
+func WithTickerJitter(jitter time.Duration) Option {
+	return func(opts *Options) {
+		opts.TickerJitter = jitter
+	}
+}
+
+// WithShutdownTimeout sets up the grace period for draining connections when the server is being shut down.
+func WithShutdownTimeout(shutdownTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.ShutdownTimeout = shutdownTimeout
+	}
+}
+
 // WithCodec sets up a codec to handle TCP stream.
 func WithCodec(codec ICodec) Option {
 	return func(opts *Options) {
@@ -105,9 +578,251 @@ func WithCodec(codec ICodec) Option {
 	}
 }
 
+// WithByteBufferPool sets up a customized allocator for byte buffers.
+func WithByteBufferPool(pool ByteBufferPool) Option {
+	return func(opts *Options) {
+		opts.ByteBufferPool = pool
+	}
+}
+
+// WithTrafficTap sets up the read-only observer of the traffic of connections.
+func WithTrafficTap(tap func(c Conn, dir Direction, data []byte)) Option {
+	return func(opts *Options) {
+		opts.TrafficTap = tap
+	}
+}
+
+// WithMaxFramesPerPoll sets up the maximum number of frames passed to React for a connection in one iteration
+// of polling.
+func WithMaxFramesPerPoll(n int) Option {
+	return func(opts *Options) {
+		opts.MaxFramesPerPoll = n
+	}
+}
+
+// WithMaxFrameSize sets up the maximum size of the frame decoded by the codec.
+func WithMaxFrameSize(n int) Option {
+	return func(opts *Options) {
+		opts.MaxFrameSize = n
+	}
+}
+
+// WithLazyInboundBuffer sets up holding the inbound ring-buffer of connection only for the partial frames.
+func WithLazyInboundBuffer(lazy bool) Option {
+	return func(opts *Options) {
+		opts.LazyInboundBuffer = lazy
+	}
+}
+
+// WithLoopTaskQueueCap sets up the capacity of the queue of tasks submitted to every event-loop by the asynchronous
+// APIs along with the policy applied when it is full.
+func WithLoopTaskQueueCap(n int, overload OverloadPolicy) Option {
+	return func(opts *Options) {
+		opts.LoopTaskQueueCap = n
+		opts.LoopTaskQueueOverload = overload
+	}
+}
+
+// WithWriteBuffering sets up the staging of the writes to connections until the end of each batch of events.
+func WithWriteBuffering(writeBuffering bool) Option {
+	return func(opts *Options) {
+		opts.WriteBuffering = writeBuffering
+	}
+}
+
+// WithAcceptRateLimit sets up the token bucket which limits the rate of accepting new connections.
+func WithAcceptRateLimit(connsPerSec, burst int) Option {
+	return func(opts *Options) {
+		opts.AcceptRate = connsPerSec
+		opts.AcceptBurst = burst
+	}
+}
+
+// WithIPv6Only sets up IPV6_V6ONLY for the listener of "tcp" or "udp".
+func WithIPv6Only(ipv6Only bool) Option {
+	return func(opts *Options) {
+		opts.IPv6Only = ipv6Only
+	}
+}
+
+// WithSocketMark sets up SO_MARK for the listeners.
+func WithSocketMark(mark int) Option {
+	return func(opts *Options) {
+		opts.SocketMark = mark
+	}
+}
+
+// WithBindToDevice sets up SO_BINDTODEVICE for the listeners.
+func WithBindToDevice(device string) Option {
+	return func(opts *Options) {
+		opts.BindToDevice = device
+	}
+}
+
+// WithFreeBind sets up IP_FREEBIND for the listeners.
+func WithFreeBind(freeBind bool) Option {
+	return func(opts *Options) {
+		opts.FreeBind = freeBind
+	}
+}
+
+// WithConnRecorder sets up the observer of the records of opening and closing connections.
+func WithConnRecorder(recorder func(rec ConnRecord)) Option {
+	return func(opts *Options) {
+		opts.ConnRecorder = recorder
+	}
+}
+
+// WithTracer sets up the instrumentation of the lifecycle of connections and frames.
+func WithTracer(tracer Tracer) Option {
+	return func(opts *Options) {
+		opts.Tracer = tracer
+	}
+}
+
+// WithLoopProfiling sets up the profiling of event-loops.
+func WithLoopProfiling(profiling bool) Option {
+	return func(opts *Options) {
+		opts.LoopProfiling = profiling
+	}
+}
+
+// WithCompression sets up the transparent compression of the streams of connections.
+func WithCompression(alg Compression, level int) Option {
+	return func(opts *Options) {
+		opts.Compression = alg
+		opts.CompressionLevel = level
+	}
+}
+
 // WithLogger sets up a customized logger.
 func WithLogger(logger Logger) Option {
 	return func(opts *Options) {
 		opts.Logger = logger
 	}
 }
+
+// WithPanicRecovery sets up whether the event-loops recover the panics in the event callbacks, they do by default.
+func WithPanicRecovery(recovery bool) Option {
+	return func(opts *Options) {
+		opts.DisablePanicRecovery = !recovery
+	}
+}
+
+// WithDebugChecks sets up whether the connections are instrumented for catching the misuse of gnet in development.
+func WithDebugChecks(debug bool) Option {
+	return func(opts *Options) {
+		opts.DebugChecks = debug
+	}
+}
+
+// WithPanicHandler sets up the handler of the panics recovered by the event-loops.
+func WithPanicHandler(handler func(c Conn, p interface{}, stack []byte)) Option {
+	return func(opts *Options) {
+		opts.PanicHandler = handler
+	}
+}
+
+// WithFDLimitPolicy sets up the watermark of file descriptors of process and the policy applied from it.
+func WithFDLimitPolicy(watermark float64, policy FDLimitPolicy) Option {
+	return func(opts *Options) {
+		opts.FDWatermark = watermark
+		opts.FDLimitPolicy = policy
+	}
+}
+
+// WithSlowConsumer sets up the detection of slow consumers and the policy applied to them.
+func WithSlowConsumer(threshold int, timeout time.Duration, policy SlowConsumerPolicy) Option {
+	return func(opts *Options) {
+		opts.SlowConsumerThreshold = threshold
+		opts.SlowConsumerTimeout = timeout
+		opts.SlowConsumerPolicy = policy
+	}
+}
+
+// WithUDPSendQueue sets up the queue of datagrams waiting for the UDP socket to be writable and the policy applied
+// when it is full, the timeout only matters to UDPSendBlock.
+func WithUDPSendQueue(size int, policy UDPSendPolicy, timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.UDPSendQueue = size
+		opts.UDPSendPolicy = policy
+		opts.UDPSendTimeout = timeout
+	}
+}
+
+// WithMemoryLimit sets up the memory budget of server and the policy applied when it is exceeded.
+func WithMemoryLimit(limit int64, policy MemoryPolicy) Option {
+	return func(opts *Options) {
+		opts.MemoryLimit = limit
+		opts.MemoryPolicy = policy
+	}
+}
+
+// WithMemoryLimitHandler sets up a callback which is invoked when the memory budget is exceeded.
+func WithMemoryLimitHandler(handler func(usage, limit int64)) Option {
+	return func(opts *Options) {
+		opts.MemoryLimitHandler = handler
+	}
+}
+
+// WithPeerStats sets up the statistics per remote IP aggregated by the prefixes of the given lengths.
+func WithPeerStats(ipv4Prefix, ipv6Prefix int) Option {
+	return func(opts *Options) {
+		opts.PeerStats = true
+		opts.PeerIPv4Prefix, opts.PeerIPv6Prefix = ipv4Prefix, ipv6Prefix
+	}
+}
+
+// WithPerIPMaxConnections sets up the limit of connections per remote IP.
+func WithPerIPMaxConnections(n int) Option {
+	return func(opts *Options) {
+		opts.PerIPMaxConnections = n
+	}
+}
+
+// OptionGroup bundles the given options into one which applies them in order, so that a set of options tuned
+// for a workload can be defined once and shared by servers. The options given after the group override it.
+func OptionGroup(options ...Option) Option {
+	return func(opts *Options) {
+		for _, option := range options {
+			option(opts)
+		}
+	}
+}
+
+// PresetLowLatency returns the options tuned for the latency of request/response traffic: event-loops busy-poll
+// after handling events, the asynchronous writes are not coalesced and no connection handles more than 32 frames
+// in a row while the others wait. Multicore is left to the caller.
+func PresetLowLatency() Option {
+	return OptionGroup(
+		WithBusyPoll(true),
+		WithWriteCoalescing(0, 0),
+		WithBatchPollerUpdates(false),
+		WithMaxFramesPerPoll(32),
+	)
+}
+
+// PresetHighThroughput returns the options tuned for the throughput of the servers pushing many small messages:
+// the asynchronous writes are coalesced within 100µs up to 64KB, the changes of interest in events are batched
+// and connections handle all of their frames at once. Multicore is left to the caller.
+func PresetHighThroughput() Option {
+	return OptionGroup(
+		WithBusyPoll(false),
+		WithWriteCoalescing(100*time.Microsecond, 64*1024),
+		WithBatchPollerUpdates(true),
+		WithMaxFramesPerPoll(0),
+	)
+}
+
+// PresetManyIdleConns returns the options tuned for the servers holding many mostly idle connections: the inbound
+// buffers are only held for the partial frames, event-loops block without busy-polling, the changes of interest
+// in events are batched and the dead peers are detected by TCP keep-alive of 5 minutes. Multicore is left to
+// the caller.
+func PresetManyIdleConns() Option {
+	return OptionGroup(
+		WithLazyInboundBuffer(true),
+		WithBusyPoll(false),
+		WithBatchPollerUpdates(true),
+		WithTCPKeepAlive(5*time.Minute),
+	)
+}
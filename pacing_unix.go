@@ -0,0 +1,93 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// writePacer paces the writes of a connection set by SetWritePacing, it is owned by the event-loop.
+type writePacer struct {
+	quota    int             // bytes written per interval
+	interval time.Duration   // length of interval
+	budget   int             // bytes left to write in the current interval
+	timer    *internal.Timer // ends the current interval, which begins with the first write in it
+	held     bool            // the writable events are not polled until the budget is refilled
+}
+
+func (c *conn) SetWritePacing(bytesPerInterval int, interval time.Duration) {
+	if c.loop == nil || !c.opened || c.udpPeer != "" {
+		return
+	}
+	c.stopPacing()
+	if bytesPerInterval <= 0 || interval <= 0 {
+		return
+	}
+	// The paced writes must all go through the event-loop.
+	c.stopDirect()
+	c.pacer = &writePacer{quota: bytesPerInterval, interval: interval, budget: bytesPerInterval}
+}
+
+// stopPacing stops pacing the writes of the connection, the writable events are polled again if they are held.
+func (c *conn) stopPacing() {
+	p := c.pacer
+	if p == nil {
+		return
+	}
+	c.pacer = nil
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.held && c.opened && !c.outboundBuffer.IsEmpty() {
+		_ = c.loop.poller.ModReadWrite(c.fd)
+	}
+}
+
+// writesHeld reports whether the writes of the connection are held by pacing until the next interval.
+func (c *conn) writesHeld() bool {
+	return c.pacer != nil && c.pacer.held
+}
+
+// limit trims the data to be written by the budget of the current interval.
+func (p *writePacer) limit(head, tail []byte) ([]byte, []byte) {
+	if len(head) >= p.budget {
+		return head[:p.budget], nil
+	}
+	if rest := p.budget - len(head); len(tail) > rest {
+		tail = tail[:rest]
+	}
+	return head, tail
+}
+
+// spendPacing takes the bytes written to the socket from the budget of the current interval, which begins with
+// the first write in it.
+func (el *eventloop) spendPacing(c *conn, n int) {
+	p := c.pacer
+	if p == nil {
+		return
+	}
+	if p.budget -= n; p.timer == nil {
+		p.timer = el.timingWheel().AfterFunc(p.interval, func() error {
+			p.timer, p.budget = nil, p.quota
+			if p.held {
+				p.held = false
+				if c.opened && !c.outboundBuffer.IsEmpty() {
+					_ = el.poller.ModReadWrite(c.fd)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// holdWrites stops polling the writable events of the connection until the budget of pacing is refilled.
+func (el *eventloop) holdWrites(c *conn) {
+	c.pacer.held = true
+	_ = el.poller.ModRead(c.fd)
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWritePacing(t *testing.T) {
+	events := &testPacingServer{network: "tcp", addr: "127.0.0.1:9947"}
+	must(Serve(events, "tcp://127.0.0.1:9947", WithCodec(new(LineBasedFrameCodec))))
+	if elapsed := time.Duration(atomic.LoadInt64(&events.elapsed)); elapsed < time.Millisecond*250 {
+		t.Fatalf("expected 64KB paced at 16KB per 100ms to take 300ms, took %v", elapsed)
+	}
+}
+
+type testPacingServer struct {
+	*EventServer
+	network, addr string
+	elapsed       int64
+}
+
+func (s *testPacingServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		start := time.Now()
+		_, err = c.Write([]byte("get\n"))
+		must(err)
+		buf := make([]byte, 64<<10+len("\npong\n"))
+		_, err = io.ReadFull(c, buf[:16<<10])
+		must(err)
+		// The request sent while the response is paced is still read.
+		_, err = c.Write([]byte("ping\n"))
+		must(err)
+		_, err = io.ReadFull(c, buf[16<<10:])
+		must(err)
+		atomic.StoreInt64(&s.elapsed, int64(time.Since(start)))
+		if !bytes.Equal(buf[:64<<10], bytes.Repeat([]byte{'x'}, 64<<10)) || string(buf[64<<10:]) != "\npong\n" {
+			panic(fmt.Sprintf("unexpected paced response ending with %q", buf[64<<10:]))
+		}
+	}()
+	return
+}
+
+func (s *testPacingServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetWritePacing(16<<10, time.Millisecond*100)
+	return
+}
+
+func (s *testPacingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	switch string(frame) {
+	case "get":
+		out = bytes.Repeat([]byte{'x'}, 64<<10)
+	case "ping":
+		out = []byte("pong")
+	}
+	return
+}
+
+func (s *testPacingServer) OnClosed(c Conn, err error) (action Action) {
+	return Shutdown
+}
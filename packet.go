@@ -0,0 +1,36 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"strings"
+)
+
+// PacketAddr is the link-layer address of the frames served on the "packet" network.
+type PacketAddr struct {
+	// Ifindex is the index of the network interface.
+	Ifindex int
+	// Protocol is the EtherType of the frame in host byte order.
+	Protocol uint16
+	// HardwareAddr is the hardware address of the sender, or of the interface for the local address.
+	HardwareAddr net.HardwareAddr
+}
+
+// Network returns the address's network name, "packet".
+func (a *PacketAddr) Network() string { return "packet" }
+
+func (a *PacketAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return a.HardwareAddr.String()
+}
+
+// isRawNetwork reports whether the given network is the raw IP or the link-layer network,
+// whose packets are delivered to React with the headers of the layer.
+func isRawNetwork(network string) bool {
+	return network == "packet" || strings.HasPrefix(network, "ip")
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenPacketSocket returns ErrUnsupportedProtocol since AF_PACKET is only supported on Linux.
+func listenPacketSocket(_ string) (net.PacketConn, error) {
+	return nil, ErrUnsupportedProtocol
+}
+
+func sockaddrToPacketAddr(_ unix.Sockaddr) net.Addr {
+	return nil
+}
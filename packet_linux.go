@@ -0,0 +1,99 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var errPacketDeadline = errors.New("deadline is not supported by the packet socket")
+
+// packetConn is the net.PacketConn of an AF_PACKET socket bound to a network interface, gnet only
+// takes the file descriptor from it and serves the socket in the event-loops.
+type packetConn struct {
+	fd    int
+	laddr *PacketAddr
+}
+
+// listenPacketSocket opens an AF_PACKET socket which receives the frames of all protocols on the interface.
+func listenPacketSocket(ifname string) (net.PacketConn, error) {
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	proto := htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return &packetConn{
+		fd:    fd,
+		laddr: &PacketAddr{Ifindex: ifi.Index, Protocol: unix.ETH_P_ALL, HardwareAddr: ifi.HardwareAddr},
+	}, nil
+}
+
+func (pc *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, sa, err := unix.Recvfrom(pc.fd, p, 0)
+	if err != nil {
+		return 0, nil, os.NewSyscallError("recvfrom", err)
+	}
+	return n, sockaddrToPacketAddr(sa), nil
+}
+
+func (pc *packetConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	pa, ok := addr.(*PacketAddr)
+	if !ok {
+		return 0, unix.EINVAL
+	}
+	sa := &unix.SockaddrLinklayer{Protocol: htons(pa.Protocol), Ifindex: pa.Ifindex, Halen: uint8(len(pa.HardwareAddr))}
+	copy(sa.Addr[:], pa.HardwareAddr)
+	if err := unix.Sendto(pc.fd, p, 0, sa); err != nil {
+		return 0, os.NewSyscallError("sendto", err)
+	}
+	return len(p), nil
+}
+
+// File returns a copy of the socket like the File of net.UDPConn does.
+func (pc *packetConn) File() (*os.File, error) {
+	fd, err := unix.Dup(pc.fd)
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	unix.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "packet:"+pc.laddr.String()), nil
+}
+
+func (pc *packetConn) Close() error                       { return unix.Close(pc.fd) }
+func (pc *packetConn) LocalAddr() net.Addr                { return pc.laddr }
+func (pc *packetConn) SetDeadline(_ time.Time) error      { return errPacketDeadline }
+func (pc *packetConn) SetReadDeadline(_ time.Time) error  { return errPacketDeadline }
+func (pc *packetConn) SetWriteDeadline(_ time.Time) error { return errPacketDeadline }
+
+// sockaddrToPacketAddr converts a Sockaddr to a PacketAddr, returns nil if conversion fails.
+func sockaddrToPacketAddr(sa unix.Sockaddr) net.Addr {
+	sll, ok := sa.(*unix.SockaddrLinklayer)
+	if !ok {
+		return nil
+	}
+	hw := make(net.HardwareAddr, sll.Halen)
+	copy(hw, sll.Addr[:])
+	return &PacketAddr{Ifindex: sll.Ifindex, Protocol: htons(sll.Protocol), HardwareAddr: hw}
+}
+
+// htons converts the short integer between host and network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,14 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !darwin,!netbsd,!freebsd,!openbsd,!dragonfly,!linux
+
+package gnet
+
+import "net"
+
+// listenPacketSocket returns ErrUnsupportedProtocol since AF_PACKET is only supported on Linux.
+func listenPacketSocket(_ string) (net.PacketConn, error) {
+	return nil, ErrUnsupportedProtocol
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// reportPanic passes the panic recovered by the event-loop to PanicHandler, or logs it along with the stack trace
+// if there is none, c is nil if the panic is not caused by the events of a connection.
+func (svr *server) reportPanic(idx int, c Conn, p interface{}, stack []byte) {
+	if h := svr.opts.PanicHandler; h != nil {
+		h(c, p, stack)
+		return
+	}
+	if c != nil {
+		svr.logger().Printf("event-loop:%d recovered from panic of connection %v: %v\n%s", idx, c.RemoteAddr(), p, stack)
+	} else {
+		svr.logger().Printf("event-loop:%d recovered from panic: %v\n%s", idx, p, stack)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PeerStats is the statistics of the connections from a remote IP, or the prefix of PeerIPv4Prefix and
+// PeerIPv6Prefix, which is collected with Options.PeerStats.
+type PeerStats struct {
+	// Connections is the number of the active connections.
+	Connections int
+
+	// BytesIn and BytesOut are the bytes read from and written to the connections since the first of them
+	// was opened.
+	BytesIn, BytesOut uint64
+
+	// InRate and OutRate are the bytes per second read and written, averaged over the last period between
+	// the calls of PeerStats for the peer which is at least a second long, or since the first connection was
+	// opened if there is no such period yet.
+	InRate, OutRate float64
+}
+
+// PeerStats returns the statistics of the connections from the given remote IP, the IP is aggregated by
+// the prefix lengths of options. It returns false if there are no connections from the IP or PeerStats
+// is not set.
+func (s Server) PeerStats(ip net.IP) (PeerStats, bool) {
+	return s.svr.peers().stats(ip)
+}
+
+// peers returns the table of peers, nil unless PeerStats or PerIPMaxConnections has been set.
+func (svr *server) peers() *peerTable {
+	t, _ := svr.peerTab.Load().(*peerTable)
+	return t
+}
+
+// reloadPeers sets up the table of peers once PerIPMaxConnections is set by ApplyOptions for the first time,
+// the connections opened before that are not counted.
+func (svr *server) reloadPeers(opts *Options) {
+	if opts.PerIPMaxConnections > 0 && svr.peers() == nil {
+		svr.peerTab.Store(newPeerTable(opts))
+	}
+}
+
+// peerTable aggregates the connections by the remote IPs for PeerStats and PerIPMaxConnections.
+type peerTable struct {
+	mu     sync.Mutex
+	peers  map[string]*peerEntry
+	v4, v6 net.IPMask
+}
+
+// peerEntry is the statistics of a remote IP, the bytes are counted by the event-loops atomically, the others
+// are guarded by the lock of table.
+type peerEntry struct {
+	bytesIn, bytesOut     uint64
+	key                   string
+	conns                 int
+	sampledAt             time.Time // start of the current period of rates
+	sampledIn, sampledOut uint64    // bytes at sampledAt
+	inRate, outRate       float64   // rates of the last period
+	rated                 bool      // inRate and outRate are set
+}
+
+// newPeerTable returns the table of peers set up by options, nil if PeerStats and PerIPMaxConnections
+// are not set.
+func newPeerTable(opts *Options) *peerTable {
+	if !opts.PeerStats && opts.PerIPMaxConnections <= 0 {
+		return nil
+	}
+	v4, v6 := opts.PeerIPv4Prefix, opts.PeerIPv6Prefix
+	if v4 <= 0 || v4 > 32 {
+		v4 = 32
+	}
+	if v6 <= 0 || v6 > 128 {
+		v6 = 128
+	}
+	return &peerTable{
+		peers: make(map[string]*peerEntry),
+		v4:    net.CIDRMask(v4, 32),
+		v6:    net.CIDRMask(v6, 128),
+	}
+}
+
+// key returns the key of the given IP in the table, which is the IP masked by the prefix.
+func (t *peerTable) key(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(t.v4).String()
+	}
+	return ip.Mask(t.v6).String()
+}
+
+// acquire counts a new connection from the given remote address, it returns false if the connection is over
+// max, the returned entry is nil for the addresses other than IP.
+func (t *peerTable) acquire(addr net.Addr, max int) (*peerEntry, bool) {
+	if t == nil {
+		return nil, true
+	}
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return nil, true
+	}
+	key := t.key(ip)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.peers[key]
+	if e == nil {
+		e = &peerEntry{key: key, sampledAt: time.Now()}
+		t.peers[key] = e
+	} else if max > 0 && e.conns >= max {
+		return nil, false
+	}
+	e.conns++
+	return e, true
+}
+
+// release stops counting a closed connection, the entry is dropped along with its last connection.
+func (t *peerTable) release(e *peerEntry) {
+	t.mu.Lock()
+	if e.conns--; e.conns == 0 {
+		delete(t.peers, e.key)
+	}
+	t.mu.Unlock()
+}
+
+func (t *peerTable) stats(ip net.IP) (PeerStats, bool) {
+	if t == nil || ip == nil {
+		return PeerStats{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.peers[t.key(ip)]
+	if e == nil {
+		return PeerStats{}, false
+	}
+	in, out := atomic.LoadUint64(&e.bytesIn), atomic.LoadUint64(&e.bytesOut)
+	st := PeerStats{Connections: e.conns, BytesIn: in, BytesOut: out, InRate: e.inRate, OutRate: e.outRate}
+	if elapsed := time.Since(e.sampledAt).Seconds(); elapsed >= 1 || !e.rated && elapsed > 0 {
+		st.InRate, st.OutRate = float64(in-e.sampledIn)/elapsed, float64(out-e.sampledOut)/elapsed
+		if elapsed >= 1 {
+			e.sampledAt, e.sampledIn, e.sampledOut = time.Now(), in, out
+			e.inRate, e.outRate, e.rated = st.InRate, st.OutRate, true
+		}
+	}
+	return st, true
+}
+
+func (e *peerEntry) addIn(n int) {
+	atomic.AddUint64(&e.bytesIn, uint64(n))
+}
+
+func (e *peerEntry) addOut(n int) {
+	atomic.AddUint64(&e.bytesOut, uint64(n))
+}
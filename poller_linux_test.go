@@ -0,0 +1,77 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchPollerUpdates(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		events := &testBatchUpdatesServer{network: "tcp", addr: "127.0.0.1:9946"}
+		must(Serve(events, "tcp://127.0.0.1:9946", WithCodec(new(LineBasedFrameCodec)),
+			WithBatchPollerUpdates(batch)))
+		var updates, syscalls uint64
+		for _, stats := range events.svr.LoopStats() {
+			updates += stats.PollerUpdates
+			syscalls += stats.PollerSyscalls
+		}
+		if !batch && syscalls != updates {
+			t.Fatalf("expected an epoll_ctl call per update without batching, got %d calls for %d updates",
+				syscalls, updates)
+		}
+		if batch && syscalls >= updates {
+			t.Fatalf("expected less epoll_ctl calls than updates with batching, got %d calls for %d updates",
+				syscalls, updates)
+		}
+	}
+}
+
+type testBatchUpdatesServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+}
+
+func (s *testBatchUpdatesServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		// The pipelined requests are handled in one batch.
+		req := []byte(strings.Repeat("ping\n", 10))
+		buf := make([]byte, len("pong\n")*10)
+		for i := 0; i < 10; i++ {
+			_, err = c.Write(req)
+			must(err)
+			_, err = io.ReadFull(c, buf)
+			must(err)
+		}
+		_, err = c.Write([]byte("bye\n"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testBatchUpdatesServer) OnOpened(c Conn) (out []byte, action Action) {
+	// Paced writes renew the interest in writable events on every write, which are coalesced by batching.
+	c.SetWritePacing(1<<20, time.Second)
+	return
+}
+
+func (s *testBatchUpdatesServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "bye" {
+		return nil, Shutdown
+	}
+	return []byte("pong"), None
+}
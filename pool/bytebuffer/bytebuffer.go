@@ -2,6 +2,8 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
+// Package bytebuffer pools the byte buffers of bytebufferpool, which gnet uses for the frames and the copies
+// of data from ring-buffers.
 package bytebuffer
 
 import "github.com/valyala/bytebufferpool"
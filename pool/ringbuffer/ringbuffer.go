@@ -3,6 +3,8 @@
 // Use of this source code is governed by a MIT license that can be found
 // at https://github.com/valyala/bytebufferpool/blob/master/LICENSE
 
+// Package ringbuffer pools the ring-buffers of package ringbuffer, the pool calibrates the size of new buffers
+// by the sizes of the buffers put back, which is the pool of the buffers of gnet connections as well.
 package ringbuffer
 
 import (
@@ -0,0 +1,113 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LoopStats is the profile of an event-loop collected with Options.LoopProfiling, the durations are accumulated
+// since the server started. The time of an event-loop not in PollWait is in Busy, which includes Handler
+// and Flush, a loop which is rarely waiting is saturated, and Handler taking most of Busy means the handlers
+// are too slow for the loop.
+type LoopStats struct {
+	// Connections is the number of active connections of the event-loop.
+	Connections int
+
+	// PollWait is the time spent in waiting for events, i.e. idle.
+	PollWait time.Duration
+
+	// Busy is the time spent in handling the events, the asynchronous tasks and the timers.
+	Busy time.Duration
+
+	// Handler is the time spent in React, ReactBatch and the handlers set by SetState.
+	Handler time.Duration
+
+	// Flush is the time spent in writing to the sockets.
+	Flush time.Duration
+
+	// PollerUpdates is the number of the changes of interest in events requested to the poller, and PollerSyscalls
+	// is the number of epoll_ctl(2) calls made for them, which is less than PollerUpdates with BatchPollerUpdates.
+	// They are counted regardless of LoopProfiling, on Linux only.
+	PollerUpdates, PollerSyscalls uint64
+
+	// UDPQueued is the number of the datagrams queued by UDPSendQueue since the UDP socket of server would block,
+	// and UDPDropped is the number of the ones dropped by UDPSendPolicy, they are counted regardless of
+	// LoopProfiling, but not on Windows.
+	UDPQueued, UDPDropped uint64
+}
+
+// LoopStats returns the profiles of the event-loops in the order of their indexes, the durations of which are
+// all zero unless LoopProfiling is set.
+func (s Server) LoopStats() []LoopStats {
+	stats := make([]LoopStats, len(s.svr.loops))
+	for i, el := range s.svr.loops {
+		updates, syscalls := el.pollerUpdates()
+		queued, dropped := el.udpSendStats()
+		stats[i] = LoopStats{
+			Connections: int(atomic.LoadInt32(&el.connCount)),
+			PollWait:    time.Duration(atomic.LoadInt64(&el.profile.pollWait)),
+			Busy:        time.Duration(atomic.LoadInt64(&el.profile.busy)),
+			Handler:     time.Duration(atomic.LoadInt64(&el.profile.handler)),
+			Flush:       time.Duration(atomic.LoadInt64(&el.profile.flush)),
+
+			PollerUpdates:  updates,
+			PollerSyscalls: syscalls,
+			UDPQueued:      queued,
+			UDPDropped:     dropped,
+		}
+	}
+	return stats
+}
+
+// loopIndex returns the index of the given event-loop in LoopStats, which stays the same while the indexes
+// of load balancers may not, e.g. those of LeastConnections are the positions in its heap.
+func (svr *server) loopIndex(el *eventloop) int {
+	for i, l := range svr.loops {
+		if l == el {
+			return i
+		}
+	}
+	return -1
+}
+
+// loopProfile accumulates the durations of LoopStats in nanoseconds, they are written by the event-loop
+// and read by LoopStats atomically.
+type loopProfile struct {
+	pollWait, busy, handler, flush int64
+	lastWake                       time.Time // end of the last wait, zero before the first one
+}
+
+// wait records a wait for events and the busy time between it and the last one, it is the wait hook of poller.
+func (p *loopProfile) wait(start, end time.Time) {
+	if !p.lastWake.IsZero() {
+		atomic.AddInt64(&p.busy, int64(start.Sub(p.lastWake)))
+	}
+	atomic.AddInt64(&p.pollWait, int64(end.Sub(start)))
+	p.lastWake = end
+}
+
+// since adds the time elapsed since start to the given duration, it is meant to be deferred like
+// defer p.since(&p.handler, time.Now()).
+func (p *loopProfile) since(d *int64, start time.Time) {
+	atomic.AddInt64(d, int64(time.Since(start)))
+}
+
+// labelLoop labels the calling goroutine with the index of the given event-loop for pprof, or "main" for
+// the main reactors accepting connections.
+func (svr *server) labelLoop(el *eventloop) {
+	id := "main"
+	for i, l := range svr.loops {
+		if l == el {
+			id = strconv.Itoa(i)
+			break
+		}
+	}
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("gnet-loop", id)))
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is the token bucket which limits the rate of accepting new connections, it is shared by all
+// acceptors of server.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time // time of the last take, zero before the first one
+}
+
+// throttleAccept takes a token of AcceptRate for accepting a new connection, it returns 0 if the token is taken
+// or AcceptRate is not set, or how long it takes for the next token to be available otherwise.
+func (svr *server) throttleAccept() time.Duration {
+	opts := svr.options()
+	if opts.AcceptRate <= 0 {
+		return 0
+	}
+	return svr.acceptLimiter.take(time.Now(), opts.AcceptRate, opts.AcceptBurst)
+}
+
+// take takes a token from the bucket with the given rate and burst, which may change between the calls,
+// it returns 0 if the token is taken, or how long it takes for the next token to be available otherwise.
+func (tb *tokenBucket) take(now time.Time, rate, burst int) time.Duration {
+	if burst < 1 {
+		burst = 1
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.last.IsZero() {
+		tb.tokens, tb.last = float64(burst), now
+	}
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * float64(rate)
+		if tb.tokens > float64(burst) {
+			tb.tokens = float64(burst)
+		}
+		tb.last = now
+	}
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / float64(rate) * float64(time.Second))
+}
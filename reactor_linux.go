@@ -6,47 +6,47 @@
 
 package gnet
 
-import "github.com/panjf2000/gnet/internal/netpoll"
-
-func (svr *server) activateMainReactor() {
-	defer svr.signalShutdown()
+func (svr *server) activateMainReactor(el *eventloop) {
+	var err error
+	defer func() {
+		svr.signalShutdown(err)
+	}()
 
-	svr.logger.Printf("main reactor exits with error:%v\n", svr.mainLoop.poller.Polling(func(fd int, ev uint32) error {
-		return svr.acceptNewConnection(fd)
-	}))
+	el.startProfiling()
+	err = el.polling(func() error {
+		return el.poller.Polling(func(fd int, ev uint32) error {
+			if el.throttleAccept() {
+				return nil
+			}
+			return svr.acceptNewConnection(fd)
+		})
+	})
+	svr.logger().Printf("main reactor exits with error:%v\n", err)
 }
 
 func (svr *server) activateSubReactor(el *eventloop) {
+	var err error
 	defer func() {
 		el.closeAllConns()
 		if el.idx == 0 && svr.opts.Ticker {
-			close(svr.ticktock)
+			el.stopTicker()
 		}
-		svr.signalShutdown()
+		svr.signalShutdown(err)
 	}()
 
 	if el.idx == 0 && svr.opts.Ticker {
-		go el.loopTicker()
+		el.startTicker()
 	}
+	el.poller.SetBatchHook(el.endBatch)
+	el.startProfiling()
 
-	svr.logger.Printf("event-loop:%d exits with error:%v\n", el.idx, el.poller.Polling(func(fd int, ev uint32) error {
-		if c, ack := el.connections[fd]; ack {
-			switch c.outboundBuffer.IsEmpty() {
-			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-			case false:
-				if ev&netpoll.OutEvents != 0 {
-					return el.loopWrite(c)
-				}
-				return nil
-			case true:
-				if ev&netpoll.InEvents != 0 {
-					return el.loopRead(c)
-				}
-				return nil
+	err = el.polling(func() error {
+		return el.poller.Polling(func(fd int, ev uint32) error {
+			if _, ack := el.connections[fd]; ack {
+				return el.handleEvent(fd, ev)
 			}
-		}
-		return nil
-	}))
+			return nil
+		})
+	})
+	svr.logger().Printf("event-loop:%d exits with error:%v\n", el.idx, err)
 }
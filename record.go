@@ -0,0 +1,33 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// ConnRecord is the record of an event in the lifecycle of a connection, which is emitted to Options.ConnRecorder
+// when the connection is opened and when it is closed.
+type ConnRecord struct {
+	// Closed tells the closing of connection from the opening.
+	Closed bool
+
+	// LocalAddr and RemoteAddr are the addresses of connection.
+	LocalAddr, RemoteAddr net.Addr
+
+	// OpenedAt is the time of opening the connection.
+	OpenedAt time.Time
+
+	// Duration is the lifetime of connection, which is 0 for the opening.
+	Duration time.Duration
+
+	// BytesIn and BytesOut are the bytes read from and written to the socket of connection, which are 0
+	// for the opening.
+	BytesIn, BytesOut uint64
+
+	// Reason is the reason of closing the connection that is passed to OnClosed, nil for the opening.
+	Reason error
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownReport sums up the lifetime of a server, it is returned by Server.Wait once the server has stopped.
+type ShutdownReport struct {
+	// Reason is why the server was shut down: ErrServerShutdown for the Shutdown action returned by the handler,
+	// ErrShutdownBySignal for SIGINT or SIGTERM, otherwise the error which terminated an event-loop.
+	Reason error
+
+	// Uptime is the time from starting the event-loops to the end of shutdown.
+	Uptime time.Duration
+
+	// Connections is the number of connections opened during the lifetime, including the adopted connections and
+	// the connected UDP sockets of UDPConnect.
+	Connections uint64
+
+	// BytesRead and BytesWritten are the bytes read from and written to the connections.
+	BytesRead, BytesWritten uint64
+
+	// Closed counts the closed connections by the reasons declared by gnet which are passed to OnClosed, e.g.
+	// ErrEOF and ErrIdleTimeout, the connections closed for the other errors, i.e. the raw errors of the failed
+	// I/O, are counted by OtherErrors.
+	Closed      map[error]uint64
+	OtherErrors uint64
+}
+
+// closeReasons are the reasons of closing connections declared by gnet, counted by ShutdownReport.Closed.
+var closeReasons = map[error]bool{
+	ErrEOF:              true,
+	ErrReset:            true,
+	ErrClosedByHandler:  true,
+	ErrBufferLimit:      true,
+	ErrWriteTimeout:     true,
+	ErrIdleTimeout:      true,
+	ErrFDLimit:          true,
+	ErrSlowConsumer:     true,
+	ErrPanic:            true,
+	ErrFrameTooLarge:    true,
+	ErrFirstByteTimeout: true,
+	ErrServerShutdown:   true,
+}
+
+// lifetime counts the connections of an event-loop for ShutdownReport, it is only accessed by the event-loop
+// until the server stops.
+type lifetime struct {
+	opened uint64
+	closed map[error]uint64
+	others uint64
+}
+
+func (lt *lifetime) close(reason error) {
+	if !closeReasons[reason] {
+		lt.others++
+		return
+	}
+	if lt.closed == nil {
+		lt.closed = make(map[error]uint64)
+	}
+	lt.closed[reason]++
+}
+
+// report sums up the lifetimes of the event-loops, it must be called after they all have stopped.
+func (svr *server) report() ShutdownReport {
+	r := ShutdownReport{Reason: svr.serr, Uptime: time.Since(svr.startedAt), Closed: make(map[error]uint64)}
+	for _, el := range svr.loops {
+		r.Connections += el.lifetime.opened
+		r.OtherErrors += el.lifetime.others
+		for reason, n := range el.lifetime.closed {
+			r.Closed[reason] += n
+		}
+		r.BytesRead += atomic.LoadUint64(&el.bytesIn)
+		r.BytesWritten += atomic.LoadUint64(&el.bytesOut)
+	}
+	return r
+}
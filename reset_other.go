@@ -0,0 +1,17 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly,!windows,!plan9
+
+package gnet
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isConnReset reports whether the error of I/O means that the connection is reset by peer.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,11 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// isConnReset reports whether the error of I/O means that the connection is reset by peer, there are no errnos
+// on Plan 9, the resets are reported as the other errors.
+func isConnReset(err error) bool {
+	return false
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+// attachReusePortProg does nothing since the selection of listener in the SO_REUSEPORT group by program
+// is only supported on Linux.
+func attachReusePortProg(_ int, _ *Options, _ int) error {
+	return nil
+}
+
+// bindCPU does nothing since the affinity of threads can't be set portably on BSD.
+func bindCPU(_ int) error {
+	return nil
+}
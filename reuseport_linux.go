@@ -0,0 +1,42 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	soAttachReusePortCBPF = 0x33
+	soAttachReusePortEBPF = 0x34
+	skfAdCPU              = 0xfffff024 // SKF_AD_OFF + SKF_AD_CPU
+)
+
+// attachReusePortProg attaches the program which selects the listener in the SO_REUSEPORT group for new
+// connections or packets, either the eBPF program given by options or a classic BPF program which returns
+// the index of CPU handling the softirq modulo the number of listeners.
+func attachReusePortProg(fd int, opts *Options, numListeners int) error {
+	if opts.ReusePortEBPF > 0 {
+		return unix.SetsockoptInt(fd, unix.SOL_SOCKET, soAttachReusePortEBPF, opts.ReusePortEBPF)
+	}
+	code := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdCPU},
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(numListeners)},
+		{Code: unix.BPF_RET | unix.BPF_A},
+	}
+	prog := unix.SockFprog{Len: uint16(len(code)), Filter: (*unix.SockFilter)(unsafe.Pointer(&code[0]))}
+	return unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, soAttachReusePortCBPF, &prog)
+}
+
+// bindCPU binds the calling thread to the given CPU.
+func bindCPU(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -4,6 +4,9 @@
 // Use of this source code is governed by a MIT-style
 // license that can be found in the LICENSE file.
 
+// Package ringbuffer provides the growable circular buffer which gnet keeps the inbound and outbound data of
+// connections in, LazyRead and LazyReadAll return the buffered bytes as two slices without copying them, so the
+// protocol libraries built on gnet can decode frames in place. The pooled buffers are got from pool/ringbuffer.
 package ringbuffer
 
 import (
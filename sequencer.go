@@ -0,0 +1,45 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// sequencer keeps the responses of AsyncWriteSeq in the order of the frames they answer, so that pipelined
+// requests handled out of order get their responses in order, it is owned by the event-loop of the connection.
+type sequencer struct {
+	frame   uint64            // sequence of the latest frame passed to React
+	flushed uint64            // sequence of the latest response written out
+	pending map[uint64][]byte // responses held until the earlier ones are written out
+}
+
+// next assigns the sequence to the frame which is about to be passed to React.
+func (s *sequencer) next() uint64 {
+	s.frame++
+	return s.frame
+}
+
+// push holds the response of the given sequence and writes out all the responses that are in order by then,
+// the stale and duplicate responses are discarded, the nil ones are skipped.
+func (s *sequencer) push(seq uint64, buf []byte, write func([]byte)) {
+	if seq <= s.flushed {
+		return
+	}
+	if seq != s.flushed+1 {
+		if s.pending == nil {
+			s.pending = make(map[uint64][]byte)
+		}
+		s.pending[seq] = buf
+		return
+	}
+	for {
+		if buf != nil {
+			write(buf)
+		}
+		s.flushed++
+		var ok bool
+		if buf, ok = s.pending[s.flushed+1]; !ok {
+			return
+		}
+		delete(s.pending, s.flushed+1)
+	}
+}
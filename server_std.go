@@ -0,0 +1,268 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package gnet
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// commandBufferSize represents the buffer size of event-loop command channel of the engine on net package.
+const (
+	commandBufferSize = 512
+)
+
+var errCloseAllConns = errors.New("close all connections in event-loop")
+
+// server is the engine built on net package with a goroutine per connection, it serves the platforms without
+// epoll or kqueue, i.e. Windows, js/wasm, Plan 9, Solaris, AIX, etc.
+type server struct {
+	ln              *listener          // all the listeners
+	cond            *sync.Cond         // shutdown signaler
+	signaled        bool               // shutdown has been signaled, guarded by cond.L
+	opts            *Options           // options with server
+	curOpts         atomic.Value       // *Options, the latest options changed at runtime
+	optsLock        sync.Mutex         // serializes the changes of options at runtime
+	pickLock        sync.Mutex         // serializes picking event-loops for the accepted and adopted connections
+	serr            error              // reason of shutdown, guarded by cond.L
+	once            sync.Once          // make sure only signalShutdown once
+	waitOnce        sync.Once          // make sure only waiting for shutdown once
+	final           ShutdownReport     // report returned by Server.Wait
+	codec           ICodec             // codec for TCP stream
+	bufPool         ByteBufferPool     // allocator of byte buffers
+	loopWG          sync.WaitGroup     // loop close WaitGroup
+	ticktock        chan time.Duration // ticker channel
+	mem             *memAccountant     // memory accountant of all buffers
+	listenerWG      sync.WaitGroup     // listener close WaitGroup
+	bus             bus                // pub/sub bus of connections
+	peerTab         atomic.Value       // *peerTable of the connections per remote IP, see peers
+	eventHandler    EventHandler       // user eventHandler
+	batchHandler    BatchHandler       // user eventHandler if it implements ReactBatch
+	trafficHandler  TrafficHandler     // user eventHandler if it implements OnTraffic
+	errorHandler    ErrorHandler       // user eventHandler if it implements OnError
+	acceptLimiter   *tokenBucket       // limits the rate of accepting new connections at AcceptRate
+	subEventLoopSet loadBalancer       // event-loops for handling events
+	loops           []*eventloop       // event-loops in the order of registration, indexed by EventLoopPicker
+	signals         chan os.Signal     // OS signals of shutdown, nil if the server hasn't started
+	server          *Server            // the Server passed to OnInitComplete and returned by Conn.Server
+	startedAt       time.Time          // time of starting the event-loops
+}
+
+// waitForShutdown waits for a signal to shutdown.
+func (svr *server) waitForShutdown() error {
+	svr.cond.L.Lock()
+	for !svr.signaled {
+		svr.cond.Wait()
+	}
+	err := svr.serr
+	svr.cond.L.Unlock()
+	return err
+}
+
+// signalShutdown signals a shutdown an begins server closing.
+func (svr *server) signalShutdown(err error) {
+	svr.once.Do(func() {
+		svr.cond.L.Lock()
+		svr.serr = err
+		svr.signaled = true
+		svr.cond.Signal()
+		svr.cond.L.Unlock()
+	})
+}
+
+func (svr *server) startListener() {
+	svr.listenerWG.Add(1)
+	go func() {
+		svr.listenerRun()
+		svr.listenerWG.Done()
+	}()
+}
+
+func (svr *server) startLoops(numEventLoop int) {
+	for i := 0; i < numEventLoop; i++ {
+		el := &eventloop{
+			ch:                make(chan interface{}, commandBufferSize),
+			svr:               svr,
+			codec:             svr.codec,
+			connections:       make(map[*stdConn]struct{}),
+			eventHandler:      svr.eventHandler,
+			calibrateCallback: svr.subEventLoopSet.calibrate,
+		}
+		svr.subEventLoopSet.register(el)
+		svr.loops = append(svr.loops, el)
+	}
+
+	svr.loopWG.Add(svr.subEventLoopSet.len())
+	svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		go el.loopRun()
+		return true
+	})
+}
+
+// options returns the latest options of server, the options which can be changed at runtime
+// must be read from it instead of svr.opts.
+func (svr *server) options() *Options {
+	return svr.curOpts.Load().(*Options)
+}
+
+// logger returns the Logger of the current options.
+func (svr *server) logger() Logger {
+	if logger := svr.options().Logger; logger != nil {
+		return logger
+	}
+	return defaultLogger
+}
+
+func (svr *server) applyOptions(options []Option) error {
+	svr.optsLock.Lock()
+	defer svr.optsLock.Unlock()
+	opts, err := reloadOptions(svr.options(), options)
+	if err != nil {
+		return err
+	}
+	svr.curOpts.Store(opts)
+	svr.reloadPeers(opts)
+	svr.mem.setLimit(opts.MemoryLimit)
+	return nil
+}
+
+func (svr *server) pauseAccept(_ bool) error {
+	return ErrUnsupportedPlatform
+}
+
+func (svr *server) acceptPaused() bool {
+	return false
+}
+
+func (svr *server) stop() {
+	// Wait on a signal for shutdown.
+	svr.logger().Printf("server is being shutdown with err: %v\n", svr.waitForShutdown())
+
+	// Close listener.
+	svr.ln.close()
+	svr.listenerWG.Wait()
+
+	// Notify all loops to close.
+	svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		el.ch <- ErrServerShutdown
+		return true
+	})
+
+	// Wait on all loops to close.
+	svr.loopWG.Wait()
+
+	// Close all connections.
+	svr.loopWG.Add(svr.subEventLoopSet.len())
+	svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		el.ch <- errCloseAllConns
+		return true
+	})
+	svr.loopWG.Wait()
+}
+
+// start sets up the server and starts the event-loops, it returns right after OnInitComplete without starting
+// anything if OnInitComplete asks for shutdown.
+func start(eventHandler EventHandler, listener *listener, options *Options) (*Server, error) {
+	// Figure out the correct number of loops/goroutines to use.
+	numEventLoop := 1
+	if options.Multicore {
+		numEventLoop = runtime.NumCPU()
+	}
+	if options.NumEventLoop > 0 {
+		numEventLoop = options.NumEventLoop
+	}
+
+	svr := new(server)
+	svr.opts = options
+	svr.curOpts.Store(options)
+	svr.eventHandler = eventHandler
+	base := unwrapHandler(eventHandler)
+	svr.trafficHandler, _ = base.(TrafficHandler)
+	svr.batchHandler, _ = base.(BatchHandler)
+	svr.errorHandler, _ = base.(ErrorHandler)
+	svr.acceptLimiter = new(tokenBucket)
+	svr.ln = listener
+
+	switch options.LB {
+	case RoundRobin:
+		svr.subEventLoopSet = new(roundRobinEventLoopSet)
+	case LeastConnections:
+		svr.subEventLoopSet = new(leastConnectionsEventLoopSet)
+	case SourceAddrHash:
+		svr.subEventLoopSet = new(sourceAddrHashEventLoopSet)
+	}
+
+	svr.ticktock = make(chan time.Duration, 1)
+	svr.cond = sync.NewCond(&sync.Mutex{})
+	svr.mem = newMemAccountant(options)
+	svr.peerTab.Store(newPeerTable(options))
+	svr.codec = func() ICodec {
+		if options.Codec == nil {
+			return new(BuiltInFrameCodec)
+		}
+		return options.Codec
+	}()
+	svr.bufPool = func() ByteBufferPool {
+		if options.ByteBufferPool == nil {
+			return defaultByteBufferPool{}
+		}
+		return options.ByteBufferPool
+	}()
+
+	server := &Server{
+		svr:          svr,
+		Multicore:    options.Multicore,
+		Addr:         listener.lnaddr,
+		NumEventLoop: numEventLoop,
+		ReusePort:    options.ReusePort,
+		TCPKeepAlive: options.TCPKeepAlive,
+	}
+	svr.server = server
+	switch svr.eventHandler.OnInitComplete(*server) {
+	case None:
+	case Shutdown:
+		return server, nil
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		if <-shutdown == nil {
+			return
+		}
+		svr.signalShutdown(ErrShutdownBySignal)
+	}()
+
+	// Start all loops.
+	svr.startedAt = time.Now()
+	svr.startLoops(numEventLoop)
+	// Start listener.
+	svr.startListener()
+	svr.signals = shutdown
+
+	return server, nil
+}
+
+// wait waits for the started server to stop, it reports whether the server has been started. It must be called
+// once, which is ensured by Server.Wait.
+func (svr *server) wait() bool {
+	if svr.signals == nil {
+		return false
+	}
+	svr.stop()
+	close(svr.signals)
+	svr.signals = nil
+	return true
+}
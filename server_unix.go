@@ -11,38 +11,81 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/panjf2000/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
 )
 
+// defaultDeferAcceptPeriod is the period for which new connections are held back in kernel in DeferAccept mode
+// when FirstByteTimeout is not set.
+const defaultDeferAcceptPeriod = 30 * time.Second
+
+// drainInterval is the interval of closing idle connections while the server is draining.
+const drainInterval = 10 * time.Millisecond
+
 type server struct {
-	ln              *listener          // all the listeners
-	wg              sync.WaitGroup     // event-loop close WaitGroup
-	opts            *Options           // options with server
-	once            sync.Once          // make sure only signalShutdown once
-	cond            *sync.Cond         // shutdown signaler
-	codec           ICodec             // codec for TCP stream
-	logger          Logger             // customized logger for logging info
-	ticktock        chan time.Duration // ticker channel
-	mainLoop        *eventloop         // main loop for accepting connections
-	eventHandler    EventHandler       // user eventHandler
-	subEventLoopSet loadBalancer       // event-loops for handling events
+	ln              *listener             // all the listeners
+	wg              sync.WaitGroup        // event-loop close WaitGroup
+	opts            *Options              // options with server
+	curOpts         atomic.Value          // *Options, the latest options changed at runtime
+	optsLock        sync.Mutex            // serializes the changes of options at runtime
+	pickLock        sync.Mutex            // serializes picking event-loops for the accepted and adopted connections
+	once            sync.Once             // make sure only signalShutdown once
+	waitOnce        sync.Once             // make sure only waiting for shutdown once
+	final           ShutdownReport        // report returned by Server.Wait
+	cond            *sync.Cond            // shutdown signaler
+	signaled        bool                  // shutdown has been signaled, guarded by cond.L
+	serr            error                 // reason of shutdown, guarded by cond.L
+	codec           ICodec                // codec for TCP stream
+	bufPool         ByteBufferPool        // allocator of byte buffers
+	ticktock        chan time.Duration    // ticker channel
+	mem             *memAccountant        // memory accountant of all buffers
+	draining        int32                 // 1 if server is draining connections before stopping
+	acceptHeld      int32                 // 1 if accepting new connections is paused by Server.PauseAccept
+	mainLoops       []*eventloop          // main loops for accepting connections
+	loops           []*eventloop          // event-loops in the order of registration, indexed by EventLoopPicker
+	groupLns        []*listener           // listeners in the SO_REUSEPORT group besides ln, owned by event-loops
+	udpPeers        sync.Map              // remote peers of the connected UDP sockets in UDPConnect mode
+	udpSessions     int32                 // number of the connected UDP sockets, accessed atomically
+	bus             bus                   // pub/sub bus of connections
+	peerTab         atomic.Value          // *peerTable of the connections per remote IP, see peers
+	eventHandler    EventHandler          // user eventHandler
+	batchHandler    BatchHandler          // user eventHandler if it implements ReactBatch
+	trafficHandler  TrafficHandler        // user eventHandler if it implements OnTraffic
+	negotiator      CompressionNegotiator // user eventHandler if it implements NegotiateCompression
+	errorHandler    ErrorHandler          // user eventHandler if it implements OnError
+	slowHandler     SlowConsumerHandler   // user eventHandler if it implements OnSlowConsumer
+	firstHandler    FirstDataHandler      // user eventHandler if it implements OnFirstData
+	fdHandler       FDHandler             // user eventHandler if it implements OnReceivedFD, nil unless unix socket
+	fdWatermark     int                   // file descriptor from which FDLimitPolicy is applied, 0 if unset
+	acceptLimiter   *tokenBucket          // limits the rate of accepting new connections at AcceptRate
+	lifecycle       *lifecyclePool        // runs OnOpened and OnClosed with LifecycleWorkers, nil if unset
+	subEventLoopSet loadBalancer          // event-loops for handling events
+	signals         chan os.Signal        // OS signals of shutdown, nil if the server hasn't started
+	server          *Server               // the Server passed to OnInitComplete and returned by Conn.Server
+	startedAt       time.Time             // time of starting the event-loops
 }
 
 // waitForShutdown waits for a signal to shutdown
 func (svr *server) waitForShutdown() {
 	svr.cond.L.Lock()
-	svr.cond.Wait()
+	for !svr.signaled {
+		svr.cond.Wait()
+	}
 	svr.cond.L.Unlock()
 }
 
-// signalShutdown signals a shutdown an begins server closing
-func (svr *server) signalShutdown() {
+// signalShutdown signals a shutdown an begins server closing, the first reason is kept for ShutdownReport.
+func (svr *server) signalShutdown(err error) {
 	svr.once.Do(func() {
 		svr.cond.L.Lock()
+		svr.serr = err
+		svr.signaled = true
 		svr.cond.Signal()
 		svr.cond.L.Unlock()
 	})
@@ -77,11 +120,72 @@ func (svr *server) startReactors() {
 	})
 }
 
+// openPoller opens a poller set up with the polling options.
+func (svr *server) openPoller() (p *netpoll.Poller, err error) {
+	if p, err = netpoll.OpenPoller(); err == nil {
+		if svr.opts.PollTimeout != 0 {
+			p.SetPollTimeout(svr.opts.PollTimeout)
+		}
+		p.SetBusyPoll(svr.opts.BusyPoll)
+		p.SetBatchUpdates(svr.opts.BatchPollerUpdates)
+	}
+	return
+}
+
+// tuneListener applies the options of the listener which can't be set up via the net package: the backlog
+// of ListenBacklog, the accept filter of AcceptFilter, and TCP_DEFER_ACCEPT of DeferAccept, whose period of waiting
+// is FirstByteTimeout if it is set, otherwise defaultDeferAcceptPeriod, or the control messages of UDPControl for UDP.
+func (svr *server) tuneListener(ln *listener) error {
+	if ln.pconn != nil {
+		if svr.opts.UDPControl && strings.HasPrefix(ln.network, "udp") {
+			return setUDPControl(ln.fd, ln.network)
+		}
+		return nil
+	}
+	// Calling listen(2) again on a listening socket updates its backlog.
+	if backlog := svr.opts.ListenBacklog; backlog > 0 {
+		if err := unix.Listen(ln.fd, backlog); err != nil {
+			return err
+		}
+	}
+	if isUnixNetwork(ln.network) {
+		return nil
+	}
+	if filter := svr.opts.AcceptFilter; filter != "" {
+		if err := setAcceptFilter(ln.fd, filter); err != nil {
+			return err
+		}
+	}
+	if !svr.opts.DeferAccept {
+		return nil
+	}
+	period := svr.options().FirstByteTimeout
+	if period <= 0 {
+		period = defaultDeferAcceptPeriod
+	}
+	return setDeferAccept(ln.fd, period)
+}
+
 func (svr *server) activateLoops(numEventLoop int) error {
+	// Every event-loop owns a listener in the SO_REUSEPORT group when the listener is selected by program.
+	groupListeners := svr.opts.ReusePort && (svr.opts.ReusePortCPUAffinity || svr.opts.ReusePortEBPF > 0)
 	// Create loops locally and bind the listeners.
 	for i := 0; i < numEventLoop; i++ {
-		if p, err := netpoll.OpenPoller(); err == nil {
+		ln := svr.ln
+		if groupListeners && i > 0 {
+			var err error
+			if ln, err = svr.ln.clone(svr.opts); err != nil {
+				return err
+			}
+			if err = svr.tuneListener(ln); err != nil {
+				ln.close()
+				return err
+			}
+			svr.groupLns = append(svr.groupLns, ln)
+		}
+		if p, err := svr.openPoller(); err == nil {
 			el := &eventloop{
+				ln:                ln,
 				svr:               svr,
 				codec:             svr.codec,
 				poller:            p,
@@ -90,12 +194,22 @@ func (svr *server) activateLoops(numEventLoop int) error {
 				eventHandler:      svr.eventHandler,
 				calibrateCallback: svr.subEventLoopSet.calibrate,
 			}
-			_ = el.poller.AddRead(svr.ln.fd)
+			if svr.acceptable() {
+				_ = el.poller.AddRead(ln.fd)
+			} else {
+				el.acceptPaused = true
+			}
 			svr.subEventLoopSet.register(el)
+			svr.loops = append(svr.loops, el)
 		} else {
 			return err
 		}
 	}
+	if groupListeners {
+		if err := attachReusePortProg(svr.ln.fd, svr.opts, numEventLoop); err != nil {
+			return err
+		}
+	}
 	// Start loops in background
 	svr.startLoops()
 	return nil
@@ -103,8 +217,9 @@ func (svr *server) activateLoops(numEventLoop int) error {
 
 func (svr *server) activateReactors(numEventLoop int) error {
 	for i := 0; i < numEventLoop; i++ {
-		if p, err := netpoll.OpenPoller(); err == nil {
+		if p, err := svr.openPoller(); err == nil {
 			el := &eventloop{
+				ln:                svr.ln,
 				svr:               svr,
 				codec:             svr.codec,
 				poller:            p,
@@ -114,34 +229,157 @@ func (svr *server) activateReactors(numEventLoop int) error {
 				calibrateCallback: svr.subEventLoopSet.calibrate,
 			}
 			svr.subEventLoopSet.register(el)
+			svr.loops = append(svr.loops, el)
 		} else {
 			return err
 		}
 	}
 
-	// Start sub reactors.
-	svr.startReactors()
-
-	if p, err := netpoll.OpenPoller(); err == nil {
+	numAcceptors := svr.opts.NumAcceptors
+	if numAcceptors <= 0 {
+		numAcceptors = 1
+	}
+	for i := 0; i < numAcceptors; i++ {
+		p, err := svr.openPoller()
+		if err != nil {
+			return err
+		}
 		el := &eventloop{
 			idx:    -1,
+			ln:     svr.ln,
 			poller: p,
 			svr:    svr,
 		}
-		_ = el.poller.AddRead(svr.ln.fd)
-		svr.mainLoop = el
-		// Start main reactor.
+		// Multiple acceptors poll the same listener exclusively to avoid the thundering herd.
+		if !svr.acceptable() {
+			el.acceptPaused = true
+		} else if numAcceptors > 1 {
+			_ = el.poller.AddReadExclusive(svr.ln.fd)
+		} else {
+			_ = el.poller.AddRead(svr.ln.fd)
+		}
+		svr.mainLoops = append(svr.mainLoops, el)
+	}
+
+	// Start sub reactors.
+	svr.startReactors()
+
+	// Start main reactors, after all of them are set up for the sub reactors to reach them.
+	for _, el := range svr.mainLoops {
+		el := el
 		svr.wg.Add(1)
 		go func() {
-			svr.activateMainReactor()
+			svr.activateMainReactor(el)
 			svr.wg.Done()
 		}()
-	} else {
+	}
+	return nil
+}
+
+// options returns the latest options of server, the options which can be changed at runtime
+// must be read from it instead of svr.opts.
+func (svr *server) options() *Options {
+	return svr.curOpts.Load().(*Options)
+}
+
+// logger returns the Logger of the current options.
+func (svr *server) logger() Logger {
+	if logger := svr.options().Logger; logger != nil {
+		return logger
+	}
+	return defaultLogger
+}
+
+func (svr *server) applyOptions(options []Option) error {
+	svr.optsLock.Lock()
+	defer svr.optsLock.Unlock()
+	opts, err := reloadOptions(svr.options(), options)
+	if err != nil {
 		return err
 	}
+	svr.curOpts.Store(opts)
+	svr.reloadPeers(opts)
+	svr.applyMemoryPolicy(svr.mem.setLimit(opts.MemoryLimit))
+	svr.toggleAccepting()
+	return nil
+}
+
+// applyMemoryPolicy invokes the handler and applies the memory policy when the memory usage crosses the limit.
+func (svr *server) applyMemoryPolicy(usage int64, crossed int) {
+	if crossed == 0 {
+		return
+	}
+	opts := svr.options()
+	if crossed > 0 && opts.MemoryLimitHandler != nil {
+		opts.MemoryLimitHandler(usage, opts.MemoryLimit)
+	}
+	switch opts.MemoryPolicy {
+	case PauseAccept:
+		svr.toggleAccepting()
+	case CloseMostBuffered:
+		if crossed > 0 {
+			svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+				el.evictMostBuffered()
+				return true
+			})
+		}
+	}
+}
+
+// acceptable reports whether the server is supposed to accept new connections at present.
+func (svr *server) acceptable() bool {
+	if atomic.LoadInt32(&svr.draining) == 1 || atomic.LoadInt32(&svr.acceptHeld) == 1 {
+		return false
+	}
+	return !(svr.options().MemoryPolicy == PauseAccept && svr.mem.overLimit())
+}
+
+// toggleAccepting makes all acceptors start or stop polling the listener according to svr.acceptable,
+// connections will stay in the backlog of listener while it is not being polled.
+func (svr *server) toggleAccepting() {
+	svr.iterateAcceptors(func(el *eventloop) {
+		sniffErrorAndLog(el.poller.Trigger(func() error {
+			el.toggleAccepting()
+			return nil
+		}))
+	})
+}
+
+func (svr *server) pauseAccept(paused bool) error {
+	if svr.ln.pconn != nil {
+		return ErrUnsupportedProtocol
+	}
+	var held int32
+	if paused {
+		held = 1
+	}
+	if atomic.SwapInt32(&svr.acceptHeld, held) != held {
+		svr.toggleAccepting()
+	}
 	return nil
 }
 
+func (svr *server) acceptPaused() bool {
+	return atomic.LoadInt32(&svr.acceptHeld) == 1
+}
+
+// iterateAcceptors calls f with every event-loop which is polling the TCP listener.
+func (svr *server) iterateAcceptors(f func(el *eventloop)) {
+	if svr.ln.pconn != nil {
+		return
+	}
+	if svr.mainLoops != nil {
+		for _, el := range svr.mainLoops {
+			f(el)
+		}
+		return
+	}
+	svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+		f(el)
+		return true
+	})
+}
+
 func (svr *server) start(numEventLoop int) error {
 	if svr.opts.ReusePort || svr.ln.pconn != nil {
 		return svr.activateLoops(numEventLoop)
@@ -153,32 +391,74 @@ func (svr *server) stop() {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
 
+	if timeout := svr.options().ShutdownTimeout; timeout > 0 {
+		svr.drain(timeout)
+	}
+
 	// Notify all loops to close by closing all listeners
 	svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
 		sniffErrorAndLog(el.poller.Trigger(func() error {
-			return errServerShutdown
+			return ErrServerShutdown
 		}))
 		return true
 	})
 
-	if svr.mainLoop != nil {
+	if svr.mainLoops != nil {
 		svr.ln.close()
-		sniffErrorAndLog(svr.mainLoop.poller.Trigger(func() error {
-			return errServerShutdown
-		}))
+		for _, el := range svr.mainLoops {
+			sniffErrorAndLog(el.poller.Trigger(func() error {
+				return ErrServerShutdown
+			}))
+		}
 	}
 
 	// Wait on all loops to complete reading events
 	svr.wg.Wait()
 
+	// Run OnClosed of the connections closed by the loops at last.
+	if svr.lifecycle != nil {
+		svr.lifecycle.stop()
+	}
+
 	svr.closeLoops()
 
-	if svr.mainLoop != nil {
-		sniffErrorAndLog(svr.mainLoop.poller.Close())
+	for _, ln := range svr.groupLns {
+		ln.close()
+	}
+
+	for _, el := range svr.mainLoops {
+		sniffErrorAndLog(el.poller.Close())
 	}
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options) error {
+// drain stops accepting new connections and closes the existing connections as soon as they become idle,
+// until all connections are closed or the timeout expires.
+func (svr *server) drain(timeout time.Duration) {
+	atomic.StoreInt32(&svr.draining, 1)
+	svr.toggleAccepting()
+
+	since := time.Now()
+	deadline := since.Add(timeout)
+	for time.Now().Before(deadline) {
+		var count int32
+		// The connections which have received data since the last round are left to the next one.
+		last := since
+		since = time.Now()
+		svr.subEventLoopSet.iterate(func(i int, el *eventloop) bool {
+			count += atomic.LoadInt32(&el.connCount)
+			sniffErrorAndLog(el.poller.Trigger(func() error { return el.closeIdleConns(last) }))
+			return true
+		})
+		if count == 0 {
+			return
+		}
+		time.Sleep(drainInterval)
+	}
+}
+
+// start sets up the server and starts the event-loops, it returns right after OnInitComplete without starting
+// anything if OnInitComplete asks for shutdown.
+func start(eventHandler EventHandler, listener *listener, options *Options) (*Server, error) {
 	// Figure out the correct number of loops/goroutines to use.
 	numEventLoop := 1
 	if options.Multicore {
@@ -190,8 +470,21 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 
 	svr := new(server)
 	svr.opts = options
+	svr.curOpts.Store(options)
 	svr.eventHandler = eventHandler
+	base := unwrapHandler(eventHandler)
+	svr.trafficHandler, _ = base.(TrafficHandler)
+	svr.batchHandler, _ = base.(BatchHandler)
+	svr.negotiator, _ = base.(CompressionNegotiator)
+	svr.errorHandler, _ = base.(ErrorHandler)
+	svr.slowHandler, _ = base.(SlowConsumerHandler)
+	svr.firstHandler, _ = base.(FirstDataHandler)
+	if listener.network == "unix" || listener.network == "unixpacket" {
+		svr.fdHandler, _ = base.(FDHandler)
+	}
+	svr.acceptLimiter = new(tokenBucket)
 	svr.ln = listener
+	svr.fdWatermark = fdWatermark(options.FDWatermark)
 
 	switch options.LB {
 	case RoundRobin:
@@ -204,20 +497,26 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.ticktock = make(chan time.Duration, 1)
-	svr.logger = func() Logger {
-		if options.Logger == nil {
-			return defaultLogger
-		}
-		return options.Logger
-	}()
+	svr.mem = newMemAccountant(options)
+	svr.peerTab.Store(newPeerTable(options))
 	svr.codec = func() ICodec {
 		if options.Codec == nil {
 			return new(BuiltInFrameCodec)
 		}
 		return options.Codec
 	}()
+	svr.bufPool = func() ByteBufferPool {
+		if options.ByteBufferPool == nil {
+			return defaultByteBufferPool{}
+		}
+		return options.ByteBufferPool
+	}()
+
+	if err := svr.tuneListener(listener); err != nil {
+		return nil, err
+	}
 
-	server := Server{
+	server := &Server{
 		svr:          svr,
 		Multicore:    options.Multicore,
 		Addr:         listener.lnaddr,
@@ -225,30 +524,59 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 		ReusePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
 	}
-	switch svr.eventHandler.OnInitComplete(server) {
+	if listener.pconn == nil {
+		server.ListenBacklog = maxListenerBacklog()
+		if backlog := options.ListenBacklog; backlog > 0 && backlog < server.ListenBacklog {
+			server.ListenBacklog = backlog
+		}
+	}
+	svr.server = server
+	switch svr.eventHandler.OnInitComplete(*server) {
 	case None:
 	case Shutdown:
-		return nil
+		return server, nil
 	}
-	defer svr.eventHandler.OnShutdown(server)
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	defer close(shutdown)
 
 	go func() {
 		if <-shutdown == nil {
 			return
 		}
-		svr.signalShutdown()
+		svr.signalShutdown(ErrShutdownBySignal)
 	}()
 
+	if options.LifecycleWorkers > 0 && listener.pconn == nil {
+		svr.lifecycle = newLifecyclePool(options.LifecycleWorkers)
+	}
+	svr.startedAt = time.Now()
 	if err := svr.start(numEventLoop); err != nil {
 		svr.closeLoops()
-		svr.logger.Printf("gnet server is stoping with error: %v\n", err)
-		return err
+		if svr.lifecycle != nil {
+			svr.lifecycle.stop()
+		}
+		for _, ln := range svr.groupLns {
+			ln.close()
+		}
+		svr.logger().Printf("gnet server is stoping with error: %v\n", err)
+		close(shutdown)
+		svr.eventHandler.OnShutdown(*server)
+		return nil, err
 	}
-	defer svr.stop()
+	svr.signals = shutdown
 
-	return nil
+	return server, nil
+}
+
+// wait waits for the started server to stop, it reports whether the server has been started. It must be called
+// once, which is ensured by Server.Wait.
+func (svr *server) wait() bool {
+	if svr.signals == nil {
+		return false
+	}
+	svr.stop()
+	close(svr.signals)
+	svr.signals = nil
+	return true
 }
@@ -0,0 +1,50 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdownInflightWrite(t *testing.T) {
+	events := &testShutdownInflightServer{network: "tcp", addr: ":9922", replied: make(chan string, 1)}
+	must(Serve(events, "tcp://:9922", WithShutdownTimeout(time.Second*5),
+		WithWriteCoalescing(time.Millisecond*100, 0)))
+	if reply := <-events.replied; reply != "reply" {
+		t.Fatalf("the in-flight write is lost in shutdown, got %q", reply)
+	}
+}
+
+type testShutdownInflightServer struct {
+	*EventServer
+	network, addr string
+	replied       chan string
+}
+
+func (s *testShutdownInflightServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, err = c.Write([]byte("request"))
+		must(err)
+		must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+		buf := make([]byte, 5)
+		n, _ := io.ReadFull(c, buf)
+		s.replied <- string(buf[:n])
+	}()
+	return
+}
+
+func (s *testShutdownInflightServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The reply stays in the batch of write coalescing for a number of rounds of draining.
+	must(c.AsyncWrite([]byte("reply")))
+	return nil, Shutdown
+}
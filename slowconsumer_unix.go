@@ -0,0 +1,125 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "sync/atomic"
+
+// bufferOutbound appends the data of a write to the outbound buffer of the connection, the end of the write
+// is marked for SlowConsumerDropOldest to discard the writes as a whole.
+func (c *conn) bufferOutbound(buf []byte) {
+	_, _ = c.outboundBuffer.Write(buf)
+	if c.loop.svr.opts.SlowConsumerPolicy == SlowConsumerDropOldest && c.loop.svr.opts.SlowConsumerThreshold > 0 {
+		c.outMarks = append(c.outMarks, c.outFlushed+uint64(c.outboundBuffer.Length()))
+	}
+}
+
+// congested reports whether the sources of the connection are pushed back by SlowConsumerPause.
+func (c *conn) congested() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// stopSlowConsumer stops watching the connection for being a slow consumer.
+func (c *conn) stopSlowConsumer() {
+	if c.slowTimer != nil {
+		c.slowTimer.Stop()
+		c.slowTimer = nil
+	}
+	atomic.StoreInt32(&c.paused, 0)
+}
+
+// trimMarks forgets the marks of the writes which have been flushed.
+func (c *conn) trimMarks() {
+	var i int
+	for i < len(c.outMarks) && c.outMarks[i] <= c.outFlushed {
+		i++
+	}
+	if i > 0 {
+		c.outMarks = c.outMarks[:copy(c.outMarks, c.outMarks[i:])]
+	}
+}
+
+// watchSlowConsumer starts the timer of SlowConsumerTimeout when the outbound buffer of the given connection
+// goes above the threshold, and stops it when the buffer drops to the threshold, which also lifts the pause
+// of SlowConsumerPause.
+func (el *eventloop) watchSlowConsumer(c *conn, out int) {
+	if out <= el.svr.opts.SlowConsumerThreshold {
+		if c.slowTimer != nil || c.paused != 0 {
+			c.stopSlowConsumer()
+		}
+		return
+	}
+	if c.slowTimer == nil {
+		c.slowTimer = el.timingWheel().AfterFunc(el.svr.opts.SlowConsumerTimeout, func() error {
+			c.slowTimer = nil
+			return el.loopSlowConsumer(c)
+		})
+	}
+}
+
+// loopSlowConsumer invokes OnSlowConsumer and applies SlowConsumerPolicy to the given connection whose
+// outbound buffer has stayed above the threshold for SlowConsumerTimeout.
+func (el *eventloop) loopSlowConsumer(c *conn) error {
+	if !c.opened {
+		return nil
+	}
+	if h := el.svr.slowHandler; h != nil {
+		switch h.OnSlowConsumer(c) {
+		case None:
+		case Close:
+			return el.loopCloseConn(c, ErrSlowConsumer)
+		case Shutdown:
+			return el.shutdown()
+		}
+		if !c.opened {
+			return nil
+		}
+	}
+	switch el.svr.opts.SlowConsumerPolicy {
+	case SlowConsumerClose:
+		return el.loopCloseConn(c, ErrSlowConsumer)
+	case SlowConsumerDropOldest:
+		if c.deflater != nil {
+			return el.loopCloseConn(c, ErrSlowConsumer)
+		}
+		el.dropOldest(c)
+	case SlowConsumerPause:
+		atomic.StoreInt32(&c.paused, 1)
+	}
+	// Start over the timer if the connection is still slow.
+	el.accountMemory(c)
+	return nil
+}
+
+// dropOldest discards the oldest writes in the outbound buffer of the given connection, except the one being
+// flushed, until the buffer drops to the threshold.
+func (el *eventloop) dropOldest(c *conn) {
+	c.trimMarks()
+	marks, start := c.outMarks, c.outFlushed
+	if len(marks) < 2 {
+		return
+	}
+
+	head, tail := c.outboundBuffer.LazyReadAll()
+	pending := make([]byte, 0, len(head)+len(tail))
+	pending = append(append(pending, head...), tail...)
+	kept, size, i := int(marks[0]-start), len(pending), 1
+	for ; i < len(marks) && size > el.svr.opts.SlowConsumerThreshold; i++ {
+		size -= int(marks[i] - marks[i-1])
+	}
+	dropped := int(marks[i-1]-start) - kept
+	if dropped == 0 {
+		return
+	}
+
+	c.outboundBuffer.Reset()
+	_, _ = c.outboundBuffer.Write(pending[:kept])
+	_, _ = c.outboundBuffer.Write(pending[kept+dropped:])
+	// The dropped bytes are taken as flushed, which keeps the bytes behind them where they were in the stream.
+	c.outFlushed += uint64(dropped)
+	marks[0] += uint64(dropped)
+	c.outMarks = marks[:1+copy(marks[1:], marks[i:])]
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// setRoutingSockopts sets up SO_MARK of SocketMark, SO_BINDTODEVICE of BindToDevice and IP_FREEBIND of FreeBind
+// on the given socket before it is bound.
+func setRoutingSockopts(fd int, opts *Options) error {
+	if opts.SocketMark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, opts.SocketMark); err != nil {
+			return err
+		}
+	}
+	if opts.BindToDevice != "" {
+		if err := unix.BindToDevice(fd, opts.BindToDevice); err != nil {
+			return err
+		}
+	}
+	if opts.FreeBind {
+		// IP_FREEBIND applies to the IPv6 sockets as well.
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_FREEBIND, 1)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRoutingSockopts(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	must(err)
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, 1)
+	_ = unix.Close(fd)
+	if err == unix.EPERM {
+		t.Skip("SO_MARK requires CAP_NET_ADMIN")
+	}
+	// 192.0.2.1 of TEST-NET-1 is not assigned to the host, it can only be bound with IP_FREEBIND.
+	events := &testRoutingSockoptsServer{}
+	must(Serve(events, "tcp://192.0.2.1:9934", WithSocketMark(42), WithBindToDevice("lo"), WithFreeBind(true)))
+	if events.mark != 42 || events.device != "lo" || events.freeBind != 1 {
+		t.Fatalf("expected the listener with SO_MARK 42, SO_BINDTODEVICE lo and IP_FREEBIND, got %d, %q and %d",
+			events.mark, events.device, events.freeBind)
+	}
+}
+
+type testRoutingSockoptsServer struct {
+	*EventServer
+	mark, freeBind int
+	device         string
+}
+
+func (s *testRoutingSockoptsServer) OnInitComplete(svr Server) (action Action) {
+	fd := svr.svr.ln.fd
+	s.mark, _ = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK)
+	s.device, _ = unix.GetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	s.freeBind, _ = unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_FREEBIND)
+	return Shutdown
+}
@@ -0,0 +1,28 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDeferAccept does nothing since TCP_DEFER_ACCEPT is only supported on Linux.
+func setDeferAccept(_ int, _ time.Duration) error {
+	return nil
+}
+
+// maxListenerBacklog returns the limit of the backlog of listeners, whose name of sysctl varies across BSDs.
+func maxListenerBacklog() int {
+	for _, name := range []string{"kern.ipc.soacceptqueue", "kern.ipc.somaxconn", "kern.somaxconn"} {
+		if n, err := unix.SysctlUint32(name); err == nil && n > 0 {
+			return int(n)
+		}
+	}
+	return unix.SOMAXCONN
+}
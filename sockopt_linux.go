@@ -0,0 +1,36 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDeferAccept sets up TCP_DEFER_ACCEPT on the listener, which holds the new connections back in kernel
+// until they have data to read or the given period is over.
+func setDeferAccept(fd int, period time.Duration) error {
+	secs := int((period + time.Second - 1) / time.Second)
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs)
+}
+
+// maxListenerBacklog returns the limit of the backlog of listeners, net.core.somaxconn.
+func maxListenerBacklog() int {
+	b, err := ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return unix.SOMAXCONN
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n <= 0 {
+		return unix.SOMAXCONN
+	}
+	return n
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// Splice relays the next n inbound bytes of src to dst, or all of them until either connection is closed if n
+// is negative, which is meant for building TCP proxies on top of gnet, src and dst may belong to different
+// event-loops. The bytes already buffered in src are relayed first, then the subsequent bytes are moved from
+// the socket of src to the socket of dst via splice(2) and a pipe on Linux without being copied into user space,
+// falling back to copying through buffers on other platforms or when the kernel refuses to splice.
+//
+// While a splice is in progress, the relayed bytes bypass React/OnTraffic of src, the relay takes effect on the
+// event-loop of src asynchronously, so the bytes which are read before that are still delivered to src as usual.
+// The reads of src are held while the outbound buffer of dst is backed up, and resumed once it's drained.
+// Splice returns ErrUnsupportedProtocol for UDP connections, use SpliceNotify to learn when the relay is over.
+func Splice(src, dst Conn, n int64) error {
+	return splice(src, dst, n, nil)
+}
+
+// SpliceNotify is like Splice, but calls done on the event-loop of src once the relay is over: err is nil when
+// the n bytes have been relayed, ErrConnClosed when dst has been closed, or the reason why src is closed, e.g.
+// ErrEOF. The relayed bytes are the ones moved out of src, some of which may still be on their way to the socket
+// of dst, done is not called if the relay doesn't take effect because src is closed or already spliced.
+func SpliceNotify(src, dst Conn, n int64, done func(err error)) error {
+	return splice(src, dst, n, done)
+}
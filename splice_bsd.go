@@ -0,0 +1,16 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+func (sp *splicer) open() {}
+
+func (sp *splicer) close() {}
+
+// loopSplice relays the inbound bytes of the given connection by copying them as splice(2) is not available.
+func (el *eventloop) loopSplice(c *conn) error {
+	return el.loopSpliceCopy(c)
+}
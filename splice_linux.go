@@ -0,0 +1,105 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunk is the maximum number of bytes moved by one splice(2), which is the default capacity of a pipe.
+const spliceChunk = 1 << 16
+
+func (sp *splicer) open() {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err == nil {
+		sp.pipe = p
+	}
+}
+
+// close flushes the bytes left in the pipe to the destination connection and then releases the pipe,
+// both are done on the event-loop of the destination connection.
+func (sp *splicer) close() {
+	if sp.pipe[0] < 0 {
+		return
+	}
+	pipe := sp.pipe
+	_ = sp.dst.trigger(func() error {
+		sp.drain()
+		_ = unix.Close(pipe[0])
+		_ = unix.Close(pipe[1])
+		return nil
+	})
+}
+
+// drain moves the bytes in the pipe to the destination connection, it must be run on the event-loop of
+// the destination connection.
+func (sp *splicer) drain() {
+	d := sp.dst
+	if !d.opened {
+		atomic.StoreInt32(&sp.dead, 1)
+		return
+	}
+	for d.outboundBuffer.IsEmpty() {
+		n, err := unix.Splice(sp.pipe[0], nil, d.fd, nil, spliceChunk, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+		if err == unix.EAGAIN {
+			break
+		}
+		if err != nil {
+			atomic.StoreInt32(&sp.dead, 1)
+			_ = d.loop.loopCloseConn(d, closeReason(err))
+			return
+		}
+		if n == 0 {
+			return
+		}
+	}
+	// Either the pipe is empty or the destination is backed up, in the latter case, move the bytes left
+	// in the pipe to the outbound buffer to keep them in order with the subsequent writes.
+	for {
+		n, err := unix.Read(sp.pipe[0], d.loop.packet)
+		if n <= 0 || err != nil {
+			break
+		}
+		d.bufferOutbound(d.loop.packet[:n])
+	}
+	if !d.outboundBuffer.IsEmpty() {
+		_ = d.loop.poller.ModReadWrite(d.fd)
+	}
+	d.loop.accountMemory(d)
+	sp.throttle()
+}
+
+// loopSplice relays the inbound bytes of the given connection via splice(2).
+func (el *eventloop) loopSplice(c *conn) error {
+	sp := c.splicer
+	if sp.pipe[0] < 0 || sp.copying {
+		return el.loopSpliceCopy(c)
+	}
+	n, err := unix.Splice(c.fd, nil, sp.pipe[1], nil, sp.quota(spliceChunk), unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+	switch {
+	case err == unix.EAGAIN:
+		// Either the socket has been drained or the pipe is full, copy the bytes in the latter case
+		// instead of spinning on the readable socket.
+		return el.loopSpliceCopy(c)
+	case err == unix.EINVAL:
+		sp.copying = true
+		return el.loopSpliceCopy(c)
+	case n == 0 || err != nil:
+		return el.loopCloseConn(c, closeReason(err))
+	}
+	sp.consume(int(n))
+	_ = sp.dst.trigger(func() error {
+		sp.drain()
+		return nil
+	})
+	if sp.remaining == 0 {
+		el.stopSplice(c, nil)
+	}
+	return nil
+}
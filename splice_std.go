@@ -0,0 +1,112 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package gnet
+
+import (
+	"sync/atomic"
+
+	"github.com/panjf2000/gnet/pool/bytebuffer"
+)
+
+// splicer relays the inbound bytes of a connection to another connection.
+type splicer struct {
+	dst       *stdConn        // destination connection
+	remaining int64           // bytes left to relay, negative means unlimited
+	dead      int32           // set to 1 once the destination connection is found closed
+	done      func(err error) // called once the relay is over, it's set by SpliceNotify
+}
+
+func splice(src, dst Conn, n int64, done func(err error)) error {
+	s, ok := src.(*stdConn)
+	if !ok || s.conn == nil {
+		return ErrUnsupportedProtocol
+	}
+	d, ok := dst.(*stdConn)
+	if !ok || d.conn == nil {
+		return ErrUnsupportedProtocol
+	}
+	if n == 0 {
+		return nil
+	}
+	return s.loop.execute(func() error {
+		if _, ok := s.loop.connections[s]; !ok || s.splicer != nil {
+			return nil
+		}
+		sp := &splicer{dst: d, remaining: n, done: done}
+		head, tail := s.inboundBuffer.LazyRead(sp.quota(s.inboundBuffer.Length()))
+		sp.relay(head)
+		sp.relay(tail)
+		s.inboundBuffer.Shift(len(head) + len(tail))
+		if sp.remaining != 0 {
+			s.splicer = sp
+		} else if done != nil {
+			done(nil)
+		}
+		return nil
+	})
+}
+
+// quota returns the number of bytes that can be relayed next, which is capped by max.
+func (sp *splicer) quota(max int) int {
+	if sp.remaining < 0 || sp.remaining > int64(max) {
+		return max
+	}
+	return int(sp.remaining)
+}
+
+// relay copies the given bytes to the destination connection.
+func (sp *splicer) relay(buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	d := sp.dst
+	pool := d.loop.svr.bufPool
+	bb := pool.Get()
+	_, _ = bb.Write(buf)
+	_ = d.loop.execute(func() error {
+		if _, ok := d.loop.connections[d]; ok {
+			_, _ = d.write(bb.B)
+		} else {
+			atomic.StoreInt32(&sp.dead, 1)
+		}
+		pool.Put(bb)
+		return nil
+	})
+	if sp.remaining > 0 {
+		sp.remaining -= int64(len(buf))
+	}
+}
+
+// loopSplice relays the inbound bytes of the given connection and returns the bytes left beyond the quota,
+// which are handled as usual.
+func (el *eventloop) loopSplice(c *stdConn, in *bytebuffer.ByteBuffer) *bytebuffer.ByteBuffer {
+	sp := c.splicer
+	if atomic.LoadInt32(&sp.dead) == 1 {
+		el.stopSplice(c, ErrConnClosed)
+		return in
+	}
+	n := sp.quota(in.Len())
+	sp.relay(in.B[:n])
+	if sp.remaining == 0 {
+		el.stopSplice(c, nil)
+	}
+	if in.B = in.B[n:]; len(in.B) > 0 {
+		return in
+	}
+	el.svr.bufPool.Put(in)
+	return nil
+}
+
+// stopSplice ends the relay of the given connection and reports err to the callback of SpliceNotify,
+// err is nil if all the bytes asked for have been relayed.
+func (el *eventloop) stopSplice(c *stdConn, err error) {
+	sp := c.splicer
+	c.splicer = nil
+	if sp.done != nil {
+		sp.done(err)
+	}
+}
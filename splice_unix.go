@@ -0,0 +1,160 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// splicer relays the inbound bytes of a connection to another connection.
+type splicer struct {
+	src       *conn           // source connection
+	dst       *conn           // destination connection
+	remaining int64           // bytes left to relay, negative means unlimited
+	pipe      [2]int          // pipe for relaying bytes within kernel, -1 if not in use
+	copying   bool            // relaying bytes by copying them as the kernel refuses to splice
+	dead      int32           // set to 1 once the destination connection is found closed
+	done      func(err error) // called once the relay is over, it's set by SpliceNotify
+	throttled bool            // the source is asked to hold its reads, owned by the event-loop of dst
+}
+
+// spliceHighWater is the number of bytes in the outbound buffer of the destination connection above which
+// the source connection is not read until the outbound buffer is drained.
+const spliceHighWater = 1 << 16
+
+func splice(src, dst Conn, n int64, done func(err error)) error {
+	s, ok := src.(*conn)
+	if !ok || s.owner() == nil || s.owner().svr.ln.pconn != nil {
+		return ErrUnsupportedProtocol
+	}
+	d, ok := dst.(*conn)
+	if !ok || d.owner() == nil || d.owner().svr.ln.pconn != nil {
+		return ErrUnsupportedProtocol
+	}
+	if n == 0 {
+		return nil
+	}
+	return s.trigger(func() error {
+		if !s.opened || s.splicer != nil {
+			return nil
+		}
+		sp := &splicer{src: s, dst: d, remaining: n, pipe: [2]int{-1, -1}, done: done}
+		if buf, _ := s.Next(sp.quota(s.BufferLength())); len(buf) > 0 {
+			sp.relay(buf)
+		}
+		s.loop.accountMemory(s)
+		if sp.remaining == 0 {
+			if done != nil {
+				done(nil)
+			}
+			return nil
+		}
+		sp.open()
+		s.splicer = sp
+		return nil
+	})
+}
+
+// quota returns the number of bytes that can be relayed next, which is capped by max.
+func (sp *splicer) quota(max int) int {
+	if sp.remaining < 0 || sp.remaining > int64(max) {
+		return max
+	}
+	return int(sp.remaining)
+}
+
+func (sp *splicer) consume(n int) {
+	if sp.remaining > 0 {
+		sp.remaining -= int64(n)
+	}
+}
+
+// relay copies the given bytes to the destination connection.
+func (sp *splicer) relay(buf []byte) {
+	d := sp.dst
+	pool := d.owner().svr.bufPool
+	bb := pool.Get()
+	_, _ = bb.Write(buf)
+	_ = d.trigger(func() error {
+		if d.opened {
+			d.write(bb.B)
+			d.loop.accountMemory(d)
+			sp.throttle()
+		} else {
+			atomic.StoreInt32(&sp.dead, 1)
+		}
+		pool.Put(bb)
+		return nil
+	})
+	sp.consume(len(buf))
+}
+
+// loopSpliceCopy relays the inbound bytes of the given connection by copying them.
+func (el *eventloop) loopSpliceCopy(c *conn) error {
+	sp := c.splicer
+	n, err := unix.Read(c.fd, el.packet[:sp.quota(len(el.packet))])
+	if n == 0 || err != nil {
+		if err == unix.EAGAIN {
+			return nil
+		}
+		return el.loopCloseConn(c, closeReason(err))
+	}
+	sp.relay(el.packet[:n])
+	if sp.remaining == 0 {
+		el.stopSplice(c, nil)
+	}
+	return nil
+}
+
+// throttle asks the source connection to hold its reads if the outbound buffer of the destination connection
+// is backed up, it must be run on the event-loop of the destination connection.
+func (sp *splicer) throttle() {
+	d := sp.dst
+	if sp.throttled || d.outboundBuffer.Length() < spliceHighWater {
+		return
+	}
+	sp.throttled = true
+	d.throttled = append(d.throttled, sp)
+	s := sp.src
+	_ = s.trigger(func() error {
+		if s.splicer == sp && s.opened {
+			s.loop.holdRead(s, holdBySplice)
+		}
+		return nil
+	})
+}
+
+// resumeSplicers lets the sources held by the connection read again, it's called once the outbound buffer is
+// drained or the connection is closed.
+func (c *conn) resumeSplicers() {
+	for i, sp := range c.throttled {
+		c.throttled[i] = nil
+		sp.throttled = false
+		s := sp.src
+		_ = s.trigger(func() error {
+			if s.splicer == sp && s.opened {
+				s.loop.releaseRead(s, holdBySplice)
+			}
+			return nil
+		})
+	}
+	c.throttled = c.throttled[:0]
+}
+
+// stopSplice ends the relay of the given connection and reports err to the callback of SpliceNotify,
+// err is nil if all the bytes asked for have been relayed.
+func (el *eventloop) stopSplice(c *conn, err error) {
+	sp := c.splicer
+	el.releaseRead(c, holdBySplice)
+	c.splicer = nil
+	sp.close()
+	if sp.done != nil {
+		sp.done(err)
+	}
+}
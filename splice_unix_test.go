@@ -0,0 +1,110 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpliceBackpressure(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testSpliceBackpressure("tcp", ":9916", false, t)
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testSpliceBackpressure("tcp", ":9916", true, t)
+	})
+}
+
+type testSpliceBackpressureServer struct {
+	*EventServer
+	network, addr string
+	svr           Server
+	mu            sync.Mutex
+	first         Conn
+	data          []byte
+	relayed       chan error
+	started       int32
+	done          int32
+}
+
+func (s *testSpliceBackpressureServer) OnInitComplete(svr Server) (action Action) {
+	s.svr = svr
+	return
+}
+
+func (s *testSpliceBackpressureServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first == nil {
+		s.first = c
+		return
+	}
+	must(SpliceNotify(s.first, c, int64(len(s.data)), func(err error) {
+		s.relayed <- err
+	}))
+	out = []byte("ok")
+	return
+}
+
+func (s *testSpliceBackpressureServer) Tick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			a, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer a.Close()
+			b, err := net.Dial(s.network, s.addr)
+			must(err)
+			defer b.Close()
+			ok := make([]byte, 2)
+			_, err = io.ReadFull(b, ok)
+			must(err)
+
+			go func() {
+				_, _ = a.Write(s.data)
+			}()
+			// b doesn't read for a while, the reads of a are held instead of buffering the whole stream.
+			time.Sleep(time.Millisecond * 500)
+			if n := s.svr.OutboundBuffered(); n > 4<<20 {
+				panic(fmt.Sprintf("%d bytes buffered for the slow destination", n))
+			}
+			reply := make([]byte, len(s.data))
+			_, err = io.ReadFull(b, reply)
+			must(err)
+			if !bytes.Equal(s.data, reply) {
+				panic("mismatched data relayed from a to b")
+			}
+			select {
+			case err := <-s.relayed:
+				must(err)
+			case <-time.After(time.Second * 5):
+				panic("the relay isn't reported to be over")
+			}
+			atomic.StoreInt32(&s.done, 1)
+		}()
+		return
+	}
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func testSpliceBackpressure(network, addr string, multicore bool, t *testing.T) {
+	data := make([]byte, 32<<20)
+	rand.Read(data)
+	events := &testSpliceBackpressureServer{network: network, addr: addr, data: data, relayed: make(chan error, 1)}
+	must(Serve(events, network+"://"+addr, WithMulticore(multicore), WithTicker(true)))
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"sync"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// taskQueue applies the overload policy of LoopTaskQueueCap to the tasks submitted to an event-loop by the
+// asynchronous APIs, the tasks themselves are queued and counted in the poller without the capacity.
+type taskQueue struct {
+	mu      sync.Mutex
+	cond    sync.Cond // signals the callers blocked by OverloadBlock, whose L is mu
+	pending []*task   // tasks waiting to run in order, only tracked with LoopTaskQueueCap
+}
+
+type task struct {
+	job     func() error
+	drop    func() // releases what the job holds once it's dropped, may be nil
+	dropped bool   // dropped by OverloadDropOldest, guarded by taskQueue.mu
+}
+
+// runJob runs the job queued by submit.
+func runJob(arg interface{}) error {
+	return arg.(func() error)()
+}
+
+// submitTask queues the function of the asynchronous APIs with its argument to the event-loop, which allocates
+// nothing without the capacity of queue, the overload policy is applied otherwise. Drop is called with the argument
+// instead of run if the task is dropped by OverloadDropOldest.
+func (el *eventloop) submitTask(run internal.TaskFunc, drop func(interface{}), arg interface{}) error {
+	if el.svr.opts.LoopTaskQueueCap <= 0 {
+		return el.poller.TriggerTask(run, arg)
+	}
+	return el.enqueue(func() error { return run(arg) }, func() { drop(arg) })
+}
+
+// submit queues the job of the asynchronous APIs to the event-loop, with the overload policy applied
+// if the capacity of queue is set.
+func (el *eventloop) submit(job func() error) error {
+	return el.enqueue(job, nil)
+}
+
+// enqueue is submit with the function called instead of the job if it's dropped by OverloadDropOldest.
+func (el *eventloop) enqueue(job func() error, drop func()) error {
+	q := &el.tasks
+	capacity := el.svr.opts.LoopTaskQueueCap
+	if capacity <= 0 {
+		return el.poller.TriggerTask(runJob, job)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) >= capacity {
+		switch el.svr.opts.LoopTaskQueueOverload {
+		case OverloadReject:
+			return ErrTaskQueueFull
+		case OverloadDropOldest:
+			q.pending[0].dropped = true
+			if q.pending[0].drop != nil {
+				q.pending[0].drop()
+			}
+			q.pending[0] = nil
+			q.pending = q.pending[1:]
+		default:
+			q.cond.L = &q.mu
+			q.cond.Wait()
+		}
+	}
+	t := &task{job: job, drop: drop}
+	q.pending = append(q.pending, t)
+	// The task is queued in the poller with the lock held, so that the tasks run in the order of pending.
+	return el.poller.Trigger(func() error {
+		q.mu.Lock()
+		if t.dropped {
+			q.mu.Unlock()
+			return nil
+		}
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		if q.cond.L != nil {
+			q.cond.Signal()
+		}
+		q.mu.Unlock()
+		return t.job()
+	})
+}
+
+// queuedTasks returns the number of the tasks submitted by the asynchronous APIs and waiting to run.
+func (el *eventloop) queuedTasks() int {
+	if el.svr.opts.LoopTaskQueueCap <= 0 {
+		return el.poller.PendingTasks()
+	}
+	q := &el.tasks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
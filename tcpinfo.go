@@ -0,0 +1,27 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// TCPInfo is a snapshot of the kernel statistics about the path quality of a TCP connection.
+type TCPInfo struct {
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+	// RTTVar is the variance of the round-trip time.
+	RTTVar time.Duration
+	// MinRTT is the minimum round-trip time observed.
+	MinRTT time.Duration
+	// Retransmits is the number of unrecovered retransmission timeouts.
+	Retransmits uint32
+	// TotalRetrans is the total number of segments retransmitted.
+	TotalRetrans uint32
+	// SndCwnd is the congestion window in segments.
+	SndCwnd uint32
+	// SndMSS is the maximum segment size for sending.
+	SndMSS uint32
+	// DeliveryRate is the most recent goodput measured in bytes per second, it is 0 on kernels older than 4.9.
+	DeliveryRate uint64
+}
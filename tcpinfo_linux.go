@@ -0,0 +1,54 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux,!386
+
+package gnet
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// rawTCPInfo mirrors the leading part of struct tcp_info in linux/tcp.h up to tcpi_delivery_rate,
+// the fields missing on older kernels are left as zero.
+type rawTCPInfo struct {
+	state, caState, retransmits, probes, backoff, options, wscale, flags uint8
+
+	rto, ato, sndMss, rcvMss                             uint32
+	unacked, sacked, lost, retrans, fackets              uint32
+	lastDataSent, lastAckSent, lastDataRecv, lastAckRecv uint32
+	pmtu, rcvSsthresh, rtt, rttvar, sndSsthresh          uint32
+	sndCwnd, advmss, reordering, rcvRtt, rcvSpace        uint32
+	totalRetrans                                         uint32
+
+	pacingRate, maxPacingRate, bytesAcked, bytesReceived uint64
+	segsOut, segsIn, notsentBytes, minRtt                uint32
+	dataSegsIn, dataSegsOut                              uint32
+	deliveryRate                                         uint64
+}
+
+func tcpInfo(fd int) (*TCPInfo, error) {
+	var (
+		raw rawTCPInfo
+		n   = uint32(unsafe.Sizeof(raw))
+	)
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.IPPROTO_TCP, unix.TCP_INFO,
+		uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&n)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &TCPInfo{
+		RTT:          time.Duration(raw.rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.rttvar) * time.Microsecond,
+		MinRTT:       time.Duration(raw.minRtt) * time.Microsecond,
+		Retransmits:  uint32(raw.retransmits),
+		TotalRetrans: raw.totalRetrans,
+		SndCwnd:      raw.sndCwnd,
+		SndMSS:       raw.sndMss,
+		DeliveryRate: raw.deliveryRate,
+	}, nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"math/rand"
+	"time"
+)
+
+// tickDelay returns the duration until the next Tick for the delay returned by Tick at now, which is rounded up
+// to the boundary of TickerAlign and then delayed randomly by up to TickerJitter.
+func tickDelay(opts *Options, delay time.Duration, now time.Time) time.Duration {
+	if align := opts.TickerAlign; align > 0 {
+		next := now.Add(delay)
+		if at := next.Truncate(align); at.Before(next) {
+			next = at.Add(align)
+		}
+		delay = next.Sub(now)
+	}
+	if jitter := opts.TickerJitter; jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return delay
+}
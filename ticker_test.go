@@ -0,0 +1,35 @@
+package gnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTickDelay(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, int(300*time.Millisecond), time.UTC)
+	if d := tickDelay(&Options{}, time.Second, now); d != time.Second {
+		t.Fatalf("expected the delay of Tick as is, got %v", d)
+	}
+	if d := tickDelay(&Options{TickerAlign: time.Second}, 200*time.Millisecond, now); d != 700*time.Millisecond {
+		t.Fatalf("expected the delay to the top of the next second, got %v", d)
+	}
+	if d := tickDelay(&Options{TickerAlign: time.Second}, 1700*time.Millisecond, now); d != 1700*time.Millisecond {
+		t.Fatalf("expected the delay ending on the boundary as is, got %v", d)
+	}
+	if d := tickDelay(&Options{TickerAlign: time.Minute}, time.Second, now); d != time.Minute-300*time.Millisecond {
+		t.Fatalf("expected the delay to the top of the next minute, got %v", d)
+	}
+
+	opts := &Options{TickerAlign: time.Second, TickerJitter: 100 * time.Millisecond}
+	jittered := false
+	for i := 0; i < 100; i++ {
+		d := tickDelay(opts, 0, now)
+		if d < 700*time.Millisecond || d >= 800*time.Millisecond {
+			t.Fatalf("expected the jitter within [0, 100ms) after the boundary, got %v", d)
+		}
+		jittered = jittered || d != 700*time.Millisecond
+	}
+	if !jittered {
+		t.Fatal("expected the delays to be jittered")
+	}
+}
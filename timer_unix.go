@@ -0,0 +1,29 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// timerTick is the precision of the timing wheels of event-loops.
+const timerTick = 10 * time.Millisecond
+
+// timingWheel returns the timing wheel of the event-loop, which is created on the first use and advanced by
+// the poller of the event-loop, it must be called on the event-loop.
+func (el *eventloop) timingWheel() *internal.TimingWheel {
+	if el.timers == nil {
+		el.timers = internal.NewTimingWheel(timerTick, time.Now())
+		el.poller.SetTimingWheel(el.timers)
+	} else if el.timers.Len() == 0 {
+		// The wheel is not advanced while it is empty, catch up with the time before adding timers.
+		_ = el.timers.Advance(time.Now())
+	}
+	return el.timers
+}
@@ -0,0 +1,25 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// setTOS sets up the type of service of the given socket, i.e. IPV6_TCLASS for IPv6 and IP_TOS for IPv4,
+// IP_TOS is also tried on IPv6 sockets for the peers of IPv4-mapped addresses.
+func setTOS(fd, tos int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); err == nil {
+			_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+		}
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetTOS(t *testing.T) {
+	events := &testTOSServer{network: "tcp", addr: "127.0.0.1:9944"}
+	must(Serve(events, "tcp://127.0.0.1:9944"))
+	if events.err != nil {
+		t.Fatal(events.err)
+	}
+}
+
+type testTOSServer struct {
+	*EventServer
+	network, addr string
+	err           error
+}
+
+func (s *testTOSServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		c, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer c.Close()
+		_, _ = c.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (s *testTOSServer) OnOpened(c Conn) (out []byte, action Action) {
+	if s.err = c.SetTOS(0xb8); s.err == nil {
+		if tos, err := unix.GetsockoptInt(c.Fd(), unix.IPPROTO_IP, unix.IP_TOS); err != nil || tos != 0xb8 {
+			s.err = fmt.Errorf("expected IP_TOS 0xb8, got %#x, %v", tos, err)
+		}
+	}
+	return nil, Shutdown
+}
@@ -0,0 +1,33 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// Tracer is the instrumentation of the lifecycle of connections and frames, which is set by Options.Tracer.
+// Its methods are invoked on the event-loops inline with the events, so they must be fast and must not block,
+// which is the same as the handlers. The Conn passed to them must not be retained after TraceClose.
+//
+// The interface is kept free of any tracing library, adapters of OpenTelemetry or the like can be built on
+// it by keeping the spans in a map keyed by Conn: start a span of connection in TraceOpen and end it in
+// TraceClose, start a span of request in TraceFrame and end it in the returned function.
+type Tracer interface {
+	// TraceOpen is called when a connection is accepted, before OnOpened.
+	TraceOpen(c Conn)
+
+	// TraceFirstByte is called when the first bytes of a stream connection are read.
+	TraceFirstByte(c Conn)
+
+	// TraceFrame is called when a frame is decoded by the codec, before it is passed to React, the returned
+	// function is called with the output and action of React right after React returns, it can be nil.
+	// The frames are not traced with TrafficHandler since there is no decoding, nor with BatchHandler since
+	// they are handled together, the datagrams of UDP are traced as frames.
+	TraceFrame(c Conn, frame []byte) (done func(out []byte, action Action))
+
+	// TraceFlush is called when n bytes of a connection are written to the socket.
+	TraceFlush(c Conn, n int)
+
+	// TraceClose is called when a connection is closed with the reason that is passed to OnClosed,
+	// before OnClosed.
+	TraceClose(c Conn, reason error)
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package gnet
+
+import "sync"
+
+// TypedContext hands out a *T per connection as its context, which saves the handlers from asserting
+// c.Context() in every callback. The values are allocated from a pool and reset to the zero value of T
+// once they are released by Put, so a TypedContext must not be copied after first use.
+type TypedContext[T any] struct {
+	pool sync.Pool
+}
+
+// Get returns the *T of the connection, it takes one from the pool and sets it as the context of
+// the connection on the first call. The context of the connection must not be set by SetContext otherwise.
+func (tc *TypedContext[T]) Get(c Conn) *T {
+	if v, ok := c.Context().(*T); ok {
+		return v
+	}
+	v, _ := tc.pool.Get().(*T)
+	if v == nil {
+		v = new(T)
+	}
+	c.SetContext(v)
+	return v
+}
+
+// Put resets the *T of the connection and releases it to the pool, it is meant to be called in OnClosed,
+// the value must not be referenced after that.
+func (tc *TypedContext[T]) Put(c Conn) {
+	v, ok := c.Context().(*T)
+	if !ok {
+		return
+	}
+	c.SetContext(nil)
+	var zero T
+	*v = zero
+	tc.pool.Put(v)
+}
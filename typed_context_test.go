@@ -0,0 +1,78 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package gnet
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTypedContext(t *testing.T) {
+	events := &testTypedContextServer{network: "tcp", addr: ":9923"}
+	must(Serve(events, "tcp://:9923"))
+	if events.closed != 2 {
+		t.Fatalf("expected 2 connections to be closed, got %d", events.closed)
+	}
+}
+
+type testTypedContextServer struct {
+	*EventServer
+	network, addr string
+	sessions      TypedContext[testTypedSession]
+	closed        int
+}
+
+type testTypedSession struct {
+	frames int
+}
+
+func (s *testTypedContextServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		// The second connection starts over with a fresh session, even if it's the one released by the first.
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial(s.network, s.addr)
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			buf := make([]byte, 1)
+			for j := 1; j <= 3; j++ {
+				_, err = c.Write([]byte("a"))
+				must(err)
+				_, err = c.Read(buf)
+				must(err)
+				if string(buf) != strconv.Itoa(j) {
+					panic("unexpected count of frames: " + string(buf))
+				}
+			}
+			must(c.Close())
+			time.Sleep(time.Millisecond * 50)
+		}
+	}()
+	return
+}
+
+func (s *testTypedContextServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	sess := s.sessions.Get(c)
+	sess.frames++
+	return []byte(strconv.Itoa(sess.frames)), None
+}
+
+func (s *testTypedContextServer) OnClosed(c Conn, err error) (action Action) {
+	if s.sessions.Get(c).frames != 3 {
+		panic("the session is lost")
+	}
+	s.sessions.Put(c)
+	if c.Context() != nil {
+		panic("the session is not released")
+	}
+	if s.closed++; s.closed == 2 {
+		return Shutdown
+	}
+	return
+}
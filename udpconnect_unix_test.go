@@ -0,0 +1,95 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPConnectLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opt    Option
+		opened int
+	}{
+		{"max-sessions", WithUDPMaxSessions(1), 2},
+		{"per-ip", WithPerIPMaxConnections(1), 2},
+		// Every connected socket would be over the watermark.
+		{"fd-watermark", WithFDLimitPolicy(1e-9, CloseLeastActive), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events := &testUDPConnectLimitsServer{network: "udp", addr: "127.0.0.1:9918"}
+			must(Serve(events, "udp://127.0.0.1:9918", WithUDPConnect(true), WithIdleTimeout(time.Millisecond*100),
+				tc.opt))
+			if events.opened != tc.opened {
+				t.Fatalf("expected %d connected sockets opened, got %d", tc.opened, events.opened)
+			}
+			if tc.opened > 0 && events.reason != ErrIdleTimeout {
+				t.Fatalf("expected the first connected socket to be closed for %v, got %v", ErrIdleTimeout, events.reason)
+			}
+		})
+	}
+}
+
+type testUDPConnectLimitsServer struct {
+	*EventServer
+	network, addr string
+	opened        int
+	reason        error
+}
+
+func (s *testUDPConnectLimitsServer) OnInitComplete(svr Server) (action Action) {
+	go func() {
+		a, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer a.Close()
+		b, err := net.Dial(s.network, s.addr)
+		must(err)
+		defer b.Close()
+		echo := func(c net.Conn, msg string) {
+			_, err := c.Write([]byte(msg))
+			must(err)
+			must(c.SetReadDeadline(time.Now().Add(time.Second * 3)))
+			buf := make([]byte, 16)
+			n, err := c.Read(buf)
+			must(err)
+			if string(buf[:n]) != msg {
+				panic("mismatched echo: " + string(buf[:n]))
+			}
+		}
+		// The second peer is served by the shared socket as long as the session of the first one is open,
+		// which is closed by IdleTimeout.
+		echo(a, "a")
+		echo(b, "b")
+		time.Sleep(time.Millisecond * 300)
+		echo(b, "b")
+		_, err = b.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (s *testUDPConnectLimitsServer) OnOpened(c Conn) (out []byte, action Action) {
+	s.opened++
+	return
+}
+
+func (s *testUDPConnectLimitsServer) OnClosed(c Conn, err error) (action Action) {
+	if s.reason == nil {
+		s.reason = err
+	}
+	return
+}
+
+func (s *testUDPConnectLimitsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "shutdown" {
+		return nil, Shutdown
+	}
+	return frame, None
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// ControlMessage is the ancillary data of a UDP datagram, which is received along with the datagram when
+// UDPControl is enabled, or passed to SendToWithControl to be sent along with the datagram.
+type ControlMessage struct {
+	// Dst is the destination address of the received datagram, it tells which address of a wildcard listener
+	// the peer has sent to.
+	Dst net.IP
+	// Src is the source address of the datagram to send, nil means the one chosen by kernel.
+	Src net.IP
+	// IfIndex is the index of the interface which the datagram is received on, or sent through if it's not 0.
+	IfIndex int
+	// TTL is the TTL or the hop limit of IPv6, 0 means the default of the socket when sending.
+	TTL int
+	// TOS is the TOS byte or the traffic class of IPv6, whose lowest 2 bits are the ECN bits, 0 means the default
+	// of the socket when sending.
+	TOS int
+	// Timestamp is the time when the datagram is received by kernel, it is zero for sending.
+	Timestamp time.Time
+}
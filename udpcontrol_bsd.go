@@ -0,0 +1,27 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+const controlMessageSpace = 0
+
+// setUDPControl does nothing since UDPControl is only supported on Linux.
+func setUDPControl(_ int, _ string) error {
+	return nil
+}
+
+func parseControlMessage(_ []byte) *ControlMessage {
+	return nil
+}
+
+func marshalControlMessage(cm *ControlMessage, _ unix.Sockaddr) ([]byte, error) {
+	if cm != nil {
+		return nil, ErrUnsupportedPlatform
+	}
+	return nil, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlMessageSpace is the size of buffer which is large enough for all the control messages of UDPControl.
+const controlMessageSpace = 256
+
+// setUDPControl enables receiving the control messages of UDPControl on the given UDP socket, the options
+// of IPv4 are enabled on the dual-stack sockets of IPv6 as well for the IPv4-mapped peers.
+func setUDPControl(fd int, network string) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		return err
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	v4Opts := []int{unix.IP_PKTINFO, unix.IP_RECVTTL, unix.IP_RECVTOS}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		for _, opt := range []int{unix.IPV6_RECVPKTINFO, unix.IPV6_RECVHOPLIMIT, unix.IPV6_RECVTCLASS} {
+			if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, opt, 1); err != nil {
+				return err
+			}
+		}
+		if network != "udp6" {
+			for _, opt := range v4Opts {
+				_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, opt, 1)
+			}
+		}
+		return nil
+	}
+	for _, opt := range v4Opts {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, opt, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseControlMessage parses the control messages received along with a datagram.
+func parseControlMessage(oob []byte) *ControlMessage {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	cm := new(ControlMessage)
+	for _, m := range msgs {
+		switch data := m.Data; {
+		case m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SO_TIMESTAMPNS &&
+			len(data) >= int(unsafe.Sizeof(unix.Timespec{})):
+			ts := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+			cm.Timestamp = time.Unix(ts.Unix())
+		case m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_PKTINFO &&
+			len(data) >= unix.SizeofInet4Pktinfo:
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+			cm.Dst = net.IPv4(info.Addr[0], info.Addr[1], info.Addr[2], info.Addr[3])
+			cm.IfIndex = int(info.Ifindex)
+		case m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_TTL && len(data) >= 4:
+			cm.TTL = int(*(*int32)(unsafe.Pointer(&data[0])))
+		case m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_TOS && len(data) >= 1:
+			cm.TOS = int(data[0])
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_PKTINFO &&
+			len(data) >= unix.SizeofInet6Pktinfo:
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+			cm.Dst = append(net.IP(nil), info.Addr[:]...)
+			cm.IfIndex = int(info.Ifindex)
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT && len(data) >= 4:
+			cm.TTL = int(*(*int32)(unsafe.Pointer(&data[0])))
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_TCLASS && len(data) >= 4:
+			cm.TOS = int(*(*int32)(unsafe.Pointer(&data[0])))
+		}
+	}
+	return cm
+}
+
+// marshalControlMessage encodes the control messages to send along with a datagram to the given peer,
+// the ones of IPv4 are used for the IPv4 peers including the IPv4-mapped ones of the dual-stack sockets.
+func marshalControlMessage(cm *ControlMessage, sa unix.Sockaddr) ([]byte, error) {
+	if cm == nil {
+		return nil, nil
+	}
+	var oob []byte
+	if isIPv4Peer(sa) {
+		if cm.Src != nil || cm.IfIndex != 0 {
+			info := unix.Inet4Pktinfo{Ifindex: int32(cm.IfIndex)}
+			if src := cm.Src.To4(); src != nil {
+				copy(info.Spec_dst[:], src)
+			}
+			oob = appendControlMessage(oob, unix.IPPROTO_IP, unix.IP_PKTINFO,
+				(*[unix.SizeofInet4Pktinfo]byte)(unsafe.Pointer(&info))[:])
+		}
+		if cm.TTL > 0 {
+			oob = appendControlMessageInt(oob, unix.IPPROTO_IP, unix.IP_TTL, cm.TTL)
+		}
+		if cm.TOS > 0 {
+			oob = appendControlMessageInt(oob, unix.IPPROTO_IP, unix.IP_TOS, cm.TOS)
+		}
+		return oob, nil
+	}
+	if cm.Src != nil || cm.IfIndex != 0 {
+		info := unix.Inet6Pktinfo{Ifindex: uint32(cm.IfIndex)}
+		if src := cm.Src.To16(); src != nil {
+			copy(info.Addr[:], src)
+		}
+		oob = appendControlMessage(oob, unix.IPPROTO_IPV6, unix.IPV6_PKTINFO,
+			(*[unix.SizeofInet6Pktinfo]byte)(unsafe.Pointer(&info))[:])
+	}
+	if cm.TTL > 0 {
+		oob = appendControlMessageInt(oob, unix.IPPROTO_IPV6, unix.IPV6_HOPLIMIT, cm.TTL)
+	}
+	if cm.TOS > 0 {
+		oob = appendControlMessageInt(oob, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, cm.TOS)
+	}
+	return oob, nil
+}
+
+func isIPv4Peer(sa unix.Sockaddr) bool {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return true
+	case *unix.SockaddrInet6:
+		return net.IP(sa.Addr[:]).To4() != nil
+	}
+	return false
+}
+
+func appendControlMessageInt(oob []byte, level, typ, v int) []byte {
+	i := int32(v)
+	return appendControlMessage(oob, level, typ, (*[4]byte)(unsafe.Pointer(&i))[:])
+}
+
+func appendControlMessage(oob []byte, level, typ int, data []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return append(oob, b...)
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// fullDatagramSocket returns a datagram socket which would block in sending to the returned peer.
+func fullDatagramSocket() (fd, peer int, sa unix.Sockaddr) {
+	_ = os.Remove("gnet-udpsend.sock")
+	sa = &unix.SockaddrUnix{Name: "gnet-udpsend.sock"}
+	peer, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	must(err)
+	must(unix.Bind(peer, sa))
+	fd, err = unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	must(err)
+	must(unix.SetNonblock(fd, true))
+	for {
+		if err = unix.Sendto(fd, []byte("fill"), 0, sa); err == unix.EAGAIN {
+			return
+		}
+		must(err)
+	}
+}
+
+func drainDatagrams(fd int) (packets []string) {
+	must(unix.SetNonblock(fd, true))
+	buf := make([]byte, 64)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return
+		}
+		if s := string(buf[:n]); s != "fill" {
+			packets = append(packets, s)
+		}
+	}
+}
+
+func TestUDPSendQueue(t *testing.T) {
+	for _, policy := range []UDPSendPolicy{UDPSendDropNewest, UDPSendDropOldest} {
+		p, err := netpoll.OpenPoller()
+		must(err)
+		fd, peer, sa := fullDatagramSocket()
+		svr := &server{opts: &Options{UDPSendQueue: 2, UDPSendPolicy: policy}}
+		svr.curOpts.Store(svr.opts)
+		el := &eventloop{poller: p, svr: svr}
+		for _, s := range []string{"a", "b"} {
+			if err = el.sendUDP(fd, []byte(s), sa); err != nil {
+				t.Fatalf("expected the datagram to be queued, got %v", err)
+			}
+		}
+		err = el.sendUDP(fd, []byte("c"), sa)
+		if policy == UDPSendDropNewest && err != ErrUDPSendDropped || policy == UDPSendDropOldest && err != nil {
+			t.Fatalf("unexpected error of sending to the full queue with policy %d: %v", policy, err)
+		}
+		if queued, dropped := el.udpSendStats(); queued != 2+uint64(policy) || dropped != 1 {
+			t.Fatalf("unexpected stats with policy %d: %d queued, %d dropped", policy, queued, dropped)
+		}
+
+		_ = drainDatagrams(peer)
+		el.flushUDP(fd)
+		expected := map[UDPSendPolicy]string{UDPSendDropNewest: "ab", UDPSendDropOldest: "bc"}[policy]
+		if got := drainDatagrams(peer); len(got) != 2 || got[0]+got[1] != expected {
+			t.Fatalf("expected the queued datagrams %q to be sent with policy %d, got %q", expected, policy, got)
+		}
+		if len(el.udpOut.packets) != 0 || el.udpOut.polled {
+			t.Fatalf("expected the queue to be empty and not polled after flushing")
+		}
+		_ = unix.Close(fd)
+		_ = unix.Close(peer)
+		_ = p.Close()
+		_ = os.Remove("gnet-udpsend.sock")
+	}
+}
+
+func TestUDPSendBlock(t *testing.T) {
+	fd, peer, sa := fullDatagramSocket()
+	defer func() {
+		_ = unix.Close(fd)
+		_ = unix.Close(peer)
+		_ = os.Remove("gnet-udpsend.sock")
+	}()
+	el := &eventloop{svr: &server{opts: &Options{UDPSendPolicy: UDPSendBlock, UDPSendTimeout: time.Millisecond * 50}}}
+	start := time.Now()
+	if err := el.sendUDP(fd, []byte("a"), sa); err != ErrUDPSendDropped || time.Since(start) < time.Millisecond*50 {
+		t.Fatalf("expected the datagram to be dropped after blocking for the timeout, got %v in %v",
+			err, time.Since(start))
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_ = drainDatagrams(peer)
+	}()
+	el.svr.opts.UDPSendTimeout = time.Second
+	if err := el.sendUDP(fd, []byte("b"), sa); err != nil {
+		t.Fatalf("expected the datagram to be sent once the buffer has room, got %v", err)
+	}
+	if _, dropped := el.udpSendStats(); dropped != 1 {
+		t.Fatalf("expected 1 datagram dropped, got %d", dropped)
+	}
+
+	// The blocked sender can't wait for the queue flushed by the event-loop.
+	err := Serve(new(EventServer), "udp://:9955", WithUDPSendQueue(8, UDPSendBlock, time.Second))
+	if err != ErrUDPSendBlockQueued {
+		t.Fatalf("expected %v, got %v", ErrUDPSendBlockQueued, err)
+	}
+}
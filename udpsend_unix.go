@@ -0,0 +1,127 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// udpSendQueue holds the datagrams waiting for the UDP socket of server to be writable, it is guarded by mu since
+// SendTo may be called by any goroutine.
+type udpSendQueue struct {
+	mu      sync.Mutex
+	packets []udpPacket
+	polled  bool   // the writable events of the socket are polled
+	queued  uint64 // datagrams queued so far, accessed atomically
+	dropped uint64 // datagrams dropped so far, accessed atomically
+}
+
+type udpPacket struct {
+	sa   unix.Sockaddr
+	data []byte
+}
+
+// sendUDP sends the datagram from the UDP socket of server polled by the event-loop, the datagram which would block
+// is dealt with by UDPSendQueue and UDPSendPolicy.
+func (el *eventloop) sendUDP(fd int, buf []byte, sa unix.Sockaddr) error {
+	opts, q := el.svr.opts, &el.udpOut
+	if opts.UDPSendQueue == 0 {
+		err := unix.Sendto(fd, buf, 0, sa)
+		if err == unix.EAGAIN {
+			if opts.UDPSendPolicy == UDPSendBlock {
+				err = el.sendUDPBlocking(fd, buf, sa)
+			}
+			if err == unix.EAGAIN || err == ErrUDPSendDropped {
+				atomic.AddUint64(&q.dropped, 1)
+				err = ErrUDPSendDropped
+			}
+		}
+		return err
+	}
+
+	q.mu.Lock()
+	// The datagrams are kept in order, the new one is queued behind the ones waiting.
+	if len(q.packets) == 0 {
+		if err := unix.Sendto(fd, buf, 0, sa); err != unix.EAGAIN {
+			q.mu.Unlock()
+			return err
+		}
+	}
+	if len(q.packets) >= opts.UDPSendQueue {
+		atomic.AddUint64(&q.dropped, 1)
+		if opts.UDPSendPolicy != UDPSendDropOldest {
+			q.mu.Unlock()
+			return ErrUDPSendDropped
+		}
+		q.packets[0] = udpPacket{}
+		q.packets = q.packets[1:]
+	}
+	q.packets = append(q.packets, udpPacket{sa, append([]byte(nil), buf...)})
+	atomic.AddUint64(&q.queued, 1)
+	poll := !q.polled
+	q.polled = true
+	q.mu.Unlock()
+
+	if poll {
+		return el.execute(func() error {
+			_ = el.poller.ModReadWrite(fd)
+			return nil
+		})
+	}
+	return nil
+}
+
+// sendUDPBlocking waits for the socket to be writable and sends the datagram for UDPSendTimeout at most.
+func (el *eventloop) sendUDPBlocking(fd int, buf []byte, sa unix.Sockaddr) error {
+	deadline := time.Now().Add(el.svr.opts.UDPSendTimeout)
+	for {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrUDPSendDropped
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+		if _, err := unix.Poll(fds, int((d+time.Millisecond-1)/time.Millisecond)); err != nil && err != unix.EINTR {
+			return err
+		}
+		if err := unix.Sendto(fd, buf, 0, sa); err != unix.EAGAIN {
+			return err
+		}
+	}
+}
+
+// flushUDP sends the queued datagrams on the writable event of the UDP socket, the writable events are not polled
+// any more once they are all sent.
+func (el *eventloop) flushUDP(fd int) {
+	q := &el.udpOut
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.packets) > 0 {
+		p := q.packets[0]
+		err := unix.Sendto(fd, p.data, 0, p.sa)
+		if err == unix.EAGAIN {
+			return
+		}
+		if err != nil {
+			el.svr.logger().Printf("failed to send queued UDP packet from fd:%d, error:%v\n", fd, err)
+		}
+		q.packets[0] = udpPacket{}
+		q.packets = q.packets[1:]
+	}
+	if q.polled {
+		q.polled = false
+		_ = el.poller.ModRead(fd)
+	}
+}
+
+// udpSendStats returns the number of the datagrams queued by UDPSendQueue and the ones dropped by UDPSendPolicy.
+func (el *eventloop) udpSendStats() (queued, dropped uint64) {
+	return atomic.LoadUint64(&el.udpOut.queued), atomic.LoadUint64(&el.udpOut.dropped)
+}
@@ -0,0 +1,13 @@
+// Copyright 2020 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package gnet
+
+import "time"
+
+func setUserTimeout(_ int, _ time.Duration) error {
+	return ErrUnsupportedPlatform
+}